@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/Essen-Labs/bloom-be/pkg/database"
+	"github.com/Essen-Labs/bloom-be/pkg/embedding"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/Essen-Labs/bloom-be/pkg/llm"
+)
+
+// embed-backfill embeds every stored message that has no vector for the
+// configured embedding model yet, e.g. after enabling semantic search or
+// switching EMBEDDING_MODEL.
+func main() {
+	batchSize := flag.Int("batch", 32, "number of messages sent to the embedder per request")
+	flag.Parse()
+
+	cfg := config.LoadConfig(config.DefaultConfigLoaders())
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to the database: %v", err)
+	}
+	defer db.Close()
+
+	embedder, err := llm.NewEmbedder(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create embedder: %v", err)
+	}
+	if embedder == nil {
+		fmt.Println("EMBEDDING_PROVIDER is not set, semantic search is disabled and nothing is embedded")
+		return
+	}
+	cipher, err := encryption.LoadCipher(db, cfg)
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+
+	count, err := embedding.NewIndex(db, embedder, cipher).Backfill(context.Background(), *batchSize)
+	if err != nil {
+		log.Fatalf("Backfill stopped after %d messages: %v", count, err)
+	}
+	fmt.Printf("Embedded %d messages with model %s\n", count, embedder.Model())
+}
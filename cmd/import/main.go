@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/Essen-Labs/bloom-be/pkg/database"
+	"github.com/Essen-Labs/bloom-be/pkg/embedding"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/Essen-Labs/bloom-be/pkg/handler"
+	"github.com/Essen-Labs/bloom-be/pkg/importer"
+	"github.com/Essen-Labs/bloom-be/pkg/llm"
+	"github.com/Essen-Labs/bloom-be/translation"
+	"github.com/dwarvesf/gerr"
+)
+
+// import loads a ChatGPT conversations.json or a bloom JSON export into the
+// conversations of a user and prints the import report as JSON.
+func main() {
+	userID := flag.String("user", "", "user ID owning the imported conversations")
+	file := flag.String("file", "", "path of the export file")
+	flag.Parse()
+
+	if *userID == "" || *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", *file, err)
+	}
+	defer f.Close()
+
+	result, err := importer.Parse(f)
+	if err != nil {
+		log.Fatalf("Failed to parse %s: %v", *file, err)
+	}
+
+	cfg := config.LoadConfig(config.DefaultConfigLoaders())
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to the database: %v", err)
+	}
+	defer db.Close()
+
+	embedder, err := llm.NewEmbedder(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create embedder: %v", err)
+	}
+	cipher, err := encryption.LoadCipher(db, cfg)
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+	h := handler.NewHandler(cfg, gerr.NewSimpleLog(), translation.NewTranslatorHelper(), db,
+		embedding.NewIndex(db, embedder, cipher), cipher)
+
+	res, err := h.ImportConversations(context.Background(), *userID, result)
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+	// Let the imported messages be embedded before exiting
+	h.Wait()
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(res) //nolint:errcheck // stdout
+}
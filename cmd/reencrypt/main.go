@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/Essen-Labs/bloom-be/pkg/database"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+)
+
+// reencrypt seals again the message content that is still in plaintext or under
+// an older data key. With -rotate-master it first wraps every data key with the
+// active master key, with -rotate-data-keys it gives every user a new data key.
+func main() {
+	rotateMaster := flag.Bool("rotate-master", false, "rewrap data keys with the active master key")
+	rotateDataKeys := flag.Bool("rotate-data-keys", false, "create a new data key for every user before re-encrypting")
+	batchSize := flag.Int("batch", 200, "number of rows read per query")
+	flag.Parse()
+
+	cfg := config.LoadConfig(config.DefaultConfigLoaders())
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to the database: %v", err)
+	}
+	defer db.Close()
+
+	cipher, err := encryption.LoadCipher(db, cfg)
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+	if !cipher.Enabled() {
+		log.Fatal("Encryption is not enabled, set ENCRYPTION_KEYS or ENCRYPTION_KEY_FILE")
+	}
+	ctx := context.Background()
+
+	if *rotateMaster {
+		count, err := cipher.RewrapDataKeys(ctx)
+		if err != nil {
+			log.Fatalf("Rewrap stopped: %v", err)
+		}
+		fmt.Printf("Rewrapped %d data keys\n", count)
+	}
+
+	if *rotateDataKeys {
+		count, err := rotateAllDataKeys(ctx, db, cipher)
+		if err != nil {
+			log.Fatalf("Data key rotation stopped after %d users: %v", count, err)
+		}
+		fmt.Printf("Rotated data keys of %d users\n", count)
+	}
+
+	count, err := cipher.Reencrypt(ctx, *batchSize)
+	if err != nil {
+		log.Fatalf("Re-encryption stopped after %d rows: %v", count, err)
+	}
+	fmt.Printf("Re-encrypted %d rows\n", count)
+}
+
+func rotateAllDataKeys(ctx context.Context, db *sql.DB, cipher *encryption.Cipher) (int, error) {
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT user_id FROM user_data_keys`)
+	if err != nil {
+		return 0, fmt.Errorf("could not query users: %v", err)
+	}
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("could not scan user: %v", err)
+		}
+		users = append(users, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("could not iterate over users: %v", err)
+	}
+
+	for idx, userID := range users {
+		if _, err := cipher.RotateDataKey(ctx, userID); err != nil {
+			return idx, err
+		}
+	}
+	return len(users), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/Essen-Labs/bloom-be/pkg/database"
+	"github.com/Essen-Labs/bloom-be/pkg/handler"
+	"github.com/Essen-Labs/bloom-be/translation"
+	"github.com/dwarvesf/gerr"
+)
+
+// retention applies the retention rules once, or with -dry-run prints per user
+// what they would delete. The server applies them every hour on its own.
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report what would be deleted")
+	userID := flag.String("user", "", "restrict the dry-run report to a user")
+	flag.Parse()
+
+	cfg := config.LoadConfig(config.DefaultConfigLoaders())
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to the database: %v", err)
+	}
+	defer db.Close()
+
+	h := handler.NewHandler(cfg, gerr.NewSimpleLog(), translation.NewTranslatorHelper(), db, nil, nil)
+
+	report, err := h.RetentionReport(context.Background(), *userID)
+	if err != nil {
+		log.Fatalf("Retention report failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report) //nolint:errcheck // stdout
+	if *dryRun {
+		return
+	}
+
+	if err := h.ApplyRetention(context.Background()); err != nil {
+		log.Fatalf("Retention failed: %v", err)
+	}
+}
@@ -9,48 +9,106 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
 	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/Essen-Labs/bloom-be/pkg/embedding"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
 	"github.com/Essen-Labs/bloom-be/pkg/handler"
-	"github.com/Essen-Labs/bloom-be/pkg/middleware"
-	"github.com/Essen-Labs/bloom-be/pkg/validator"
+	"github.com/Essen-Labs/bloom-be/pkg/llm"
+	"github.com/Essen-Labs/bloom-be/pkg/mail"
+	"github.com/Essen-Labs/bloom-be/pkg/oidc"
+	"github.com/Essen-Labs/bloom-be/pkg/ratelimit"
+	"github.com/Essen-Labs/bloom-be/pkg/router"
+	"github.com/Essen-Labs/bloom-be/pkg/scheduler"
 	"github.com/Essen-Labs/bloom-be/translation"
 	"github.com/dwarvesf/gerr"
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
-	"github.com/gin-gonic/gin/binding"
+)
 
-	_ "github.com/Essen-Labs/bloom-be/docs"
-	swaggerFiles "github.com/swaggo/files"
-	ginSwagger "github.com/swaggo/gin-swagger"
+const (
+	trashPurgeInterval = time.Hour
+	retentionInterval  = time.Hour
+	tokenPurgeInterval = time.Hour
+	// Data exports are built soon after they are requested, even without the
+	// instance that received the request
+	dataExportInterval      = time.Minute
+	accountDeletionInterval = time.Hour
 )
 
 // App api app instance
 type App struct {
-	cfg config.Config
-	l   gerr.Log
-	th  translation.Helper
-	db  *sql.DB
+	cfg     config.Config
+	l       gerr.Log
+	th      translation.Helper
+	db      *sql.DB
+	replica *sql.DB
+	idx     *embedding.Index
+	cipher  *encryption.Cipher
+	tokens  *auth.Tokens
+	sso     *oidc.Provider
+	limiter ratelimit.Limiter
+	mailer  mail.Mailer
 }
 
-// LoadApp load config and init app
-func LoadApp(db *sql.DB) *App {
+// LoadApp load config and init app, replica may be nil to serve every query from db
+func LoadApp(db, replica *sql.DB) *App {
 	cls := config.DefaultConfigLoaders()
 	cfg := config.LoadConfig(cls)
 	l := gerr.NewSimpleLog()
 	th := translation.NewTranslatorHelper()
+	embedder, err := llm.NewEmbedder(cfg)
+	if err != nil {
+		log.Fatal("Error creating embedder: ", err)
+	}
+	cipher, err := encryption.LoadCipher(db, cfg)
+	if err != nil {
+		log.Fatal("Error loading encryption keys: ", err)
+	}
+	signingKeys, err := auth.LoadSigningKeys(cfg)
+	if err != nil {
+		log.Fatal("Error loading token signing keys: ", err)
+	}
+	sso, err := oidc.LoadProvider(cfg)
+	if err != nil {
+		log.Fatal("Error loading single sign-on provider: ", err)
+	}
+	limiter, err := ratelimit.LoadLimiter(cfg)
+	if err != nil {
+		log.Fatal("Error loading rate limiter: ", err)
+	}
+	mailer, err := mail.LoadMailer(cfg)
+	if err != nil {
+		log.Fatal("Error loading mailer: ", err)
+	}
+	if replica != nil {
+		l.Info("list and search queries are served by the read replica") //nolint:errcheck // Ignore unused function warning
+	}
 
 	return &App{
-		cfg: cfg,
-		l:   l,
-		th:  th,
-		db:  db,
+		cfg:     cfg,
+		l:       l,
+		th:      th,
+		db:      db,
+		replica: replica,
+		idx:     embedding.NewIndex(db, embedder, cipher).WithReplica(replica),
+		cipher:  cipher,
+		tokens:  auth.NewTokens(signingKeys, cfg.AccessTokenTTL),
+		sso:     sso,
+		limiter: limiter,
+		mailer:  mailer,
 	}
 }
 
 // Run api app
 func (a App) Run() {
-	router := a.setupRouter()
+	h := handler.NewHandler(a.cfg, a.l, a.th, a.db, a.idx, a.cipher).
+		WithReplica(a.replica).
+		WithTokens(a.tokens).
+		WithSSO(a.sso).
+		WithLimiter(a.limiter).
+		WithMailer(a.mailer)
+	router := router.New(a.cfg, a.th, h)
 	quit := make(chan os.Signal)
 	signal.Notify(quit, os.Interrupt) //nolint
 	err := a.createTables()
@@ -67,6 +125,10 @@ func (a App) Run() {
 		return path
 	})
 
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	a.setupJobs(h).Start(jobsCtx)
+
 	srv := &http.Server{
 		Addr:    fmt.Sprintf("0.0.0.0:%s", a.cfg.Port),
 		Handler: router,
@@ -88,6 +150,7 @@ func (a App) Run() {
 	case <-quit:
 
 		a.l.Info("Shutdown Server ...") //nolint:errcheck // Ignore unused function warning
+		stopJobs()
 		ctx, cancel := context.WithTimeout(context.Background(), a.cfg.GetShutdownTimeout())
 		defer cancel()
 		if err := srv.Shutdown(ctx); err != nil {
@@ -97,30 +160,12 @@ func (a App) Run() {
 	}
 }
 
-func (a App) setupRouter() *gin.Engine {
-	r := gin.New()
-	binding.Validator = validator.NewStructValidator(a.th)
-	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	r.Use(middleware.NewLogDataMiddleware(a.cfg.ServiceName, a.cfg.Env))
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "HEAD"},
-		AllowHeaders:     []string{"Origin", "Host", "Content-Type", "Content-Length", "Accept-Encoding", "Accept-Language", "Accept", "X-CSRF-Token", "Authorization", "X-Requested-With", "X-Access-Token", "user-id"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-	}))
-
-	h := handler.NewHandler(a.cfg, a.l, a.th, a.db)
-
-	// handlers
-	r.GET("/healthz", h.Healthz)
-	r.GET("/get-chat-by-id/:conversation_id", h.GetChatById)
-	r.GET("/get-chat-list", h.GetAllChat)
-	r.POST("/send-chat", h.Completions)
-	r.GET("/get-all-msgs-by-id/:conversation_id", h.GetAllMsgsByID)
-	r.DELETE("/delete-chat/:conversation_id", h.DeleteChatById)
-	r.DELETE("/delete-all-chat", h.DeleteAllChat)
-	r.POST("/edit-chat", h.EditChat)
-	// r.GET("/get-model-list", h.Completions)     // TODO
-	return r
+func (a App) setupJobs(h *handler.Handler) *scheduler.Scheduler {
+	return scheduler.New(a.l,
+		scheduler.Job{Name: "purge-expired-trash", Interval: trashPurgeInterval, Run: h.PurgeExpiredTrash, Exclusive: true},
+		scheduler.Job{Name: "apply-retention", Interval: retentionInterval, Run: h.ApplyRetention, Exclusive: true},
+		scheduler.Job{Name: "purge-expired-tokens", Interval: tokenPurgeInterval, Run: h.PurgeExpiredTokens, Exclusive: true},
+		scheduler.Job{Name: "build-data-exports", Interval: dataExportInterval, Run: h.BuildDataExports, Exclusive: true, Trigger: h.DataExportRequests()},
+		scheduler.Job{Name: "delete-scheduled-accounts", Interval: accountDeletionInterval, Run: h.DeleteScheduledAccounts, Exclusive: true},
+	).WithLocker(scheduler.NewPGLocker(a.db))
 }
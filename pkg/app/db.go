@@ -2,6 +2,8 @@ package app
 
 import (
 	"log"
+
+	"github.com/lib/pq"
 )
 
 func (a App) createTables() error {
@@ -34,5 +36,494 @@ func (a App) createTables() error {
 		log.Fatal("Error creating messages table: ", err)
 		return err
 	}
+
+	// Create the text search configurations used by the search endpoint. Postgres
+	// ships no Vietnamese dictionary, so the "vietnamese" config tokenizes words
+	// without stemming, which suits an isolating language.
+	_, err = a.db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'vietnamese') THEN
+				CREATE TEXT SEARCH CONFIGURATION vietnamese (COPY = simple);
+			END IF;
+		END
+		$$
+	`)
+	if err != nil {
+		log.Fatal("Error creating text search configurations: ", err)
+		return err
+	}
+
+	// Add full-text search columns and their GIN indexes
+	_, err = a.db.Exec(`
+		ALTER TABLE messages
+			ADD COLUMN IF NOT EXISTS search_en tsvector
+				GENERATED ALWAYS AS (to_tsvector('english', coalesce(content, ''))) STORED,
+			ADD COLUMN IF NOT EXISTS search_vi tsvector
+				GENERATED ALWAYS AS (to_tsvector('vietnamese', coalesce(content, ''))) STORED;
+		ALTER TABLE conversations
+			ADD COLUMN IF NOT EXISTS search_en tsvector
+				GENERATED ALWAYS AS (to_tsvector('english', coalesce(conversation_name, ''))) STORED,
+			ADD COLUMN IF NOT EXISTS search_vi tsvector
+				GENERATED ALWAYS AS (to_tsvector('vietnamese', coalesce(conversation_name, ''))) STORED;
+		CREATE INDEX IF NOT EXISTS messages_search_en_idx ON messages USING GIN (search_en);
+		CREATE INDEX IF NOT EXISTS messages_search_vi_idx ON messages USING GIN (search_vi);
+		CREATE INDEX IF NOT EXISTS conversations_search_en_idx ON conversations USING GIN (search_en);
+		CREATE INDEX IF NOT EXISTS conversations_search_vi_idx ON conversations USING GIN (search_vi);
+	`)
+	if err != nil {
+		log.Fatal("Error creating search indexes: ", err)
+		return err
+	}
+
+	// Create Message embeddings table
+	_, err = a.db.Exec(`
+		CREATE TABLE IF NOT EXISTS message_embeddings (
+			message_id INTEGER PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+			model VARCHAR(255) NOT NULL,
+			embedding REAL[],
+			sealed_embedding BYTEA,
+			created_at VARCHAR(255),
+			CHECK ((embedding IS NULL) <> (sealed_embedding IS NULL))
+		);
+		CREATE INDEX IF NOT EXISTS message_embeddings_model_idx ON message_embeddings (model);
+	`)
+	if err != nil {
+		log.Fatal("Error creating message embeddings table: ", err)
+		return err
+	}
+
+	// Add the trash column to conversations
+	_, err = a.db.Exec(`
+		ALTER TABLE conversations ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+		CREATE INDEX IF NOT EXISTS conversations_user_deleted_idx ON conversations (user_id, deleted_at);
+	`)
+	if err != nil {
+		log.Fatal("Error adding trash column to conversations: ", err)
+		return err
+	}
+
+	// Create Folders and Tags tables
+	_, err = a.db.Exec(`
+		CREATE TABLE IF NOT EXISTS folders (
+			id SERIAL PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			UNIQUE (user_id, name)
+		);
+		CREATE TABLE IF NOT EXISTS tags (
+			id SERIAL PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			UNIQUE (user_id, name)
+		);
+		CREATE TABLE IF NOT EXISTS conversation_tags (
+			conversation_id INTEGER REFERENCES conversations(id) ON DELETE CASCADE,
+			tag_id INTEGER REFERENCES tags(id) ON DELETE CASCADE,
+			PRIMARY KEY (conversation_id, tag_id)
+		);
+		CREATE INDEX IF NOT EXISTS conversation_tags_tag_idx ON conversation_tags (tag_id);
+	`)
+	if err != nil {
+		log.Fatal("Error creating folders and tags tables: ", err)
+		return err
+	}
+
+	// Add the organization columns to conversations
+	_, err = a.db.Exec(`
+		ALTER TABLE conversations
+			ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT false,
+			ADD COLUMN IF NOT EXISTS archived BOOLEAN NOT NULL DEFAULT false,
+			ADD COLUMN IF NOT EXISTS folder_id INTEGER REFERENCES folders(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS conversations_folder_idx ON conversations (folder_id);
+	`)
+	if err != nil {
+		log.Fatal("Error adding organization columns to conversations: ", err)
+		return err
+	}
+
+	// Add the model and token usage of completions to messages
+	_, err = a.db.Exec(`
+		ALTER TABLE messages
+			ADD COLUMN IF NOT EXISTS model VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS prompt_tokens INTEGER,
+			ADD COLUMN IF NOT EXISTS completion_tokens INTEGER,
+			ADD COLUMN IF NOT EXISTS total_tokens INTEGER;
+	`)
+	if err != nil {
+		log.Fatal("Error adding usage columns to messages: ", err)
+		return err
+	}
+
+	// Create the share tables. A share is a copy of the conversation taken when it
+	// is published, later edits or deletion of the conversation do not change it.
+	_, err = a.db.Exec(`
+		CREATE TABLE IF NOT EXISTS shared_conversations (
+			id SERIAL PRIMARY KEY,
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			conversation_id INTEGER REFERENCES conversations(id) ON DELETE SET NULL,
+			user_id VARCHAR(255) NOT NULL,
+			conversation_name VARCHAR(255),
+			model VARCHAR(255),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ,
+			revoked_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS shared_conversations_user_idx ON shared_conversations (user_id);
+		CREATE TABLE IF NOT EXISTS shared_messages (
+			id SERIAL PRIMARY KEY,
+			share_id INTEGER NOT NULL REFERENCES shared_conversations(id) ON DELETE CASCADE,
+			role VARCHAR(255),
+			content TEXT,
+			timestamp VARCHAR(255),
+			model VARCHAR(255)
+		);
+		CREATE INDEX IF NOT EXISTS shared_messages_share_idx ON shared_messages (share_id);
+	`)
+	if err != nil {
+		log.Fatal("Error creating share tables: ", err)
+		return err
+	}
+
+	// Create the per-user retention rules, the global rule comes from the config
+	_, err = a.db.Exec(`
+		CREATE TABLE IF NOT EXISTS retention_policies (
+			user_id VARCHAR(255) PRIMARY KEY,
+			max_age_days INTEGER NOT NULL CHECK (max_age_days > 0),
+			exempt_pinned BOOLEAN NOT NULL DEFAULT true,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS messages_conversation_idx ON messages (conversation_id);
+	`)
+	if err != nil {
+		log.Fatal("Error creating retention policies table: ", err)
+		return err
+	}
+
+	// Create the table of wrapped per-user data keys encrypting message content.
+	// content_encrypted tells sealed content from plaintext, which may look the
+	// same. Encrypted content cannot feed generated search columns, they become
+	// plain columns filled when messages are inserted, and left empty while
+	// encryption is enabled.
+	_, err = a.db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_data_keys (
+			user_id VARCHAR(255) NOT NULL,
+			version INTEGER NOT NULL,
+			master_key_id VARCHAR(64) NOT NULL,
+			wrapped_key BYTEA NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (user_id, version)
+		);
+		ALTER TABLE messages
+			ADD COLUMN IF NOT EXISTS content_encrypted BOOLEAN NOT NULL DEFAULT false,
+			ALTER COLUMN search_en DROP EXPRESSION IF EXISTS,
+			ALTER COLUMN search_vi DROP EXPRESSION IF EXISTS;
+		ALTER TABLE shared_messages ADD COLUMN IF NOT EXISTS content_encrypted BOOLEAN NOT NULL DEFAULT false;
+	`)
+	if err != nil {
+		log.Fatal("Error creating encryption tables: ", err)
+		return err
+	}
+
+	// Create the feedback table, one rating per assistant message
+	_, err = a.db.Exec(`
+		CREATE TABLE IF NOT EXISTS message_feedback (
+			message_id INTEGER PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+			user_id VARCHAR(255) NOT NULL,
+			score SMALLINT NOT NULL CHECK (score IN (-1, 1)),
+			reasons TEXT[] NOT NULL DEFAULT '{}',
+			comment TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		log.Fatal("Error creating feedback table: ", err)
+		return err
+	}
+
+	// Create the audit log, a trigger keeps it append-only. The only update it
+	// allows erases the ip, user agent and values of the entries of an account
+	// that no longer exists, leaving every other column as it was.
+	_, err = a.db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGSERIAL PRIMARY KEY,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			actor_id VARCHAR(255),
+			action VARCHAR(64) NOT NULL,
+			target_type VARCHAR(64),
+			target_id VARCHAR(255),
+			request_id VARCHAR(64),
+			ip VARCHAR(64),
+			user_agent TEXT,
+			before JSONB,
+			after JSONB
+		);
+		CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_id, created_at);
+		CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log (target_type, target_id);
+		CREATE INDEX IF NOT EXISTS audit_log_action_idx ON audit_log (action, created_at);
+		CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'UPDATE' AND OLD.actor_id IS NOT NULL
+				AND NOT EXISTS (SELECT 1 FROM users WHERE id = OLD.actor_id)
+				AND (NEW.id, NEW.created_at, NEW.actor_id, NEW.action, NEW.target_type, NEW.target_id, NEW.request_id)
+					IS NOT DISTINCT FROM (OLD.id, OLD.created_at, OLD.actor_id, OLD.action, OLD.target_type, OLD.target_id, OLD.request_id)
+				AND NEW.ip IS NULL AND NEW.user_agent IS NULL AND NEW.before IS NULL AND NEW.after IS NULL THEN
+				RETURN NEW;
+			END IF;
+			RAISE EXCEPTION 'audit_log is append-only';
+		END
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+		CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+			FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+		DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+		CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+			FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+	`)
+	if err != nil {
+		log.Fatal("Error creating audit log: ", err)
+		return err
+	}
+
+	// Add the version used for optimistic concurrency to conversations. Every
+	// update bumps it, as does tagging or untagging since tags are part of the
+	// conversation returned to clients.
+	_, err = a.db.Exec(`
+		ALTER TABLE conversations
+			ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1,
+			ADD COLUMN IF NOT EXISTS custom_name BOOLEAN NOT NULL DEFAULT false;
+		CREATE OR REPLACE FUNCTION conversations_bump_version() RETURNS trigger AS $$
+		BEGIN
+			IF NEW.version = OLD.version THEN
+				NEW.version := OLD.version + 1;
+			END IF;
+			RETURN NEW;
+		END
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS conversations_bump_version ON conversations;
+		CREATE TRIGGER conversations_bump_version BEFORE UPDATE ON conversations
+			FOR EACH ROW EXECUTE FUNCTION conversations_bump_version();
+		CREATE OR REPLACE FUNCTION conversation_tags_bump_version() RETURNS trigger AS $$
+		BEGIN
+			UPDATE conversations SET version = version + 1
+			WHERE id = coalesce(NEW.conversation_id, OLD.conversation_id);
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS conversation_tags_bump_version ON conversation_tags;
+		CREATE TRIGGER conversation_tags_bump_version AFTER INSERT OR DELETE ON conversation_tags
+			FOR EACH ROW EXECUTE FUNCTION conversation_tags_bump_version();
+	`)
+	if err != nil {
+		log.Fatal("Error adding version to conversations: ", err)
+		return err
+	}
+
+	// Create the users table. Users created before accounts existed, only known
+	// by the user id their client sent, have neither username nor password.
+	_, err = a.db.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id VARCHAR(255) PRIMARY KEY,
+			username VARCHAR(64),
+			password_hash TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS users_username_idx ON users (lower(username));
+		CREATE TABLE IF NOT EXISTS user_sessions (
+			id VARCHAR(64) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			revoked_at TIMESTAMPTZ,
+			ip VARCHAR(64),
+			user_agent TEXT
+		);
+		CREATE INDEX IF NOT EXISTS user_sessions_user_idx ON user_sessions (user_id);
+	`)
+	if err != nil {
+		log.Fatal("Error creating users table: ", err)
+		return err
+	}
+
+	// Link conversations to users, creating the users of existing conversations
+	_, err = a.db.Exec(`
+		INSERT INTO users (id)
+		SELECT DISTINCT user_id FROM conversations WHERE user_id IS NOT NULL
+		ON CONFLICT (id) DO NOTHING;
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'conversations_user_id_fkey') THEN
+				ALTER TABLE conversations ADD CONSTRAINT conversations_user_id_fkey
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+			END IF;
+		END
+		$$
+	`)
+	if err != nil {
+		log.Fatal("Error linking conversations to users: ", err)
+		return err
+	}
+
+	// Group the refresh tokens of a session into a family. Refreshing replaces
+	// the token with a new one of the same family, logging out or reusing an
+	// exchanged token revokes the whole family.
+	_, err = a.db.Exec(`
+		ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS family_id VARCHAR(64);
+		UPDATE user_sessions SET family_id = id WHERE family_id IS NULL;
+		ALTER TABLE user_sessions ALTER COLUMN family_id SET NOT NULL;
+		CREATE INDEX IF NOT EXISTS user_sessions_family_idx ON user_sessions (family_id);
+	`)
+	if err != nil {
+		log.Fatal("Error adding refresh token families: ", err)
+		return err
+	}
+
+	// Personal API keys, stored as a SHA-256 hash with only a hint of the key
+	// kept in clear. rate_limit is in requests per minute, unlimited when null.
+	_, err = a.db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id SERIAL PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			hint VARCHAR(16) NOT NULL,
+			key_hash VARCHAR(64) NOT NULL UNIQUE,
+			scopes TEXT[] NOT NULL,
+			rate_limit INTEGER,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ,
+			last_used_at TIMESTAMPTZ,
+			revoked_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS api_keys_user_idx ON api_keys (user_id);
+	`)
+	if err != nil {
+		log.Fatal("Error creating api_keys table: ", err)
+		return err
+	}
+
+	// Link users to the accounts of OpenID Connect providers. Emails are unique
+	// once verified, single sign-on links an identity to the account of its
+	// verified email.
+	_, err = a.db.Exec(`
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR(320);
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+		CREATE UNIQUE INDEX IF NOT EXISTS users_verified_email_idx ON users (lower(email)) WHERE email_verified_at IS NOT NULL;
+		CREATE TABLE IF NOT EXISTS user_identities (
+			issuer VARCHAR(255) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(320),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			last_login_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (issuer, subject)
+		);
+		CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities (user_id);
+	`)
+	if err != nil {
+		log.Fatal("Error creating user_identities table: ", err)
+		return err
+	}
+
+	// Roles, bans and quotas of users. Access tokens issued before
+	// sessions_revoked_at are rejected, which logs the user out everywhere.
+	// daily_message_quota overrides DAILY_MESSAGE_QUOTA, 0 is unlimited.
+	_, err = a.db.Exec(`
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_reason TEXT;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS sessions_revoked_at TIMESTAMPTZ;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS daily_message_quota INTEGER;
+		CREATE TABLE IF NOT EXISTS user_usage (
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			day DATE NOT NULL,
+			messages INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (user_id, day)
+		);
+	`)
+	if err != nil {
+		log.Fatal("Error adding user roles and quotas: ", err)
+		return err
+	}
+
+	// ADMIN_USER_IDS bootstraps the first admins, who then manage roles through
+	// the admin routes. Anonymous visitors are never promoted.
+	_, err = a.db.Exec(`
+		UPDATE users SET role = 'admin'
+		WHERE id = ANY($1) AND username IS NOT NULL AND role <> 'admin'`, pq.Array(a.cfg.GetAdminUserIDs()))
+	if err != nil {
+		log.Fatal("Error promoting admin users: ", err)
+		return err
+	}
+
+	// Tokens sent by email to verify an address or reset a password, stored
+	// hashed. email is the address the token was sent to, a verification only
+	// applies while it is still the address of the user.
+	_, err = a.db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_tokens (
+			id VARCHAR(64) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			purpose VARCHAR(32) NOT NULL,
+			email VARCHAR(320) NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			used_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS user_tokens_user_idx ON user_tokens (user_id, purpose);
+		CREATE INDEX IF NOT EXISTS user_tokens_expires_idx ON user_tokens (expires_at);
+	`)
+	if err != nil {
+		log.Fatal("Error creating user_tokens table: ", err)
+		return err
+	}
+
+	// Archives of the data of users, built in the background and kept until
+	// expires_at, stored in chunks sealed with the data key of the user as they
+	// are written. Accounts are deleted once deletion_scheduled_at is past.
+	_, err = a.db.Exec(`
+		CREATE TABLE IF NOT EXISTS data_exports (
+			id VARCHAR(36) PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			status VARCHAR(16) NOT NULL DEFAULT 'pending',
+			error TEXT,
+			size BIGINT,
+			chunks INTEGER,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			started_at TIMESTAMPTZ,
+			completed_at TIMESTAMPTZ,
+			expires_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS data_exports_user_idx ON data_exports (user_id, created_at);
+		CREATE INDEX IF NOT EXISTS data_exports_status_idx ON data_exports (status, created_at);
+		CREATE UNIQUE INDEX IF NOT EXISTS data_exports_active_idx ON data_exports (user_id) WHERE status IN ('pending', 'running');
+		CREATE TABLE IF NOT EXISTS data_export_chunks (
+			export_id VARCHAR(36) NOT NULL REFERENCES data_exports(id) ON DELETE CASCADE,
+			n INTEGER NOT NULL,
+			data BYTEA NOT NULL,
+			encrypted BOOLEAN NOT NULL DEFAULT false,
+			PRIMARY KEY (export_id, n)
+		);
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMPTZ;
+		CREATE INDEX IF NOT EXISTS users_deletion_idx ON users (deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
+	`)
+	if err != nil {
+		log.Fatal("Error creating data_exports table: ", err)
+		return err
+	}
+
+	// Conversation ids come from the conversations sequence. They used to be
+	// the highest id plus one, which never advanced it, so move it past them.
+	_, err = a.db.Exec(`
+		SELECT setval('conversations_id_seq', max(id))
+		FROM conversations
+		HAVING max(id) >= (SELECT last_value FROM conversations_id_seq)
+	`)
+	if err != nil {
+		log.Fatal("Error advancing the conversations sequence: ", err)
+		return err
+	}
 	return nil
 }
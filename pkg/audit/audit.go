@@ -0,0 +1,152 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionConversationDelete     = "conversation.delete"
+	ActionConversationDeleteAll  = "conversation.delete_all"
+	ActionConversationRename     = "conversation.rename"
+	ActionConversationRestore    = "conversation.restore"
+	ActionConversationPurge      = "conversation.purge"
+	ActionTrashEmpty             = "trash.empty"
+	ActionTrashPurgeExpired      = "trash.purge_expired"
+	ActionFolderDelete           = "folder.delete"
+	ActionTagDelete              = "tag.delete"
+	ActionShareCreate            = "share.create"
+	ActionShareRevoke            = "share.revoke"
+	ActionRetentionSet           = "retention.set"
+	ActionRetentionDelete        = "retention.delete"
+	ActionRetentionApply         = "retention.apply"
+	ActionUserMerge              = "user.merge"
+	ActionUserProvision          = "user.provision"
+	ActionUserLinkIdentity       = "user.link_identity"
+	ActionAPIKeyCreate           = "api_key.create"
+	ActionAPIKeyRevoke           = "api_key.revoke"
+	ActionUserDisable            = "user.disable"
+	ActionUserEnable             = "user.enable"
+	ActionUserForceLogout        = "user.force_logout"
+	ActionUserSetQuota           = "user.set_quota"
+	ActionUserSetRole            = "user.set_role"
+	ActionAdminViewConversations = "admin.view_conversations"
+	ActionAdminViewConversation  = "admin.view_conversation"
+	ActionUserVerifyEmail        = "user.verify_email"
+	ActionUserResetPassword      = "user.reset_password"
+	ActionDataExportRequest      = "data_export.request"
+	ActionUserScheduleDeletion   = "user.schedule_deletion"
+	ActionUserCancelDeletion     = "user.cancel_deletion"
+	ActionUserDelete             = "user.delete"
+)
+
+// Target types of the audited actions
+const (
+	TargetConversation = "conversation"
+	TargetUser         = "user"
+	TargetFolder       = "folder"
+	TargetTag          = "tag"
+	TargetShare        = "share"
+	TargetAPIKey       = "api_key"
+)
+
+// systemActorID is the actor of the actions run by scheduled jobs
+const systemActorID = "system"
+
+// Actor is who performed an action and the request it came from
+type Actor struct {
+	UserID    string `json:"userId"`
+	RequestID string `json:"requestId"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+}
+
+// System returns the actor of scheduled jobs
+func System() Actor {
+	return Actor{UserID: systemActorID}
+}
+
+// Entry is an action to record, Before and After are marshaled to JSON and may be nil
+type Entry struct {
+	Actor
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     interface{}
+	After      interface{}
+}
+
+// Record is an entry read back from the audit log
+type Record struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	ActorID    string          `json:"actorId"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"targetType"`
+	TargetID   string          `json:"targetId"`
+	RequestID  string          `json:"requestId"`
+	IP         string          `json:"ip"`
+	UserAgent  string          `json:"userAgent"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+}
+
+// Log is the append-only audit_log table. Rows cannot be updated or deleted, a
+// trigger created with the table rejects it, but for Erase.
+type Log struct {
+	db *sql.DB
+}
+
+// NewLog make an audit log
+func NewLog(db *sql.DB) *Log {
+	return &Log{db: db}
+}
+
+// Record appends an entry
+func (l *Log) Record(ctx context.Context, e Entry) error {
+	before, err := marshalValue(e.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshalValue(e.After)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.db.ExecContext(ctx, `
+		INSERT INTO audit_log (actor_id, action, target_type, target_id, request_id, ip, user_agent, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		e.UserID, e.Action, e.TargetType, e.TargetID, e.RequestID, e.IP, e.UserAgent, before, after)
+	if err != nil {
+		return fmt.Errorf("could not record %s audit entry: %v", e.Action, err)
+	}
+	return nil
+}
+
+// Erase clears the ip, user agent, before and after of the entries made by a
+// user whose account was deleted in tx, keeping what was done and when. The
+// trigger of the table rejects it while the account exists.
+func Erase(ctx context.Context, tx *sql.Tx, userID string) (int64, error) {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE audit_log SET ip = NULL, user_agent = NULL, before = NULL, after = NULL
+		WHERE actor_id = $1 AND (ip IS NOT NULL OR user_agent IS NOT NULL OR before IS NOT NULL OR after IS NOT NULL)`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("could not erase audit entries: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+func marshalValue(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal audit value: %v", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,130 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Filter selects audit records, zero fields do not filter
+type Filter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	RequestID  string
+	From       time.Time
+	To         time.Time
+}
+
+// where builds the conditions of the filter, with placeholders numbered from 1
+func (f Filter) where() (string, []interface{}) {
+	conditions := []string{"true"}
+	args := []interface{}{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.ActorID != "" {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = $%d", f.TargetID)
+	}
+	if f.RequestID != "" {
+		add("request_id = $%d", f.RequestID)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+const recordColumns = `id, created_at, coalesce(actor_id, ''), action, coalesce(target_type, ''), coalesce(target_id, ''),
+	coalesce(request_id, ''), coalesce(ip, ''), coalesce(user_agent, ''), before, after`
+
+// Query returns a page of records, newest first, and the number of matching records
+func (l *Log) Query(ctx context.Context, f Filter, limit, offset int) ([]Record, int, error) {
+	where, args := f.where()
+
+	var total int
+	if err := l.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("could not count audit records: %v", err)
+	}
+
+	args = append(args, limit, offset)
+	rows, err := l.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %s FROM audit_log
+		WHERE %s
+		ORDER BY id DESC
+		LIMIT $%d OFFSET $%d`, recordColumns, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not query audit records: %v", err)
+	}
+	defer rows.Close()
+
+	records := []Record{}
+	for rows.Next() {
+		r, err := scanRecord(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("could not iterate over audit records: %v", err)
+	}
+	return records, total, nil
+}
+
+// Each calls fn for every matching record, oldest first, without loading them all
+func (l *Log) Each(ctx context.Context, f Filter, fn func(Record) error) error {
+	where, args := f.where()
+	rows, err := l.db.QueryContext(ctx, `SELECT `+recordColumns+` FROM audit_log WHERE `+where+` ORDER BY id ASC`, args...)
+	if err != nil {
+		return fmt.Errorf("could not query audit records: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanRecord(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over audit records: %v", err)
+	}
+	return nil
+}
+
+func scanRecord(rows *sql.Rows) (Record, error) {
+	var r Record
+	var before, after []byte
+	if err := rows.Scan(&r.ID, &r.CreatedAt, &r.ActorID, &r.Action, &r.TargetType, &r.TargetID,
+		&r.RequestID, &r.IP, &r.UserAgent, &before, &after); err != nil {
+		return Record{}, fmt.Errorf("could not scan audit record: %v", err)
+	}
+	if before != nil {
+		r.Before = before
+	}
+	if after != nil {
+		r.After = after
+	}
+	return r, nil
+}
@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilter_where(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		filter   Filter
+		want     string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "no filter",
+			want:     "true",
+			wantArgs: []interface{}{},
+		},
+		{
+			name:     "target and time",
+			filter:   Filter{TargetType: TargetConversation, TargetID: "12", From: from},
+			want:     "true AND target_type = $1 AND target_id = $2 AND created_at >= $3",
+			wantArgs: []interface{}{TargetConversation, "12", from},
+		},
+		{
+			name:     "actor and action",
+			filter:   Filter{ActorID: "u1", Action: ActionConversationDelete},
+			want:     "true AND actor_id = $1 AND action = $2",
+			wantArgs: []interface{}{"u1", ActionConversationDelete},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := tt.filter.where()
+			if got != tt.want {
+				t.Errorf("Filter.where() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("Filter.where() args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
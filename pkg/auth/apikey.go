@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// APIKeyPrefix starts every API key, telling them apart from access tokens
+const APIKeyPrefix = "bloom_"
+
+// apiKeyHintLength is the number of characters of a key kept in clear to
+// recognize it in listings
+const apiKeyHintLength = 12
+
+// Scopes of API keys
+const (
+	ScopeChatRead  = "chat:read"
+	ScopeChatWrite = "chat:write"
+	ScopeExport    = "export"
+)
+
+// NewAPIKey returns a random API key, the hint to show in its place once
+// created, and the hash to store
+func NewAPIKey() (key, hint, hash string, err error) {
+	token, _, err := NewToken()
+	if err != nil {
+		return "", "", "", fmt.Errorf("could not generate API key: %v", err)
+	}
+	key = APIKeyPrefix + token
+	return key, key[:apiKeyHintLength], HashToken(key), nil
+}
+
+// IsAPIKey tells whether a bearer token is an API key
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// HasScope tells whether scopes grant scope
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
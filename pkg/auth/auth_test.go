@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestCheckPasswordStrength(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     error
+	}{
+		{
+			name:     "letters and digits",
+			password: "correct7horse",
+		},
+		{
+			name:     "letters and symbols",
+			password: "correct-horse-battery",
+		},
+		{
+			name:     "vietnamese letters count as letters",
+			password: "mậtkhẩu-rất-dài",
+		},
+		{
+			name:     "too short",
+			password: "abc123",
+			want:     ErrPasswordTooShort,
+		},
+		{
+			name:     "too long for bcrypt",
+			password: strings.Repeat("a1", 37),
+			want:     ErrPasswordTooLong,
+		},
+		{
+			name:     "only letters",
+			password: "correcthorsebattery",
+			want:     ErrPasswordTooWeak,
+		},
+		{
+			name:     "only digits",
+			password: "12345678901",
+			want:     ErrPasswordTooWeak,
+		},
+		{
+			name:     "letters and spaces",
+			password: "correct horse battery",
+			want:     ErrPasswordTooWeak,
+		},
+		{
+			name:     "common password",
+			password: "Password123",
+			want:     ErrPasswordCommon,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckPasswordStrength(tt.password); got != tt.want {
+				t.Errorf("CheckPasswordStrength(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct7horse")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if !CheckPassword(hash, "correct7horse") {
+		t.Error("CheckPassword() rejected the right password")
+	}
+	if CheckPassword(hash, "correct7horsf") {
+		t.Error("CheckPassword() accepted a wrong password")
+	}
+	if CheckPassword("", "correct7horse") {
+		t.Error("CheckPassword() accepted a user without password")
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	token, hash, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken() error = %v", err)
+	}
+	if len(token) != 43 {
+		t.Errorf("len(token) = %d, want 43", len(token))
+	}
+	if hash != HashToken(token) {
+		t.Error("NewToken() hash does not match HashToken(token)")
+	}
+	other, _, _ := NewToken()
+	if other == token {
+		t.Error("NewToken() returned the same token twice")
+	}
+}
+
+func testSigningKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, minSigningKeySize))
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       string
+		active     string
+		wantActive string
+		wantErr    bool
+	}{
+		{
+			name:       "single key",
+			spec:       "k1:" + testSigningKey(1),
+			wantActive: "k1",
+		},
+		{
+			name:       "last key is active by default",
+			spec:       "# rotated 2026-10\nk1:" + testSigningKey(1) + "\nk2:" + testSigningKey(2) + "\n",
+			wantActive: "k2",
+		},
+		{
+			name:       "explicit active key",
+			spec:       "k1:" + testSigningKey(1) + ";k2:" + testSigningKey(2),
+			active:     "k1",
+			wantActive: "k1",
+		},
+		{
+			name:    "unknown active key",
+			spec:    "k1:" + testSigningKey(1),
+			active:  "k3",
+			wantErr: true,
+		},
+		{
+			name:    "short key",
+			spec:    "k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+			wantErr: true,
+		},
+		{
+			name:    "duplicate id",
+			spec:    "k1:" + testSigningKey(1) + ",k1:" + testSigningKey(2),
+			wantErr: true,
+		},
+		{
+			name:    "no key",
+			spec:    "# nothing yet",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSigningKeys(tt.spec, tt.active)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSigningKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Active() != tt.wantActive {
+				t.Errorf("ParseSigningKeys() active = %v, want %v", got.Active(), tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestTokens(t *testing.T) {
+	oldKeys, _ := ParseSigningKeys("k1:"+testSigningKey(1), "")
+	rotatedKeys, _ := ParseSigningKeys("k1:"+testSigningKey(1)+",k2:"+testSigningKey(2), "")
+	otherKeys, _ := ParseSigningKeys("k1:"+testSigningKey(3), "")
+	now := time.Now()
+
+	issue := func(keys *SigningKeys) string {
+		token, _, err := NewTokens(keys, 15*time.Minute).Issue("user-1", "session-1")
+		if err != nil {
+			t.Fatalf("Issue() error = %v", err)
+		}
+		return token
+	}
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   "user-1",
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name    string
+		token   string
+		keys    *SigningKeys
+		at      time.Time
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			token: issue(oldKeys),
+			keys:  oldKeys,
+			at:    now,
+		},
+		{
+			name:  "signed by a key rotated out of activity",
+			token: issue(oldKeys),
+			keys:  rotatedKeys,
+			at:    now,
+		},
+		{
+			name:    "expired",
+			token:   issue(oldKeys),
+			keys:    oldKeys,
+			at:      now.Add(16 * time.Minute),
+			wantErr: true,
+		},
+		{
+			name:    "signed by another key with the same id",
+			token:   issue(otherKeys),
+			keys:    oldKeys,
+			at:      now,
+			wantErr: true,
+		},
+		{
+			name:    "signed by a removed key",
+			token:   issue(rotatedKeys),
+			keys:    oldKeys,
+			at:      now,
+			wantErr: true,
+		},
+		{
+			name:    "unsigned",
+			token:   unsigned,
+			keys:    oldKeys,
+			at:      now,
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			token:   "not.a.token",
+			keys:    oldKeys,
+			at:      now,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := NewTokens(tt.keys, 15*time.Minute)
+			tokens.now = func() time.Time { return tt.at }
+			claims, err := tokens.Verify(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (claims.Subject != "user-1" || claims.SessionID != "session-1") {
+				t.Errorf("Verify() claims = %+v, want user-1 of session-1", claims)
+			}
+		})
+	}
+}
+
+func TestTokens_VerifyCookie(t *testing.T) {
+	oldKeys, _ := ParseSigningKeys("k1:"+testSigningKey(1), "")
+	rotatedKeys, _ := ParseSigningKeys("k1:"+testSigningKey(1)+",k2:"+testSigningKey(2), "")
+	otherKeys, _ := ParseSigningKeys("k1:"+testSigningKey(3), "")
+
+	sign := func(keys *SigningKeys, name, value string) string {
+		return NewTokens(keys, time.Minute).SignCookie(name, value)
+	}
+	signed := sign(oldKeys, "user_id", "visitor.1")
+
+	tests := []struct {
+		name   string
+		signed string
+		keys   *SigningKeys
+		want   string
+		wantOK bool
+	}{
+		{
+			name:   "valid",
+			signed: signed,
+			keys:   oldKeys,
+			want:   "visitor.1",
+			wantOK: true,
+		},
+		{
+			name:   "signed by a key rotated out of activity",
+			signed: signed,
+			keys:   rotatedKeys,
+			want:   "visitor.1",
+			wantOK: true,
+		},
+		{
+			name:   "signed by another key with the same id",
+			signed: sign(otherKeys, "user_id", "visitor.1"),
+			keys:   oldKeys,
+		},
+		{
+			name:   "signed by a removed key",
+			signed: sign(rotatedKeys, "user_id", "visitor.1"),
+			keys:   oldKeys,
+		},
+		{
+			name:   "signed for another cookie",
+			signed: sign(oldKeys, "other", "visitor.1"),
+			keys:   oldKeys,
+		},
+		{
+			name:   "value changed",
+			signed: "visitor.2" + strings.TrimPrefix(signed, "visitor.1"),
+			keys:   oldKeys,
+		},
+		{
+			name:   "unsigned",
+			signed: "visitor",
+			keys:   oldKeys,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := NewTokens(tt.keys, time.Minute).VerifyCookie("user_id", tt.signed)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("VerifyCookie() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, hint, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("NewAPIKey() error = %v", err)
+	}
+	if !IsAPIKey(key) {
+		t.Errorf("IsAPIKey(%q) = false, want true", key)
+	}
+	if !strings.HasPrefix(key, hint) || len(hint) >= len(key) {
+		t.Errorf("hint %q does not start key %q", hint, key)
+	}
+	if hash != HashToken(key) {
+		t.Errorf("hash = %q, want the hash of the key", hash)
+	}
+
+	other, _, _, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("NewAPIKey() error = %v", err)
+	}
+	if other == key {
+		t.Error("NewAPIKey() returned the same key twice")
+	}
+}
+
+func TestIsAPIKey(t *testing.T) {
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{token: "bloom_abc", want: true},
+		{token: "eyJhbGciOiJIUzI1NiJ9.e30.sig", want: false},
+		{token: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := IsAPIKey(tt.token); got != tt.want {
+			t.Errorf("IsAPIKey(%q) = %v, want %v", tt.token, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// SignCookie signs the value of the cookie name with the active signing key, as
+// value.kid.mac with the key id and the HMAC-SHA256 encoded in base64url. The
+// name is part of the MAC, a value signed for a cookie is not valid for another.
+func (t *Tokens) SignCookie(name, value string) string {
+	kid := t.keys.active
+	return value + "." + base64.RawURLEncoding.EncodeToString([]byte(kid)) + "." +
+		base64.RawURLEncoding.EncodeToString(cookieMAC(t.keys.keys[kid], name, value))
+}
+
+// VerifyCookie returns the value of a cookie signed by SignCookie, ok is false
+// when the signature is missing, wrong or made with a key no longer in the set
+func (t *Tokens) VerifyCookie(name, signed string) (value string, ok bool) {
+	rest, encodedMAC, found := cutLast(signed, ".")
+	if !found {
+		return "", false
+	}
+	value, encodedKid, found := cutLast(rest, ".")
+	if !found {
+		return "", false
+	}
+	kid, err := base64.RawURLEncoding.DecodeString(encodedKid)
+	if err != nil {
+		return "", false
+	}
+	key, known := t.keys.keys[string(kid)]
+	if !known {
+		return "", false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, cookieMAC(key, name, value)) {
+		return "", false
+	}
+	return value, true
+}
+
+// cookieMAC keeps cookie MACs apart from the signatures of access tokens made
+// with the same keys
+func cookieMAC(key []byte, name, value string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("cookie\x00" + name + "\x00" + value))
+	return mac.Sum(nil)
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minSigningKeySize is the shortest accepted HMAC signing key
+const minSigningKeySize = 32
+
+// tokenIssuer is the iss claim of access tokens
+const tokenIssuer = "bloom-be"
+
+// ErrInvalidToken is returned for access tokens that are malformed, expired or
+// not signed by a known key
+var ErrInvalidToken = errors.New("access token is invalid or expired")
+
+// SigningKeys holds the keys signing access tokens. New tokens are signed with
+// the active key, older keys stay to verify the tokens they signed until those
+// expire.
+type SigningKeys struct {
+	keys   map[string][]byte
+	active string
+}
+
+// LoadSigningKeys reads the signing keys from JWT_SIGNING_KEYS and
+// JWT_SIGNING_KEY_FILE. In the local environment a random key is generated when
+// neither is set, so tokens do not survive a restart.
+func LoadSigningKeys(cfg config.Config) (*SigningKeys, error) {
+	spec := cfg.JWTSigningKeys
+	if cfg.JWTSigningKeyFile != "" {
+		b, err := os.ReadFile(cfg.JWTSigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read signing key file: %v", err)
+		}
+		spec += "\n" + string(b)
+	}
+	if strings.TrimSpace(spec) == "" {
+		if cfg.Env != "local" {
+			return nil, errors.New("JWT_SIGNING_KEYS or JWT_SIGNING_KEY_FILE must be set")
+		}
+		key := make([]byte, minSigningKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("could not generate signing key: %v", err)
+		}
+		return &SigningKeys{keys: map[string][]byte{"local": key}, active: "local"}, nil
+	}
+	return ParseSigningKeys(spec, cfg.JWTActiveKey)
+}
+
+// ParseSigningKeys parses signing keys written as id:base64 and separated by
+// newlines, commas or semicolons. Lines starting with # are ignored. The active
+// key defaults to the last one listed.
+func ParseSigningKeys(spec, active string) (*SigningKeys, error) {
+	k := &SigningKeys{keys: map[string][]byte{}}
+	fields := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == '\n' || r == ',' || r == ';'
+	})
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(field, ":")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			return nil, fmt.Errorf("signing key %q is not written as id:base64", field)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s is not valid base64: %v", id, err)
+		}
+		if len(key) < minSigningKeySize {
+			return nil, fmt.Errorf("signing key %s must be at least %d bytes, got %d", id, minSigningKeySize, len(key))
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("signing key %s is listed twice", id)
+		}
+		k.keys[id] = key
+		k.active = id
+	}
+
+	if len(k.keys) == 0 {
+		return nil, errors.New("no signing key found")
+	}
+	if active != "" {
+		if _, ok := k.keys[active]; !ok {
+			return nil, fmt.Errorf("active signing key %s is not in the key set", active)
+		}
+		k.active = active
+	}
+	return k, nil
+}
+
+// Active returns the id of the key signing new tokens
+func (k *SigningKeys) Active() string {
+	return k.active
+}
+
+// AccessClaims are the claims of an access token
+type AccessClaims struct {
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Tokens issues and verifies access tokens
+type Tokens struct {
+	keys *SigningKeys
+	ttl  time.Duration
+	now  func() time.Time
+}
+
+// NewTokens makes access tokens valid for ttl
+func NewTokens(keys *SigningKeys, ttl time.Duration) *Tokens {
+	return &Tokens{keys: keys, ttl: ttl, now: time.Now}
+}
+
+// Issue signs an access token of the user, tied to the session it was refreshed from
+func (t *Tokens) Issue(userID, sessionID string) (string, time.Time, error) {
+	now := t.now()
+	expiresAt := now.Add(t.ttl)
+	claims := AccessClaims{
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = t.keys.active
+	signed, err := token.SignedString(t.keys.keys[t.keys.active])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("could not sign access token: %v", err)
+	}
+	return signed, expiresAt, nil
+}
+
+// Verify checks the signature and expiry of an access token and returns its claims
+func (t *Tokens) Verify(token string) (AccessClaims, error) {
+	var claims AccessClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := t.keys.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(t.now),
+	)
+	if err != nil || claims.Subject == "" {
+		return AccessClaims{}, ErrInvalidToken
+	}
+	return claims, nil
+}
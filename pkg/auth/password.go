@@ -0,0 +1,95 @@
+// Package auth holds the credentials logic of user accounts: password hashing
+// and strength rules, the random refresh tokens identifying sessions and the
+// signed access tokens authenticating requests.
+package auth
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password length limits, bcrypt ignores everything after 72 bytes
+const (
+	MinPasswordLength = 10
+	MaxPasswordBytes  = 72
+)
+
+// bcryptCost is the work factor of new password hashes
+const bcryptCost = 12
+
+// Errors of CheckPasswordStrength
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordTooWeak  = errors.New("password must mix letters with digits or symbols")
+	ErrPasswordCommon   = errors.New("password is too common")
+)
+
+// commonPasswords are rejected even when they pass the other rules
+var commonPasswords = map[string]bool{
+	"password123":  true,
+	"password1234": true,
+	"qwerty12345":  true,
+	"qwertyuiop1":  true,
+	"1234567890a":  true,
+	"a1234567890":  true,
+	"iloveyou123":  true,
+	"welcome123":   true,
+	"admin12345":   true,
+	"letmein1234":  true,
+	"matkhau123":   true,
+}
+
+// dummyHash is compared against when a login names an unknown user, so the
+// response time does not reveal which usernames exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcryptCost)
+
+// CheckPasswordStrength returns why a password is not strong enough, or nil
+func CheckPasswordStrength(password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if len(password) > MaxPasswordBytes {
+		return ErrPasswordTooLong
+	}
+
+	var letter, other bool
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			letter = true
+		} else if !unicode.IsSpace(r) {
+			other = true
+		}
+	}
+	if !letter || !other {
+		return ErrPasswordTooWeak
+	}
+	if commonPasswords[strings.ToLower(password)] {
+		return ErrPasswordCommon
+	}
+	return nil
+}
+
+// HashPassword hashes a password for storage
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches a hash made by HashPassword.
+// An empty hash, for users without a password, never matches but takes as long
+// as a real comparison.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password)) //nolint:errcheck // only spends time
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
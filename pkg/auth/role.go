@@ -0,0 +1,7 @@
+package auth
+
+// Roles of users. Admins may manage users and read the audit log.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
@@ -0,0 +1,96 @@
+// Package authz decides whether a user may act on the resource a request
+// targets. Routes declare which resource they act on with a Rule, the
+// Authorizer loads that resource and asks the Policy. Resources the user may
+// not act on are reported as not found, so their existence is not disclosed.
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Action is what a request does to a resource
+type Action string
+
+// Actions of the rules
+const (
+	Read   Action = "read"
+	Write  Action = "write"
+	Delete Action = "delete"
+)
+
+// Resource types
+const (
+	Conversation = "conversation"
+	Message      = "message"
+	Folder       = "folder"
+	Tag          = "tag"
+)
+
+// ErrNotFound is returned for resources that do not exist and for those the
+// user may not act on
+var ErrNotFound = errors.New("resource not found")
+
+// ErrBodyTooLarge is returned for request bodies too large to read the id from
+var ErrBodyTooLarge = errors.New("request body is too large")
+
+// Resource is the owner and state of a resource, as far as authorization cares
+type Resource struct {
+	Type    string
+	ID      string
+	OwnerID string
+	// Trashed is set for conversations in the trash and their messages
+	Trashed bool
+}
+
+// Loader loads resources, returning ErrNotFound for those that do not exist
+type Loader interface {
+	Load(ctx context.Context, resourceType, id string) (Resource, error)
+}
+
+// Policy decides whether a user may perform an action on a resource
+type Policy interface {
+	Allow(userID string, action Action, r Resource) bool
+}
+
+// PolicyFunc adapts a function to Policy
+type PolicyFunc func(userID string, action Action, r Resource) bool
+
+// Allow calls f
+func (f PolicyFunc) Allow(userID string, action Action, r Resource) bool {
+	return f(userID, action, r)
+}
+
+// OwnerPolicy lets users act on their own resources only
+var OwnerPolicy = PolicyFunc(func(userID string, action Action, r Resource) bool {
+	return userID != "" && r.OwnerID == userID
+})
+
+// Authorizer loads the resources targeted by requests and checks them against a policy
+type Authorizer struct {
+	loader Loader
+	policy Policy
+}
+
+// New makes an authorizer
+func New(loader Loader, policy Policy) *Authorizer {
+	return &Authorizer{loader: loader, policy: policy}
+}
+
+// Authorize returns the resource of the rule with the given id when the user
+// may act on it, and ErrNotFound when it does not exist, is not in the state
+// the rule requires or the policy denies it.
+func (a *Authorizer) Authorize(ctx context.Context, userID string, rule Rule, id string) (Resource, error) {
+	r, err := a.loader.Load(ctx, rule.Resource, id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return Resource{}, ErrNotFound
+		}
+		return Resource{}, fmt.Errorf("could not load %s %s: %v", rule.Resource, id, err)
+	}
+	if !rule.State.matches(r) || !a.policy.Allow(userID, rule.Action, r) {
+		return Resource{}, ErrNotFound
+	}
+	return r, nil
+}
@@ -0,0 +1,206 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	owner    = "owner"
+	stranger = "stranger"
+)
+
+type fakeLoader map[string]Resource
+
+func (l fakeLoader) Load(ctx context.Context, resourceType, id string) (Resource, error) {
+	r, ok := l[resourceType+":"+id]
+	if !ok {
+		return Resource{}, ErrNotFound
+	}
+	return r, nil
+}
+
+var errBroken = errors.New("connection refused")
+
+type brokenLoader struct{}
+
+func (brokenLoader) Load(ctx context.Context, resourceType, id string) (Resource, error) {
+	return Resource{}, errBroken
+}
+
+// testLoader has, for every resource type, the id 1 owned by owner, the id 2
+// owned by owner and in the trash, and the id 3 owned by stranger
+func testLoader() fakeLoader {
+	l := fakeLoader{}
+	for _, resourceType := range []string{Conversation, Message, Folder, Tag} {
+		l[resourceType+":1"] = Resource{Type: resourceType, ID: "1", OwnerID: owner}
+		l[resourceType+":2"] = Resource{Type: resourceType, ID: "2", OwnerID: owner, Trashed: true}
+		l[resourceType+":3"] = Resource{Type: resourceType, ID: "3", OwnerID: stranger}
+	}
+	return l
+}
+
+func TestAuthorize(t *testing.T) {
+	read := Rule{Resource: Conversation, Action: Read}
+	restore := Rule{Resource: Conversation, Action: Write, State: Trashed}
+
+	tests := []struct {
+		name    string
+		loader  Loader
+		userID  string
+		rule    Rule
+		id      string
+		wantErr error
+	}{
+		{name: "owner", loader: testLoader(), userID: owner, rule: read, id: "1"},
+		{name: "other user", loader: testLoader(), userID: stranger, rule: read, id: "1", wantErr: ErrNotFound},
+		{name: "no user", loader: testLoader(), userID: "", rule: read, id: "1", wantErr: ErrNotFound},
+		{name: "missing", loader: testLoader(), userID: owner, rule: read, id: "9", wantErr: ErrNotFound},
+		{name: "trashed", loader: testLoader(), userID: owner, rule: read, id: "2", wantErr: ErrNotFound},
+		{name: "trashed only", loader: testLoader(), userID: owner, rule: restore, id: "2"},
+		{name: "trashed only but active", loader: testLoader(), userID: owner, rule: restore, id: "1", wantErr: ErrNotFound},
+		{name: "loader failure", loader: brokenLoader{}, userID: owner, rule: read, id: "1", wantErr: errBroken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.loader, OwnerPolicy).Authorize(context.Background(), tt.userID, tt.rule, tt.id)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Authorize() error = %v, want nil", err)
+			}
+			if tt.wantErr == ErrNotFound && !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Authorize() error = %v, want %v", err, ErrNotFound)
+			}
+			if tt.wantErr == errBroken && (err == nil || errors.Is(err, ErrNotFound)) {
+				t.Fatalf("Authorize() error = %v, want a load error", err)
+			}
+		})
+	}
+}
+
+// ruleRequest builds a request to the route of the rule targeting id
+func ruleRequest(rule Rule, id string) *http.Request {
+	path := rule.Path
+	var body string
+	if rule.Body {
+		body = `{"` + rule.Param + `": "` + id + `"}`
+	} else {
+		path = strings.Replace(path, ":"+rule.Param, id, 1)
+	}
+	req := httptest.NewRequest(rule.Method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestMiddleware_Rules(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := New(testLoader(), OwnerPolicy)
+	r := gin.New()
+	r.Use(a.Middleware(Rules, func(c *gin.Context) string {
+		return c.GetHeader("X-Test-User")
+	}, func(c *gin.Context, rule Rule, id string, err error) {
+		c.AbortWithStatus(http.StatusNotFound)
+	}))
+	seen := map[string]bool{}
+	for _, rule := range Rules {
+		if seen[rule.Method+rule.Path] {
+			continue
+		}
+		seen[rule.Method+rule.Path] = true
+		r.Handle(rule.Method, rule.Path, func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+	}
+
+	for _, rule := range Rules {
+		activeID, trashedID := "1", "2"
+		if rule.State == Trashed {
+			activeID, trashedID = "2", "1"
+		}
+		cases := []struct {
+			name   string
+			userID string
+			id     string
+			want   int
+		}{
+			{name: "owner", userID: owner, id: activeID, want: http.StatusOK},
+			{name: "other user", userID: stranger, id: activeID, want: http.StatusNotFound},
+			{name: "owned by other user", userID: owner, id: "3", want: http.StatusNotFound},
+			{name: "missing", userID: owner, id: "9", want: http.StatusNotFound},
+		}
+		if (rule.Resource == Conversation || rule.Resource == Message) && rule.State != AnyState {
+			cases = append(cases, struct {
+				name   string
+				userID string
+				id     string
+				want   int
+			}{name: "wrong trash state", userID: owner, id: trashedID, want: http.StatusNotFound})
+		}
+
+		for _, tc := range cases {
+			t.Run(rule.Method+" "+rule.Path+" "+rule.Param+" "+tc.name, func(t *testing.T) {
+				req := ruleRequest(rule, tc.id)
+				req.Header.Set("X-Test-User", tc.userID)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if w.Code != tc.want {
+					t.Errorf("status = %d, want %d", w.Code, tc.want)
+				}
+			})
+		}
+	}
+}
+
+func TestMiddleware_Body(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := New(testLoader(), OwnerPolicy)
+	rule := Rule{Method: "POST", Path: "/edit-chat", Resource: Conversation, Action: Write, Param: "conversation_id", Body: true}
+	r := gin.New()
+	r.POST("/edit-chat", a.Middleware([]Rule{rule}, func(c *gin.Context) string {
+		return owner
+	}, func(c *gin.Context, rule Rule, id string, err error) {
+		c.AbortWithStatus(http.StatusNotFound)
+	}), func(c *gin.Context) {
+		var req struct {
+			ConversationID string `json:"conversation_id"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		c.String(http.StatusOK, req.ConversationID)
+	})
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "owned", body: `{"conversation_id": "1"}`, wantCode: http.StatusOK, wantBody: "1"},
+		{name: "not owned", body: `{"conversation_id": "3"}`, wantCode: http.StatusNotFound},
+		{name: "other spelling of the field", body: `{"Conversation_ID": "3"}`, wantCode: http.StatusNotFound},
+		{name: "two spellings", body: `{"conversation_id": "1", "CONVERSATION_ID": "3"}`, wantCode: http.StatusNotFound},
+		{name: "number", body: `{"conversation_id": 3}`, wantCode: http.StatusNotFound},
+		{name: "no id is left to the handler", body: `{}`, wantCode: http.StatusOK, wantBody: ""},
+		{name: "not json is left to the handler", body: `conversation_id=3`, wantCode: http.StatusBadRequest},
+		{name: "too large", body: `{"conversation_id": "1", "pad": "` + strings.Repeat("a", maxPeekedBody) + `"}`, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("POST", "/edit-chat", strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK && w.Body.String() != tt.wantBody {
+				t.Errorf("handler read conversation %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package authz
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// resourceQueries select the owner and trash state of a resource by id
+var resourceQueries = map[string]string{
+	Conversation: `
+		SELECT coalesce(user_id, ''), deleted_at IS NOT NULL
+		FROM conversations WHERE id = $1`,
+	Message: `
+		SELECT coalesce(c.user_id, ''), c.deleted_at IS NOT NULL
+		FROM messages m JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.id = $1`,
+	Folder: `SELECT user_id, false FROM folders WHERE id = $1`,
+	Tag:    `SELECT user_id, false FROM tags WHERE id = $1`,
+}
+
+// SQLLoader loads resources from the database
+type SQLLoader struct {
+	db *sql.DB
+}
+
+// NewSQLLoader makes a loader reading db
+func NewSQLLoader(db *sql.DB) *SQLLoader {
+	return &SQLLoader{db: db}
+}
+
+// Load reads the owner and state of a resource
+func (l *SQLLoader) Load(ctx context.Context, resourceType, id string) (Resource, error) {
+	query, ok := resourceQueries[resourceType]
+	if !ok {
+		return Resource{}, fmt.Errorf("unknown resource type %s", resourceType)
+	}
+	// Every resource has an integer id, anything else cannot exist
+	if _, err := strconv.ParseInt(id, 10, 32); err != nil {
+		return Resource{}, ErrNotFound
+	}
+
+	r := Resource{Type: resourceType, ID: id}
+	err := l.db.QueryRowContext(ctx, query, id).Scan(&r.OwnerID, &r.Trashed)
+	if err == sql.ErrNoRows {
+		return Resource{}, ErrNotFound
+	}
+	if err != nil {
+		return Resource{}, err
+	}
+	return r, nil
+}
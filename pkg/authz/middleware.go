@@ -0,0 +1,103 @@
+package authz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPeekedBody bounds the request bodies read for the ids of body rules
+const maxPeekedBody = 1 << 20
+
+// DenyFunc aborts a request denied by a rule, with ErrNotFound, ErrBodyTooLarge
+// or an error loading the resource
+type DenyFunc func(c *gin.Context, rule Rule, id string, err error)
+
+// Middleware authorizes the requests of routes listed in rules, other routes
+// are passed on. userID reads the authenticated user of the request.
+func (a *Authorizer) Middleware(rules []Rule, userID func(*gin.Context) string, deny DenyFunc) gin.HandlerFunc {
+	byRoute := routeRules(rules)
+	return func(c *gin.Context) {
+		for _, rule := range byRoute[c.Request.Method+" "+c.FullPath()] {
+			id, err := ruleID(c, rule)
+			if err != nil {
+				deny(c, rule, id, err)
+				return
+			}
+			if id == "" {
+				continue
+			}
+			if _, err := a.Authorize(c.Request.Context(), userID(c), rule, id); err != nil {
+				deny(c, rule, id, err)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// ruleID reads the id targeted by the rule from the request
+func ruleID(c *gin.Context, rule Rule) (string, error) {
+	if !rule.Body {
+		return c.Param(rule.Param), nil
+	}
+
+	body, err := peekBody(c)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		// Not a JSON object, the handler rejects it when binding
+		return "", nil
+	}
+
+	// Handlers bind fields case insensitively, every spelling of the field is
+	// read so that none escapes the check
+	id := ""
+	for key, value := range fields {
+		if !strings.EqualFold(key, rule.Param) {
+			continue
+		}
+		var v string
+		switch value := value.(type) {
+		case string:
+			v = value
+		case json.Number:
+			v = value.String()
+		default:
+			continue
+		}
+		if id != "" && v != id {
+			return "", ErrNotFound
+		}
+		id = v
+	}
+	return id, nil
+}
+
+// peekBody reads the request body and puts it back for the handler
+func peekBody(c *gin.Context) ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPeekedBody+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not read request body: %v", err)
+	}
+	rest := c.Request.Body
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), rest), rest}
+	if len(body) > maxPeekedBody {
+		return nil, ErrBodyTooLarge
+	}
+	return body, nil
+}
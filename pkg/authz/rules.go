@@ -0,0 +1,74 @@
+package authz
+
+// State is the trash state a conversation must be in for a rule
+type State int
+
+// States of the rules, conversations outside the trash by default
+const (
+	Active State = iota
+	Trashed
+	AnyState
+)
+
+func (s State) matches(r Resource) bool {
+	switch s {
+	case Active:
+		return !r.Trashed
+	case Trashed:
+		return r.Trashed
+	default:
+		return true
+	}
+}
+
+// Rule declares the resource a route acts on. The id is read from the path
+// parameter Param, or from the JSON body field Param when Body is set. Requests
+// without id are passed on, the handler rejects them when the id is required.
+type Rule struct {
+	Method   string
+	Path     string
+	Resource string
+	Action   Action
+	Param    string
+	Body     bool
+	State    State
+}
+
+// Rules lists every route acting on a given conversation, message, folder or
+// tag. bulk-chat acts on many conversations at once and checks each of them,
+// and its folder, in the handler as it reports results per conversation.
+// send-chat accepts conversations in the trash, the handler tells the user to
+// restore them.
+var Rules = []Rule{
+	{Method: "GET", Path: "/get-chat-by-id/:conversation_id", Resource: Conversation, Action: Read, Param: "conversation_id"},
+	{Method: "POST", Path: "/send-chat", Resource: Conversation, Action: Write, Param: "conversation_id", Body: true, State: AnyState},
+	{Method: "GET", Path: "/get-all-msgs-by-id/:conversation_id", Resource: Conversation, Action: Read, Param: "conversation_id"},
+	{Method: "DELETE", Path: "/delete-chat/:conversation_id", Resource: Conversation, Action: Delete, Param: "conversation_id"},
+	{Method: "POST", Path: "/edit-chat", Resource: Conversation, Action: Write, Param: "conversation_id", Body: true},
+	{Method: "POST", Path: "/fork-chat", Resource: Conversation, Action: Read, Param: "conversation_id", Body: true},
+	{Method: "POST", Path: "/restore-chat/:conversation_id", Resource: Conversation, Action: Write, Param: "conversation_id", State: Trashed},
+	{Method: "DELETE", Path: "/purge-chat/:conversation_id", Resource: Conversation, Action: Delete, Param: "conversation_id", State: Trashed},
+	{Method: "POST", Path: "/pin-chat", Resource: Conversation, Action: Write, Param: "conversation_id", Body: true},
+	{Method: "POST", Path: "/archive-chat", Resource: Conversation, Action: Write, Param: "conversation_id", Body: true},
+	{Method: "POST", Path: "/move-chat", Resource: Conversation, Action: Write, Param: "conversation_id", Body: true},
+	{Method: "POST", Path: "/move-chat", Resource: Folder, Action: Write, Param: "folder_id", Body: true},
+	{Method: "POST", Path: "/edit-folder", Resource: Folder, Action: Write, Param: "folder_id", Body: true},
+	{Method: "DELETE", Path: "/delete-folder/:folder_id", Resource: Folder, Action: Delete, Param: "folder_id"},
+	{Method: "POST", Path: "/tag-chat", Resource: Conversation, Action: Write, Param: "conversation_id", Body: true},
+	{Method: "POST", Path: "/untag-chat", Resource: Conversation, Action: Write, Param: "conversation_id", Body: true},
+	{Method: "DELETE", Path: "/delete-tag/:tag_id", Resource: Tag, Action: Delete, Param: "tag_id"},
+	{Method: "GET", Path: "/export-chat/:conversation_id", Resource: Conversation, Action: Read, Param: "conversation_id"},
+	{Method: "POST", Path: "/share-chat", Resource: Conversation, Action: Read, Param: "conversation_id", Body: true},
+	{Method: "POST", Path: "/set-feedback", Resource: Message, Action: Write, Param: "message_id", Body: true},
+	{Method: "DELETE", Path: "/clear-feedback/:message_id", Resource: Message, Action: Write, Param: "message_id"},
+}
+
+// routeRules indexes rules by method and path
+func routeRules(rules []Rule) map[string][]Rule {
+	byRoute := map[string][]Rule{}
+	for _, rule := range rules {
+		key := rule.Method + " " + rule.Path
+		byRoute[key] = append(byRoute[key], rule)
+	}
+	return byRoute
+}
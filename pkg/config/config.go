@@ -22,6 +22,65 @@ type Config struct {
 	DBPass          string
 	DBSSLMode       string
 	FrontendBaseURL string
+
+	DBMaxOpenConns     int
+	DBMaxIdleConns     int
+	DBConnMaxLifetime  time.Duration
+	DBConnMaxIdleTime  time.Duration
+	DBStatementTimeout time.Duration
+	DBReplicaDSN       string
+
+	LLMBaseURL        string
+	LLMAPIKey         string
+	EmbeddingProvider string
+	EmbeddingModel    string
+
+	TrashRetentionDays int
+
+	RetentionDays         int
+	RetentionExemptPinned bool
+
+	EncryptionKeys      string
+	EncryptionKeyFile   string
+	EncryptionActiveKey string
+
+	AdminUserIDs      string
+	DailyMessageQuota int
+
+	SessionTTL        time.Duration
+	AccessTokenTTL    time.Duration
+	JWTSigningKeys    string
+	JWTSigningKeyFile string
+	JWTActiveKey      string
+	AnonymousUsers    bool
+	PasswordLogin     bool
+
+	OIDCIssuer        string
+	OIDCClientID      string
+	OIDCClientSecret  string
+	OIDCRedirectURL   string
+	OIDCScopes        string
+	OIDCUsernameClaim string
+	OIDCEmailClaim    string
+
+	RateLimitBackend       string
+	RateLimitRedisURL      string
+	RateLimitChatPerMinute int
+	RateLimitReadPerMinute int
+	TrustedProxies         string
+
+	MailBackend          string
+	MailFrom             string
+	MailOutboxDir        string
+	SMTPHost             string
+	SMTPPort             int
+	SMTPUsername         string
+	SMTPPassword         string
+	EmailVerificationTTL time.Duration
+	PasswordResetTTL     time.Duration
+
+	DataExportTTL        time.Duration
+	AccountDeletionGrace time.Duration
 }
 
 // GetCORS in config
@@ -56,13 +115,80 @@ func generateConfigFromViper(v *viper.Viper) Config {
 		DBPort:    v.GetString("DB_PORT"),
 		DBUser:    v.GetString("DB_USER"),
 		DBName:    v.GetString("DB_NAME"),
-		DBPass:    v.GetString("DB_PASS"),
+		DBPass:    dbPassword(v),
 		DBSSLMode: v.GetString("DB_SSL_MODE"),
 
+		DBMaxOpenConns:     v.GetInt("DB_MAX_OPEN_CONNS"),
+		DBMaxIdleConns:     v.GetInt("DB_MAX_IDLE_CONNS"),
+		DBConnMaxLifetime:  v.GetDuration("DB_CONN_MAX_LIFETIME"),
+		DBConnMaxIdleTime:  v.GetDuration("DB_CONN_MAX_IDLE_TIME"),
+		DBStatementTimeout: v.GetDuration("DB_STATEMENT_TIMEOUT"),
+		DBReplicaDSN:       v.GetString("DB_REPLICA_DSN"),
+
 		FrontendBaseURL: v.GetString("FRONTEND_BASE_URL"),
+
+		LLMBaseURL:        v.GetString("LLM_BASE_URL"),
+		LLMAPIKey:         v.GetString("LLM_API_KEY"),
+		EmbeddingProvider: v.GetString("EMBEDDING_PROVIDER"),
+		EmbeddingModel:    v.GetString("EMBEDDING_MODEL"),
+
+		TrashRetentionDays: v.GetInt("TRASH_RETENTION_DAYS"),
+
+		RetentionDays:         v.GetInt("RETENTION_DAYS"),
+		RetentionExemptPinned: v.GetBool("RETENTION_EXEMPT_PINNED"),
+
+		EncryptionKeys:      v.GetString("ENCRYPTION_KEYS"),
+		EncryptionKeyFile:   v.GetString("ENCRYPTION_KEY_FILE"),
+		EncryptionActiveKey: v.GetString("ENCRYPTION_ACTIVE_KEY"),
+
+		AdminUserIDs:      v.GetString("ADMIN_USER_IDS"),
+		DailyMessageQuota: v.GetInt("DAILY_MESSAGE_QUOTA"),
+
+		SessionTTL:        v.GetDuration("SESSION_TTL"),
+		AccessTokenTTL:    v.GetDuration("ACCESS_TOKEN_TTL"),
+		JWTSigningKeys:    v.GetString("JWT_SIGNING_KEYS"),
+		JWTSigningKeyFile: v.GetString("JWT_SIGNING_KEY_FILE"),
+		JWTActiveKey:      v.GetString("JWT_ACTIVE_KEY"),
+		AnonymousUsers:    v.GetBool("ANONYMOUS_USERS"),
+		PasswordLogin:     v.GetBool("PASSWORD_LOGIN"),
+
+		OIDCIssuer:        v.GetString("OIDC_ISSUER"),
+		OIDCClientID:      v.GetString("OIDC_CLIENT_ID"),
+		OIDCClientSecret:  v.GetString("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:   v.GetString("OIDC_REDIRECT_URL"),
+		OIDCScopes:        v.GetString("OIDC_SCOPES"),
+		OIDCUsernameClaim: v.GetString("OIDC_USERNAME_CLAIM"),
+		OIDCEmailClaim:    v.GetString("OIDC_EMAIL_CLAIM"),
+
+		RateLimitBackend:       v.GetString("RATE_LIMIT_BACKEND"),
+		RateLimitRedisURL:      v.GetString("RATE_LIMIT_REDIS_URL"),
+		RateLimitChatPerMinute: v.GetInt("RATE_LIMIT_CHAT_PER_MINUTE"),
+		RateLimitReadPerMinute: v.GetInt("RATE_LIMIT_READ_PER_MINUTE"),
+		TrustedProxies:         v.GetString("TRUSTED_PROXIES"),
+
+		MailBackend:          v.GetString("MAIL_BACKEND"),
+		MailFrom:             v.GetString("MAIL_FROM"),
+		MailOutboxDir:        v.GetString("MAIL_OUTBOX_DIR"),
+		SMTPHost:             v.GetString("SMTP_HOST"),
+		SMTPPort:             v.GetInt("SMTP_PORT"),
+		SMTPUsername:         v.GetString("SMTP_USERNAME"),
+		SMTPPassword:         v.GetString("SMTP_PASSWORD"),
+		EmailVerificationTTL: v.GetDuration("EMAIL_VERIFICATION_TTL"),
+		PasswordResetTTL:     v.GetDuration("PASSWORD_RESET_TTL"),
+
+		DataExportTTL:        v.GetDuration("DATA_EXPORT_TTL"),
+		AccountDeletionGrace: v.GetDuration("ACCOUNT_DELETION_GRACE"),
 	}
 }
 
+// dbPassword reads DB_PASS, falling back to DB_PASSWORD used by earlier releases
+func dbPassword(v *viper.Viper) string {
+	if pass := v.GetString("DB_PASS"); pass != "" {
+		return pass
+	}
+	return v.GetString("DB_PASSWORD")
+}
+
 // DefaultConfigLoaders is default loader list
 func DefaultConfigLoaders() []Loader {
 	loaders := []Loader{}
@@ -78,6 +204,33 @@ func LoadConfig(loaders []Loader) Config {
 	v := viper.New()
 	v.SetDefault("PORT", "8080")
 	v.SetDefault("ENV", "local")
+	v.SetDefault("DB_NAME", "bloom")
+	v.SetDefault("DB_SSL_MODE", "disable")
+	v.SetDefault("DB_MAX_OPEN_CONNS", 25)
+	v.SetDefault("DB_MAX_IDLE_CONNS", 5)
+	v.SetDefault("DB_CONN_MAX_LIFETIME", "30m")
+	v.SetDefault("DB_CONN_MAX_IDLE_TIME", "5m")
+	v.SetDefault("DB_STATEMENT_TIMEOUT", "60s")
+	v.SetDefault("LLM_BASE_URL", "https://chatapi.akash.network/api/v1")
+	v.SetDefault("TRASH_RETENTION_DAYS", 30)
+	v.SetDefault("RETENTION_EXEMPT_PINNED", true)
+	v.SetDefault("SESSION_TTL", "720h")
+	v.SetDefault("ACCESS_TOKEN_TTL", "15m")
+	v.SetDefault("ANONYMOUS_USERS", false)
+	v.SetDefault("PASSWORD_LOGIN", true)
+	v.SetDefault("OIDC_SCOPES", "openid email profile")
+	v.SetDefault("OIDC_USERNAME_CLAIM", "preferred_username")
+	v.SetDefault("OIDC_EMAIL_CLAIM", "email")
+	v.SetDefault("RATE_LIMIT_BACKEND", "memory")
+	v.SetDefault("RATE_LIMIT_CHAT_PER_MINUTE", 20)
+	v.SetDefault("RATE_LIMIT_READ_PER_MINUTE", 300)
+	v.SetDefault("MAIL_BACKEND", "outbox")
+	v.SetDefault("MAIL_FROM", "Bloom <no-reply@localhost>")
+	v.SetDefault("SMTP_PORT", 587)
+	v.SetDefault("EMAIL_VERIFICATION_TTL", "48h")
+	v.SetDefault("PASSWORD_RESET_TTL", "1h")
+	v.SetDefault("DATA_EXPORT_TTL", "168h")
+	v.SetDefault("ACCOUNT_DELETION_GRACE", "720h")
 
 	for idx := range loaders {
 		newV, err := loaders[idx].Load(*v)
@@ -93,3 +246,33 @@ func LoadConfig(loaders []Loader) Config {
 func (c *Config) GetShutdownTimeout() time.Duration {
 	return 10 * time.Second
 }
+
+// GetTrashRetention get how long deleted conversations stay in the trash
+func (c *Config) GetTrashRetention() time.Duration {
+	return time.Duration(c.TrashRetentionDays) * 24 * time.Hour
+}
+
+// GetTrustedProxies lists the addresses or CIDR ranges of TRUSTED_PROXIES, a
+// comma separated list of the proxies whose X-Forwarded-For header gives the
+// client IP. It is nil when empty, the IP of the connection being used then.
+func (c *Config) GetTrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(c.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// GetAdminUserIDs lists the users of ADMIN_USER_IDS, a comma separated list,
+// given the admin role at startup
+func (c *Config) GetAdminUserIDs() []string {
+	ids := []string{}
+	for _, id := range strings.Split(c.AdminUserIDs, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -76,7 +76,7 @@ func TestLoadConfig(t *testing.T) {
 				DBHost:          "127.0.0.1",
 				DBPort:          "5432",
 				DBUser:          "user",
-				DBName:          "dbname",
+				DBName:          "bloom",
 				DBPass:          "pass",
 				DBSSLMode:       "disable",
 			},
@@ -123,6 +123,90 @@ func TestConfig_GetCORS(t *testing.T) {
 	}
 }
 
+func TestConfig_GetAdminUserIDs(t *testing.T) {
+	tests := []struct {
+		name         string
+		adminUserIDs string
+		want         []string
+	}{
+		{
+			name:         "none",
+			adminUserIDs: "",
+			want:         []string{},
+		},
+		{
+			name:         "comma separated with spaces",
+			adminUserIDs: "user-1, user-2,,",
+			want:         []string{"user-1", "user-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				AdminUserIDs: tt.adminUserIDs,
+			}
+			if got := c.GetAdminUserIDs(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Config.GetAdminUserIDs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_GetTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies string
+		want           []string
+	}{
+		{
+			name:           "none",
+			trustedProxies: "",
+			want:           nil,
+		},
+		{
+			name:           "comma separated with spaces",
+			trustedProxies: "10.0.0.0/8, 192.168.1.2,,",
+			want:           []string{"10.0.0.0/8", "192.168.1.2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				TrustedProxies: tt.trustedProxies,
+			}
+			if got := c.GetTrustedProxies(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Config.GetTrustedProxies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_AnonymousUsers(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []fakeFlag
+		want  bool
+	}{
+		{
+			name: "disabled by default",
+			want: false,
+		},
+		{
+			name:  "enabled",
+			flags: []fakeFlag{{Key: "ANONYMOUS_USERS", Value: "true"}},
+			want:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LoadConfig([]Loader{&fakeENVLoader{flags: tt.flags}})
+			if got.AnonymousUsers != tt.want {
+				t.Errorf("LoadConfig() AnonymousUsers = %v, want %v", got.AnonymousUsers, tt.want)
+			}
+		})
+	}
+}
+
 func TestDefaultConfigLoaders(t *testing.T) {
 	tests := []struct {
 		name string
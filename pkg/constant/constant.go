@@ -6,4 +6,19 @@ const (
 
 	// LanguageKey key to save language from
 	LanguageKey = "lanG"
+
+	// UserIDKey key to save the authenticated user ID to context
+	UserIDKey = "useR"
+
+	// AnonymousKey key to save to context whether the user is an anonymous visitor
+	AnonymousKey = "anoN"
+
+	// RoleKey key to save the role of the authenticated user to context
+	RoleKey = "rolE"
+
+	// APIKeyScopesKey key to save to context the scopes of the API key authenticating the request
+	APIKeyScopesKey = "apiK"
+
+	// APIKeyIDKey key to save to context the ID of the API key authenticating the request
+	APIKeyIDKey = "apiI"
 )
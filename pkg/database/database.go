@@ -0,0 +1,155 @@
+// Package database opens the Postgres connection pools from the config, so the
+// server and the command line tools connect the same way.
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/lib/pq"
+)
+
+// pingTimeout bounds the connection check made when a pool is opened
+const pingTimeout = 10 * time.Second
+
+// maintenanceDB is connected to when the configured database must be created
+const maintenanceDB = "postgres"
+
+// DSN builds the key/value connection string of the primary database, the
+// statement timeout is passed as a run-time parameter of every session
+func DSN(cfg config.Config) string {
+	params := map[string]string{
+		"host":     cfg.DBHost,
+		"port":     cfg.DBPort,
+		"user":     cfg.DBUser,
+		"password": cfg.DBPass,
+		"dbname":   cfg.DBName,
+		"sslmode":  cfg.DBSSLMode,
+	}
+	if cfg.DBStatementTimeout > 0 {
+		params["statement_timeout"] = strconv.FormatInt(cfg.DBStatementTimeout.Milliseconds(), 10)
+	}
+
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+quoteValue(params[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ReplicaDSN returns the connection string of the read replica with the
+// statement timeout of the config, or "" when no replica is configured. The
+// replica DSN may be a URL or a key/value string.
+func ReplicaDSN(cfg config.Config) (string, error) {
+	dsn := strings.TrimSpace(cfg.DBReplicaDSN)
+	if dsn == "" || cfg.DBStatementTimeout <= 0 {
+		return dsn, nil
+	}
+	timeout := strconv.FormatInt(cfg.DBStatementTimeout.Milliseconds(), 10)
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", fmt.Errorf("invalid DB_REPLICA_DSN: %v", err)
+		}
+		q := u.Query()
+		if q.Get("statement_timeout") == "" {
+			q.Set("statement_timeout", timeout)
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+	if strings.Contains(dsn, "statement_timeout=") {
+		return dsn, nil
+	}
+	return dsn + " statement_timeout=" + timeout, nil
+}
+
+// Open connects to the primary database with the pool limits of the config
+func Open(cfg config.Config) (*sql.DB, error) {
+	return open(DSN(cfg), cfg)
+}
+
+// OpenReplica connects to the read replica, it returns nil when none is configured
+func OpenReplica(cfg config.Config) (*sql.DB, error) {
+	dsn, err := ReplicaDSN(cfg)
+	if err != nil || dsn == "" {
+		return nil, err
+	}
+	db, err := open(dsn, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("replica: %v", err)
+	}
+	return db, nil
+}
+
+// EnsureDatabase creates the configured database when it does not exist yet.
+// It reports whether the database was created.
+func EnsureDatabase(cfg config.Config) (bool, error) {
+	db, err := Open(cfg)
+	if err == nil {
+		return false, db.Close()
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code.Name() != "invalid_catalog_name" {
+		return false, err
+	}
+
+	maintenance := cfg
+	maintenance.DBName = maintenanceDB
+	db, err = Open(maintenance)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE DATABASE " + pq.QuoteIdentifier(cfg.DBName)); err != nil {
+		return false, fmt.Errorf("could not create database %s: %v", cfg.DBName, err)
+	}
+	return true, nil
+}
+
+func open(dsn string, cfg config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.DBConnMaxIdleTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// quoteValue quotes a connection string value when it is empty or contains
+// spaces, quotes or backslashes
+func quoteValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(v) + "'"
+}
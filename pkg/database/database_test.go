@@ -0,0 +1,93 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+)
+
+func TestDSN(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want string
+	}{
+		{
+			name: "all fields",
+			cfg: config.Config{
+				DBHost: "db", DBPort: "5432", DBUser: "bloom", DBPass: "secret", DBName: "bloom", DBSSLMode: "require",
+				DBStatementTimeout: 30 * time.Second,
+			},
+			want: "dbname=bloom host=db password=secret port=5432 sslmode=require statement_timeout=30000 user=bloom",
+		},
+		{
+			name: "empty fields are left to the driver defaults",
+			cfg:  config.Config{DBHost: "localhost", DBName: "bloom"},
+			want: "dbname=bloom host=localhost",
+		},
+		{
+			name: "special characters are quoted",
+			cfg:  config.Config{DBName: "bloom", DBPass: `it's a \secret`},
+			want: `dbname=bloom password='it\'s a \\secret'`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DSN(tt.cfg); got != tt.want {
+				t.Errorf("DSN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplicaDSN(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "no replica",
+			cfg:  config.Config{DBStatementTimeout: time.Second},
+			want: "",
+		},
+		{
+			name: "key value without timeout",
+			cfg:  config.Config{DBReplicaDSN: "host=replica dbname=bloom"},
+			want: "host=replica dbname=bloom",
+		},
+		{
+			name: "key value gets the timeout",
+			cfg:  config.Config{DBReplicaDSN: "host=replica dbname=bloom", DBStatementTimeout: 5 * time.Second},
+			want: "host=replica dbname=bloom statement_timeout=5000",
+		},
+		{
+			name: "key value keeps its own timeout",
+			cfg:  config.Config{DBReplicaDSN: "host=replica statement_timeout=100", DBStatementTimeout: 5 * time.Second},
+			want: "host=replica statement_timeout=100",
+		},
+		{
+			name: "url gets the timeout",
+			cfg:  config.Config{DBReplicaDSN: "postgres://bloom:pw@replica:5432/bloom?sslmode=require", DBStatementTimeout: 5 * time.Second},
+			want: "postgres://bloom:pw@replica:5432/bloom?sslmode=require&statement_timeout=5000",
+		},
+		{
+			name:    "invalid url",
+			cfg:     config.Config{DBReplicaDSN: "postgres://replica:port/bloom", DBStatementTimeout: 5 * time.Second},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReplicaDSN(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReplicaDSN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ReplicaDSN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Package dbtest is a fake database answering the SQL of the code under test
+// from a function, to test code running queries without a Postgres server.
+// Transactions are accepted and recorded, nothing is rolled back.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Query is a statement received by the fake database, COMMIT and ROLLBACK
+// included
+type Query struct {
+	SQL  string
+	Args []driver.Value
+}
+
+// Has tells whether the statement contains every fragment, ignoring spaces
+func (q Query) Has(fragments ...string) bool {
+	sql := compact(q.SQL)
+	for _, fragment := range fragments {
+		if !strings.Contains(sql, compact(fragment)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Result is the answer to a statement, Rows for queries and RowsAffected for
+// other statements
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+}
+
+// Handler answers a statement
+type Handler func(q Query) (Result, error)
+
+// DB is a fake database
+type DB struct {
+	handle Handler
+
+	mu      sync.Mutex
+	queries []Query
+}
+
+// Open opens a database answering statements with handle
+func Open(handle Handler) (*sql.DB, *DB) {
+	d := &DB{handle: handle}
+	return sql.OpenDB(d), d
+}
+
+// Queries returns the statements received so far
+func (d *DB) Queries() []Query {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Query(nil), d.queries...)
+}
+
+func (d *DB) run(query string, args []driver.NamedValue) (Result, error) {
+	q := Query{SQL: query}
+	for _, arg := range args {
+		q.Args = append(q.Args, arg.Value)
+	}
+	d.mu.Lock()
+	d.queries = append(d.queries, q)
+	d.mu.Unlock()
+	if query == "COMMIT" || query == "ROLLBACK" {
+		return Result{}, nil
+	}
+	return d.handle(q)
+}
+
+func (d *DB) Connect(context.Context) (driver.Conn, error) { return &conn{db: d}, nil }
+func (d *DB) Driver() driver.Driver                        { return nil }
+
+type conn struct {
+	db *DB
+}
+
+func (c *conn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("dbtest: prepared statements are not supported")
+}
+func (c *conn) Close() error              { return nil }
+func (c *conn) Begin() (driver.Tx, error) { return tx{conn: c}, nil }
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return tx{conn: c}, nil
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{columns: res.Columns, rows: res.Rows}, nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.RowsAffected), nil
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t tx) Commit() error {
+	_, err := t.conn.db.run("COMMIT", nil)
+	return err
+}
+
+func (t tx) Rollback() error {
+	_, err := t.conn.db.run("ROLLBACK", nil)
+	return err
+}
+
+type rows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func compact(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,308 @@
+package embedding
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/Essen-Labs/bloom-be/pkg/llm"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultBackfillBatchSize = 32
+	// SearchCandidateLimit is the number of the user's latest embedded messages
+	// Search ranks, older messages are not matched
+	SearchCandidateLimit = 5000
+)
+
+// Match is the message that best matches a query inside one conversation
+type Match struct {
+	ConversationID   string
+	ConversationName string
+	MessageID        int
+	Role             string
+	Content          string
+	Encrypted        bool
+	Score            float64
+}
+
+// Index stores message embeddings in the message_embeddings table and ranks
+// them in process. Vectors are compared only against the current embedder's
+// model, so switching models requires a backfill. Vectors are derived from the
+// content of messages, when encryption is enabled they are sealed like it, in
+// sealed_embedding, instead of stored in embedding.
+type Index struct {
+	db       *sql.DB
+	replica  *sql.DB
+	embedder llm.Embedder
+	cipher   *encryption.Cipher
+}
+
+// NewIndex make an embedding index, cipher seals vectors and decrypts the
+// messages read by Backfill, it may be nil when content is not encrypted. The
+// index is nil when embedder is, semantic search is then disabled.
+func NewIndex(db *sql.DB, embedder llm.Embedder, cipher *encryption.Cipher) *Index {
+	if embedder == nil {
+		return nil
+	}
+	return &Index{
+		db:       db,
+		embedder: embedder,
+		cipher:   cipher,
+	}
+}
+
+// WithReplica makes Search read embeddings from a read replica, vectors stored
+// moments before may be missed. It does nothing on a disabled index.
+func (i *Index) WithReplica(replica *sql.DB) *Index {
+	if i != nil {
+		i.replica = replica
+	}
+	return i
+}
+
+// reader returns the pool serving Search
+func (i *Index) reader() *sql.DB {
+	if i.replica != nil {
+		return i.replica
+	}
+	return i.db
+}
+
+// Enabled reports whether messages are embedded and can be searched
+func (i *Index) Enabled() bool {
+	return i != nil
+}
+
+// pendingMessage is a message to embed, with its plaintext content
+type pendingMessage struct {
+	id      int
+	userID  string
+	content string
+}
+
+// IndexMessage embeds a message of the user and stores its vector
+func (i *Index) IndexMessage(ctx context.Context, userID string, messageID int, content string) error {
+	return i.index(ctx, []pendingMessage{{id: messageID, userID: userID, content: content}})
+}
+
+// IndexMessages embeds messages of the user in one call and stores their
+// vectors, contents are in the order of messageIDs
+func (i *Index) IndexMessages(ctx context.Context, userID string, messageIDs []int, contents []string) error {
+	messages := make([]pendingMessage, len(messageIDs))
+	for idx, id := range messageIDs {
+		messages[idx] = pendingMessage{id: id, userID: userID, content: contents[idx]}
+	}
+	return i.index(ctx, messages)
+}
+
+// index embeds the messages that are not empty in one call and stores their vectors
+func (i *Index) index(ctx context.Context, messages []pendingMessage) error {
+	var embedded []pendingMessage
+	var inputs []string
+	for _, m := range messages {
+		if m.content != "" {
+			embedded = append(embedded, m)
+			inputs = append(inputs, m.content)
+		}
+	}
+	if len(embedded) == 0 {
+		return nil
+	}
+
+	vectors, err := i.embedder.Embed(ctx, inputs)
+	if err != nil {
+		if len(embedded) == 1 {
+			return fmt.Errorf("could not embed message %d: %v", embedded[0].id, err)
+		}
+		return fmt.Errorf("could not embed batch: %v", err)
+	}
+	for idx, m := range embedded {
+		if err := i.store(ctx, m.userID, m.id, vectors[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Index) store(ctx context.Context, userID string, messageID int, vector []float32) error {
+	var plain, sealed interface{}
+	if i.cipher.Enabled() {
+		data, _, err := i.cipher.EncryptBytes(ctx, userID, encryption.MessageEmbedding(messageID), encodeVector(vector))
+		if err != nil {
+			return fmt.Errorf("could not encrypt embedding for message %d: %v", messageID, err)
+		}
+		sealed = data
+	} else {
+		plain = pq.Array(vector)
+	}
+
+	_, err := i.db.ExecContext(ctx, `
+		INSERT INTO message_embeddings (message_id, model, embedding, sealed_embedding, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (message_id) DO UPDATE
+		SET model = EXCLUDED.model, embedding = EXCLUDED.embedding, sealed_embedding = EXCLUDED.sealed_embedding,
+			created_at = EXCLUDED.created_at`,
+		messageID, i.embedder.Model(), plain, sealed, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("could not store embedding for message %d: %v", messageID, err)
+	}
+	return nil
+}
+
+// Backfill embeds every message that has no vector for the current model and
+// returns the number of messages embedded. Messages are visited once in id
+// order, those left without vector, like empty ones, do not stop it.
+func (i *Index) Backfill(ctx context.Context, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = defaultBackfillBatchSize
+	}
+
+	total := 0
+	lastID := 0
+	for {
+		messages, err := i.pending(ctx, lastID, batchSize)
+		if err != nil {
+			return total, err
+		}
+		if len(messages) == 0 {
+			return total, nil
+		}
+
+		if err := i.index(ctx, messages); err != nil {
+			return total, err
+		}
+		for _, m := range messages {
+			if m.content != "" {
+				total++
+			}
+		}
+		lastID = messages[len(messages)-1].id
+	}
+}
+
+func (i *Index) pending(ctx context.Context, lastID, limit int) ([]pendingMessage, error) {
+	rows, err := i.db.QueryContext(ctx, `
+		SELECT m.id, m.content, m.content_encrypted, coalesce(c.user_id, '')
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		LEFT JOIN message_embeddings e ON e.message_id = m.id AND e.model = $1
+		WHERE e.message_id IS NULL AND coalesce(m.content, '') <> '' AND m.id > $2
+		ORDER BY m.id ASC
+		LIMIT $3`, i.embedder.Model(), lastID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not query messages to embed: %v", err)
+	}
+	defer rows.Close()
+
+	var messages []pendingMessage
+	for rows.Next() {
+		var m pendingMessage
+		var encrypted bool
+		if err := rows.Scan(&m.id, &m.content, &encrypted, &m.userID); err != nil {
+			return nil, fmt.Errorf("could not scan message to embed: %v", err)
+		}
+		m.content, err = i.cipher.Decrypt(ctx, m.userID, encryption.MessageContent(m.id), m.content, encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt message %d to embed: %v", m.id, err)
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over messages to embed: %v", err)
+	}
+	return messages, nil
+}
+
+// Search returns the user's conversations closest to the query, best first,
+// with at most one match per conversation. Only the SearchCandidateLimit latest
+// embedded messages of the user are ranked. Match content is returned as
+// stored, sealed when Encrypted is set.
+func (i *Index) Search(ctx context.Context, userID, query string, limit int) ([]Match, error) {
+	vectors, err := i.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("could not embed query: %v", err)
+	}
+	queryVector := vectors[0]
+
+	rows, err := i.reader().QueryContext(ctx, `
+		SELECT c.id, coalesce(c.conversation_name, ''), m.id, m.role, m.content, m.content_encrypted,
+			e.embedding, e.sealed_embedding
+		FROM message_embeddings e
+		JOIN messages m ON m.id = e.message_id
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE c.user_id = $1 AND c.deleted_at IS NULL AND e.model = $2
+		ORDER BY m.id DESC
+		LIMIT $3`, userID, i.embedder.Model(), SearchCandidateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("could not query embeddings: %v", err)
+	}
+	defer rows.Close()
+
+	best := map[string]Match{}
+	for rows.Next() {
+		var m Match
+		var vector pq.Float32Array
+		var sealed []byte
+		if err := rows.Scan(&m.ConversationID, &m.ConversationName, &m.MessageID, &m.Role, &m.Content, &m.Encrypted,
+			&vector, &sealed); err != nil {
+			return nil, fmt.Errorf("could not scan embedding: %v", err)
+		}
+		if sealed != nil {
+			data, err := i.cipher.DecryptBytes(ctx, userID, encryption.MessageEmbedding(m.MessageID), sealed, true)
+			if err != nil {
+				return nil, fmt.Errorf("could not decrypt embedding of message %d: %v", m.MessageID, err)
+			}
+			if vector, err = decodeVector(data); err != nil {
+				return nil, fmt.Errorf("could not decode embedding of message %d: %v", m.MessageID, err)
+			}
+		}
+		m.Score = llm.CosineSimilarity(queryVector, vector)
+		if current, ok := best[m.ConversationID]; !ok || m.Score > current.Score {
+			best[m.ConversationID] = m
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over embeddings: %v", err)
+	}
+
+	matches := make([]Match, 0, len(best))
+	for _, m := range best {
+		matches = append(matches, m)
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		return matches[a].Score > matches[b].Score
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// encodeVector serializes a vector to be sealed, 4 bytes per component
+func encodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for idx, v := range vector {
+		binary.LittleEndian.PutUint32(data[4*idx:], math.Float32bits(v))
+	}
+	return data
+}
+
+// decodeVector reads a vector serialized by encodeVector
+func decodeVector(data []byte) ([]float32, error) {
+	if len(data)%4 != 0 {
+		return nil, errors.New("vector length is not a multiple of 4 bytes")
+	}
+	vector := make([]float32, len(data)/4)
+	for idx := range vector {
+		vector[idx] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*idx:]))
+	}
+	return vector, nil
+}
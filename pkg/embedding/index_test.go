@@ -0,0 +1,193 @@
+package embedding
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/Essen-Labs/bloom-be/pkg/llm"
+)
+
+// countingEmbedder records the inputs it embeds
+type countingEmbedder struct {
+	llm.Embedder
+
+	mu     sync.Mutex
+	inputs []string
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	e.mu.Lock()
+	e.inputs = append(e.inputs, inputs...)
+	e.mu.Unlock()
+	return e.Embedder.Embed(ctx, inputs)
+}
+
+// storedMessage is a row of the fake messages table
+type storedMessage struct {
+	id        int64
+	userID    string
+	content   string
+	encrypted bool
+}
+
+func TestIndex_Backfill(t *testing.T) {
+	keys, err := encryption.ParseKeyring("k1:"+base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var messages []storedMessage
+	embedded := map[int64]bool{}
+	sealedVectors := map[int64][]byte{}
+	dataKeys := map[string][]driver.Value{}
+	pendingQueries := 0
+
+	db, _ := dbtest.Open(func(q dbtest.Query) (dbtest.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case q.Has("INSERT INTO user_data_keys"):
+			dataKeys[q.Args[0].(string)] = []driver.Value{q.Args[2], q.Args[3]}
+			return dbtest.Result{RowsAffected: 1}, nil
+		case q.Has("SELECT coalesce(MAX(version), 0) FROM user_data_keys"):
+			version := int64(0)
+			if _, ok := dataKeys[q.Args[0].(string)]; ok {
+				version = 1
+			}
+			return dbtest.Result{Columns: []string{"version"}, Rows: [][]driver.Value{{version}}}, nil
+		case q.Has("SELECT master_key_id, wrapped_key FROM user_data_keys"):
+			return dbtest.Result{Columns: []string{"master_key_id", "wrapped_key"}, Rows: [][]driver.Value{dataKeys[q.Args[0].(string)]}}, nil
+		case q.Has("FROM messages m", "LEFT JOIN message_embeddings"):
+			pendingQueries++
+			if pendingQueries > 10 {
+				return dbtest.Result{}, errors.New("backfill does not advance")
+			}
+			lastID, limit := q.Args[1].(int64), q.Args[2].(int64)
+			res := dbtest.Result{Columns: []string{"id", "content", "content_encrypted", "user_id"}}
+			for _, m := range messages {
+				if m.id > lastID && !embedded[m.id] && int64(len(res.Rows)) < limit {
+					res.Rows = append(res.Rows, []driver.Value{m.id, m.content, m.encrypted, m.userID})
+				}
+			}
+			return res, nil
+		case q.Has("INSERT INTO message_embeddings"):
+			id := q.Args[0].(int64)
+			embedded[id] = true
+			if q.Args[2] != nil {
+				t.Errorf("message %d embedding stored in plaintext while encryption is enabled", id)
+			}
+			sealedVectors[id], _ = q.Args[3].([]byte)
+			return dbtest.Result{RowsAffected: 1}, nil
+		case q.Has("FROM message_embeddings e"):
+			res := dbtest.Result{Columns: []string{"id", "name", "message_id", "role", "content", "content_encrypted", "embedding", "sealed_embedding"}}
+			for _, m := range messages {
+				if m.userID == q.Args[0].(string) && sealedVectors[m.id] != nil {
+					res.Rows = append(res.Rows, []driver.Value{fmt.Sprint("conv-", m.id), "", m.id, "user", m.content, m.encrypted, nil, sealedVectors[m.id]})
+				}
+			}
+			return res, nil
+		}
+		return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+	})
+	defer db.Close()
+
+	cipher := encryption.NewCipher(db, keys)
+	ctx := context.Background()
+	seal := func(id int64, content string) storedMessage {
+		sealed, encrypted, err := cipher.Encrypt(ctx, "user-1", encryption.MessageContent(int(id)), content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return storedMessage{id: id, userID: "user-1", content: sealed, encrypted: encrypted}
+	}
+	// The empty message is stored sealed, so the query cannot tell it is empty.
+	// Plaintext looking like sealed content is still plaintext.
+	messages = []storedMessage{
+		seal(1, ""),
+		seal(2, "quarterly revenue report"),
+		seal(3, ""),
+		{id: 4, userID: "user-2", content: "plaintext from before encryption"},
+		{id: 5, userID: "user-2", content: "enc:v1:x"},
+	}
+
+	embedder := &countingEmbedder{Embedder: llm.NewLocalEmbedder(8)}
+	count, err := NewIndex(db, embedder, cipher).Backfill(ctx, 2)
+	if err != nil {
+		t.Fatalf("Backfill() error = %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Backfill() = %d, want 3 messages embedded", count)
+	}
+	if !embedded[2] || !embedded[4] || !embedded[5] || embedded[1] || embedded[3] {
+		t.Errorf("embedded messages = %v, want 2, 4 and 5", embedded)
+	}
+	want := map[string]bool{"quarterly revenue report": true, "plaintext from before encryption": true, "enc:v1:x": true}
+	for _, input := range embedder.inputs {
+		if !want[input] {
+			t.Errorf("embedder got %q, want plaintext content only", input)
+		}
+	}
+
+	matches, err := NewIndex(db, embedder, cipher).Search(ctx, "user-1", "quarterly revenue report", 5)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(matches) != 1 || matches[0].MessageID != 2 || matches[0].Score < 0.99 {
+		t.Errorf("Search() = %+v, want message 2 opened from its sealed vector", matches)
+	}
+}
+
+func TestIndex_Search_Replica(t *testing.T) {
+	primary, _ := dbtest.Open(func(q dbtest.Query) (dbtest.Result, error) {
+		return dbtest.Result{}, fmt.Errorf("unexpected query on the primary %s", q.SQL)
+	})
+	defer primary.Close()
+
+	embedder := llm.NewLocalEmbedder(8)
+	vectors, err := embedder.Embed(context.Background(), []string{"quarterly revenue report"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, fake := dbtest.Open(func(q dbtest.Query) (dbtest.Result, error) {
+		if !q.Has("FROM message_embeddings e", "ORDER BY m.id DESC", "LIMIT $3") {
+			return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+		}
+		if q.Args[2] != int64(SearchCandidateLimit) {
+			t.Errorf("candidate limit = %v, want %d", q.Args[2], SearchCandidateLimit)
+		}
+		return dbtest.Result{
+			Columns: []string{"id", "name", "message_id", "role", "content", "content_encrypted", "embedding", "sealed_embedding"},
+			Rows:    [][]driver.Value{{"1", "", int64(2), "user", "quarterly revenue report", false, vectorLiteral(vectors[0]), nil}},
+		}, nil
+	})
+	defer replica.Close()
+
+	matches, err := NewIndex(primary, embedder, nil).WithReplica(replica).Search(context.Background(), "user-1", "quarterly revenue report", 5)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(matches) != 1 || matches[0].MessageID != 2 {
+		t.Errorf("Search() = %+v, want message 2", matches)
+	}
+	if len(fake.Queries()) != 1 {
+		t.Errorf("replica queries = %d, want 1", len(fake.Queries()))
+	}
+}
+
+// vectorLiteral formats a vector as a postgres array, as pq.Float32Array reads it
+func vectorLiteral(vector []float32) string {
+	parts := make([]string, len(vector))
+	for idx, v := range vector {
+		parts[idx] = fmt.Sprint(v)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,486 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+)
+
+// contentPrefix starts encrypted content, it is followed by the data key version
+// and the base64 of nonce and ciphertext: enc:v1:<version>:<base64>. Whether
+// stored content is encrypted is never told from the prefix, plaintext may start
+// with it too, but from the content_encrypted column stored next to it.
+const contentPrefix = "enc:v1:"
+
+// Location is the row storing sealed content. Content is bound to its owner
+// and location, so that it does not decrypt once copied into another row.
+type Location struct {
+	Table string
+	ID    string
+}
+
+// MessageContent is the location of the content of a message
+func MessageContent(messageID int) Location {
+	return Location{Table: "messages", ID: strconv.Itoa(messageID)}
+}
+
+// SharedMessageContent is the location of the content of a message of a share
+// snapshot
+func SharedMessageContent(sharedMessageID int) Location {
+	return Location{Table: "shared_messages", ID: strconv.Itoa(sharedMessageID)}
+}
+
+// MessageEmbedding is the location of the embedding vector of a message
+func MessageEmbedding(messageID int) Location {
+	return Location{Table: "message_embeddings", ID: strconv.Itoa(messageID)}
+}
+
+// DataExportChunk is the location of the chunk n of a data export archive. The
+// last chunk is final, an archive cut short of it does not decrypt.
+func DataExportChunk(exportID string, n int, final bool) Location {
+	id := exportID + "/" + strconv.Itoa(n)
+	if final {
+		id += "/final"
+	}
+	return Location{Table: "data_export_chunks", ID: id}
+}
+
+// Cipher encrypts message content with a data key per user, stored wrapped by a
+// master key in the user_data_keys table. A nil Cipher or one without keyring
+// leaves content in plaintext. Plaintext content, written before encryption was
+// enabled, is always read as is. Encrypt reports whether it sealed the content,
+// callers store that flag with the content and pass it back to Decrypt.
+type Cipher struct {
+	db   *sql.DB
+	keys *Keyring
+
+	mu    sync.Mutex
+	cache map[dataKeyRef][]byte
+}
+
+type dataKeyRef struct {
+	userID  string
+	version int
+}
+
+// NewCipher make a cipher, keys may be nil to disable encryption
+func NewCipher(db *sql.DB, keys *Keyring) *Cipher {
+	return &Cipher{
+		db:    db,
+		keys:  keys,
+		cache: map[dataKeyRef][]byte{},
+	}
+}
+
+// LoadCipher make a cipher with the master keys of the config
+func LoadCipher(db *sql.DB, cfg config.Config) (*Cipher, error) {
+	keys, err := LoadKeyring(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewCipher(db, keys), nil
+}
+
+// Enabled reports whether new content is encrypted
+func (c *Cipher) Enabled() bool {
+	return c != nil && c.keys != nil
+}
+
+// Encrypt seals content stored at loc with the user's current data key, and
+// reports whether it did, content is left in plaintext when encryption is off
+func (c *Cipher) Encrypt(ctx context.Context, userID string, loc Location, plaintext string) (string, bool, error) {
+	if !c.Enabled() {
+		return plaintext, false, nil
+	}
+	version, key, err := c.activeDataKey(ctx, userID)
+	if err != nil {
+		return "", false, err
+	}
+	sealed, err := seal(key, contentAAD(userID, loc), []byte(plaintext))
+	if err != nil {
+		return "", false, err
+	}
+	return formatContent(version, sealed), true, nil
+}
+
+// Decrypt opens content sealed by Encrypt for the same user and location.
+// Content stored with encrypted false is plaintext and returned as is.
+func (c *Cipher) Decrypt(ctx context.Context, userID string, loc Location, stored string, encrypted bool) (string, error) {
+	if !encrypted {
+		return stored, nil
+	}
+	if !c.Enabled() {
+		return "", errors.New("content is encrypted but no encryption key is configured")
+	}
+	version, sealed, err := parseContent(stored)
+	if err != nil {
+		return "", err
+	}
+	key, err := c.dataKey(ctx, userID, version)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(key, contentAAD(userID, loc), sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// EncryptBytes seals binary data stored at loc with the user's current data key,
+// as contentPrefix, the data key version on 4 bytes, then nonce and ciphertext.
+// Like Encrypt, it reports whether the data was sealed.
+func (c *Cipher) EncryptBytes(ctx context.Context, userID string, loc Location, data []byte) ([]byte, bool, error) {
+	if !c.Enabled() {
+		return data, false, nil
+	}
+	version, key, err := c.activeDataKey(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	header := make([]byte, len(contentPrefix)+4)
+	copy(header, contentPrefix)
+	binary.BigEndian.PutUint32(header[len(contentPrefix):], uint32(version))
+	sealed, err := seal(key, contentAAD(userID, loc), data)
+	if err != nil {
+		return nil, false, err
+	}
+	return append(header, sealed...), true, nil
+}
+
+// DecryptBytes opens binary data sealed by EncryptBytes for the same user and
+// location, data stored with encrypted false is returned as is
+func (c *Cipher) DecryptBytes(ctx context.Context, userID string, loc Location, stored []byte, encrypted bool) ([]byte, error) {
+	if !encrypted {
+		return stored, nil
+	}
+	if !c.Enabled() {
+		return nil, errors.New("data is encrypted but no encryption key is configured")
+	}
+	if !bytes.HasPrefix(stored, []byte(contentPrefix)) {
+		return nil, errors.New("encrypted data has no encryption header")
+	}
+	body := stored[len(contentPrefix):]
+	if len(body) < 4 {
+		return nil, errors.New("encrypted data has no data key version")
+	}
+	key, err := c.dataKey(ctx, userID, int(binary.BigEndian.Uint32(body)))
+	if err != nil {
+		return nil, err
+	}
+	return open(key, contentAAD(userID, loc), body[4:])
+}
+
+// Reseal opens content sealed for one user and seals it for another, when
+// content changes owner. Plaintext content is returned as is.
+func (c *Cipher) Reseal(ctx context.Context, fromUserID, toUserID string, loc Location, stored string, encrypted bool) (string, bool, error) {
+	if !encrypted {
+		return stored, false, nil
+	}
+	plaintext, err := c.Decrypt(ctx, fromUserID, loc, stored, encrypted)
+	if err != nil {
+		return "", false, err
+	}
+	return c.Encrypt(ctx, toUserID, loc, plaintext)
+}
+
+// ResealBytes opens binary data sealed for one user and seals it for another,
+// like Reseal
+func (c *Cipher) ResealBytes(ctx context.Context, fromUserID, toUserID string, loc Location, stored []byte, encrypted bool) ([]byte, bool, error) {
+	if !encrypted {
+		return stored, false, nil
+	}
+	data, err := c.DecryptBytes(ctx, fromUserID, loc, stored, encrypted)
+	if err != nil {
+		return nil, false, err
+	}
+	return c.EncryptBytes(ctx, toUserID, loc, data)
+}
+
+// NeedsReencrypt reports whether stored content is plaintext or sealed with a
+// data key older than the user's current one
+func (c *Cipher) NeedsReencrypt(ctx context.Context, userID, stored string, encrypted bool) (bool, error) {
+	if !c.Enabled() {
+		return false, nil
+	}
+	if !encrypted {
+		return true, nil
+	}
+	version, _, err := parseContent(stored)
+	if err != nil {
+		return false, err
+	}
+	current, _, err := c.activeDataKey(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return version != current, nil
+}
+
+// RotateDataKey creates a new data key for the user, used by Encrypt from now
+// on. Content sealed with the previous keys stays readable.
+func (c *Cipher) RotateDataKey(ctx context.Context, userID string) (int, error) {
+	if !c.Enabled() {
+		return 0, errors.New("encryption is not enabled")
+	}
+	var version int
+	err := c.db.QueryRowContext(ctx, `
+		SELECT coalesce(MAX(version), 0) + 1 FROM user_data_keys WHERE user_id = $1`, userID).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("could not get data key version: %v", err)
+	}
+	if err := c.createDataKey(ctx, userID, version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// RewrapDataKeys wraps every data key that is not wrapped by the active master
+// key again with it, so that older master keys can be removed from the keyring
+func (c *Cipher) RewrapDataKeys(ctx context.Context) (int, error) {
+	if !c.Enabled() {
+		return 0, errors.New("encryption is not enabled")
+	}
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT user_id, version, master_key_id, wrapped_key
+		FROM user_data_keys
+		WHERE master_key_id <> $1`, c.keys.Active())
+	if err != nil {
+		return 0, fmt.Errorf("could not query data keys to rewrap: %v", err)
+	}
+
+	type wrappedKey struct {
+		ref      dataKeyRef
+		masterID string
+		wrapped  []byte
+	}
+	var keys []wrappedKey
+	for rows.Next() {
+		var k wrappedKey
+		if err := rows.Scan(&k.ref.userID, &k.ref.version, &k.masterID, &k.wrapped); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("could not scan data key: %v", err)
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("could not iterate over data keys: %v", err)
+	}
+
+	for _, k := range keys {
+		key, err := c.unwrap(k.ref, k.masterID, k.wrapped)
+		if err != nil {
+			return 0, err
+		}
+		wrapped, err := c.wrap(k.ref, key)
+		if err != nil {
+			return 0, err
+		}
+		_, err = c.db.ExecContext(ctx, `
+			UPDATE user_data_keys SET master_key_id = $1, wrapped_key = $2
+			WHERE user_id = $3 AND version = $4`, c.keys.Active(), wrapped, k.ref.userID, k.ref.version)
+		if err != nil {
+			return 0, fmt.Errorf("could not rewrap data key of %s: %v", k.ref.userID, err)
+		}
+	}
+	return len(keys), nil
+}
+
+// activeDataKey returns the user's latest data key, creating the first one
+func (c *Cipher) activeDataKey(ctx context.Context, userID string) (int, []byte, error) {
+	var version int
+	err := c.db.QueryRowContext(ctx, `
+		SELECT coalesce(MAX(version), 0) FROM user_data_keys WHERE user_id = $1`, userID).Scan(&version)
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not get data key version: %v", err)
+	}
+	if version == 0 {
+		version = 1
+		if err := c.createDataKey(ctx, userID, version); err != nil {
+			return 0, nil, err
+		}
+	}
+	key, err := c.dataKey(ctx, userID, version)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, key, nil
+}
+
+// createDataKey stores a new random data key. When another request created the
+// same version concurrently, its key is kept.
+func (c *Cipher) createDataKey(ctx context.Context, userID string, version int) error {
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("could not generate data key: %v", err)
+	}
+	wrapped, err := c.wrap(dataKeyRef{userID: userID, version: version}, key)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.ExecContext(ctx, `
+		INSERT INTO user_data_keys (user_id, version, master_key_id, wrapped_key)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, version) DO NOTHING`, userID, version, c.keys.Active(), wrapped)
+	if err != nil {
+		return fmt.Errorf("could not store data key: %v", err)
+	}
+	return nil
+}
+
+// Forget drops the cached data keys of a user whose keys were deleted, so that
+// nothing decrypts their content anymore
+func (c *Cipher) Forget(userID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ref := range c.cache {
+		if ref.userID == userID {
+			delete(c.cache, ref)
+		}
+	}
+}
+
+// dataKey loads and unwraps a data key, keys are cached since they never change
+func (c *Cipher) dataKey(ctx context.Context, userID string, version int) ([]byte, error) {
+	ref := dataKeyRef{userID: userID, version: version}
+	c.mu.Lock()
+	key, ok := c.cache[ref]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var masterID string
+	var wrapped []byte
+	err := c.db.QueryRowContext(ctx, `
+		SELECT master_key_id, wrapped_key
+		FROM user_data_keys
+		WHERE user_id = $1 AND version = $2`, userID, version).Scan(&masterID, &wrapped)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("data key %d of user %s not found", version, userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load data key: %v", err)
+	}
+
+	key, err = c.unwrap(ref, masterID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.cache[ref] = key
+	c.mu.Unlock()
+	return key, nil
+}
+
+func (c *Cipher) wrap(ref dataKeyRef, key []byte) ([]byte, error) {
+	master, err := c.keys.key(c.keys.Active())
+	if err != nil {
+		return nil, err
+	}
+	return seal(master, wrapAAD(ref), key)
+}
+
+func (c *Cipher) unwrap(ref dataKeyRef, masterID string, wrapped []byte) ([]byte, error) {
+	master, err := c.keys.key(masterID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := open(master, wrapAAD(ref), wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("could not unwrap data key %d of user %s: %v", ref.version, ref.userID, err)
+	}
+	return key, nil
+}
+
+// contentAAD binds sealed content to its owner and location
+func contentAAD(userID string, loc Location) []byte {
+	return []byte(userID + "|" + loc.Table + "|" + loc.ID)
+}
+
+// wrapAAD binds a wrapped data key to its owner and version
+func wrapAAD(ref dataKeyRef) []byte {
+	return []byte("bloom-data-key:" + ref.userID + ":" + strconv.Itoa(ref.version))
+}
+
+// seal encrypts with AES-GCM and returns the nonce followed by the ciphertext
+func seal(key, aad, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %v", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open decrypts the output of seal
+func open(key, aad, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt: %v", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create GCM: %v", err)
+	}
+	return gcm, nil
+}
+
+func formatContent(version int, sealed []byte) string {
+	return contentPrefix + strconv.Itoa(version) + ":" + base64.StdEncoding.EncodeToString(sealed)
+}
+
+func parseContent(stored string) (int, []byte, error) {
+	body := strings.TrimPrefix(stored, contentPrefix)
+	if body == stored {
+		return 0, nil, errors.New("encrypted content has no encryption prefix")
+	}
+	versionStr, encoded, ok := strings.Cut(body, ":")
+	if !ok {
+		return 0, nil, errors.New("encrypted content has no data key version")
+	}
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return 0, nil, fmt.Errorf("encrypted content has an invalid data key version: %v", err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, nil, fmt.Errorf("encrypted content is not valid base64: %v", err)
+	}
+	return version, sealed, nil
+}
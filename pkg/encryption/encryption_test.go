@@ -0,0 +1,269 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, masterKeySize))
+}
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       string
+		active     string
+		wantActive string
+		wantErr    bool
+	}{
+		{
+			name:       "single key",
+			spec:       "k1:" + testKey(1),
+			wantActive: "k1",
+		},
+		{
+			name:       "last key is active by default",
+			spec:       "# rotated 2026-01\nk1:" + testKey(1) + "\nk2:" + testKey(2) + "\n",
+			wantActive: "k2",
+		},
+		{
+			name:       "explicit active key",
+			spec:       "k1:" + testKey(1) + ";k2:" + testKey(2),
+			active:     "k1",
+			wantActive: "k1",
+		},
+		{
+			name:    "unknown active key",
+			spec:    "k1:" + testKey(1),
+			active:  "k3",
+			wantErr: true,
+		},
+		{
+			name:    "missing id",
+			spec:    testKey(1),
+			wantErr: true,
+		},
+		{
+			name:    "short key",
+			spec:    "k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+			wantErr: true,
+		},
+		{
+			name:    "duplicate id",
+			spec:    "k1:" + testKey(1) + ",k1:" + testKey(2),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKeyring(tt.spec, tt.active)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeyring() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Active() != tt.wantActive {
+				t.Errorf("ParseKeyring() active = %v, want %v", got.Active(), tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, masterKeySize)
+	sealed, err := seal(key, []byte("user-1"), []byte("secret contract"))
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		aad     string
+		sealed  []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "same key and owner",
+			key:    key,
+			aad:    "user-1",
+			sealed: sealed,
+			want:   "secret contract",
+		},
+		{
+			name:    "other owner",
+			key:     key,
+			aad:     "user-2",
+			sealed:  sealed,
+			wantErr: true,
+		},
+		{
+			name:    "other key",
+			key:     bytes.Repeat([]byte{8}, masterKeySize),
+			aad:     "user-1",
+			sealed:  sealed,
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			key:     key,
+			aad:     "user-1",
+			sealed:  sealed[:4],
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := open(tt.key, []byte(tt.aad), tt.sealed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("open() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentFormat(t *testing.T) {
+	stored := formatContent(3, []byte("sealed"))
+	version, sealed, err := parseContent(stored)
+	if err != nil {
+		t.Fatalf("parseContent() error = %v", err)
+	}
+	if version != 3 || string(sealed) != "sealed" {
+		t.Errorf("parseContent() = %v, %q, want 3, \"sealed\"", version, sealed)
+	}
+	if _, _, err := parseContent("enc:v1:x:" + base64.StdEncoding.EncodeToString([]byte("a"))); err == nil {
+		t.Error("parseContent() with invalid version, want error")
+	}
+	if _, _, err := parseContent("hello"); err == nil {
+		t.Error("parseContent() without prefix, want error")
+	}
+}
+
+func TestCipher_Disabled(t *testing.T) {
+	var c *Cipher
+	ctx := context.Background()
+
+	got, encrypted, err := c.Encrypt(ctx, "user-1", MessageContent(1), "hello")
+	if err != nil || got != "hello" || encrypted {
+		t.Errorf("Encrypt() = %q, %v, %v, want plaintext", got, encrypted, err)
+	}
+	got, err = c.Decrypt(ctx, "user-1", MessageContent(1), "hello", false)
+	if err != nil || got != "hello" {
+		t.Errorf("Decrypt() = %q, %v, want plaintext", got, err)
+	}
+	if _, err := c.Decrypt(ctx, "user-1", MessageContent(1), formatContent(1, []byte("x")), true); err == nil || !strings.Contains(err.Error(), "no encryption key") {
+		t.Errorf("Decrypt() of encrypted content error = %v, want missing key error", err)
+	}
+}
+
+func TestCipher_DecryptLocation(t *testing.T) {
+	keys, err := ParseKeyring("k1:"+testKey(1), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCipher(nil, keys)
+	key := bytes.Repeat([]byte{9}, masterKeySize)
+	c.cache[dataKeyRef{userID: "user-1", version: 1}] = key
+
+	sealed, err := seal(key, contentAAD("user-1", MessageContent(7)), []byte("secret contract"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		loc       Location
+		stored    string
+		encrypted bool
+		want      string
+		wantErr   bool
+	}{
+		{name: "same row", loc: MessageContent(7), stored: formatContent(1, sealed), encrypted: true, want: "secret contract"},
+		{name: "other message", loc: MessageContent(8), stored: formatContent(1, sealed), encrypted: true, wantErr: true},
+		{name: "copied into a share", loc: SharedMessageContent(7), stored: formatContent(1, sealed), encrypted: true, wantErr: true},
+		{name: "plaintext looking sealed", loc: MessageContent(7), stored: "enc:v1:x", want: "enc:v1:x"},
+		{name: "sealed without prefix", loc: MessageContent(7), stored: "hello", encrypted: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Decrypt(context.Background(), "user-1", tt.loc, tt.stored, tt.encrypted)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Decrypt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCipher_DecryptBytes(t *testing.T) {
+	keys, err := ParseKeyring("k1:"+testKey(1), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCipher(nil, keys)
+	key := bytes.Repeat([]byte{9}, masterKeySize)
+	c.cache[dataKeyRef{userID: "user-1", version: 1}] = key
+	// Same key for both users, only the binding tells them apart
+	c.cache[dataKeyRef{userID: "user-2", version: 1}] = key
+	ctx := context.Background()
+
+	ciphertext, err := seal(key, contentAAD("user-1", DataExportChunk("export-1", 0, false)), []byte("PK archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := append([]byte(contentPrefix+"\x00\x00\x00\x01"), ciphertext...)
+
+	tests := []struct {
+		name      string
+		userID    string
+		loc       Location
+		stored    []byte
+		encrypted bool
+		wantErr   bool
+	}{
+		{name: "same chunk", userID: "user-1", loc: DataExportChunk("export-1", 0, false), stored: sealed, encrypted: true},
+		{name: "marked final", userID: "user-1", loc: DataExportChunk("export-1", 0, true), stored: sealed, encrypted: true, wantErr: true},
+		{name: "other chunk", userID: "user-1", loc: DataExportChunk("export-1", 1, false), stored: sealed, encrypted: true, wantErr: true},
+		{name: "other export", userID: "user-1", loc: DataExportChunk("export-2", 0, false), stored: sealed, encrypted: true, wantErr: true},
+		{name: "other user", userID: "user-2", loc: DataExportChunk("export-1", 0, false), stored: sealed, encrypted: true, wantErr: true},
+		{name: "truncated", userID: "user-1", loc: DataExportChunk("export-1", 0, false), stored: sealed[:len(contentPrefix)+2], encrypted: true, wantErr: true},
+		{name: "plaintext", userID: "user-1", loc: DataExportChunk("export-1", 0, false), stored: []byte("PK archive")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.DecryptBytes(ctx, tt.userID, tt.loc, tt.stored, tt.encrypted)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecryptBytes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != "PK archive" {
+				t.Errorf("DecryptBytes() = %q, want the plaintext", got)
+			}
+		})
+	}
+}
+
+func TestCipher_Forget(t *testing.T) {
+	c := NewCipher(nil, nil)
+	c.cache[dataKeyRef{userID: "user-1", version: 1}] = []byte("a")
+	c.cache[dataKeyRef{userID: "user-1", version: 2}] = []byte("b")
+	c.cache[dataKeyRef{userID: "user-2", version: 1}] = []byte("c")
+
+	c.Forget("user-1")
+	if len(c.cache) != 1 {
+		t.Errorf("cache has %d keys, want only the key of user-2", len(c.cache))
+	}
+	if _, ok := c.cache[dataKeyRef{userID: "user-2", version: 1}]; !ok {
+		t.Error("Forget() dropped the key of another user")
+	}
+
+	var disabled *Cipher
+	disabled.Forget("user-1")
+}
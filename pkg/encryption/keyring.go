@@ -0,0 +1,96 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+)
+
+// masterKeySize is the size of AES-256 master and data keys
+const masterKeySize = 32
+
+// Keyring holds the master keys wrapping the users' data keys. New data keys are
+// wrapped with the active key, older keys stay to unwrap what they wrapped until
+// the data keys are rewrapped.
+type Keyring struct {
+	keys   map[string][]byte
+	active string
+}
+
+// LoadKeyring reads the master keys from ENCRYPTION_KEYS and ENCRYPTION_KEY_FILE.
+// It returns a nil keyring, meaning encryption is disabled, when neither is set.
+func LoadKeyring(cfg config.Config) (*Keyring, error) {
+	spec := cfg.EncryptionKeys
+	if cfg.EncryptionKeyFile != "" {
+		b, err := os.ReadFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read encryption key file: %v", err)
+		}
+		spec += "\n" + string(b)
+	}
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	return ParseKeyring(spec, cfg.EncryptionActiveKey)
+}
+
+// ParseKeyring parses master keys written as id:base64 and separated by
+// newlines, commas or semicolons. Lines starting with # are ignored. The active
+// key defaults to the last one listed.
+func ParseKeyring(spec, active string) (*Keyring, error) {
+	k := &Keyring{keys: map[string][]byte{}}
+	fields := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == '\n' || r == ',' || r == ';'
+	})
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(field, ":")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			return nil, fmt.Errorf("master key %q is not written as id:base64", field)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("master key %s is not valid base64: %v", id, err)
+		}
+		if len(key) != masterKeySize {
+			return nil, fmt.Errorf("master key %s must be %d bytes, got %d", id, masterKeySize, len(key))
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("master key %s is listed twice", id)
+		}
+		k.keys[id] = key
+		k.active = id
+	}
+
+	if len(k.keys) == 0 {
+		return nil, errors.New("no master key found")
+	}
+	if active != "" {
+		if _, ok := k.keys[active]; !ok {
+			return nil, fmt.Errorf("active master key %s is not in the keyring", active)
+		}
+		k.active = active
+	}
+	return k, nil
+}
+
+// Active returns the id of the key wrapping new data keys
+func (k *Keyring) Active() string {
+	return k.active
+}
+
+func (k *Keyring) key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("master key %s is not in the keyring", id)
+	}
+	return key, nil
+}
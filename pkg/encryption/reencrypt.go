@@ -0,0 +1,161 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+const defaultReencryptBatchSize = 200
+
+// encryptedTable is a table whose content column is sealed with the data key
+// of the user owning the row
+type encryptedTable struct {
+	name string
+	// scan selects id, owner, content and content_encrypted of the rows after
+	// id $1, at most $2
+	scan string
+	// clear, when set, empties the columns derived from the plaintext of the
+	// rows with ids in $1, they would reveal the sealed content
+	clear string
+}
+
+var encryptedTables = []encryptedTable{
+	{
+		name: "messages",
+		scan: `
+			SELECT m.id, coalesce(c.user_id, ''), m.content, m.content_encrypted
+			FROM messages m
+			JOIN conversations c ON c.id = m.conversation_id
+			WHERE m.id > $1 AND m.content IS NOT NULL
+			ORDER BY m.id ASC
+			LIMIT $2`,
+		clear: `
+			UPDATE messages SET search_en = NULL, search_vi = NULL
+			WHERE id = ANY($1) AND (search_en IS NOT NULL OR search_vi IS NOT NULL)`,
+	},
+	{
+		name: "shared_messages",
+		scan: `
+			SELECT m.id, s.user_id, m.content, m.content_encrypted
+			FROM shared_messages m
+			JOIN shared_conversations s ON s.id = m.share_id
+			WHERE m.id > $1 AND m.content IS NOT NULL
+			ORDER BY m.id ASC
+			LIMIT $2`,
+	},
+}
+
+type encryptedRow struct {
+	id        int
+	userID    string
+	content   string
+	encrypted bool
+}
+
+// Reencrypt seals again every content that is in plaintext or sealed with an
+// older data key than its owner's current one, and returns how many rows were
+// rewritten. The search vectors of messages are cleared along the way, and so
+// are the embeddings stored in plaintext, the embed-backfill command stores
+// them again sealed. It is safe to run while the server writes new messages.
+func (c *Cipher) Reencrypt(ctx context.Context, batchSize int) (int, error) {
+	if !c.Enabled() {
+		return 0, errors.New("encryption is not enabled")
+	}
+	if batchSize <= 0 {
+		batchSize = defaultReencryptBatchSize
+	}
+
+	_, err := c.db.ExecContext(ctx, `DELETE FROM message_embeddings WHERE embedding IS NOT NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("could not clear the plaintext embeddings: %v", err)
+	}
+
+	total := 0
+	for _, table := range encryptedTables {
+		n, err := c.reencryptTable(ctx, table, batchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (c *Cipher) reencryptTable(ctx context.Context, table encryptedTable, batchSize int) (int, error) {
+	updated := 0
+	lastID := 0
+	for {
+		rows, err := c.scanBatch(ctx, table, lastID, batchSize)
+		if err != nil {
+			return updated, err
+		}
+		if len(rows) == 0 {
+			return updated, nil
+		}
+		lastID = rows[len(rows)-1].id
+
+		if table.clear != "" {
+			ids := make([]int64, len(rows))
+			for idx, row := range rows {
+				ids[idx] = int64(row.id)
+			}
+			if _, err := c.db.ExecContext(ctx, table.clear, pq.Array(ids)); err != nil {
+				return updated, fmt.Errorf("could not clear the search columns of %s: %v", table.name, err)
+			}
+		}
+
+		for _, row := range rows {
+			loc := Location{Table: table.name, ID: strconv.Itoa(row.id)}
+			needed, err := c.NeedsReencrypt(ctx, row.userID, row.content, row.encrypted)
+			if err != nil {
+				return updated, fmt.Errorf("could not check %s %d: %v", table.name, row.id, err)
+			}
+			if !needed {
+				continue
+			}
+			plaintext, err := c.Decrypt(ctx, row.userID, loc, row.content, row.encrypted)
+			if err != nil {
+				return updated, fmt.Errorf("could not decrypt %s %d: %v", table.name, row.id, err)
+			}
+			sealed, encrypted, err := c.Encrypt(ctx, row.userID, loc, plaintext)
+			if err != nil {
+				return updated, fmt.Errorf("could not encrypt %s %d: %v", table.name, row.id, err)
+			}
+
+			// The content check skips rows changed since they were read
+			_, err = c.db.ExecContext(ctx, fmt.Sprintf(`
+				UPDATE %s SET content = $1, content_encrypted = $2
+				WHERE id = $3 AND content = $4 AND content_encrypted = $5`, table.name),
+				sealed, encrypted, row.id, row.content, row.encrypted)
+			if err != nil {
+				return updated, fmt.Errorf("could not update %s %d: %v", table.name, row.id, err)
+			}
+			updated++
+		}
+	}
+}
+
+func (c *Cipher) scanBatch(ctx context.Context, table encryptedTable, afterID, limit int) ([]encryptedRow, error) {
+	rows, err := c.db.QueryContext(ctx, table.scan, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not query %s to re-encrypt: %v", table.name, err)
+	}
+	defer rows.Close()
+
+	var batch []encryptedRow
+	for rows.Next() {
+		var row encryptedRow
+		if err := rows.Scan(&row.id, &row.userID, &row.content, &row.encrypted); err != nil {
+			return nil, fmt.Errorf("could not scan %s: %v", table.name, err)
+		}
+		batch = append(batch, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over %s: %v", table.name, err)
+	}
+	return batch, nil
+}
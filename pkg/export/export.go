@@ -0,0 +1,136 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Export formats
+const (
+	FormatMarkdown = "md"
+	FormatJSON     = "json"
+	FormatHTML     = "html"
+)
+
+// DocumentFormat identifies bloom JSON exports, DocumentVersion is bumped on breaking changes
+const (
+	DocumentFormat  = "bloom.conversation"
+	DocumentVersion = 1
+)
+
+// Conversation is the exported metadata of a conversation
+type Conversation struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	Pinned    bool      `json:"pinned"`
+	Archived  bool      `json:"archived"`
+	Tags      []string  `json:"tags"`
+}
+
+// Usage counts the tokens spent on completions
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Add returns the sum of two usages
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+	}
+}
+
+// Message is an exported message
+type Message struct {
+	ID        int       `json:"id"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	Model     string    `json:"model,omitempty"`
+	Usage     *Usage    `json:"usage,omitempty"`
+}
+
+// Document is the layout of a JSON export, written incrementally by the JSON writer
+type Document struct {
+	Format       string       `json:"format"`
+	Version      int          `json:"version"`
+	ExportedAt   time.Time    `json:"exported_at"`
+	Conversation Conversation `json:"conversation"`
+	Messages     []Message    `json:"messages"`
+	Usage        Usage        `json:"usage"`
+}
+
+// Writer streams one conversation: Begin once, Message for each message in
+// order, then End. Nothing is buffered beyond the message being written.
+type Writer interface {
+	Begin(c Conversation) error
+	Message(m Message) error
+	End() error
+}
+
+// NewWriter make a writer for the format
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatMarkdown:
+		return &markdownWriter{w: w}, nil
+	case FormatJSON:
+		return &jsonWriter{w: w}, nil
+	case FormatHTML:
+		return &htmlWriter{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// ContentType of a format
+func ContentType(format string) string {
+	switch format {
+	case FormatJSON:
+		return "application/json; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "text/markdown; charset=utf-8"
+	}
+}
+
+// FileName of an exported conversation, safe to use in a zip or a Content-Disposition header
+func FileName(c Conversation, format string) string {
+	return fmt.Sprintf("%s-%s.%s", c.ID, slug(c.Name), format)
+}
+
+func slug(s string) string {
+	b := make([]rune, 0, len(s))
+	dash := false
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b = append(b, r)
+			dash = false
+		case r >= 'A' && r <= 'Z':
+			b = append(b, r+'a'-'A')
+			dash = false
+		default:
+			if !dash && len(b) > 0 {
+				b = append(b, '-')
+				dash = true
+			}
+		}
+		if len(b) >= 50 {
+			break
+		}
+	}
+	for len(b) > 0 && b[len(b)-1] == '-' {
+		b = b[:len(b)-1]
+	}
+	if len(b) == 0 {
+		return "conversation"
+	}
+	return string(b)
+}
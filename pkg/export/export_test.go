@@ -0,0 +1,122 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testConversation = Conversation{
+		ID:        "42",
+		Name:      "Payment <retry> bug",
+		Model:     "Meta-Llama-3-1-8B-Instruct-FP8",
+		CreatedAt: time.Unix(1700000000, 0).UTC(),
+		Tags:      []string{"work"},
+	}
+	testMessages = []Message{
+		{ID: 1, Role: "user", Content: "Why does <script> retry?", CreatedAt: time.Unix(1700000001, 0).UTC()},
+		{ID: 2, Role: "assistant", Content: "Because of the backoff.", CreatedAt: time.Unix(1700000002, 0).UTC(),
+			Model: "Meta-Llama-3-1-8B-Instruct-FP8", Usage: &Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}},
+	}
+)
+
+func write(t *testing.T, format string, messages []Message) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := w.Begin(testConversation); err != nil {
+		t.Fatalf("Writer.Begin() error = %v", err)
+	}
+	for _, m := range messages {
+		if err := w.Message(m); err != nil {
+			t.Fatalf("Writer.Message() error = %v", err)
+		}
+	}
+	if err := w.End(); err != nil {
+		t.Fatalf("Writer.End() error = %v", err)
+	}
+	return buf.String()
+}
+
+func TestJSONWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		messages  []Message
+		wantUsage Usage
+	}{
+		{
+			name:      "conversation with usage",
+			messages:  testMessages,
+			wantUsage: Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		},
+		{
+			name:     "empty conversation",
+			messages: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc Document
+			if err := json.Unmarshal([]byte(write(t, FormatJSON, tt.messages)), &doc); err != nil {
+				t.Fatalf("json export is not valid JSON: %v", err)
+			}
+			if doc.Format != DocumentFormat || doc.Version != DocumentVersion {
+				t.Errorf("Document format = %v %v", doc.Format, doc.Version)
+			}
+			if !reflect.DeepEqual(doc.Conversation, testConversation) {
+				t.Errorf("Document.Conversation = %v, want %v", doc.Conversation, testConversation)
+			}
+			if len(doc.Messages) != len(tt.messages) {
+				t.Errorf("len(Document.Messages) = %v, want %v", len(doc.Messages), len(tt.messages))
+			}
+			if doc.Usage != tt.wantUsage {
+				t.Errorf("Document.Usage = %v, want %v", doc.Usage, tt.wantUsage)
+			}
+		})
+	}
+}
+
+func TestHTMLWriter(t *testing.T) {
+	got := write(t, FormatHTML, testMessages)
+	if strings.Contains(got, "<script>") || strings.Contains(got, "<retry>") {
+		t.Errorf("html export does not escape content: %v", got)
+	}
+	if !strings.Contains(got, "&lt;script&gt;") {
+		t.Errorf("html export is missing message content: %v", got)
+	}
+}
+
+func TestMarkdownWriter(t *testing.T) {
+	got := write(t, FormatMarkdown, testMessages)
+	for _, want := range []string{"# Payment <retry> bug", "### User", "### Assistant", "Because of the backoff."} {
+		if !strings.Contains(got, want) {
+			t.Errorf("markdown export is missing %q: %v", want, got)
+		}
+	}
+}
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		conv Conversation
+		want string
+	}{
+		{name: "slugged name", conv: Conversation{ID: "7", Name: "Hello, World!"}, want: "7-hello-world.md"},
+		{name: "no ascii letters", conv: Conversation{ID: "8", Name: "Xin chào"}, want: "8-xin-ch-o.md"},
+		{name: "empty name", conv: Conversation{ID: "9"}, want: "9-conversation.md"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FileName(tt.conv, FormatMarkdown); got != tt.want {
+				t.Errorf("FileName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package export
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+// htmlStyle is inlined so the exported page has no external dependency
+const htmlStyle = `body{font-family:-apple-system,BlinkMacSystemFont,"Segoe UI",Roboto,sans-serif;max-width:800px;margin:2rem auto;padding:0 1rem;color:#1f2328;background:#fff}
+header{border-bottom:1px solid #d0d7de;margin-bottom:1.5rem}
+.meta{color:#656d76;font-size:.9rem}
+.tag{display:inline-block;background:#eef1f4;border-radius:1rem;padding:0 .6rem;margin-right:.3rem}
+.message{border:1px solid #d0d7de;border-radius:8px;padding:.75rem 1rem;margin:1rem 0}
+.message.user{background:#f6f8fa}
+.role{font-weight:600}
+.time{color:#656d76;font-size:.8rem;margin-left:.5rem}
+.content{white-space:pre-wrap;word-wrap:break-word;margin-top:.5rem}`
+
+type htmlWriter struct {
+	w io.Writer
+}
+
+func (h *htmlWriter) Begin(c Conversation) error {
+	var b strings.Builder
+	name := html.EscapeString(c.Name)
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>%s</style>\n</head>\n<body>\n", name, htmlStyle)
+	fmt.Fprintf(&b, "<header>\n<h1>%s</h1>\n<p class=\"meta\">%s · %s</p>\n", name,
+		html.EscapeString(c.Model), c.CreatedAt.UTC().Format(time.RFC3339))
+	if len(c.Tags) > 0 {
+		b.WriteString("<p>")
+		for _, tag := range c.Tags {
+			fmt.Fprintf(&b, "<span class=\"tag\">%s</span>", html.EscapeString(tag))
+		}
+		b.WriteString("</p>\n")
+	}
+	b.WriteString("</header>\n<main>\n")
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *htmlWriter) Message(m Message) error {
+	_, err := fmt.Fprintf(h.w, "<section class=\"message %s\">\n<span class=\"role\">%s</span><span class=\"time\">%s</span>\n<div class=\"content\">%s</div>\n</section>\n",
+		html.EscapeString(m.Role), html.EscapeString(roleTitle(m.Role)),
+		m.CreatedAt.UTC().Format(time.RFC3339), html.EscapeString(m.Content))
+	return err
+}
+
+func (h *htmlWriter) End() error {
+	_, err := io.WriteString(h.w, "</main>\n</body>\n</html>\n")
+	return err
+}
@@ -0,0 +1,69 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// jsonWriter streams a Document, messages are written as they arrive and the
+// usage totals are appended at the end
+type jsonWriter struct {
+	w     io.Writer
+	count int
+	usage Usage
+}
+
+func (j *jsonWriter) Begin(c Conversation) error {
+	if c.Tags == nil {
+		c.Tags = []string{}
+	}
+	head, err := json.Marshal(struct {
+		Format       string       `json:"format"`
+		Version      int          `json:"version"`
+		ExportedAt   time.Time    `json:"exported_at"`
+		Conversation Conversation `json:"conversation"`
+	}{DocumentFormat, DocumentVersion, time.Now().UTC(), c})
+	if err != nil {
+		return err
+	}
+	// Reopen the object to append the messages
+	if _, err := j.w.Write(head[:len(head)-1]); err != nil {
+		return err
+	}
+	_, err = io.WriteString(j.w, `,"messages":[`)
+	return err
+}
+
+func (j *jsonWriter) Message(m Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if j.count > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.count++
+	if m.Usage != nil {
+		j.usage = j.usage.Add(*m.Usage)
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) End() error {
+	usage, err := json.Marshal(j.usage)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(j.w, `],"usage":`); err != nil {
+		return err
+	}
+	if _, err := j.w.Write(usage); err != nil {
+		return err
+	}
+	_, err = io.WriteString(j.w, "}\n")
+	return err
+}
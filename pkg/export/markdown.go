@@ -0,0 +1,42 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type markdownWriter struct {
+	w io.Writer
+}
+
+func (m *markdownWriter) Begin(c Conversation) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", c.Name)
+	fmt.Fprintf(&b, "- Model: %s\n", c.Model)
+	fmt.Fprintf(&b, "- Created: %s\n", c.CreatedAt.UTC().Format(time.RFC3339))
+	if len(c.Tags) > 0 {
+		fmt.Fprintf(&b, "- Tags: %s\n", strings.Join(c.Tags, ", "))
+	}
+	b.WriteString("\n---\n")
+	_, err := io.WriteString(m.w, b.String())
+	return err
+}
+
+func (m *markdownWriter) Message(msg Message) error {
+	_, err := fmt.Fprintf(m.w, "\n### %s · %s\n\n%s\n", roleTitle(msg.Role), msg.CreatedAt.UTC().Format(time.RFC3339), msg.Content)
+	return err
+}
+
+func (m *markdownWriter) End() error {
+	return nil
+}
+
+// roleTitle capitalizes a role for display
+func roleTitle(role string) string {
+	if role == "" {
+		return "Unknown"
+	}
+	return strings.ToUpper(role[:1]) + role[1:]
+}
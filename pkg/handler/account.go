@@ -0,0 +1,298 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+// accountDeletionBatch bounds the accounts deleted by a run of the job
+const accountDeletionBatch = 100
+
+// Account deletion errors, their messages are translated
+var (
+	errDeletionNeedsAccount = gerr.E(http.StatusForbidden, "only accounts can be deleted, delete your conversations instead")
+	errIncorrectPassword    = gerr.E(http.StatusForbidden, "password is incorrect")
+	errDeletionNotScheduled = gerr.E(http.StatusConflict, "account deletion is not scheduled")
+)
+
+// DeleteAccountRequest confirms the deletion of an account with its password,
+// accounts signing in only with single sign-on have none
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// AccountDeletionResponse represents when the account is deleted, if it is
+type AccountDeletionResponse struct {
+	Success             bool       `json:"success"`
+	Message             string     `json:"message"`
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt"`
+}
+
+// DeleteAccount schedules the deletion of the account
+// @Summary Delete account
+// @Description Schedules the deletion of the account after a grace period of ACCOUNT_DELETION_GRACE, during which the account keeps working and the deletion can be cancelled. The account, its conversations, messages, folders, tags, shares, feedback, usage, sessions, API keys and data exports are then deleted for good. The audit log keeps its entries. A verified email is told when the account will be deleted.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body DeleteAccountRequest false "Password of the account"
+// @Success 200 {object} AccountDeletionResponse
+// @Failure 403 {object} ErrorResponse "Anonymous visitor, or incorrect password"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /delete-account [post]
+func (h *Handler) DeleteAccount(c *gin.Context) {
+	if c.GetBool(constant.AnonymousKey) {
+		h.handleError(c, errDeletionNeedsAccount)
+		return
+	}
+
+	var req DeleteAccountRequest
+	// The body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.handleError(c, err)
+			return
+		}
+	}
+
+	res, err := h.doDeleteAccount(h.actor(c), c.GetString(constant.LanguageKey), req.Password)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doDeleteAccount(actor audit.Actor, locale, password string) (AccountDeletionResponse, error) {
+	var username, email, hash string
+	var verified bool
+	var scheduledAt *time.Time
+	err := h.db.QueryRow(`
+		SELECT coalesce(username, ''), coalesce(email, ''), email_verified_at IS NOT NULL,
+			coalesce(password_hash, ''), deletion_scheduled_at
+		FROM users
+		WHERE id = $1`, actor.UserID).Scan(&username, &email, &verified, &hash, &scheduledAt)
+	if err == sql.ErrNoRows {
+		return AccountDeletionResponse{}, errUserNotFound(actor.UserID)
+	}
+	if err != nil {
+		return AccountDeletionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not find user: %v", err)))
+	}
+
+	// A stolen access token alone does not delete the account
+	if hash != "" && !auth.CheckPassword(hash, password) {
+		return AccountDeletionResponse{}, errIncorrectPassword
+	}
+	if scheduledAt != nil {
+		return AccountDeletionResponse{
+			Success:             true,
+			Message:             "Account deletion already scheduled",
+			DeletionScheduledAt: scheduledAt,
+		}, nil
+	}
+
+	err = h.db.QueryRow(`
+		UPDATE users SET deletion_scheduled_at = coalesce(deletion_scheduled_at, now() + make_interval(secs => $2)), updated_at = now()
+		WHERE id = $1
+		RETURNING deletion_scheduled_at`, actor.UserID, h.cfg.AccountDeletionGrace.Seconds()).Scan(&scheduledAt)
+	if err != nil {
+		return AccountDeletionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not schedule account deletion: %v", err)))
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionUserScheduleDeletion,
+		TargetType: audit.TargetUser,
+		TargetID:   actor.UserID,
+		After:      map[string]time.Time{"deletion_scheduled_at": *scheduledAt},
+	})
+
+	// The deletion is scheduled either way, the email only warns the owner
+	if verified {
+		if err := h.sendTemplateEmail(locale, "email_delete", email, username, email, formatEmailTime(*scheduledAt)); err != nil {
+			h.log.Error("could not send account deletion email:", err) //nolint:errcheck // Ignore unused function warning
+		}
+	}
+
+	return AccountDeletionResponse{
+		Success:             true,
+		Message:             "Account deletion scheduled",
+		DeletionScheduledAt: scheduledAt,
+	}, nil
+}
+
+// CancelAccountDeletion cancels the scheduled deletion of the account
+// @Summary Cancel account deletion
+// @Description Keeps the account whose deletion was scheduled, as long as the grace period is not over
+// @Tags user
+// @Produce json
+// @Success 200 {object} AccountDeletionResponse
+// @Failure 409 {object} ErrorResponse "Account deletion is not scheduled"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /cancel-account-deletion [post]
+func (h *Handler) CancelAccountDeletion(c *gin.Context) {
+	res, err := h.doCancelAccountDeletion(h.actor(c))
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doCancelAccountDeletion(actor audit.Actor) (AccountDeletionResponse, error) {
+	var scheduledAt time.Time
+	err := h.db.QueryRow(`
+		UPDATE users u SET deletion_scheduled_at = NULL, updated_at = now()
+		FROM (SELECT id, deletion_scheduled_at FROM users WHERE id = $1 FOR UPDATE) before
+		WHERE u.id = before.id AND before.deletion_scheduled_at IS NOT NULL
+		RETURNING before.deletion_scheduled_at`, actor.UserID).Scan(&scheduledAt)
+	if err == sql.ErrNoRows {
+		return AccountDeletionResponse{}, errDeletionNotScheduled
+	}
+	if err != nil {
+		return AccountDeletionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not cancel account deletion: %v", err)))
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionUserCancelDeletion,
+		TargetType: audit.TargetUser,
+		TargetID:   actor.UserID,
+		Before:     map[string]time.Time{"deletion_scheduled_at": scheduledAt},
+	})
+
+	return AccountDeletionResponse{
+		Success: true,
+		Message: "Account deletion cancelled",
+	}, nil
+}
+
+// DeleteScheduledAccounts deletes the accounts whose grace period is over
+func (h *Handler) DeleteScheduledAccounts(ctx context.Context) error {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id FROM users
+		WHERE deletion_scheduled_at <= now()
+		ORDER BY deletion_scheduled_at ASC
+		LIMIT $1`, accountDeletionBatch)
+	if err != nil {
+		return fmt.Errorf("error querying accounts to delete: %v", err)
+	}
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning account to delete: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over accounts to delete: %v", err)
+	}
+
+	for _, userID := range userIDs {
+		if err := h.deleteAccount(ctx, userID); err != nil {
+			return err
+		}
+	}
+	if len(userIDs) > 0 {
+		h.log.Info("deleted ", len(userIDs), " accounts") //nolint:errcheck // Ignore unused function warning
+	}
+	return nil
+}
+
+// deleteAccount deletes a user and everything they own, unless the deletion
+// was cancelled meanwhile. The entries of the user stay in the audit log,
+// erased of their ip, user agent and values.
+func (h *Handler) deleteAccount(ctx context.Context, userID string) (err error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	deleted := false
+	var conversations, auditEntries int64
+
+	// Rollback the transaction if there's an error
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback() //nolint:errcheck // the panic is what matters
+			panic(p)      // Re-raise the panic
+		} else if err != nil {
+			tx.Rollback() //nolint:errcheck // the original error is returned
+		} else if err = tx.Commit(); err != nil {
+			err = fmt.Errorf("could not commit account deletion: %v", err)
+		} else if deleted {
+			h.cipher.Forget(userID)
+			h.recordAudit(audit.Entry{
+				Actor:      audit.System(),
+				Action:     audit.ActionUserDelete,
+				TargetType: audit.TargetUser,
+				TargetID:   userID,
+				Before:     map[string]int64{"conversations": conversations, "erased_audit_entries": auditEntries},
+			})
+		}
+	}()
+
+	var due bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT coalesce(deletion_scheduled_at <= now(), false)
+		FROM users
+		WHERE id = $1
+		FOR UPDATE`, userID).Scan(&due)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not lock account %s: %v", userID, err)
+	}
+	if !due {
+		return nil
+	}
+
+	// Messages, their embeddings and tags go with the conversations, sessions,
+	// API keys, identities, usage, tokens and data exports with the user
+	result, err := tx.ExecContext(ctx, `DELETE FROM conversations WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("could not delete conversations of %s: %v", userID, err)
+	}
+	if conversations, err = result.RowsAffected(); err != nil {
+		return fmt.Errorf("could not count deleted conversations of %s: %v", userID, err)
+	}
+
+	statements := []struct {
+		what  string
+		query string
+	}{
+		{"feedback", `DELETE FROM message_feedback WHERE user_id = $1`},
+		{"shares", `DELETE FROM shared_conversations WHERE user_id = $1`},
+		{"folders", `DELETE FROM folders WHERE user_id = $1`},
+		{"tags", `DELETE FROM tags WHERE user_id = $1`},
+		{"retention policy", `DELETE FROM retention_policies WHERE user_id = $1`},
+		{"data keys", `DELETE FROM user_data_keys WHERE user_id = $1`},
+		{"user", `DELETE FROM users WHERE id = $1`},
+	}
+	for _, s := range statements {
+		if _, err = tx.ExecContext(ctx, s.query, userID); err != nil {
+			return fmt.Errorf("could not delete %s of %s: %v", s.what, userID, err)
+		}
+	}
+
+	if auditEntries, err = audit.Erase(ctx, tx, userID); err != nil {
+		return fmt.Errorf("could not erase audit entries of %s: %v", userID, err)
+	}
+
+	deleted = true
+	return nil
+}
@@ -0,0 +1,697 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultUserPageSize = 50
+	defaultUsageDays    = 30
+)
+
+// errAdminSelf keeps admins from locking themselves out, its message is translated
+var errAdminSelf = gerr.E(http.StatusBadRequest, "admins cannot disable, log out or demote themselves")
+
+func errUserNotFound(userID string) error {
+	return gerr.E(http.StatusNotFound, fmt.Sprintf("user with id %s not found", userID))
+}
+
+// AdminUser is a user as seen by admins
+type AdminUser struct {
+	ID                string     `json:"id"`
+	Username          string     `json:"username"`
+	Email             string     `json:"email"`
+	Anonymous         bool       `json:"anonymous"`
+	Role              string     `json:"role"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	DisabledAt        *time.Time `json:"disabledAt"`
+	DisabledReason    string     `json:"disabledReason"`
+	SessionsRevokedAt *time.Time `json:"sessionsRevokedAt"`
+	DailyMessageQuota *int       `json:"dailyMessageQuota"` // DAILY_MESSAGE_QUOTA applies when null
+}
+
+const adminUserColumns = `u.id, coalesce(u.username, ''), coalesce(u.email, ''), u.username IS NULL, u.role,
+	u.created_at, u.disabled_at, coalesce(u.disabled_reason, ''), u.sessions_revoked_at, u.daily_message_quota`
+
+// scanAdminUser reads a row selected with adminUserColumns, followed by any extra columns
+func scanAdminUser(row rowScanner, extra ...interface{}) (AdminUser, error) {
+	var u AdminUser
+	dest := []interface{}{
+		&u.ID, &u.Username, &u.Email, &u.Anonymous, &u.Role,
+		&u.CreatedAt, &u.DisabledAt, &u.DisabledReason, &u.SessionsRevokedAt, &u.DailyMessageQuota,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return u, err
+}
+
+// GetUserListRequest holds the filters of the user list
+type GetUserListRequest struct {
+	Query     string `form:"q" binding:"max=100"`
+	Role      string `form:"role" binding:"omitempty,oneof=user admin"`
+	Status    string `form:"status" binding:"omitempty,oneof=active disabled"`
+	Anonymous bool   `form:"anonymous"`
+	Page      int    `form:"page" binding:"omitempty,min=1"`
+	PageSize  int    `form:"page_size" binding:"omitempty,min=1,max=500"`
+}
+
+// GetUserListResponse represents a page of users
+type GetUserListResponse struct {
+	Success  bool        `json:"success"`
+	Message  string      `json:"message"`
+	Users    []AdminUser `json:"users"`
+	Page     int         `json:"page"`
+	PageSize int         `json:"page_size"`
+	Total    int         `json:"total"`
+}
+
+// GetUserList lists and searches users, for admins only
+// @Summary List users
+// @Description Lists accounts, newest first, searching their id, username or email. Anonymous visitors are only listed with anonymous=true
+// @Tags admin
+// @Produce json
+// @Param q query string false "Part of the username or email, or the exact id"
+// @Param role query string false "user or admin"
+// @Param status query string false "active or disabled"
+// @Param anonymous query bool false "Include anonymous visitors"
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Users per page (max 500)"
+// @Success 200 {object} GetUserListResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /admin/get-user-list [get]
+func (h *Handler) GetUserList(c *gin.Context) {
+	var req GetUserListRequest
+
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	if req.PageSize == 0 {
+		req.PageSize = defaultUserPageSize
+	}
+
+	res, err := h.doGetUserList(c.Request.Context(), req)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetUserList(ctx context.Context, req GetUserListRequest) (GetUserListResponse, error) {
+	// Build the filters, every value is passed as a query argument
+	args := []interface{}{}
+	filters := "TRUE"
+	if !req.Anonymous {
+		filters += " AND u.username IS NOT NULL"
+	}
+	if q := strings.TrimSpace(req.Query); q != "" {
+		args = append(args, q, "%"+escapeLike(q)+"%")
+		filters += fmt.Sprintf(" AND (u.id = $%d OR u.username ILIKE $%d OR u.email ILIKE $%d)", len(args)-1, len(args), len(args))
+	}
+	if req.Role != "" {
+		args = append(args, req.Role)
+		filters += fmt.Sprintf(" AND u.role = $%d", len(args))
+	}
+	switch req.Status {
+	case "active":
+		filters += " AND u.disabled_at IS NULL"
+	case "disabled":
+		filters += " AND u.disabled_at IS NOT NULL"
+	}
+
+	args = append(args, req.PageSize, (req.Page-1)*req.PageSize)
+	rows, err := h.reader().QueryContext(ctx, `
+		SELECT `+adminUserColumns+`, count(*) OVER ()
+		FROM users u
+		WHERE `+filters+`
+		ORDER BY u.created_at DESC, u.id
+		LIMIT $`+fmt.Sprint(len(args)-1)+` OFFSET $`+fmt.Sprint(len(args)), args...)
+	if err != nil {
+		return GetUserListResponse{}, fmt.Errorf("error querying users: %v", err)
+	}
+	defer rows.Close()
+
+	users := []AdminUser{}
+	total := 0
+	for rows.Next() {
+		u, err := scanAdminUser(rows, &total)
+		if err != nil {
+			return GetUserListResponse{}, fmt.Errorf("error scanning user: %v", err)
+		}
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return GetUserListResponse{}, fmt.Errorf("error iterating over users: %v", err)
+	}
+
+	return GetUserListResponse{
+		Success:  len(users) > 0,
+		Message:  fmt.Sprintf("Found %d users", total),
+		Users:    users,
+		Page:     req.Page,
+		PageSize: req.PageSize,
+		Total:    total,
+	}, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// DailyUsage is the number of messages a user sent in a day
+type DailyUsage struct {
+	Day      string `json:"day"`
+	Messages int    `json:"messages"`
+}
+
+// GetUserUsageResponse represents the usage of a user
+type GetUserUsageResponse struct {
+	Success           bool         `json:"success"`
+	Message           string       `json:"message"`
+	User              AdminUser    `json:"user"`
+	Conversations     int          `json:"conversations"`
+	Messages          int          `json:"messages"`
+	MessagesToday     int          `json:"messagesToday"`
+	DailyMessageQuota int          `json:"dailyMessageQuota"` // Quota in effect, 0 is unlimited
+	Days              []DailyUsage `json:"days"`
+}
+
+// GetUserUsage reports the usage of a user, for admins only
+// @Summary Get the usage of a user
+// @Description Counts the conversations and messages of a user, and the messages it sent each of the last days
+// @Tags admin
+// @Produce json
+// @Param user_id path string true "User ID"
+// @Param days query int false "Days of daily usage (default 30, max 366)"
+// @Success 200 {object} GetUserUsageResponse
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /admin/get-user-usage/{user_id} [get]
+func (h *Handler) GetUserUsage(c *gin.Context) {
+	var req struct {
+		Days int `form:"days" binding:"omitempty,min=1,max=366"`
+	}
+
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	if req.Days == 0 {
+		req.Days = defaultUsageDays
+	}
+
+	res, err := h.doGetUserUsage(c.Request.Context(), c.Param("user_id"), req.Days)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetUserUsage(ctx context.Context, userID string, days int) (GetUserUsageResponse, error) {
+	user, err := h.getAdminUser(ctx, userID)
+	if err != nil {
+		return GetUserUsageResponse{}, err
+	}
+
+	res := GetUserUsageResponse{
+		Success:           true,
+		Message:           fmt.Sprintf("Usage of user %s", userID),
+		User:              user,
+		DailyMessageQuota: h.cfg.DailyMessageQuota,
+		Days:              []DailyUsage{},
+	}
+	if user.DailyMessageQuota != nil {
+		res.DailyMessageQuota = *user.DailyMessageQuota
+	}
+
+	err = h.reader().QueryRowContext(ctx, `
+		SELECT
+			(SELECT count(*) FROM conversations WHERE user_id = $1 AND deleted_at IS NULL),
+			(SELECT count(*) FROM messages m JOIN conversations c ON c.id = m.conversation_id
+				WHERE c.user_id = $1 AND c.deleted_at IS NULL)`, userID).Scan(&res.Conversations, &res.Messages)
+	if err != nil {
+		return GetUserUsageResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not count conversations: %v", err)))
+	}
+
+	rows, err := h.reader().QueryContext(ctx, `
+		SELECT to_char(day, 'YYYY-MM-DD'), messages, day = current_date
+		FROM user_usage
+		WHERE user_id = $1 AND day > current_date - $2::int
+		ORDER BY day DESC`, userID, days)
+	if err != nil {
+		return GetUserUsageResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("error querying usage: %v", err)))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d DailyUsage
+		var today bool
+		if err := rows.Scan(&d.Day, &d.Messages, &today); err != nil {
+			return GetUserUsageResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("error scanning usage: %v", err)))
+		}
+		if today {
+			res.MessagesToday = d.Messages
+		}
+		res.Days = append(res.Days, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return GetUserUsageResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("error iterating over usage: %v", err)))
+	}
+	return res, nil
+}
+
+// AdminViewRequest holds why an admin reads the conversations of a user, which
+// is recorded in the audit log
+type AdminViewRequest struct {
+	Reason string `form:"reason" binding:"required,max=500"`
+}
+
+// GetUserChatList lists the conversations of a user, for admins only
+// @Summary List the conversations of a user
+// @Description Lists the user's conversations as get-chat-list does. The reason is recorded in the audit log
+// @Tags admin
+// @Produce json
+// @Param user_id path string true "User ID"
+// @Param reason query string true "Why the conversations are read"
+// @Param archived query bool false "List archived conversations instead of active ones"
+// @Success 200 {object} GetAllChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /admin/get-user-chat-list/{user_id} [get]
+func (h *Handler) GetUserChatList(c *gin.Context) {
+	var view AdminViewRequest
+	var req GetAllChatRequest
+
+	err := c.ShouldBindQuery(&view)
+	if err == nil {
+		err = c.ShouldBindQuery(&req)
+	}
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	userID := c.Param("user_id")
+	if _, err := h.getAdminUser(c.Request.Context(), userID); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.doGetAllChat(userID, req)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      h.actor(c),
+		Action:     audit.ActionAdminViewConversations,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+		After:      map[string]interface{}{"reason": view.Reason, "archived": req.Archived},
+	})
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", res)
+}
+
+// GetUserChatResponse represents a conversation of a user and its messages
+type GetUserChatResponse struct {
+	Success      bool            `json:"success"`
+	Message      string          `json:"message"`
+	Conversation Conversation    `json:"conversation"`
+	Messages     json.RawMessage `json:"messages"`
+}
+
+// GetUserChat reads a conversation of a user, for admins only
+// @Summary Read a conversation of a user
+// @Description Returns the conversation and its messages. The reason is recorded in the audit log
+// @Tags admin
+// @Produce json
+// @Param user_id path string true "User ID"
+// @Param conversation_id path string true "Conversation ID"
+// @Param reason query string true "Why the conversation is read"
+// @Success 200 {object} GetUserChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Failure 404 {object} ErrorResponse "Conversation not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /admin/get-user-chat/{user_id}/{conversation_id} [get]
+func (h *Handler) GetUserChat(c *gin.Context) {
+	var view AdminViewRequest
+
+	err := c.ShouldBindQuery(&view)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	userID := c.Param("user_id")
+	conversationID := c.Param("conversation_id")
+
+	chat, err := h.doGetChatByID(userID, conversationID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	messages, err := h.doGetAllMsgsByID(c.Request.Context(), userID, conversationID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      h.actor(c),
+		Action:     audit.ActionAdminViewConversation,
+		TargetType: audit.TargetConversation,
+		TargetID:   conversationID,
+		After:      map[string]interface{}{"reason": view.Reason, "user_id": userID},
+	})
+
+	c.JSON(http.StatusOK, GetUserChatResponse{
+		Success:      true,
+		Message:      chat.Message,
+		Conversation: chat.Conversation,
+		Messages:     messages,
+	})
+}
+
+// DisableUserRequest disables an account
+type DisableUserRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// DisableUser bans an account, for admins only
+// @Summary Disable a user
+// @Description Bans the account and logs it out everywhere. Disabled users cannot sign in, refresh their tokens or use their API keys until enabled again
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body DisableUserRequest true "User and reason"
+// @Success 200 {object} AdminUserResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /admin/disable-user [post]
+func (h *Handler) DisableUser(c *gin.Context) {
+	var req DisableUserRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.updateUser(c, audit.ActionUserDisable, req.UserID, true, `
+		disabled_at = coalesce(u.disabled_at, now()), disabled_reason = $2, sessions_revoked_at = now()`, req.Reason)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// AdminUserRequest names the user an admin acts on
+type AdminUserRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+}
+
+// EnableUser lifts the ban of an account, for admins only
+// @Summary Enable a user
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body AdminUserRequest true "User"
+// @Success 200 {object} AdminUserResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /admin/enable-user [post]
+func (h *Handler) EnableUser(c *gin.Context) {
+	var req AdminUserRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.updateUser(c, audit.ActionUserEnable, req.UserID, false, `
+		disabled_at = NULL, disabled_reason = NULL`)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// LogoutUser logs an account out everywhere, for admins only
+// @Summary Log a user out
+// @Description Revokes every session of the account. Access tokens already issued are rejected too
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body AdminUserRequest true "User"
+// @Success 200 {object} AdminUserResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /admin/logout-user [post]
+func (h *Handler) LogoutUser(c *gin.Context) {
+	var req AdminUserRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.updateUser(c, audit.ActionUserForceLogout, req.UserID, true, `
+		sessions_revoked_at = now()`)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// SetUserQuotaRequest sets the daily message quota of an account
+type SetUserQuotaRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+	// DailyMessageQuota is the number of messages the user may send a day, 0
+	// for unlimited, or null to apply DAILY_MESSAGE_QUOTA
+	DailyMessageQuota *int `json:"daily_message_quota" binding:"omitempty,min=0,max=1000000"`
+}
+
+// SetUserQuota adjusts the daily message quota of an account, for admins only
+// @Summary Set the quota of a user
+// @Description Sets how many messages the user may send a day, 0 for unlimited, or null to apply the default quota
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body SetUserQuotaRequest true "User and quota"
+// @Success 200 {object} AdminUserResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /admin/set-user-quota [post]
+func (h *Handler) SetUserQuota(c *gin.Context) {
+	var req SetUserQuotaRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.updateUser(c, audit.ActionUserSetQuota, req.UserID, false, `
+		daily_message_quota = $2`, req.DailyMessageQuota)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// SetUserRoleRequest sets the role of an account
+type SetUserRoleRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+	Role   string `json:"role" binding:"required,oneof=user admin"`
+}
+
+// SetUserRole makes an account an admin or a user, for admins only
+// @Summary Set the role of a user
+// @Description The role applies from the next request of the user
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body SetUserRoleRequest true "User and role"
+// @Success 200 {object} AdminUserResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /admin/set-user-role [post]
+func (h *Handler) SetUserRole(c *gin.Context) {
+	var req SetUserRoleRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Demoting oneself would leave no way back
+	if req.Role != auth.RoleAdmin && req.UserID == c.GetString(constant.UserIDKey) {
+		h.handleError(c, errAdminSelf)
+		return
+	}
+
+	res, err := h.updateUser(c, audit.ActionUserSetRole, req.UserID, false, `
+		role = $2`, req.Role)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// AdminUserResponse represents a user after an admin changed it
+type AdminUserResponse struct {
+	Success bool      `json:"success"`
+	Message string    `json:"message"`
+	User    AdminUser `json:"user"`
+}
+
+// updateUser applies set, an UPDATE assignment list taking the user id as $1
+// and arg as $2, to an account and audits it. revokeSessions ends the sessions
+// of the user in the same transaction. Admins cannot revoke their own sessions.
+func (h *Handler) updateUser(c *gin.Context, action, userID string, revokeSessions bool, set string, args ...interface{}) (res AdminUserResponse, err error) {
+	actor := h.actor(c)
+	if revokeSessions && userID == actor.UserID {
+		return res, errAdminSelf
+	}
+
+	tx, err := h.db.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not begin transaction: %v", err)))
+	}
+
+	var before AdminUser
+
+	// Rollback the transaction if there's an error
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback() //nolint:errcheck // the panic is what matters
+			panic(p)      // Re-raise the panic
+		} else if err != nil {
+			tx.Rollback() //nolint:errcheck // the original error is returned
+		} else if err = tx.Commit(); err != nil {
+			err = gerr.E(500, gerr.Trace(fmt.Errorf("could not commit user change: %v", err)))
+		} else {
+			h.recordAudit(audit.Entry{
+				Actor:      actor,
+				Action:     action,
+				TargetType: audit.TargetUser,
+				TargetID:   userID,
+				Before:     before.auditState(),
+				After:      res.User.auditState(),
+			})
+		}
+	}()
+
+	// Lock the account so that the audited state before is the one changed
+	before, err = scanAdminUser(tx.QueryRow(`
+		SELECT `+adminUserColumns+` FROM users u
+		WHERE u.id = $1 AND u.username IS NOT NULL
+		FOR UPDATE`, userID))
+	if err == sql.ErrNoRows {
+		return res, errUserNotFound(userID)
+	}
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not find user: %v", err)))
+	}
+
+	res.User, err = scanAdminUser(tx.QueryRow(`
+		UPDATE users u SET `+set+`
+		WHERE u.id = $1
+		RETURNING `+adminUserColumns, append([]interface{}{userID}, args...)...))
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not update user: %v", err)))
+	}
+
+	if revokeSessions {
+		_, err = tx.Exec(`
+			UPDATE user_sessions SET revoked_at = now()
+			WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+		if err != nil {
+			return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not revoke sessions: %v", err)))
+		}
+	}
+
+	res.Success = true
+	res.Message = fmt.Sprintf("User %s updated", userID)
+	return res, nil
+}
+
+// auditState is what the audit log records of a user change
+func (u AdminUser) auditState() map[string]interface{} {
+	return map[string]interface{}{
+		"role":                u.Role,
+		"disabled_at":         u.DisabledAt,
+		"disabled_reason":     u.DisabledReason,
+		"sessions_revoked_at": u.SessionsRevokedAt,
+		"daily_message_quota": u.DailyMessageQuota,
+	}
+}
+
+// getAdminUser reads any user, anonymous visitors included
+func (h *Handler) getAdminUser(ctx context.Context, userID string) (AdminUser, error) {
+	user, err := scanAdminUser(h.reader().QueryRowContext(ctx, `
+		SELECT `+adminUserColumns+` FROM users u WHERE u.id = $1`, userID))
+	if err == sql.ErrNoRows {
+		return AdminUser{}, errUserNotFound(userID)
+	}
+	if err != nil {
+		return AdminUser{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not find user: %v", err)))
+	}
+	return user, nil
+}
@@ -0,0 +1,404 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/ratelimit"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// apiKeyUseInterval is how often the last use of an API key is written, at most
+const apiKeyUseInterval = time.Minute
+
+// API key errors, their messages are translated
+var (
+	errAPIKeyScope        = gerr.E(http.StatusForbidden, "API key does not allow this request")
+	errAPIKeyNeedsAccount = gerr.E(http.StatusForbidden, "sign up to create API keys")
+	errTooManyRequests    = gerr.E(http.StatusTooManyRequests, "too many requests")
+)
+
+// apiKeyScopes lists the routes API keys may call and the scope each requires,
+// an empty scope being granted to every key. Other routes, like the management
+// of API keys, require an access token.
+var apiKeyScopes = map[string]string{
+	"GET /get-current-user":                    "",
+	"GET /get-chat-by-id/:conversation_id":     auth.ScopeChatRead,
+	"GET /get-chat-list":                       auth.ScopeChatRead,
+	"GET /get-all-msgs-by-id/:conversation_id": auth.ScopeChatRead,
+	"GET /search-chat":                         auth.ScopeChatRead,
+	"GET /semantic-search-chat":                auth.ScopeChatRead,
+	"GET /get-trash-list":                      auth.ScopeChatRead,
+	"GET /get-folder-list":                     auth.ScopeChatRead,
+	"GET /get-tag-list":                        auth.ScopeChatRead,
+	"GET /get-share-list":                      auth.ScopeChatRead,
+	"POST /send-chat":                          auth.ScopeChatWrite,
+	"DELETE /delete-chat/:conversation_id":     auth.ScopeChatWrite,
+	"DELETE /delete-all-chat":                  auth.ScopeChatWrite,
+	"POST /edit-chat":                          auth.ScopeChatWrite,
+	"POST /fork-chat":                          auth.ScopeChatWrite,
+	"POST /restore-chat/:conversation_id":      auth.ScopeChatWrite,
+	"DELETE /purge-chat/:conversation_id":      auth.ScopeChatWrite,
+	"DELETE /empty-trash":                      auth.ScopeChatWrite,
+	"POST /pin-chat":                           auth.ScopeChatWrite,
+	"POST /archive-chat":                       auth.ScopeChatWrite,
+	"POST /move-chat":                          auth.ScopeChatWrite,
+	"POST /bulk-chat":                          auth.ScopeChatWrite,
+	"POST /create-folder":                      auth.ScopeChatWrite,
+	"POST /edit-folder":                        auth.ScopeChatWrite,
+	"DELETE /delete-folder/:folder_id":         auth.ScopeChatWrite,
+	"POST /tag-chat":                           auth.ScopeChatWrite,
+	"POST /untag-chat":                         auth.ScopeChatWrite,
+	"DELETE /delete-tag/:tag_id":               auth.ScopeChatWrite,
+	"POST /import-chat":                        auth.ScopeChatWrite,
+	"POST /share-chat":                         auth.ScopeChatWrite,
+	"DELETE /revoke-share/:share_id":           auth.ScopeChatWrite,
+	"POST /fork-shared-chat/:token":            auth.ScopeChatWrite,
+	"POST /set-feedback":                       auth.ScopeChatWrite,
+	"DELETE /clear-feedback/:message_id":       auth.ScopeChatWrite,
+	"GET /export-chat/:conversation_id":        auth.ScopeExport,
+	"GET /export-all-chat":                     auth.ScopeExport,
+}
+
+// APIKey is a personal API key, without the key itself which is only returned once
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  *int       `json:"rateLimit"` // Requests per minute, unlimited when null
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+// CreateAPIKeyRequest creates an API key, ExpiresInDays and RateLimit are optional
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,max=3,dive,oneof=chat:read chat:write export"`
+	ExpiresInDays *int     `json:"expires_in_days" binding:"omitempty,min=1,max=3650"`
+	RateLimit     *int     `json:"rate_limit" binding:"omitempty,min=1,max=10000"`
+}
+
+// CreateAPIKeyResponse represents a created API key, Key is never returned again
+type CreateAPIKeyResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Key     string `json:"key"`
+	APIKey  APIKey `json:"apiKey"`
+}
+
+// GetAPIKeyListResponse represents the API keys of a user
+type GetAPIKeyListResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message"`
+	APIKeys []APIKey `json:"apiKeys"`
+}
+
+func errAPIKeyNotFound(id string) error {
+	return gerr.E(http.StatusNotFound, fmt.Sprintf("API key with id %s not found", id))
+}
+
+// CreateAPIKey creates a personal API key
+// @Summary Create an API key
+// @Description Creates an API key to send as `Authorization: Bearer <key>` from scripts, granting the routes of its scopes. The key is only returned in this response, only a hash of it is stored.
+// @Tags api-key
+// @Accept json
+// @Produce json
+// @Param request body CreateAPIKeyRequest true "Name, scopes, and optional expiry and rate limit in requests per minute"
+// @Success 200 {object} CreateAPIKeyResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Anonymous visitors cannot create API keys"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /create-api-key [post]
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// API keys act on behalf of accounts, anonymous visitors have none
+	if c.GetBool(constant.AnonymousKey) {
+		h.handleError(c, errAPIKeyNeedsAccount)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doCreateAPIKey(h.actor(c), userID, req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doCreateAPIKey(actor audit.Actor, userID string, req CreateAPIKeyRequest) (CreateAPIKeyResponse, error) {
+	key, hint, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return CreateAPIKeyResponse{}, gerr.E(500, gerr.Trace(err))
+	}
+
+	apiKey := APIKey{
+		Name:      req.Name,
+		Hint:      hint,
+		Scopes:    uniqueStrings(req.Scopes),
+		RateLimit: req.RateLimit,
+	}
+	if req.ExpiresInDays != nil {
+		expiresAt := time.Now().AddDate(0, 0, *req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	err = h.db.QueryRow(`
+		INSERT INTO api_keys (user_id, name, hint, key_hash, scopes, rate_limit, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`,
+		userID, apiKey.Name, hint, hash, pq.Array(apiKey.Scopes), apiKey.RateLimit, apiKey.ExpiresAt).
+		Scan(&apiKey.ID, &apiKey.CreatedAt)
+	if err != nil {
+		return CreateAPIKeyResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not create API key: %v", err)))
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionAPIKeyCreate,
+		TargetType: audit.TargetAPIKey,
+		TargetID:   strconv.Itoa(apiKey.ID),
+		After: map[string]interface{}{
+			"name":       apiKey.Name,
+			"scopes":     apiKey.Scopes,
+			"rate_limit": apiKey.RateLimit,
+			"expires_at": apiKey.ExpiresAt,
+		},
+	})
+
+	return CreateAPIKeyResponse{
+		Success: true,
+		Message: "API key created, store it now as it will not be shown again",
+		Key:     key,
+		APIKey:  apiKey,
+	}, nil
+}
+
+// GetAPIKeyList lists the API keys of the user
+// @Summary List API keys
+// @Description Lists the user's API keys, including revoked and expired ones, without the keys themselves
+// @Tags api-key
+// @Produce json
+// @Success 200 {object} GetAPIKeyListResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /get-api-key-list [get]
+func (h *Handler) GetAPIKeyList(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doGetAPIKeyList(userID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetAPIKeyList(userID string) (GetAPIKeyListResponse, error) {
+	rows, err := h.db.Query(`
+		SELECT id, name, hint, scopes, rate_limit, created_at, expires_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return GetAPIKeyListResponse{}, fmt.Errorf("error querying API keys: %v", err)
+	}
+	defer rows.Close()
+
+	apiKeys := []APIKey{}
+	for rows.Next() {
+		var apiKey APIKey
+		if err := rows.Scan(&apiKey.ID, &apiKey.Name, &apiKey.Hint, pq.Array(&apiKey.Scopes), &apiKey.RateLimit,
+			&apiKey.CreatedAt, &apiKey.ExpiresAt, &apiKey.LastUsedAt, &apiKey.RevokedAt); err != nil {
+			return GetAPIKeyListResponse{}, fmt.Errorf("error scanning API key: %v", err)
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	if err := rows.Err(); err != nil {
+		return GetAPIKeyListResponse{}, fmt.Errorf("error iterating over API keys: %v", err)
+	}
+
+	return GetAPIKeyListResponse{
+		Success: len(apiKeys) > 0,
+		Message: fmt.Sprintf("Found %d API keys", len(apiKeys)),
+		APIKeys: apiKeys,
+	}, nil
+}
+
+// RevokeAPIKey makes an API key unusable
+// @Summary Revoke an API key
+// @Tags api-key
+// @Produce json
+// @Param key_id path int true "API key ID"
+// @Success 200 {object} organizeChatResponse
+// @Failure 404 {object} ErrorResponse "API key not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /revoke-api-key/{key_id} [delete]
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	id := c.Param("key_id")
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doRevokeAPIKey(h.actor(c), userID, id)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doRevokeAPIKey(actor audit.Actor, userID, id string) (organizeChatResponse, error) {
+	if _, err := strconv.Atoi(id); err != nil {
+		return organizeChatResponse{}, errAPIKeyNotFound(id)
+	}
+
+	var name string
+	err := h.db.QueryRow(`
+		UPDATE api_keys SET revoked_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+		RETURNING name`, id, userID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return organizeChatResponse{}, errAPIKeyNotFound(id)
+	}
+	if err != nil {
+		return organizeChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not revoke API key: %v", err)))
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionAPIKeyRevoke,
+		TargetType: audit.TargetAPIKey,
+		TargetID:   id,
+		Before:     map[string]interface{}{"name": name},
+	})
+
+	return organizeChatResponse{
+		Success: true,
+		Message: "API key revoked",
+	}, nil
+}
+
+// apiKeyOwner is what authenticating a request with an API key needs
+type apiKeyOwner struct {
+	ID         int
+	UserID     string
+	Role       string
+	Disabled   bool
+	Scopes     []string
+	RateLimit  *int
+	LastUsedAt *time.Time
+}
+
+// authenticateAPIKey authenticates the request as the owner of the API key,
+// when the key grants the route and is within its rate limit
+func (h *Handler) authenticateAPIKey(c *gin.Context, key string) {
+	owner, err := h.loadAPIKey(c.Request.Context(), key)
+	if err == sql.ErrNoRows {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		h.handleError(c, errAuthenticationRequired)
+		return
+	}
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+	if owner.Disabled {
+		h.handleError(c, errAccountDisabled)
+		return
+	}
+
+	scope, ok := apiKeyScopes[c.Request.Method+" "+c.FullPath()]
+	if !ok || (scope != "" && !auth.HasScope(owner.Scopes, scope)) {
+		if scope != "" {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+		}
+		h.handleError(c, errAPIKeyScope)
+		return
+	}
+
+	if owner.RateLimit != nil {
+		res, err := h.limiter.Allow(c.Request.Context(), "api_key:"+strconv.Itoa(owner.ID), ratelimit.PerMinute(*owner.RateLimit))
+		if err != nil {
+			// The limits of routes still apply, the key is not refused for it
+			h.log.Error("could not rate limit API key:", err) //nolint:errcheck // Ignore unused function warning
+		} else {
+			ratelimit.SetHeaders(c.Writer.Header(), res)
+			if !res.Allowed {
+				h.handleError(c, errTooManyRequests)
+				return
+			}
+		}
+	}
+
+	c.Set(constant.UserIDKey, owner.UserID)
+	c.Set(constant.RoleKey, owner.Role)
+	c.Set(constant.APIKeyIDKey, strconv.Itoa(owner.ID))
+	c.Set(constant.APIKeyScopesKey, owner.Scopes)
+	c.Next()
+}
+
+// loadAPIKey reads the owner of an active API key, sql.ErrNoRows for unknown,
+// revoked or expired keys
+func (h *Handler) loadAPIKey(ctx context.Context, key string) (apiKeyOwner, error) {
+	var owner apiKeyOwner
+	err := h.db.QueryRowContext(ctx, `
+		SELECT k.id, k.user_id, u.role, u.disabled_at IS NOT NULL, k.scopes, k.rate_limit, k.last_used_at
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > now())`,
+		auth.HashToken(key)).
+		Scan(&owner.ID, &owner.UserID, &owner.Role, &owner.Disabled, pq.Array(&owner.Scopes), &owner.RateLimit, &owner.LastUsedAt)
+	if err == sql.ErrNoRows {
+		return owner, err
+	}
+	if err != nil {
+		return owner, fmt.Errorf("could not load API key: %v", err)
+	}
+
+	// The last use is approximate, scripts calling in a loop do not write every request
+	if owner.LastUsedAt == nil || time.Since(*owner.LastUsedAt) >= apiKeyUseInterval {
+		if _, err := h.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = now() WHERE id = $1`, owner.ID); err != nil {
+			h.log.Error("could not record API key use:", err) //nolint:errcheck // Ignore unused function warning
+		}
+	}
+	return owner, nil
+}
+
+// uniqueStrings removes the duplicates of values, keeping their order
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultAuditPageSize = 50
+
+// GetAuditLogRequest holds the filters of the audit log endpoints
+type GetAuditLogRequest struct {
+	ActorID    string    `form:"actor_id"`
+	Action     string    `form:"action"`
+	TargetType string    `form:"target_type"`
+	TargetID   string    `form:"target_id"`
+	RequestID  string    `form:"request_id"`
+	From       time.Time `form:"from" time_format:"2006-01-02"`
+	To         time.Time `form:"to" time_format:"2006-01-02"`
+	Page       int       `form:"page" binding:"omitempty,min=1"`
+	PageSize   int       `form:"page_size" binding:"omitempty,min=1,max=500"`
+	Format     string    `form:"format" binding:"omitempty,oneof=json csv"`
+}
+
+func (r GetAuditLogRequest) filter() audit.Filter {
+	f := audit.Filter{
+		ActorID:    r.ActorID,
+		Action:     r.Action,
+		TargetType: r.TargetType,
+		TargetID:   r.TargetID,
+		RequestID:  r.RequestID,
+		From:       r.From,
+	}
+	if !r.To.IsZero() {
+		f.To = r.To.AddDate(0, 0, 1)
+	}
+	return f
+}
+
+// GetAuditLogResponse represents a page of audit records
+type GetAuditLogResponse struct {
+	Success  bool           `json:"success"`
+	Message  string         `json:"message"`
+	Records  []audit.Record `json:"records"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	Total    int            `json:"total"`
+}
+
+// GetAuditLog lists audit records, for admins only
+// @Summary Query the audit log
+// @Description Lists destructive and administrative actions, newest first. Only admins can read it
+// @Tags audit
+// @Produce json
+// @Param actor_id query string false "User who performed the action"
+// @Param action query string false "Action, e.g. conversation.delete"
+// @Param target_type query string false "Target type, e.g. conversation"
+// @Param target_id query string false "Target ID"
+// @Param request_id query string false "Request ID (X-Request-ID)"
+// @Param from query string false "First day, YYYY-MM-DD"
+// @Param to query string false "Last day, YYYY-MM-DD"
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Records per page (max 500)"
+// @Success 200 {object} GetAuditLogResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /get-audit-log [get]
+func (h *Handler) GetAuditLog(c *gin.Context) {
+	var req GetAuditLogRequest
+
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	if !h.requireAdmin(c) {
+		return
+	}
+
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	if req.PageSize == 0 {
+		req.PageSize = defaultAuditPageSize
+	}
+
+	records, total, err := h.auditLog.Query(c.Request.Context(), req.filter(), req.PageSize, (req.Page-1)*req.PageSize)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, GetAuditLogResponse{
+		Success:  len(records) > 0,
+		Message:  fmt.Sprintf("Found %d audit records", total),
+		Records:  records,
+		Page:     req.Page,
+		PageSize: req.PageSize,
+		Total:    total,
+	})
+}
+
+// ExportAuditLog downloads the matching audit records, for admins only
+// @Summary Export the audit log
+// @Description Streams every matching record, oldest first, as JSON lines (default) or CSV
+// @Tags audit
+// @Produce application/x-ndjson,text/csv
+// @Param actor_id query string false "User who performed the action"
+// @Param action query string false "Action, e.g. conversation.delete"
+// @Param target_type query string false "Target type, e.g. conversation"
+// @Param target_id query string false "Target ID"
+// @Param from query string false "First day, YYYY-MM-DD"
+// @Param to query string false "Last day, YYYY-MM-DD"
+// @Param format query string false "json (default) or csv"
+// @Success 200 {file} file "Audit records"
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Router /export-audit-log [get]
+func (h *Handler) ExportAuditLog(c *gin.Context) {
+	var req GetAuditLogRequest
+
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	if !h.requireAdmin(c) {
+		return
+	}
+
+	ext, contentType := "jsonl", "application/x-ndjson"
+	if req.Format == "csv" {
+		ext, contentType = "csv", "text/csv; charset=utf-8"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="bloom-audit-%s.%s"`, time.Now().UTC().Format("20060102-150405"), ext))
+	c.Status(http.StatusOK)
+
+	var write func(audit.Record) error
+	var flush func() error
+	if req.Format == "csv" {
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"id", "created_at", "actor_id", "action", "target_type", "target_id", //nolint:errcheck // checked by Error below
+			"request_id", "ip", "user_agent", "before", "after"})
+		write = func(r audit.Record) error {
+			return w.Write([]string{strconv.FormatInt(r.ID, 10), r.CreatedAt.UTC().Format(time.RFC3339), r.ActorID, r.Action,
+				r.TargetType, r.TargetID, r.RequestID, r.IP, r.UserAgent, string(r.Before), string(r.After)})
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	} else {
+		enc := json.NewEncoder(c.Writer)
+		write = func(r audit.Record) error {
+			return enc.Encode(r)
+		}
+		flush = func() error { return nil }
+	}
+
+	// The response is already streaming, a failure can only be logged and the body cut short
+	err = h.auditLog.Each(c.Request.Context(), req.filter(), write)
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		h.log.Error("could not export audit log:", err) //nolint:errcheck // Ignore unused function warning
+		c.Abort()
+	}
+}
+
+// requireAdmin aborts the request with 403 unless the user is an admin
+func (h *Handler) requireAdmin(c *gin.Context) bool {
+	if c.GetString(constant.RoleKey) != auth.RoleAdmin {
+		h.handleError(c, gerr.E(http.StatusForbidden, "admin access required"))
+		return false
+	}
+	return true
+}
+
+// RequireAdmin restricts routes to admins, it must run after Authenticate
+func (h *Handler) RequireAdmin(c *gin.Context) {
+	if !h.requireAdmin(c) {
+		return
+	}
+	c.Next()
+}
+
+// actor identifies the user and the request performing an action
+func (h *Handler) actor(c *gin.Context) audit.Actor {
+	actor := audit.Actor{
+		UserID:    c.GetString(constant.UserIDKey),
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if logData, ok := c.Get(constant.LogDataKey); ok {
+		if info, ok := logData.(gerr.LogInfo); ok {
+			actor.RequestID = info.GetTraceID()
+		}
+	}
+	return actor
+}
+
+// recordAudit appends to the audit log, even when the request was cancelled in
+// the meantime. The action already happened, so a failure is logged rather
+// than returned.
+func (h *Handler) recordAudit(entry audit.Entry) {
+	if err := h.auditLog.Record(context.Background(), entry); err != nil {
+		h.log.Error("could not record audit entry:", err) //nolint:errcheck // Ignore unused function warning
+	}
+}
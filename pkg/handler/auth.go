@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Authentication errors of protected routes, their messages are translated
+var (
+	errAuthenticationRequired = gerr.E(http.StatusUnauthorized, "authentication required")
+	errAccountDisabled        = gerr.E(http.StatusForbidden, "account is disabled")
+)
+
+// Authenticate checks the bearer access token or API key of protected routes
+// and puts the authenticated user ID in the context, read by handlers with
+// constant.UserIDKey. Requests without token are served as the anonymous
+// visitor of the user_id cookie, unless anonymous users are disabled.
+func (h *Handler) Authenticate(c *gin.Context) {
+	token := bearerToken(c)
+	if auth.IsAPIKey(token) {
+		h.authenticateAPIKey(c, token)
+		return
+	}
+	if token == "" && h.cfg.AnonymousUsers {
+		h.authenticateAnonymous(c)
+		return
+	}
+
+	claims, err := h.tokens.Verify(token)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		h.handleError(c, errAuthenticationRequired)
+		return
+	}
+
+	// Bans and forced logouts apply to access tokens already issued
+	account, err := h.loadAccount(c.Request.Context(), claims.Subject)
+	if err == sql.ErrNoRows || (err == nil && account.loggedOutSince(claims.IssuedAt)) {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		h.handleError(c, errAuthenticationRequired)
+		return
+	}
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+	if account.disabled {
+		h.handleError(c, errAccountDisabled)
+		return
+	}
+
+	c.Set(constant.UserIDKey, claims.Subject)
+	c.Set(constant.RoleKey, account.role)
+	c.Next()
+}
+
+// account is the state of a user checked on every authenticated request
+type account struct {
+	role              string
+	disabled          bool
+	sessionsRevokedAt *time.Time
+}
+
+// loggedOutSince tells whether the user was logged out everywhere after the
+// token was issued. Tokens carry seconds, those of the same second are rejected.
+func (a account) loggedOutSince(issuedAt *jwt.NumericDate) bool {
+	if a.sessionsRevokedAt == nil {
+		return false
+	}
+	return issuedAt == nil || !issuedAt.After(a.sessionsRevokedAt.Truncate(time.Second))
+}
+
+// loadAccount reads the role and state of a user, sql.ErrNoRows when it was deleted
+func (h *Handler) loadAccount(ctx context.Context, userID string) (account, error) {
+	var a account
+	err := h.db.QueryRowContext(ctx, `
+		SELECT role, disabled_at IS NOT NULL, sessions_revoked_at
+		FROM users WHERE id = $1`, userID).Scan(&a.role, &a.disabled, &a.sessionsRevokedAt)
+	if err == sql.ErrNoRows {
+		return a, err
+	}
+	if err != nil {
+		return a, fmt.Errorf("could not load account: %v", err)
+	}
+	return a, nil
+}
+
+// authenticateAnonymous identifies the visitor by the user_id cookie, issuing a
+// new one to first-time visitors
+func (h *Handler) authenticateAnonymous(c *gin.Context) {
+	var userID string
+	if cookie, err := h.GetUserFromCookie(c); err == nil {
+		var disabled bool
+		userID, disabled, err = h.checkAnonymousUser(cookie)
+		if err != nil {
+			h.handleError(c, gerr.E(500, gerr.Trace(err)))
+			return
+		}
+		// Disabled visitors are refused like accounts, not given a new identity
+		if disabled {
+			h.handleError(c, errAccountDisabled)
+			return
+		}
+	}
+	if userID == "" {
+		userID = h.SetUserCookie(c)
+	}
+
+	// Anonymous visitors are never admins, whatever the role of their id
+	c.Set(constant.UserIDKey, userID)
+	c.Set(constant.RoleKey, auth.RoleUser)
+	c.Set(constant.AnonymousKey, true)
+	c.Next()
+}
+
+// checkAnonymousUser returns the id of a cookie when it can be an anonymous
+// visitor, or "" when it must be replaced, and whether an admin disabled the
+// visitor. Ids of accounts are never accepted, they would otherwise give access
+// to the account without its password.
+func (h *Handler) checkAnonymousUser(userID string) (string, bool, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return "", false, nil
+	}
+
+	var account, disabled bool
+	err := h.db.QueryRow(`
+		SELECT username IS NOT NULL, disabled_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&account, &disabled)
+	if err == sql.ErrNoRows {
+		// Visitors are stored on their first write
+		return userID, false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("could not check anonymous user: %v", err)
+	}
+	if account {
+		return "", false, nil
+	}
+	return userID, disabled, nil
+}
+
+// bearerToken reads the token of the Authorization header
+func bearerToken(c *gin.Context) string {
+	scheme, token, ok := strings.Cut(c.Request.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
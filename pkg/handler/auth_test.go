@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+	"github.com/Essen-Labs/bloom-be/translation"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuthenticate_Anonymous(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys, err := auth.ParseSigningKeys("k1:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := auth.NewTokens(keys, time.Minute)
+
+	const (
+		visitor  = "8f0e4c1a-8d4e-4d8e-9a57-2f1de3a0a001"
+		disabled = "8f0e4c1a-8d4e-4d8e-9a57-2f1de3a0a002"
+		account  = "8f0e4c1a-8d4e-4d8e-9a57-2f1de3a0a003"
+		newcomer = "8f0e4c1a-8d4e-4d8e-9a57-2f1de3a0a004"
+	)
+	// users has, for each id, whether it is an account and whether it is disabled
+	users := map[string][]driver.Value{
+		visitor:  {false, false},
+		disabled: {false, true},
+		account:  {true, false},
+	}
+	db, _ := dbtest.Open(func(q dbtest.Query) (dbtest.Result, error) {
+		if !q.Has("FROM users WHERE id = $1") {
+			return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+		}
+		res := dbtest.Result{Columns: []string{"account", "disabled"}}
+		if row, ok := users[q.Args[0].(string)]; ok {
+			res.Rows = [][]driver.Value{row}
+		}
+		return res, nil
+	})
+	defer db.Close()
+
+	tests := []struct {
+		name      string
+		anonymous bool
+		cookie    string
+		wantCode  int
+		wantUser  string
+		wantNewID bool
+	}{
+		{name: "anonymous users disabled", anonymous: false, cookie: visitor, wantCode: http.StatusUnauthorized},
+		{name: "first visit", anonymous: true, wantCode: http.StatusOK, wantNewID: true},
+		{name: "returning visitor", anonymous: true, cookie: visitor, wantCode: http.StatusOK, wantUser: visitor},
+		{name: "visitor not stored yet", anonymous: true, cookie: newcomer, wantCode: http.StatusOK, wantUser: newcomer},
+		{name: "disabled visitor", anonymous: true, cookie: disabled, wantCode: http.StatusForbidden},
+		{name: "id of an account", anonymous: true, cookie: account, wantCode: http.StatusOK, wantNewID: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{AnonymousUsers: tt.anonymous}
+			h := NewHandler(cfg, gerr.NewSimpleLog(), translation.NewTranslatorHelper(), db, nil, nil).WithTokens(tokens)
+
+			r := gin.New()
+			r.GET("/get-current-user", h.Authenticate, func(c *gin.Context) {
+				c.String(http.StatusOK, c.GetString(constant.UserIDKey))
+			})
+			req := httptest.NewRequest(http.MethodGet, "/get-current-user", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: anonymousCookie, Value: tokens.SignCookie(anonymousCookie, tt.cookie)})
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantUser != "" && w.Body.String() != tt.wantUser {
+				t.Errorf("user = %q, want %q", w.Body.String(), tt.wantUser)
+			}
+			if tt.wantNewID && (w.Body.String() == tt.cookie || w.Header().Get("Set-Cookie") == "") {
+				t.Errorf("user = %q, want a new visitor with its cookie", w.Body.String())
+			}
+		})
+	}
+}
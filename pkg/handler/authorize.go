@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Essen-Labs/bloom-be/pkg/authz"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+// Authorize checks that the authenticated user may act on the conversation,
+// message, folder or tag targeted by the request, following authz.Rules. It
+// must run after Authenticate.
+func (h *Handler) Authorize() gin.HandlerFunc {
+	return h.authorizer.Middleware(authz.Rules, func(c *gin.Context) string {
+		return c.GetString(constant.UserIDKey)
+	}, h.denyRequest)
+}
+
+// denyRequest answers 404 for resources the user may not act on, as for those
+// that do not exist
+func (h *Handler) denyRequest(c *gin.Context, rule authz.Rule, id string, err error) {
+	switch {
+	case errors.Is(err, authz.ErrNotFound) && id == "":
+		h.handleError(c, gerr.E(http.StatusNotFound, fmt.Sprintf("%s not found", rule.Resource)))
+	case errors.Is(err, authz.ErrNotFound):
+		h.handleError(c, gerr.E(http.StatusNotFound, fmt.Sprintf("%s with id %s not found", rule.Resource, id)))
+	case errors.Is(err, authz.ErrBodyTooLarge):
+		h.handleError(c, gerr.E(http.StatusRequestEntityTooLarge, err.Error()))
+	default:
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+	}
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+	"github.com/Essen-Labs/bloom-be/translation"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	owner    = "owner"
+	stranger = "stranger"
+)
+
+// ownedRow answers the statements selecting a row by id and owner, passed as
+// $1 and $2, with row when the owner matches and no row otherwise
+func ownedRow(id string, columns []string, row []driver.Value) func(q dbtest.Query) (dbtest.Result, error) {
+	return func(q dbtest.Query) (dbtest.Result, error) {
+		if q.Has("INSERT INTO audit_log") {
+			return dbtest.Result{RowsAffected: 1}, nil
+		}
+		res := dbtest.Result{Columns: columns}
+		if len(q.Args) >= 2 && q.Args[0] == id && q.Args[1] == owner {
+			res.Rows = [][]driver.Value{row}
+		}
+		return res, nil
+	}
+}
+
+// TestHandler_OwnedByOtherUser covers the routes whose resources authz.Rules
+// does not know, their handlers look them up by owner and must answer 404 to
+// other users as for resources that do not exist
+func TestHandler_OwnedByOtherUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name      string
+		method    string
+		route     string
+		path      string
+		handle    func(h *Handler) gin.HandlerFunc
+		db        func(q dbtest.Query) (dbtest.Result, error)
+		ownerCode int
+	}{
+		{
+			name:   "revoke share",
+			method: http.MethodDelete,
+			route:  "/revoke-share/:share_id",
+			path:   "/revoke-share/3",
+			handle: func(h *Handler) gin.HandlerFunc { return h.RevokeShare },
+			db: ownedRow("3", []string{"conversation_id"},
+				[]driver.Value{int64(7)}),
+			ownerCode: http.StatusOK,
+		},
+		{
+			name:   "revoke API key",
+			method: http.MethodDelete,
+			route:  "/revoke-api-key/:key_id",
+			path:   "/revoke-api-key/4",
+			handle: func(h *Handler) gin.HandlerFunc { return h.RevokeAPIKey },
+			db: ownedRow("4", []string{"name"},
+				[]driver.Value{"ci"}),
+			ownerCode: http.StatusOK,
+		},
+		{
+			name:   "download data export",
+			method: http.MethodGet,
+			route:  "/download-data-export/:export_id",
+			path:   "/download-data-export/e1",
+			handle: func(h *Handler) gin.HandlerFunc { return h.DownloadDataExport },
+			db: ownedRow("e1", []string{"status", "size", "chunks", "created_at"},
+				[]driver.Value{"pending", nil, nil, time.Now()}),
+			ownerCode: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		for _, userID := range []string{owner, stranger} {
+			t.Run(fmt.Sprintf("%s as %s", tt.name, userID), func(t *testing.T) {
+				db, _ := dbtest.Open(tt.db)
+				defer db.Close()
+				h := NewHandler(config.Config{}, gerr.NewSimpleLog(), translation.NewTranslatorHelper(), db, nil, nil)
+
+				r := gin.New()
+				r.Handle(tt.method, tt.route, func(c *gin.Context) {
+					c.Set(constant.UserIDKey, userID)
+				}, tt.handle(h))
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+				want := tt.ownerCode
+				if userID == stranger {
+					want = http.StatusNotFound
+				}
+				if w.Code != want {
+					t.Errorf("status = %d, want %d: %s", w.Code, want, w.Body.String())
+				}
+			})
+		}
+	}
+}
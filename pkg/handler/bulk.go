@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/export"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Operations of the bulk endpoint
+const (
+	bulkDelete    = "delete"
+	bulkArchive   = "archive"
+	bulkUnarchive = "unarchive"
+	bulkMove      = "move"
+	bulkTag       = "tag"
+	bulkExport    = "export"
+)
+
+// BulkChatRequest applies one operation to many conversations. FolderID is the
+// target of move, null removes the conversations from their folder. Tags are
+// added by tag and Format is the file format of export.
+type BulkChatRequest struct {
+	ConversationIDs []string `json:"conversation_ids" binding:"required,min=1,max=500,dive,required,numeric"`
+	Operation       string   `json:"operation" binding:"required,oneof=delete archive unarchive move tag export"`
+	FolderID        *int     `json:"folder_id" binding:"omitempty,min=1"`
+	Tags            []string `json:"tags" binding:"omitempty,max=20,dive,max=50"`
+	Format          string   `json:"format" binding:"omitempty,oneof=md json html"`
+}
+
+// BulkChatResult is the outcome of the operation on one conversation
+type BulkChatResult struct {
+	ConversationID string `json:"conversation_id"`
+	Success        bool   `json:"success"`
+	Error          string `json:"error,omitempty"`
+}
+
+// BulkChatResponse reports the outcome of a bulk operation per conversation
+type BulkChatResponse struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message"`
+	Results []BulkChatResult `json:"results"`
+}
+
+// BulkChat applies an operation to many conversations of the user
+// @Summary Bulk conversation operations
+// @Description Deletes (to the trash), archives, unarchives, moves to a folder or tags the listed conversations in one transaction. The request fails with 404 and changes nothing when a conversation is not found or belongs to another user, and a database error leaves every conversation untouched too. The export operation streams a zip archive of the listed conversations instead, skipping the ones not found
+// @Tags organize
+// @Accept json
+// @Produce json,application/zip
+// @Param request body BulkChatRequest true "Conversations and operation"
+// @Success 200 {object} BulkChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Conversation or folder not found, or no conversation to export"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /bulk-chat [post]
+func (h *Handler) BulkChat(c *gin.Context) {
+	var req BulkChatRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	if req.Operation == bulkTag && len(req.Tags) == 0 {
+		h.handleError(c, gerr.E(http.StatusBadRequest, "tags are required by the tag operation"))
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	if req.Operation == bulkExport {
+		// The route is granted by chat:write, exporting also takes the export scope
+		if _, byAPIKey := c.Get(constant.APIKeyScopesKey); byAPIKey &&
+			!auth.HasScope(c.GetStringSlice(constant.APIKeyScopesKey), auth.ScopeExport) {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, auth.ScopeExport))
+			h.handleError(c, errAPIKeyScope)
+			return
+		}
+		h.bulkExport(c, userID, req)
+		return
+	}
+
+	res, err := h.doBulkChat(c.Request.Context(), h.actor(c), userID, req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) bulkExport(c *gin.Context, userID string, req BulkChatRequest) {
+	if req.Format == "" {
+		req.Format = export.FormatMarkdown
+	}
+
+	conversations, err := h.getExportConversations(c.Request.Context(), userID, req.ConversationIDs)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+	if len(conversations) == 0 {
+		h.handleError(c, gerr.E(http.StatusNotFound, "no conversation to export"))
+		return
+	}
+
+	h.streamExportArchive(c, req.Format, conversations)
+}
+
+func (h *Handler) doBulkChat(ctx context.Context, actor audit.Actor, userID string, req BulkChatRequest) (res BulkChatResponse, err error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not begin transaction: %v", err)))
+	}
+
+	// Deletions are audited once they are committed
+	var entries []audit.Entry
+
+	// Rollback the transaction if there's an error
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback() //nolint:errcheck // the panic is what matters
+			panic(p)      // Re-raise the panic
+		} else if err != nil {
+			tx.Rollback() //nolint:errcheck // the original error is returned
+		} else if err = tx.Commit(); err != nil {
+			err = gerr.E(500, gerr.Trace(fmt.Errorf("could not commit transaction: %v", err)))
+		} else {
+			for _, entry := range entries {
+				h.recordAudit(entry)
+			}
+		}
+	}()
+
+	// A conversation of another user, or a missing folder, fails the whole
+	// request before anything is changed
+	if err = checkConversationOwners(tx, userID, req.ConversationIDs); err != nil {
+		return res, err
+	}
+	if req.Operation == bulkMove && req.FolderID != nil {
+		if err = checkFolderOwner(tx, userID, *req.FolderID); err != nil {
+			return res, err
+		}
+	}
+
+	results := []BulkChatResult{}
+	succeeded := 0
+	seen := map[string]bool{}
+	for _, conversationID := range req.ConversationIDs {
+		if seen[conversationID] {
+			continue
+		}
+		seen[conversationID] = true
+
+		var opErr error
+		switch req.Operation {
+		case bulkDelete:
+			var entry audit.Entry
+			entry, opErr = trashConversation(tx, actor, userID, conversationID)
+			if opErr == nil {
+				entries = append(entries, entry)
+			}
+		case bulkArchive, bulkUnarchive:
+			_, opErr = h.doSetConversationFlag(tx, userID, conversationID, "archived", req.Operation == bulkArchive, nil)
+		case bulkMove:
+			_, opErr = h.doMoveChat(tx, userID, MoveChatRequest{ConversationID: conversationID, FolderID: req.FolderID}, nil)
+		case bulkTag:
+			opErr = addConversationTags(tx, userID, conversationID, req.Tags)
+		}
+
+		// Other errors of a single conversation do not stop the others
+		result := BulkChatResult{ConversationID: conversationID, Success: opErr == nil}
+		var itemErr gerr.Error
+		switch {
+		case opErr == nil:
+			succeeded++
+		case errors.As(opErr, &itemErr) && itemErr.Code < http.StatusInternalServerError:
+			result.Error = itemErr.Message
+		default:
+			return res, opErr
+		}
+		results = append(results, result)
+	}
+
+	return BulkChatResponse{
+		Success: succeeded > 0,
+		Message: fmt.Sprintf("Applied %s to %d of %d conversations", req.Operation, succeeded, len(results)),
+		Results: results,
+	}, nil
+}
+
+// checkConversationOwners returns a not found error for the first of the
+// conversations that is not a conversation of the user out of the trash, and
+// locks the others until the transaction ends
+func checkConversationOwners(q querier, userID string, conversationIDs []string) error {
+	rows, err := q.Query(`
+		SELECT id FROM conversations
+		WHERE id = ANY($1::int[]) AND user_id = $2 AND deleted_at IS NULL
+		FOR UPDATE`, pq.Array(conversationIDs), userID)
+	if err != nil {
+		return gerr.E(500, gerr.Trace(fmt.Errorf("could not check conversation owners: %v", err)))
+	}
+	defer rows.Close()
+
+	owned := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return gerr.E(500, gerr.Trace(fmt.Errorf("error scanning conversation owner: %v", err)))
+		}
+		owned[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return gerr.E(500, gerr.Trace(fmt.Errorf("error iterating over conversation owners: %v", err)))
+	}
+
+	for _, conversationID := range conversationIDs {
+		if id, err := strconv.Atoi(conversationID); err != nil || !owned[id] {
+			return errConversationNotFound(conversationID)
+		}
+	}
+	return nil
+}
+
+// trashConversation moves a conversation of the user to the trash and returns
+// the audit entry to record once the transaction is committed
+func trashConversation(q querier, actor audit.Actor, userID, conversationID string) (audit.Entry, error) {
+	var name sql.NullString
+	var deletedAt time.Time
+	err := q.QueryRow(`
+		UPDATE conversations SET deleted_at = now()
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		RETURNING conversation_name, deleted_at`, conversationID, userID).Scan(&name, &deletedAt)
+	if err == sql.ErrNoRows {
+		return audit.Entry{}, errConversationNotFound(conversationID)
+	}
+	if err != nil {
+		return audit.Entry{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not delete conversation: %v", err)))
+	}
+
+	return audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionConversationDelete,
+		TargetType: audit.TargetConversation,
+		TargetID:   conversationID,
+		Before:     map[string]string{"user_id": userID, "conversation_name": name.String},
+		After:      map[string]time.Time{"deleted_at": deletedAt},
+	}, nil
+}
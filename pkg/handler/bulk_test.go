@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+	"github.com/lib/pq"
+)
+
+// bulkConversations answers the statements of bulk operations on conversations
+// 1 and 2 of owner and conversation 3 of stranger
+func bulkConversations(q dbtest.Query) (dbtest.Result, error) {
+	owners := map[string]string{"1": owner, "2": owner, "3": stranger}
+	switch {
+	case q.Has("INSERT INTO audit_log"):
+		return dbtest.Result{RowsAffected: 1}, nil
+	case q.Has("SELECT id FROM conversations", "FOR UPDATE"):
+		var ids pq.StringArray
+		if err := ids.Scan(q.Args[0]); err != nil {
+			return dbtest.Result{}, err
+		}
+		res := dbtest.Result{Columns: []string{"id"}}
+		for _, id := range ids {
+			if owners[id] == q.Args[1] {
+				res.Rows = append(res.Rows, []driver.Value{id})
+			}
+		}
+		return res, nil
+	case q.Has("UPDATE conversations SET deleted_at = now()"):
+		res := dbtest.Result{Columns: []string{"conversation_name", "deleted_at"}}
+		if owners[q.Args[0].(string)] == q.Args[1] {
+			res.Rows = [][]driver.Value{{"", time.Now()}}
+		}
+		return res, nil
+	case q.Has("UPDATE conversations SET archived = $1"):
+		res := dbtest.Result{Columns: []string{"version"}}
+		if owners[q.Args[1].(string)] == q.Args[2] {
+			res.Rows = [][]driver.Value{{int64(2)}}
+		}
+		return res, nil
+	}
+	return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+}
+
+func TestBulkChat_OtherUsersConversation(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantSQL  []string
+	}{
+		{
+			name:     "delete own conversations",
+			body:     `{"conversation_ids": ["1", "2"], "operation": "delete"}`,
+			wantCode: http.StatusOK,
+			wantSQL:  []string{"SELECT id FROM conversations", "UPDATE conversations", "UPDATE conversations", "COMMIT"},
+		},
+		{
+			name:     "delete with a conversation of another user",
+			body:     `{"conversation_ids": ["1", "3", "2"], "operation": "delete"}`,
+			wantCode: http.StatusNotFound,
+			wantSQL:  []string{"SELECT id FROM conversations", "ROLLBACK"},
+		},
+		{
+			name:     "archive with a conversation of another user",
+			body:     `{"conversation_ids": ["1", "3"], "operation": "archive"}`,
+			wantCode: http.StatusNotFound,
+			wantSQL:  []string{"SELECT id FROM conversations", "ROLLBACK"},
+		},
+		{
+			name:     "delete with a missing conversation",
+			body:     `{"conversation_ids": ["2", "9"], "operation": "delete"}`,
+			wantCode: http.StatusNotFound,
+			wantSQL:  []string{"SELECT id FROM conversations", "ROLLBACK"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, fake := newTestHandler(t, bulkConversations)
+
+			w := serve(h.BulkChat, "/bulk-chat", owner, newRequest(http.MethodPost, "/bulk-chat", tt.body))
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+
+			var statements []string
+			for _, q := range fake.Queries() {
+				if !q.Has("INSERT INTO audit_log") {
+					statements = append(statements, q.SQL)
+				}
+			}
+			if len(statements) != len(tt.wantSQL) {
+				t.Fatalf("statements = %q, want %q", statements, tt.wantSQL)
+			}
+			for idx, statement := range statements {
+				if !(dbtest.Query{SQL: statement}).Has(tt.wantSQL[idx]) {
+					t.Errorf("statement %d = %q, want %q", idx, statement, tt.wantSQL[idx])
+				}
+			}
+		})
+	}
+}
@@ -3,12 +3,15 @@ package handler
 import (
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
 	"github.com/dwarvesf/gerr"
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
 // GetChatByIDRequest represents the request structure to get a chat by ID
@@ -49,7 +52,7 @@ type GetAllChatResponse struct {
 // @Summary Get a conversation by ID
 // @Description Retrieves a conversation from the database using its ID
 // @Param conversation_id is path string true "Conversation ID"
-// @Success 200 {object} GetChatByIDResponse "Successfully retrieved the conversation"
+// @Success 200 {object} GetChatByIDResponse "Successfully retrieved the conversation, with its version as ETag"
 // @Failure 400 {object} ErrorResponse "Invalid request"
 // @Failure 404 {object} ErrorResponse "Conversation not found"
 // @Failure 500 {object} ErrorResponse "Internal server error"
@@ -57,47 +60,68 @@ type GetAllChatResponse struct {
 func (h *Handler) GetChatById(c *gin.Context) {
 	conversationID := c.Param("conversation_id")
 
-	res, err := h.doGetChatByID(conversationID)
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doGetChatByID(userID, conversationID)
 	if err != nil {
-		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		h.handleError(c, err)
 		return
 	}
 
+	setConversationETag(c, conversationID, res.Conversation.Version)
 	c.JSON(http.StatusOK, res)
 }
 
-func (h *Handler) doGetChatByID(conversationID string) ([]byte, error) {
-	var conversation Conversation
-
-	err := h.db.QueryRow(`
-        SELECT id, model, conversation_name, user_id, created_at FROM conversations WHERE id = $1`, conversationID).Scan(
-		&conversation.ID, &conversation.Model, &conversation.ConversationName, &conversation.UserID, &conversation.CreatedAt)
+func (h *Handler) doGetChatByID(userID, conversationID string) (GetChatByIDResponse, error) {
+	conversation, err := scanConversation(h.db.QueryRow(`
+        SELECT `+conversationColumns+` FROM conversations c
+        WHERE c.id = $1 AND c.user_id = $2 AND c.deleted_at IS NULL`, conversationID, userID))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("conversation not found")
+			return GetChatByIDResponse{}, errConversationNotFound(conversationID)
 		}
-		return nil, fmt.Errorf("could not fetch conversation: %v", err)
+		return GetChatByIDResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not fetch conversation: %v", err)))
 	}
 
-	response := GetChatByIDResponse{
+	return GetChatByIDResponse{
 		Success:      true,
 		Message:      fmt.Sprintf("Conversation with ID %s found", conversationID),
 		Conversation: conversation,
-	}
-	return json.Marshal(response)
+	}, nil
+}
+
+// GetAllChatRequest holds the optional filters of the conversation list
+type GetAllChatRequest struct {
+	Pinned   *bool  `form:"pinned" json:"pinned"`
+	Archived bool   `form:"archived" json:"archived"`
+	FolderID *int   `form:"folder_id" json:"folder_id" binding:"omitempty,min=1"`
+	Tag      string `form:"tag" json:"tag"`
 }
 
 // GetAllChat retrieves all conversations
 // @Summary Get all conversations
-// @Description Retrieves all conversations stored in the database
+// @Description Retrieves the user's conversations, pinned first. Archived conversations are only listed with archived=true
+// @Param pinned query bool false "Only pinned (true) or unpinned (false) conversations"
+// @Param archived query bool false "List archived conversations instead of active ones"
+// @Param folder_id query int false "Only conversations in this folder"
+// @Param tag query string false "Only conversations with this tag"
 // @Success 200 {object} GetAllChatResponse "Successfully retrieved all conversations"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /get-chat-list [get]
 func (h *Handler) GetAllChat(c *gin.Context) {
-	// Get the user ID from the header
-	userID := c.Request.Header.Get("user-id")
+	var req GetAllChatRequest
+
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
 
-	res, err := h.doGetAllChat(userID)
+	res, err := h.doGetAllChat(userID, req)
 	if err != nil {
 		h.handleError(c, gerr.E(500, gerr.Trace(err)))
 		return
@@ -106,9 +130,27 @@ func (h *Handler) GetAllChat(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
-func (h *Handler) doGetAllChat(userID string) ([]byte, error) {
+func (h *Handler) doGetAllChat(userID string, req GetAllChatRequest) ([]byte, error) {
+	// Build the filters, every value is passed as a query argument
+	args := []interface{}{userID, req.Archived}
+	filters := "c.user_id = $1 AND c.deleted_at IS NULL AND c.archived = $2"
+	if req.Pinned != nil {
+		args = append(args, *req.Pinned)
+		filters += fmt.Sprintf(" AND c.pinned = $%d", len(args))
+	}
+	if req.FolderID != nil {
+		args = append(args, *req.FolderID)
+		filters += fmt.Sprintf(" AND c.folder_id = $%d", len(args))
+	}
+	if req.Tag != "" {
+		args = append(args, req.Tag)
+		filters += fmt.Sprintf(` AND EXISTS (SELECT 1 FROM conversation_tags ct JOIN tags t ON t.id = ct.tag_id
+			WHERE ct.conversation_id = c.id AND t.name = $%d)`, len(args))
+	}
+
 	// Query to get all conversations
-	rows, err := h.db.Query("SELECT id, model, conversation_name, user_id, created_at FROM conversations WHERE user_id = $1", userID)
+	rows, err := h.reader().Query(`SELECT `+conversationColumns+` FROM conversations c WHERE `+filters+`
+		ORDER BY c.pinned DESC, c.id DESC`, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying conversations: %v", err)
 	}
@@ -119,8 +161,8 @@ func (h *Handler) doGetAllChat(userID string) ([]byte, error) {
 
 	// Iterate over the rows
 	for rows.Next() {
-		var conversation Conversation
-		if err := rows.Scan(&conversation.ID, &conversation.Model, &conversation.ConversationName, &conversation.UserID, &conversation.CreatedAt); err != nil {
+		conversation, err := scanConversation(rows)
+		if err != nil {
 			return nil, fmt.Errorf("error scanning conversation: %v", err)
 		}
 		conversations = append(conversations, conversation)
@@ -154,9 +196,9 @@ type deleteChatByIDResponse struct {
 	Message string `json:"message"`
 }
 
-// DeleteChatById deletes a conversation by its ID
+// DeleteChatById moves a conversation to the trash
 // @Summary Delete a conversation by ID
-// @Description Deletes the specified conversation and its associated messages from the database
+// @Description Moves the specified conversation to the trash, it is purged after the retention period
 // @Param conversation_id path string true "Conversation ID"
 // @Success 200 {object} deleteChatByIDResponse "Successfully deleted the conversation"
 // @Failure 400 {object} ErrorResponse "Invalid request"
@@ -166,9 +208,12 @@ type deleteChatByIDResponse struct {
 func (h *Handler) DeleteChatById(c *gin.Context) {
 	conversationID := c.Param("conversation_id")
 
-	res, err := h.doDeleteChatByID(conversationID)
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doDeleteChatByID(h.actor(c), userID, conversationID)
 	if err != nil {
-		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		h.handleError(c, err)
 		return
 	}
 
@@ -180,67 +225,52 @@ type deleteAllChatByUserIDResponse struct {
 	Message string `json:"message"`
 }
 
-func (h *Handler) doDeleteChatByID(conversationID string) ([]byte, error) {
-	tx, err := h.db.Begin()
-	if err != nil {
-		return nil, fmt.Errorf("could not begin transaction: %v", err)
+func (h *Handler) doDeleteChatByID(actor audit.Actor, userID, conversationID string) ([]byte, error) {
+	var ownerID, name sql.NullString
+	var deletedAt time.Time
+	err := h.db.QueryRow(`
+		UPDATE conversations SET deleted_at = now()
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		RETURNING user_id, conversation_name, deleted_at`, conversationID, userID).Scan(&ownerID, &name, &deletedAt)
+	if err == sql.ErrNoRows {
+		return nil, errConversationNotFound(conversationID)
 	}
-
-	// Rollback the transaction if there's an error
-	defer func() {
-		if p := recover(); p != nil {
-			err = tx.Rollback()
-			panic(err) // Re-raise the panic
-		} else if err != nil {
-			err = tx.Rollback()
-		} else {
-			err = tx.Commit()
-		}
-	}()
-
-	_, err = tx.Exec("DELETE FROM messages WHERE conversation_id = $1", conversationID)
 	if err != nil {
-		return nil, fmt.Errorf("could not delete messages: %v", err)
+		return nil, gerr.E(500, gerr.Trace(fmt.Errorf("could not delete conversation: %v", err)))
 	}
 
-	result, err := tx.Exec("DELETE FROM conversations WHERE id = $1", conversationID)
-	if err != nil {
-		return nil, fmt.Errorf("could not delete conversation: %v", err)
-	}
-
-	// Check if a row was actually deleted
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return nil, fmt.Errorf("could not check rows affected: %v", err)
-	}
-	if rowsAffected == 0 {
-		return nil, fmt.Errorf("conversation with id %s not found", conversationID)
-	}
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionConversationDelete,
+		TargetType: audit.TargetConversation,
+		TargetID:   conversationID,
+		Before:     map[string]string{"user_id": ownerID.String, "conversation_name": name.String},
+		After:      map[string]time.Time{"deleted_at": deletedAt},
+	})
 
 	// Step 3: Create a success response
 	response := deleteChatByIDResponse{
 		Success: true,
-		Message: fmt.Sprintf("Conversation with ID %s deleted successfully", conversationID),
+		Message: fmt.Sprintf("Conversation with ID %s moved to trash", conversationID),
 	}
 
 	// Marshal the response to JSON
 	return json.Marshal(response)
 }
 
-// DeleteAllChat deletes all conversations for a user
+// DeleteAllChat moves all conversations of a user to the trash
 // @Summary Delete all conversations for a user
-// @Description Deletes all conversations and their associated messages for a given user
+// @Description Moves all conversations of the user to the trash, they are purged after the retention period
 // @Security BearerAuth
 // @Success 200 {object} deleteAllChatByUserIDResponse "Successfully deleted all conversations for the user"
 // @Failure 400 {object} ErrorResponse "Invalid request"
-// @Failure 404 {object} ErrorResponse "No conversations found for the user"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /delete-all-chat [delete]
 func (h *Handler) DeleteAllChat(c *gin.Context) {
-	// Get the user ID from the header
-	userID := c.Request.Header.Get("user-id")
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
 
-	res, err := h.doDeleteAllChatByUserID(userID)
+	res, err := h.doDeleteAllChatByUserID(h.actor(c), userID)
 	if err != nil {
 		h.handleError(c, gerr.E(500, gerr.Trace(err)))
 		return
@@ -249,51 +279,34 @@ func (h *Handler) DeleteAllChat(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
-func (h *Handler) doDeleteAllChatByUserID(userID string) ([]byte, error) {
-	// Start a transaction to delete messages and conversations atomically
-	tx, err := h.db.Begin()
-	if err != nil {
-		return nil, fmt.Errorf("could not begin transaction: %v", err)
-	}
-
-	// Rollback the transaction if there's an error
-	defer func() {
-		if p := recover(); p != nil {
-			err = tx.Rollback()
-			panic(err) // Re-raise the panic
-		} else if err != nil {
-			err = tx.Rollback()
-		} else {
-			err = tx.Commit()
-		}
-	}()
-
-	_, err = tx.Exec(`
-		DELETE FROM messages
-		WHERE conversation_id IN (
-			SELECT id FROM conversations WHERE user_id = $1
+func (h *Handler) doDeleteAllChatByUserID(actor audit.Actor, userID string) ([]byte, error) {
+	var conversationIDs []int64
+	err := h.db.QueryRow(`
+		WITH deleted AS (
+			UPDATE conversations SET deleted_at = now()
+			WHERE user_id = $1 AND deleted_at IS NULL
+			RETURNING id
 		)
-	`, userID)
-	if err != nil {
-		return nil, fmt.Errorf("could not delete messages for user_id %s: %v", userID, err)
-	}
-
-	result, err := tx.Exec("DELETE FROM conversations WHERE user_id = $1", userID)
+		SELECT coalesce(array_agg(id ORDER BY id), '{}') FROM deleted`, userID).Scan(pq.Array(&conversationIDs))
 	if err != nil {
 		return nil, fmt.Errorf("could not delete conversations for user_id %s: %v", userID, err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return nil, fmt.Errorf("could not check rows affected: %v", err)
-	}
-	if rowsAffected == 0 {
-		return nil, fmt.Errorf("no conversations found for user_id %s", userID)
+	// Nothing to delete is not an error, an empty history is already clear
+	rowsAffected := len(conversationIDs)
+	if rowsAffected > 0 {
+		h.recordAudit(audit.Entry{
+			Actor:      actor,
+			Action:     audit.ActionConversationDeleteAll,
+			TargetType: audit.TargetUser,
+			TargetID:   userID,
+			After:      map[string][]int64{"conversation_ids": conversationIDs},
+		})
 	}
 
 	response := deleteAllChatByUserIDResponse{
 		Success: true,
-		Message: fmt.Sprintf("Deleted %d conversations and their messages for user ID %s", rowsAffected, userID),
+		Message: fmt.Sprintf("Moved %d conversations to trash for user ID %s", rowsAffected, userID),
 	}
 
 	return json.Marshal(response)
@@ -304,6 +317,26 @@ type EditChatRequest struct {
 	ConversationID string `json:"conversation_id" binding:"required"`
 }
 
+// EditChatResponse represents a renamed conversation
+type EditChatResponse struct {
+	ID               string `json:"id"`
+	ConversationName string `json:"conversation_name"`
+	Version          int    `json:"version"`
+}
+
+// EditChat renames a conversation
+// @Summary Rename a conversation
+// @Description A renamed conversation keeps its name, it is no longer replaced by the generated title
+// @Accept json
+// @Produce json
+// @Param request body EditChatRequest true "Conversation and new name"
+// @Param If-Match header string false "ETag of the conversation, the update fails with 412 if it changed"
+// @Success 200 {object} EditChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Conversation not found"
+// @Failure 412 {object} ErrorResponse "Conversation changed since it was read"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /edit-chat [post]
 func (h *Handler) EditChat(c *gin.Context) {
 	req := EditChatRequest{}
 
@@ -313,53 +346,62 @@ func (h *Handler) EditChat(c *gin.Context) {
 		return
 	}
 
-	// Get the user ID from the header
-	userID := c.Request.Header.Get("user-id")
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
 
-	res, err := h.doEditChat(userID, req)
+	versions, err := ifMatchVersions(c, req.ConversationID)
 	if err != nil {
-		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		h.handleError(c, err)
 		return
 	}
 
+	res, err := h.doEditChat(h.actor(c), userID, req, versions)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	setConversationETag(c, res.ID, res.Version)
 	c.JSON(http.StatusOK, res)
 }
 
-func (h *Handler) doEditChat(userID string, req EditChatRequest) ([]byte, error) {
+func (h *Handler) doEditChat(actor audit.Actor, userID string, req EditChatRequest, versions []int64) (EditChatResponse, error) {
 	// Validate input
 	if req.NewName == "" || req.ConversationID == "" {
-		return nil, errors.New("new_name and conversation_id are required")
+		return EditChatResponse{}, gerr.E(http.StatusBadRequest, "new_name and conversation_id are required")
 	}
 
-	// SQL query to update the conversation name
+	// SQL query to update the conversation name, the previous name is kept for the audit log.
+	// custom_name stops the generated title from replacing it.
 	query := `
-		UPDATE conversations 
-		SET conversation_name = $1
-		WHERE id = $2 AND user_id = $3
-		RETURNING conversation_name
+		UPDATE conversations c
+		SET conversation_name = $1, custom_name = true
+		FROM (SELECT id, conversation_name FROM conversations WHERE id = $2 FOR UPDATE) previous
+		WHERE c.id = previous.id AND c.user_id = $3 AND c.deleted_at IS NULL
+			AND ($4::bigint[] IS NULL OR c.version = ANY($4))
+		RETURNING previous.conversation_name, c.conversation_name, c.version
 		`
-	var updatedName string
+	var previousName sql.NullString
+	res := EditChatResponse{ID: req.ConversationID}
 
 	// Execute the query
-	err := h.db.QueryRow(query, req.NewName, req.ConversationID, userID).Scan(&updatedName)
+	err := h.db.QueryRow(query, req.NewName, req.ConversationID, userID, pq.Array(versions)).
+		Scan(&previousName, &res.ConversationName, &res.Version)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("conversation not found or user not authorized")
+			return EditChatResponse{}, checkVersionConflict(h.db, userID, req.ConversationID, versions)
 		}
-		return nil, err
-	}
-
-	// Prepare the response
-	response := map[string]string{
-		"id":                req.ConversationID,
-		"conversation_name": updatedName,
+		return EditChatResponse{}, gerr.E(500, gerr.Trace(err))
 	}
 
-	// Marshal the response to JSON
-	responseJSON, err := json.Marshal(response)
-	if err != nil {
-		return nil, err
-	}
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionConversationRename,
+		TargetType: audit.TargetConversation,
+		TargetID:   req.ConversationID,
+		Before:     map[string]string{"conversation_name": previousName.String},
+		After:      map[string]string{"conversation_name": res.ConversationName},
+	})
 
-	return responseJSON, nil
+	return res, nil
 }
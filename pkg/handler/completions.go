@@ -2,14 +2,18 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
 	"github.com/dwarvesf/gerr"
 	"github.com/gin-gonic/gin"
 )
@@ -21,6 +25,9 @@ var (
 
 const defaultModel = "Meta-Llama-3-1-8B-Instruct-FP8"
 
+// errMessageQuotaReached is returned once the user sent its daily messages
+var errMessageQuotaReached = gerr.E(http.StatusTooManyRequests, "daily message quota reached, try again tomorrow")
+
 type completionsRequest struct {
 	Role           string `json:"role" binding:"required"`
 	Content        string `json:"content" binding:"required"`
@@ -89,6 +96,9 @@ func handleError[T any](msg string, err error) (T, error) {
 // @Param request body completionsRequest true "Chat message request body"
 // @Success 200 {object} CompletionResponse
 // @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Conversation not found"
+// @Failure 409 {object} ErrorResponse "Conversation is in the trash"
+// @Failure 429 {object} ErrorResponse "Daily message quota reached"
 // @Failure 500 {object} ErrorResponse "Internal Server Error"
 // @Router /send-chat [post]
 func (h *Handler) Completions(c *gin.Context) {
@@ -100,18 +110,13 @@ func (h *Handler) Completions(c *gin.Context) {
 		return
 	}
 
-	// Get the user ID from the header
-	userID := c.Request.Header.Get("user-id")
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
 
-	// If the conversation ID is not provided, get the most recent conversation ID and increment it by 1
-	if req.ConversationID == "" {
-		id, err := getMostRecentConversationID(h.db)
-		if err != nil {
-			h.handleError(c, gerr.E(500, gerr.Trace(err)))
-			return
-		}
-		// Increment the conversation ID by 1
-		req.ConversationID = strconv.Itoa(id + 1)
+	err = h.useMessageQuota(userID)
+	if err != nil {
+		h.handleError(c, err)
+		return
 	}
 
 	// If the model is not provided, use the default model
@@ -119,8 +124,23 @@ func (h *Handler) Completions(c *gin.Context) {
 		req.Model = defaultModel
 	}
 
+	// New conversations take the next id of the sequence, the ids sent by
+	// clients must be of conversations they own
+	if req.ConversationID == "" {
+		req.ConversationID, err = insertConversation(h.db, userID, req.Model, "New Conversation", time.Now().Unix())
+		if err != nil {
+			h.handleError(c, gerr.E(500, gerr.Trace(err)))
+			return
+		}
+	}
+
 	res, err := h.doCompletions(req, userID, req.ConversationID, req.Model)
 	if err != nil {
+		var reqErr gerr.Error
+		if errors.As(err, &reqErr) && reqErr.Code < http.StatusInternalServerError {
+			h.handleError(c, reqErr)
+			return
+		}
 		h.handleError(c, gerr.E(500, gerr.Trace(err)))
 		return
 	}
@@ -128,13 +148,40 @@ func (h *Handler) Completions(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+// useMessageQuota counts a message sent today by the user, unless it already
+// sent its daily quota. The quota of the user overrides DAILY_MESSAGE_QUOTA,
+// 0 is unlimited.
+func (h *Handler) useMessageQuota(userID string) error {
+	if err := ensureUser(h.db, userID); err != nil {
+		return gerr.E(500, gerr.Trace(err))
+	}
+
+	var messages int
+	err := h.db.QueryRow(`
+		WITH quota AS (
+			SELECT coalesce(daily_message_quota, $2) AS n FROM users WHERE id = $1
+		)
+		INSERT INTO user_usage (user_id, day, messages)
+		SELECT $1, current_date, 1 FROM quota
+		ON CONFLICT (user_id, day) DO UPDATE SET messages = user_usage.messages + 1
+		WHERE (SELECT n FROM quota) = 0 OR user_usage.messages < (SELECT n FROM quota)
+		RETURNING messages`, userID, h.cfg.DailyMessageQuota).Scan(&messages)
+	if err == sql.ErrNoRows {
+		return errMessageQuotaReached
+	}
+	if err != nil {
+		return gerr.E(500, gerr.Trace(fmt.Errorf("could not count message: %v", err)))
+	}
+	return nil
+}
+
 func (h *Handler) doCompletions(cReq completionsRequest, userID, conversationID, model string) (CompletionResponse, error) {
-	_, err := ensureConversation(h.db, conversationID, model, userID)
+	err := checkConversation(h.db, conversationID, userID)
 	if err != nil {
-		return handleError[CompletionResponse]("Error ensuring conversation:", err)
+		return handleError[CompletionResponse]("Error checking conversation:", err)
 	}
 
-	oldMsgs, err := getOldMessages(h.db, conversationID)
+	oldMsgs, err := h.getOldMessages(context.Background(), conversationID)
 	if err != nil {
 		return handleError[CompletionResponse]("Error getting old messages:", err)
 	}
@@ -143,10 +190,10 @@ func (h *Handler) doCompletions(cReq completionsRequest, userID, conversationID,
 		"role":    cReq.Role,
 		"content": cReq.Content,
 	})
-	err = h.setMessages(conversationID, ChoiceMessage{
+	err = h.setMessages(userID, conversationID, ChoiceMessage{
 		Role:    cReq.Role,
 		Content: cReq.Content,
-	}, time.Now().Unix())
+	}, time.Now().Unix(), "", nil)
 	if err != nil {
 		return handleError[CompletionResponse]("Error inserting message into DB:", err)
 	}
@@ -193,7 +240,8 @@ func (h *Handler) doCompletions(cReq completionsRequest, userID, conversationID,
 		return handleError[CompletionResponse]("Error unmarshaling JSON:", err)
 	}
 
-	err = h.setMessages(conversationID, completionResponse.Choices[0].Message, completionResponse.Created)
+	err = h.setMessages(userID, conversationID, completionResponse.Choices[0].Message, completionResponse.Created,
+		completionResponse.Model, &completionResponse.Usage)
 	if err != nil {
 		return handleError[CompletionResponse]("Error inserting completion message into DB:", err)
 	}
@@ -251,7 +299,7 @@ func (h *Handler) doCompletions(cReq completionsRequest, userID, conversationID,
 			return handleError[CompletionResponse]("Error unmarshaling JSON:", err)
 		}
 
-		_, err = insertName(h.db, conversationID, summarizeResponse.Choices[0].Message.Content)
+		conversationName, err := insertName(h.db, conversationID, summarizeResponse.Choices[0].Message.Content)
 		if err != nil {
 			return handleError[CompletionResponse]("Error insert name:", err)
 		}
@@ -263,7 +311,7 @@ func (h *Handler) doCompletions(cReq completionsRequest, userID, conversationID,
 			Role:             completionResponse.Choices[0].Message.Role,
 			ConversationID:   conversationID,
 			CreatedAt:        completionResponse.Created,
-			ConversationName: summarizeResponse.Choices[0].Message.Content,
+			ConversationName: conversationName,
 		}, nil
 	}
 
@@ -280,21 +328,31 @@ func (h *Handler) doCompletions(cReq completionsRequest, userID, conversationID,
 	return response, nil
 }
 
-func getOldMessages(db *sql.DB, conversationID string) ([]map[string]string, error) {
+func (h *Handler) getOldMessages(ctx context.Context, conversationID string) ([]map[string]string, error) {
 	var messages []map[string]string
 
-	rows, err := db.Query(`
-		SELECT role, content FROM messages WHERE conversation_id = $1 ORDER BY timestamp ASC`, conversationID)
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT m.id, m.role, m.content, m.content_encrypted, coalesce(c.user_id, '')
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.conversation_id = $1
+		ORDER BY m.timestamp ASC`, conversationID)
 	if err != nil {
 		return nil, fmt.Errorf("could not fetch old messages: %v", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var role, content string
-		if err := rows.Scan(&role, &content); err != nil {
+		var messageID int
+		var role, content, ownerID string
+		var encrypted bool
+		if err := rows.Scan(&messageID, &role, &content, &encrypted, &ownerID); err != nil {
 			return nil, fmt.Errorf("could not scan message: %v", err)
 		}
+		content, err = h.cipher.Decrypt(ctx, ownerID, encryption.MessageContent(messageID), content, encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt message: %v", err)
+		}
 		// Add the old messages to the slice
 		messages = append(messages, map[string]string{
 			"role":    role,
@@ -309,95 +367,112 @@ func getOldMessages(db *sql.DB, conversationID string) ([]map[string]string, err
 	return messages, nil
 }
 
-func (h *Handler) setMessages(conversationID string, message ChoiceMessage, created int64) error {
-	// Insert the new message into the messages table
-	_, err := h.db.Exec(`
-		INSERT INTO messages (conversation_id, role, content, timestamp)
-		VALUES ($1, $2, $3, $4)`,
-		conversationID, message.Role, message.Content, created)
+// setMessages stores a message of the user, model and usage are only known for
+// completions and are stored as NULL for user messages
+func (h *Handler) setMessages(userID, conversationID string, message ChoiceMessage, created int64, model string, usage *Usage) error {
+	var promptTokens, completionTokens, totalTokens interface{}
+	if usage != nil {
+		promptTokens, completionTokens, totalTokens = usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens
+	}
+
+	messageID, err := nextMessageID(h.db)
+	if err != nil {
+		return err
+	}
+	content, encrypted, err := h.cipher.Encrypt(context.Background(), userID, encryption.MessageContent(messageID), message.Content)
+	if err != nil {
+		return fmt.Errorf("could not encrypt message: %v", err)
+	}
+
+	// Insert the new message into the messages table, search vectors are computed
+	// from the plaintext since the stored content may be encrypted
+	_, err = h.db.Exec(`
+		INSERT INTO messages (id, conversation_id, role, content, content_encrypted, timestamp, model, prompt_tokens, completion_tokens, total_tokens,
+			search_en, search_vi)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, `+searchVectors("$11")+`)`,
+		messageID, conversationID, message.Role, content, encrypted, created, sql.NullString{String: model, Valid: model != ""},
+		promptTokens, completionTokens, totalTokens, h.searchable(message.Content))
 	if err != nil {
 		return fmt.Errorf("could not insert message: %v", err)
 	}
 
+	h.embedMessage(userID, messageID, message.Content)
 	return nil
 }
 
-// ensureConversation ensures that a conversation exists with the provided conversationID.
-// If not, it creates a new conversation.
-func ensureConversation(db *sql.DB, conversationID string, model, userID string) (string, error) {
-	// Check if the conversation already exists
-	var exists bool
+// checkConversation ensures that the user can send messages to the
+// conversation, it must be one of theirs and not be in the trash.
+// Conversations are only created with ids of the sequence, so unknown ids and
+// conversations of other users are not found alike.
+func checkConversation(db *sql.DB, conversationID, userID string) error {
+	if _, err := strconv.Atoi(conversationID); err != nil {
+		return errConversationNotFound(conversationID)
+	}
+
+	var trashed bool
 	err := db.QueryRow(`
-		SELECT EXISTS(SELECT 1 FROM conversations WHERE id = $1)`, conversationID).Scan(&exists)
+		SELECT deleted_at IS NOT NULL FROM conversations
+		WHERE id = $1 AND user_id = $2`, conversationID, userID).Scan(&trashed)
+	if err == sql.ErrNoRows {
+		return errConversationNotFound(conversationID)
+	}
 	if err != nil {
-		return "", fmt.Errorf("could not check if conversation exists: %v", err)
+		return fmt.Errorf("could not check conversation: %v", err)
 	}
 
-	// If conversation exists, return the existing conversationID
-	if exists {
-		return conversationID, nil
+	// Conversations in the trash must be restored before they can be continued
+	if trashed {
+		return errConversationTrashed
 	}
+	return nil
+}
 
-	// If conversation doesn't exist, create a new one
-	var newConversationID string
-	err = db.QueryRow(`
-		INSERT INTO conversations (id, model, conversation_name ,user_id, created_at)
-		VALUES ($1, $2, $3, $4, $5)
-		ON CONFLICT(id) DO NOTHING
-		RETURNING id`, conversationID, model, "New Conversation", userID, time.Now().Unix()).Scan(&newConversationID)
+// insertConversation creates a conversation with the next id of the sequence
+func insertConversation(q querier, userID, model, name string, createdAt int64) (string, error) {
+	if err := ensureUser(q, userID); err != nil {
+		return "", err
+	}
+	var conversationID string
+	err := q.QueryRow(`
+		INSERT INTO conversations (model, conversation_name, user_id, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`, model, name, userID, createdAt).Scan(&conversationID)
 	if err != nil {
 		return "", fmt.Errorf("could not create conversation: %v", err)
 	}
-
-	// Return the newly created conversationID
-	if newConversationID == "" {
-		// If no new conversation was created, use the original conversationID
-		return conversationID, nil
-	}
-
-	return newConversationID, nil
+	return conversationID, nil
 }
 
+// insertName sets the generated title of a conversation and returns the name
+// the conversation ends up with. The conversation is created by
+// insertConversation, a missing one is an error.
 func insertName(db *sql.DB, conversationID string, conversation_name string) (string, error) {
-	var existingID string
-
-	// Check if the conversation already exists
-	err := db.QueryRow(`SELECT id FROM conversations WHERE id = $1`, conversationID).Scan(&existingID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			// If not found, insert a new row
-			err = db.QueryRow(
-				`INSERT INTO conversations (id, conversation_name) 
-                 VALUES ($1, $2) 
-                 RETURNING id`,
-				conversationID, conversation_name,
-			).Scan(&existingID)
-			if err != nil {
-				return "", fmt.Errorf("could not insert conversation: %v", err)
-			}
-			return existingID, nil
-		}
-		// Return other errors during the SELECT operation
-		return "", fmt.Errorf("could not check conversation existence: %v", err)
+	// Keep the name the user gave while the title was generated
+	var name string
+	err := db.QueryRow(`
+		UPDATE conversations SET conversation_name = $1
+		WHERE id = $2 AND NOT custom_name
+		RETURNING conversation_name`, conversation_name, conversationID).Scan(&name)
+	if err == sql.ErrNoRows {
+		err = db.QueryRow(`SELECT coalesce(conversation_name, '') FROM conversations WHERE id = $1`, conversationID).Scan(&name)
+	}
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("conversation %s does not exist", conversationID)
 	}
-
-	// If found, update the conversation name
-	_, err = db.Exec(`UPDATE conversations SET conversation_name = $1 WHERE id = $2`, conversation_name, conversationID)
 	if err != nil {
 		return "", fmt.Errorf("could not update conversation name: %v", err)
 	}
 
-	return existingID, nil
+	return name, nil
 }
 
-func getMostRecentConversationID(db *sql.DB) (int, error) {
-	var conversationID int
-	err := db.QueryRow("SELECT id FROM conversations ORDER BY id DESC LIMIT 1").Scan(&conversationID)
+// nextMessageID reserves the id of a message, its content is sealed for the
+// row before the row is inserted
+func nextMessageID(q querier) (int, error) {
+	var messageID int
+	err := q.QueryRow(`SELECT nextval('messages_id_seq')`).Scan(&messageID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, nil // No conversations found
-		}
-		return 0, fmt.Errorf("could not fetch the most recent conversation ID: %v", err)
+		return 0, fmt.Errorf("could not allocate a message ID: %v", err)
 	}
-	return conversationID, nil
+	return messageID, nil
 }
@@ -0,0 +1,674 @@
+package handler
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/Essen-Labs/bloom-be/pkg/export"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Statuses of data exports
+const (
+	dataExportPending = "pending"
+	dataExportRunning = "running"
+	dataExportReady   = "ready"
+	dataExportFailed  = "failed"
+)
+
+// dataExportChunkSize is the size of the chunks archives are stored in
+const dataExportChunkSize = 1 << 20
+
+// dataExportStaleAfter is how long a build may run before it is presumed lost,
+// with the instance building it, and started again
+const dataExportStaleAfter = time.Hour
+
+// Data export errors, their messages are translated
+var (
+	errDataExportNotReady = gerr.E(http.StatusConflict, "data export is not ready yet")
+	errDataExportFailed   = gerr.E(http.StatusConflict, "data export failed, request a new one")
+)
+
+func errDataExportNotFound(exportID string) error {
+	return gerr.E(http.StatusNotFound, fmt.Sprintf("data export with id %s not found", exportID))
+}
+
+// DataExport is an archive of the data of a user
+type DataExport struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Size        *int64     `json:"size"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+}
+
+// DataExportResponse represents a requested data export
+type DataExportResponse struct {
+	Success bool       `json:"success"`
+	Message string     `json:"message"`
+	Export  DataExport `json:"export"`
+}
+
+// GetDataExportListResponse represents the data exports of a user
+type GetDataExportListResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Exports []DataExport `json:"exports"`
+}
+
+// dataExportProfile is the profile.json file of an archive
+type dataExportProfile struct {
+	User       User                 `json:"user"`
+	Identities []dataExportIdentity `json:"identities"`
+	Sessions   []dataExportSession  `json:"sessions"`
+	APIKeys    []APIKey             `json:"apiKeys"`
+	Folders    []Folder             `json:"folders"`
+	Tags       []Tag                `json:"tags"`
+}
+
+// dataExportIdentity is an account of a single sign-on provider linked to the user
+type dataExportIdentity struct {
+	Issuer      string    `json:"issuer"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastLoginAt time.Time `json:"lastLoginAt"`
+}
+
+// dataExportSession is a login session of the user
+type dataExportSession struct {
+	IP        string     `json:"ip"`
+	UserAgent string     `json:"userAgent"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+// dataExportFeedback is a rating given by the user, in feedback.json
+type dataExportFeedback struct {
+	Feedback
+	ConversationID int       `json:"conversationId"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// dataExportUsage is the usage.json file of an archive
+type dataExportUsage struct {
+	Days   []DailyUsage           `json:"days"`
+	Models []dataExportModelUsage `json:"models"`
+}
+
+// dataExportModelUsage counts the answers of a model and their tokens
+type dataExportModelUsage struct {
+	Model            string `json:"model"`
+	Messages         int    `json:"messages"`
+	PromptTokens     int    `json:"promptTokens"`
+	CompletionTokens int    `json:"completionTokens"`
+	TotalTokens      int    `json:"totalTokens"`
+}
+
+const dataExportColumns = `id, status, size, created_at, completed_at, expires_at`
+
+func scanDataExport(row rowScanner) (DataExport, error) {
+	var e DataExport
+	err := row.Scan(&e.ID, &e.Status, &e.Size, &e.CreatedAt, &e.CompletedAt, &e.ExpiresAt)
+	return e, err
+}
+
+// RequestDataExport starts building an archive of the data of the user
+// @Summary Request a data export
+// @Description Starts building, in the background, a zip archive of the profile, conversations with their messages, feedback and usage of the user. A user has at most one export in progress, asking again returns it. Archives are kept for DATA_EXPORT_TTL.
+// @Tags user
+// @Produce json
+// @Success 202 {object} DataExportResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /request-data-export [post]
+func (h *Handler) RequestDataExport(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doRequestDataExport(h.actor(c), userID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// The job picks the export up anyway, waking it saves the wait. A signal
+	// already pending covers this export too.
+	select {
+	case h.dataExports <- struct{}{}:
+	default:
+	}
+
+	c.JSON(http.StatusAccepted, res)
+}
+
+func (h *Handler) doRequestDataExport(actor audit.Actor, userID string) (DataExportResponse, error) {
+	if err := ensureUser(h.db, userID); err != nil {
+		return DataExportResponse{}, gerr.E(500, gerr.Trace(err))
+	}
+
+	e, err := scanDataExport(h.db.QueryRow(`
+		INSERT INTO data_exports (id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) WHERE status IN ('pending', 'running') DO NOTHING
+		RETURNING `+dataExportColumns, uuid.New().String(), userID))
+	if err == sql.ErrNoRows {
+		e, err = scanDataExport(h.db.QueryRow(`
+			SELECT `+dataExportColumns+`
+			FROM data_exports
+			WHERE user_id = $1 AND status IN ('pending', 'running')`, userID))
+		if err != nil {
+			return DataExportResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not find data export in progress: %v", err)))
+		}
+		return DataExportResponse{Success: true, Message: "Data export already in progress", Export: e}, nil
+	}
+	if err != nil {
+		return DataExportResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not create data export: %v", err)))
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionDataExportRequest,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+		After:      map[string]string{"export_id": e.ID},
+	})
+
+	return DataExportResponse{Success: true, Message: "Data export requested", Export: e}, nil
+}
+
+// GetDataExportList lists the data exports of the user
+// @Summary List data exports
+// @Description Lists the data exports of the user that have not expired, the latest first
+// @Tags user
+// @Produce json
+// @Success 200 {object} GetDataExportListResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /get-data-export-list [get]
+func (h *Handler) GetDataExportList(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doGetDataExportList(userID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetDataExportList(userID string) (GetDataExportListResponse, error) {
+	rows, err := h.db.Query(`
+		SELECT `+dataExportColumns+`
+		FROM data_exports
+		WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > now())
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return GetDataExportListResponse{}, fmt.Errorf("error querying data exports: %v", err)
+	}
+	defer rows.Close()
+
+	exports := []DataExport{}
+	for rows.Next() {
+		e, err := scanDataExport(rows)
+		if err != nil {
+			return GetDataExportListResponse{}, fmt.Errorf("error scanning data export: %v", err)
+		}
+		exports = append(exports, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return GetDataExportListResponse{}, fmt.Errorf("error iterating over data exports: %v", err)
+	}
+
+	return GetDataExportListResponse{
+		Success: len(exports) > 0,
+		Message: fmt.Sprintf("Found %d data exports", len(exports)),
+		Exports: exports,
+	}, nil
+}
+
+// DownloadDataExport downloads the archive of a data export
+// @Summary Download a data export
+// @Description Downloads the zip archive of a ready data export. It holds profile.json, usage.json, feedback.json and a conversations folder with one JSON file per conversation, those in the trash included.
+// @Tags user
+// @Produce application/zip
+// @Param export_id path string true "Data export ID"
+// @Success 200 {file} file "Zip archive of the data of the user"
+// @Failure 404 {object} ErrorResponse "Data export not found or expired"
+// @Failure 409 {object} ErrorResponse "Data export not ready yet, or failed"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /download-data-export/{export_id} [get]
+func (h *Handler) DownloadDataExport(c *gin.Context) {
+	exportID := c.Param("export_id")
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	archive, err := h.doDownloadDataExport(userID, exportID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="bloom-data-%s.zip"`, archive.createdAt.UTC().Format("20060102-150405")))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Length", strconv.FormatInt(archive.size, 10))
+	c.Status(http.StatusOK)
+
+	// The response has started, a failure can only cut it short of its length
+	if err := h.streamDataExport(c.Request.Context(), c.Writer, userID, exportID, archive.chunks); err != nil {
+		h.log.Error("could not stream data export "+exportID+":", err) //nolint:errcheck // Ignore unused function warning
+		c.Abort()
+	}
+}
+
+// dataExportArchive describes the stored archive of a ready export
+type dataExportArchive struct {
+	createdAt time.Time
+	size      int64
+	chunks    int
+}
+
+func (h *Handler) doDownloadDataExport(userID, exportID string) (dataExportArchive, error) {
+	var status string
+	var size sql.NullInt64
+	var chunks sql.NullInt32
+	var archive dataExportArchive
+	err := h.db.QueryRow(`
+		SELECT status, size, chunks, created_at
+		FROM data_exports
+		WHERE id = $1 AND user_id = $2 AND (expires_at IS NULL OR expires_at > now())`, exportID, userID).
+		Scan(&status, &size, &chunks, &archive.createdAt)
+	if err == sql.ErrNoRows {
+		return archive, errDataExportNotFound(exportID)
+	}
+	if err != nil {
+		return archive, gerr.E(500, gerr.Trace(fmt.Errorf("could not find data export: %v", err)))
+	}
+	if status == dataExportFailed {
+		return archive, errDataExportFailed
+	}
+	if status != dataExportReady {
+		return archive, errDataExportNotReady
+	}
+	archive.size, archive.chunks = size.Int64, int(chunks.Int32)
+	return archive, nil
+}
+
+// streamDataExport decrypts the chunks of an archive into w, one at a time
+func (h *Handler) streamDataExport(ctx context.Context, w io.Writer, userID, exportID string, chunks int) error {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT n, data, encrypted
+		FROM data_export_chunks
+		WHERE export_id = $1
+		ORDER BY n ASC`, exportID)
+	if err != nil {
+		return fmt.Errorf("could not query data export chunks: %v", err)
+	}
+	defer rows.Close()
+
+	next := 0
+	for rows.Next() {
+		var n int
+		var data []byte
+		var encrypted bool
+		if err := rows.Scan(&n, &data, &encrypted); err != nil {
+			return fmt.Errorf("error scanning data export chunk: %v", err)
+		}
+		if n != next || n >= chunks {
+			return fmt.Errorf("unexpected data export chunk %d", n)
+		}
+		data, err = h.cipher.DecryptBytes(ctx, userID, encryption.DataExportChunk(exportID, n, n == chunks-1), data, encrypted)
+		if err != nil {
+			return fmt.Errorf("could not decrypt data export chunk %d: %v", n, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("could not write data export chunk %d: %v", n, err)
+		}
+		next++
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over data export chunks: %v", err)
+	}
+	if next != chunks {
+		return fmt.Errorf("data export has %d of its %d chunks", next, chunks)
+	}
+	return nil
+}
+
+// DataExportRequests receives when a data export is requested, to trigger
+// BuildDataExports
+func (h *Handler) DataExportRequests() <-chan struct{} {
+	return h.dataExports
+}
+
+// BuildDataExports builds the pending data exports one after another, and
+// drops the expired ones
+func (h *Handler) BuildDataExports(ctx context.Context) error {
+	_, err := h.db.ExecContext(ctx, `DELETE FROM data_exports WHERE expires_at < now()`)
+	if err != nil {
+		return fmt.Errorf("could not delete expired data exports: %v", err)
+	}
+
+	for {
+		var exportID, userID string
+		err := h.db.QueryRowContext(ctx, `
+			UPDATE data_exports SET status = $1, started_at = now()
+			WHERE id = (
+				SELECT id FROM data_exports
+				WHERE status = $2 OR (status = $1 AND started_at < now() - make_interval(secs => $3))
+				ORDER BY created_at
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, user_id`, dataExportRunning, dataExportPending, dataExportStaleAfter.Seconds()).Scan(&exportID, &userID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not claim data export: %v", err)
+		}
+
+		if err := h.buildDataExport(ctx, exportID, userID); err != nil {
+			return err
+		}
+	}
+}
+
+// buildDataExport builds and stores the archive of an export, or marks it
+// failed
+func (h *Handler) buildDataExport(ctx context.Context, exportID, userID string) error {
+	// A build presumed lost may have stored some chunks already
+	_, err := h.db.ExecContext(ctx, `DELETE FROM data_export_chunks WHERE export_id = $1`, exportID)
+	if err != nil {
+		return fmt.Errorf("could not clear data export %s: %v", exportID, err)
+	}
+
+	archive := &dataExportWriter{ctx: ctx, h: h, exportID: exportID, userID: userID}
+	buildErr := h.writeDataArchive(ctx, archive, userID)
+	if buildErr == nil {
+		buildErr = archive.Close()
+	}
+	if buildErr != nil {
+		h.log.Error("could not build data export "+exportID+":", buildErr) //nolint:errcheck // Ignore unused function warning
+		_, err := h.db.ExecContext(ctx, `
+			DELETE FROM data_export_chunks WHERE export_id = $1`, exportID)
+		if err != nil {
+			return fmt.Errorf("could not clear data export %s: %v", exportID, err)
+		}
+		_, err = h.db.ExecContext(ctx, `
+			UPDATE data_exports SET status = $2, error = $3, completed_at = now(), expires_at = now() + make_interval(secs => $4)
+			WHERE id = $1`, exportID, dataExportFailed, buildErr.Error(), h.cfg.DataExportTTL.Seconds())
+		if err != nil {
+			return fmt.Errorf("could not mark data export %s failed: %v", exportID, err)
+		}
+		return nil
+	}
+
+	_, err = h.db.ExecContext(ctx, `
+		UPDATE data_exports SET status = $2, size = $3, chunks = $4, completed_at = now(), expires_at = now() + make_interval(secs => $5)
+		WHERE id = $1`, exportID, dataExportReady, archive.size, archive.n, h.cfg.DataExportTTL.Seconds())
+	if err != nil {
+		return fmt.Errorf("could not store data export %s: %v", exportID, err)
+	}
+	return nil
+}
+
+// dataExportWriter stores an archive as it is written, in chunks sealed with
+// the data key of the user. The last chunk is sealed as final on Close, so an
+// archive missing its end does not decrypt.
+type dataExportWriter struct {
+	ctx      context.Context
+	h        *Handler
+	exportID string
+	userID   string
+	buf      []byte
+	n        int
+	size     int64
+}
+
+func (w *dataExportWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	// A full chunk is kept until more follows, it may be the last one
+	for len(w.buf) > dataExportChunkSize {
+		if err := w.store(w.buf[:dataExportChunkSize], false); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[dataExportChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+// Close stores the final chunk
+func (w *dataExportWriter) Close() error {
+	err := w.store(w.buf, true)
+	w.buf = nil
+	return err
+}
+
+func (w *dataExportWriter) store(chunk []byte, final bool) error {
+	data, encrypted, err := w.h.cipher.EncryptBytes(w.ctx, w.userID, encryption.DataExportChunk(w.exportID, w.n, final), chunk)
+	if err != nil {
+		return fmt.Errorf("could not encrypt data export chunk %d: %v", w.n, err)
+	}
+	_, err = w.h.db.ExecContext(w.ctx, `
+		INSERT INTO data_export_chunks (export_id, n, data, encrypted)
+		VALUES ($1, $2, $3, $4)`, w.exportID, w.n, data, encrypted)
+	if err != nil {
+		return fmt.Errorf("could not store data export chunk %d: %v", w.n, err)
+	}
+	w.n++
+	w.size += int64(len(chunk))
+	return nil
+}
+
+// writeDataArchive writes the zip archive of the data of a user
+func (h *Handler) writeDataArchive(ctx context.Context, w io.Writer, userID string) error {
+	zw := zip.NewWriter(w)
+	writeJSON := func(name string, v interface{}) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return fmt.Errorf("could not add %s to archive: %v", name, err)
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	profile, err := h.getDataExportProfile(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("profile.json", profile); err != nil {
+		return err
+	}
+	usage, err := h.getDataExportUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("usage.json", usage); err != nil {
+		return err
+	}
+	feedback, err := h.getDataExportFeedback(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("feedback.json", feedback); err != nil {
+		return err
+	}
+
+	// Conversations in the trash are still held, they are exported too
+	conversations, err := h.queryExportConversations(ctx, "c.user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	for _, conversation := range conversations {
+		f, err := zw.Create("conversations/" + export.FileName(conversation, export.FormatJSON))
+		if err != nil {
+			return fmt.Errorf("could not add conversation %s to archive: %v", conversation.ID, err)
+		}
+		if err := h.writeConversationExport(ctx, f, export.FormatJSON, conversation); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (h *Handler) getDataExportProfile(ctx context.Context, userID string) (dataExportProfile, error) {
+	var profile dataExportProfile
+
+	current, err := h.doGetCurrentUser(userID)
+	if err != nil {
+		return profile, err
+	}
+	profile.User = current.User
+	profile.User.Anonymous = profile.User.Username == ""
+
+	folders, err := h.doGetFolderList(userID)
+	if err != nil {
+		return profile, err
+	}
+	profile.Folders = folders.Folders
+	tags, err := h.doGetTagList(userID)
+	if err != nil {
+		return profile, err
+	}
+	profile.Tags = tags.Tags
+	apiKeys, err := h.doGetAPIKeyList(userID)
+	if err != nil {
+		return profile, err
+	}
+	profile.APIKeys = apiKeys.APIKeys
+
+	profile.Identities = []dataExportIdentity{}
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT issuer, coalesce(email, ''), created_at, last_login_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at ASC`, userID)
+	if err != nil {
+		return profile, fmt.Errorf("error querying identities to export: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var identity dataExportIdentity
+		if err := rows.Scan(&identity.Issuer, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt); err != nil {
+			return profile, fmt.Errorf("error scanning identity to export: %v", err)
+		}
+		profile.Identities = append(profile.Identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return profile, fmt.Errorf("error iterating over identities to export: %v", err)
+	}
+
+	profile.Sessions = []dataExportSession{}
+	rows, err = h.db.QueryContext(ctx, `
+		SELECT coalesce(ip, ''), coalesce(user_agent, ''), created_at, expires_at, revoked_at
+		FROM user_sessions
+		WHERE user_id = $1
+		ORDER BY created_at ASC`, userID)
+	if err != nil {
+		return profile, fmt.Errorf("error querying sessions to export: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var session dataExportSession
+		if err := rows.Scan(&session.IP, &session.UserAgent, &session.CreatedAt, &session.ExpiresAt, &session.RevokedAt); err != nil {
+			return profile, fmt.Errorf("error scanning session to export: %v", err)
+		}
+		profile.Sessions = append(profile.Sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return profile, fmt.Errorf("error iterating over sessions to export: %v", err)
+	}
+	return profile, nil
+}
+
+func (h *Handler) getDataExportUsage(ctx context.Context, userID string) (dataExportUsage, error) {
+	usage := dataExportUsage{Days: []DailyUsage{}, Models: []dataExportModelUsage{}}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT to_char(day, 'YYYY-MM-DD'), messages
+		FROM user_usage
+		WHERE user_id = $1
+		ORDER BY day ASC`, userID)
+	if err != nil {
+		return usage, fmt.Errorf("error querying usage to export: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d DailyUsage
+		if err := rows.Scan(&d.Day, &d.Messages); err != nil {
+			return usage, fmt.Errorf("error scanning usage to export: %v", err)
+		}
+		usage.Days = append(usage.Days, d)
+	}
+	if err := rows.Err(); err != nil {
+		return usage, fmt.Errorf("error iterating over usage to export: %v", err)
+	}
+
+	rows, err = h.db.QueryContext(ctx, `
+		SELECT m.model, count(*), coalesce(sum(m.prompt_tokens), 0), coalesce(sum(m.completion_tokens), 0), coalesce(sum(m.total_tokens), 0)
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE c.user_id = $1 AND m.model IS NOT NULL
+		GROUP BY m.model
+		ORDER BY m.model ASC`, userID)
+	if err != nil {
+		return usage, fmt.Errorf("error querying model usage to export: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m dataExportModelUsage
+		if err := rows.Scan(&m.Model, &m.Messages, &m.PromptTokens, &m.CompletionTokens, &m.TotalTokens); err != nil {
+			return usage, fmt.Errorf("error scanning model usage to export: %v", err)
+		}
+		usage.Models = append(usage.Models, m)
+	}
+	if err := rows.Err(); err != nil {
+		return usage, fmt.Errorf("error iterating over model usage to export: %v", err)
+	}
+	return usage, nil
+}
+
+func (h *Handler) getDataExportFeedback(ctx context.Context, userID string) ([]dataExportFeedback, error) {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT f.message_id, m.conversation_id, f.score, f.reasons, f.comment, f.created_at, f.updated_at
+		FROM message_feedback f
+		JOIN messages m ON m.id = f.message_id
+		WHERE f.user_id = $1
+		ORDER BY f.created_at ASC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying feedback to export: %v", err)
+	}
+	defer rows.Close()
+
+	feedback := []dataExportFeedback{}
+	for rows.Next() {
+		var f dataExportFeedback
+		if err := rows.Scan(&f.MessageID, &f.ConversationID, &f.Score, pq.Array(&f.Reasons), &f.Comment, &f.CreatedAt, &f.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning feedback to export: %v", err)
+		}
+		feedback = append(feedback, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over feedback to export: %v", err)
+	}
+	return feedback, nil
+}
@@ -0,0 +1,537 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/mail"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+// Purposes of the tokens sent by email
+const (
+	tokenVerifyEmail   = "verify_email"
+	tokenResetPassword = "reset_password"
+)
+
+// emailInterval is the least time between two emails of the same purpose to a user
+const emailInterval = time.Minute
+
+// emailSendTimeout bounds sending an email in the background
+const emailSendTimeout = time.Minute
+
+// Email errors, their messages are translated
+var (
+	errInvalidEmailToken    = gerr.E(http.StatusBadRequest, "link is invalid or expired")
+	errEmailTaken           = gerr.E(http.StatusConflict, "email is already used by another account")
+	errEmailRequired        = gerr.E(http.StatusBadRequest, "add an email address to your account first")
+	errEmailAlreadyVerified = gerr.E(http.StatusConflict, "email is already verified")
+	errEmailNeedsAccount    = gerr.E(http.StatusForbidden, "sign up to verify an email address")
+	errTooManyEmails        = gerr.E(http.StatusTooManyRequests, "an email was just sent, wait a minute before asking for another")
+)
+
+// SendVerificationEmailRequest optionally changes the email of the user before verifying it
+type SendVerificationEmailRequest struct {
+	Email string `json:"email" binding:"omitempty,email,max=320"`
+}
+
+// VerifyEmailRequest carries the token of a verification link
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ForgotPasswordRequest asks for a password reset link
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email,max=320"`
+}
+
+// ResetPasswordRequest sets a new password with the token of a reset link
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,strongpassword"`
+}
+
+// EmailResponse represents the result of the email routes
+type EmailResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// emailToken is a token read back from a link
+type emailToken struct {
+	UserID string
+	Email  string
+}
+
+// SendVerificationEmail sends a verification link to the email of the user
+// @Summary Send verification email
+// @Description Sends a link verifying the email of the user, first changing it when one is given. A changed email is unverified until its link is opened. Links expire after EMAIL_VERIFICATION_TTL, sending a new one invalidates the previous ones.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body SendVerificationEmailRequest false "New email"
+// @Success 200 {object} EmailResponse
+// @Failure 400 {object} ErrorResponse "Bad Request, or the user has no email"
+// @Failure 403 {object} ErrorResponse "Anonymous visitors have no email"
+// @Failure 409 {object} ErrorResponse "Email already verified"
+// @Failure 429 {object} ErrorResponse "An email was just sent"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /send-verification-email [post]
+func (h *Handler) SendVerificationEmail(c *gin.Context) {
+	if c.GetBool(constant.AnonymousKey) {
+		h.handleError(c, errEmailNeedsAccount)
+		return
+	}
+
+	var req SendVerificationEmailRequest
+	// The body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.handleError(c, err)
+			return
+		}
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doSendVerificationEmail(userID, c.GetString(constant.LanguageKey), strings.TrimSpace(req.Email))
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doSendVerificationEmail(userID, locale, email string) (EmailResponse, error) {
+	var username, current string
+	var verified bool
+	err := h.db.QueryRow(`
+		SELECT coalesce(username, ''), coalesce(email, ''), email_verified_at IS NOT NULL
+		FROM users
+		WHERE id = $1`, userID).Scan(&username, &current, &verified)
+	if err == sql.ErrNoRows {
+		return EmailResponse{}, errUserNotFound(userID)
+	}
+	if err != nil {
+		return EmailResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not find user: %v", err)))
+	}
+
+	if email != "" && !strings.EqualFold(email, current) {
+		_, err = h.db.Exec(`
+			UPDATE users SET email = $2, email_verified_at = NULL, updated_at = now()
+			WHERE id = $1`, userID, email)
+		if err != nil {
+			return EmailResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not change email: %v", err)))
+		}
+		current, verified = email, false
+	}
+	if current == "" {
+		return EmailResponse{}, errEmailRequired
+	}
+	if verified {
+		return EmailResponse{}, errEmailAlreadyVerified
+	}
+	recent, err := h.recentEmailToken(userID, tokenVerifyEmail)
+	if err != nil {
+		return EmailResponse{}, err
+	}
+	if recent {
+		return EmailResponse{}, errTooManyEmails
+	}
+
+	token, err := h.issueEmailToken(userID, tokenVerifyEmail, current, h.cfg.EmailVerificationTTL)
+	if err != nil {
+		return EmailResponse{}, err
+	}
+	if err := h.sendTokenEmail(locale, "email_verify", username, current, token, "/verify-email", h.cfg.EmailVerificationTTL); err != nil {
+		return EmailResponse{}, err
+	}
+
+	return EmailResponse{
+		Success: true,
+		Message: fmt.Sprintf("Verification email sent to %s", current),
+	}, nil
+}
+
+// VerifyEmail verifies the email of a user
+// @Summary Verify email
+// @Description Verifies the email the link was sent to, if it is still the email of the user. Each link can be used once.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body VerifyEmailRequest true "Token of the link"
+// @Success 200 {object} EmailResponse
+// @Failure 400 {object} ErrorResponse "Link is invalid or expired"
+// @Failure 409 {object} ErrorResponse "Email verified by another account meanwhile"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /verify-email [post]
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.doVerifyEmail(h.actor(c), req.Token)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doVerifyEmail(actor audit.Actor, token string) (res EmailResponse, err error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not begin transaction: %v", err)))
+	}
+
+	var t emailToken
+
+	// Rollback the transaction if there's an error
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback() //nolint:errcheck // the panic is what matters
+			panic(p)      // Re-raise the panic
+		} else if err != nil {
+			tx.Rollback() //nolint:errcheck // the original error is returned
+		} else if err = tx.Commit(); err != nil {
+			err = gerr.E(500, gerr.Trace(fmt.Errorf("could not commit verification: %v", err)))
+		} else {
+			actor.UserID = t.UserID
+			h.recordAudit(audit.Entry{
+				Actor:      actor,
+				Action:     audit.ActionUserVerifyEmail,
+				TargetType: audit.TargetUser,
+				TargetID:   t.UserID,
+				After:      map[string]string{"email": t.Email},
+			})
+		}
+	}()
+
+	t, err = useEmailToken(tx, token, tokenVerifyEmail)
+	if err != nil {
+		return res, err
+	}
+
+	// The user may have changed their email since the link was sent
+	result, err := tx.Exec(`
+		UPDATE users SET email_verified_at = now(), updated_at = now()
+		WHERE id = $1 AND lower(email) = lower($2) AND disabled_at IS NULL`, t.UserID, t.Email)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return res, errEmailTaken
+		}
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not verify email: %v", err)))
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not check verified email: %v", err)))
+	}
+	if affected == 0 {
+		return res, errInvalidEmailToken
+	}
+
+	return EmailResponse{
+		Success: true,
+		Message: fmt.Sprintf("Email %s verified", t.Email),
+	}, nil
+}
+
+// ForgotPassword sends a password reset link
+// @Summary Forgot password
+// @Description Sends a link resetting the password of the account of a verified email. The response is the same whether or not such an account exists. Links expire after PASSWORD_RESET_TTL, sending a new one invalidates the previous ones.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body ForgotPasswordRequest true "Email of the account"
+// @Success 200 {object} EmailResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Password login is disabled"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /forgot-password [post]
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	if !h.cfg.PasswordLogin {
+		h.handleError(c, errPasswordLoginDisabled)
+		return
+	}
+
+	var req ForgotPasswordRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.doForgotPassword(c.GetString(constant.LanguageKey), strings.TrimSpace(req.Email))
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doForgotPassword(locale, email string) (EmailResponse, error) {
+	res := EmailResponse{
+		Success: true,
+		Message: "If an account uses this email, a link to reset its password was sent",
+	}
+
+	// Only verified emails prove who the account belongs to
+	var userID, username, verified string
+	err := h.db.QueryRow(`
+		SELECT id, username, email
+		FROM users
+		WHERE lower(email) = lower($1) AND email_verified_at IS NOT NULL
+			AND username IS NOT NULL AND disabled_at IS NULL`, email).Scan(&userID, &username, &verified)
+	if err == sql.ErrNoRows {
+		return res, nil
+	}
+	if err != nil {
+		return EmailResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not find user: %v", err)))
+	}
+
+	recent, err := h.recentEmailToken(userID, tokenResetPassword)
+	if err != nil {
+		return EmailResponse{}, err
+	}
+	if recent {
+		// Telling would reveal that the account exists
+		return res, nil
+	}
+
+	token, err := h.issueEmailToken(userID, tokenResetPassword, verified, h.cfg.PasswordResetTTL)
+	if err != nil {
+		return EmailResponse{}, err
+	}
+	if err := h.sendTokenEmail(locale, "email_reset", username, verified, token, "/reset-password", h.cfg.PasswordResetTTL); err != nil {
+		return EmailResponse{}, err
+	}
+	return res, nil
+}
+
+// ResetPassword sets a new password with a reset link
+// @Summary Reset password
+// @Description Sets the password of the account the link was sent for, and logs it out everywhere. Each link can be used once.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body ResetPasswordRequest true "Token of the link and new password"
+// @Success 200 {object} EmailResponse
+// @Failure 400 {object} ErrorResponse "Bad Request, or the link is invalid or expired"
+// @Failure 403 {object} ErrorResponse "Password login is disabled"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /reset-password [post]
+func (h *Handler) ResetPassword(c *gin.Context) {
+	if !h.cfg.PasswordLogin {
+		h.handleError(c, errPasswordLoginDisabled)
+		return
+	}
+
+	var req ResetPasswordRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.doResetPassword(h.actor(c), req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doResetPassword(actor audit.Actor, req ResetPasswordRequest) (res EmailResponse, err error) {
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not hash password: %v", err)))
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not begin transaction: %v", err)))
+	}
+
+	var t emailToken
+
+	// Rollback the transaction if there's an error
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback() //nolint:errcheck // the panic is what matters
+			panic(p)      // Re-raise the panic
+		} else if err != nil {
+			tx.Rollback() //nolint:errcheck // the original error is returned
+		} else if err = tx.Commit(); err != nil {
+			err = gerr.E(500, gerr.Trace(fmt.Errorf("could not commit password reset: %v", err)))
+		} else {
+			actor.UserID = t.UserID
+			h.recordAudit(audit.Entry{
+				Actor:      actor,
+				Action:     audit.ActionUserResetPassword,
+				TargetType: audit.TargetUser,
+				TargetID:   t.UserID,
+				After:      map[string]string{"email": t.Email},
+			})
+		}
+	}()
+
+	t, err = useEmailToken(tx, req.Token, tokenResetPassword)
+	if err != nil {
+		return res, err
+	}
+
+	// Whoever had the old password is logged out
+	result, err := tx.Exec(`
+		UPDATE users SET password_hash = $2, sessions_revoked_at = now(), updated_at = now()
+		WHERE id = $1 AND disabled_at IS NULL`, t.UserID, hash)
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not reset password: %v", err)))
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not check reset password: %v", err)))
+	}
+	if affected == 0 {
+		return res, errInvalidEmailToken
+	}
+	_, err = tx.Exec(`
+		UPDATE user_sessions SET revoked_at = now()
+		WHERE user_id = $1 AND revoked_at IS NULL`, t.UserID)
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not revoke sessions: %v", err)))
+	}
+
+	return EmailResponse{
+		Success: true,
+		Message: "Password reset, log in with the new password",
+	}, nil
+}
+
+// PurgeExpiredTokens deletes the email tokens that can no longer be used
+func (h *Handler) PurgeExpiredTokens(ctx context.Context) error {
+	_, err := h.db.ExecContext(ctx, `
+		DELETE FROM user_tokens
+		WHERE expires_at < now() OR used_at IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("could not purge expired tokens: %v", err)
+	}
+	return nil
+}
+
+// recentEmailToken tells whether a token of the purpose was sent to the user
+// less than emailInterval ago, users get at most one email per interval
+func (h *Handler) recentEmailToken(userID, purpose string) (bool, error) {
+	var recent bool
+	err := h.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM user_tokens
+			WHERE user_id = $1 AND purpose = $2 AND created_at > now() - make_interval(secs => $3)
+		)`, userID, purpose, emailInterval.Seconds()).Scan(&recent)
+	if err != nil {
+		return false, gerr.E(500, gerr.Trace(fmt.Errorf("could not check sent emails: %v", err)))
+	}
+	return recent, nil
+}
+
+// issueEmailToken stores a new token of the purpose sent to email, which
+// invalidates the unused tokens sent before, and returns it
+func (h *Handler) issueEmailToken(userID, purpose, email string, ttl time.Duration) (string, error) {
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		return "", gerr.E(500, gerr.Trace(err))
+	}
+	_, err = h.db.Exec(`
+		WITH replaced AS (
+			DELETE FROM user_tokens
+			WHERE user_id = $2 AND purpose = $3 AND used_at IS NULL
+		)
+		INSERT INTO user_tokens (id, user_id, purpose, email, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`, hash, userID, purpose, email, time.Now().Add(ttl))
+	if err != nil {
+		return "", gerr.E(500, gerr.Trace(fmt.Errorf("could not store token: %v", err)))
+	}
+	return token, nil
+}
+
+// useEmailToken uses up a token of the purpose, errInvalidEmailToken when it
+// is unknown, used or expired
+func useEmailToken(q querier, token, purpose string) (emailToken, error) {
+	var t emailToken
+	err := q.QueryRow(`
+		UPDATE user_tokens SET used_at = now()
+		WHERE id = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id, email`, auth.HashToken(token), purpose).Scan(&t.UserID, &t.Email)
+	if err == sql.ErrNoRows {
+		return t, errInvalidEmailToken
+	}
+	if err != nil {
+		return t, gerr.E(500, gerr.Trace(fmt.Errorf("could not use token: %v", err)))
+	}
+	return t, nil
+}
+
+// sendTokenEmail sends the email of the template with a link to path of the
+// frontend carrying the token. The template takes the username, the email,
+// the link and its expiry.
+func (h *Handler) sendTokenEmail(locale, template, username, email, token, path string, ttl time.Duration) error {
+	base := h.cfg.FrontendBaseURL
+	if base == "" {
+		base = h.cfg.BaseURL
+	}
+	// Tokens are URL safe
+	link := strings.TrimRight(base, "/") + path + "?token=" + token
+	return h.sendTemplateEmail(locale, template, email, username, email, link, formatEmailTime(time.Now().Add(ttl)))
+}
+
+// sendTemplateEmail sends the email of the template to email, in the language
+// of the request, the body taking params
+func (h *Handler) sendTemplateEmail(locale, template, email string, params ...string) error {
+	tr := h.translator.GetTranslator(locale)
+	subject, err := tr.T(template + "_subject")
+	if err != nil {
+		return gerr.E(500, gerr.Trace(fmt.Errorf("could not render %s email: %v", template, err)))
+	}
+	text, err := tr.T(template+"_body", params...)
+	if err != nil {
+		return gerr.E(500, gerr.Trace(fmt.Errorf("could not render %s email: %v", template, err)))
+	}
+
+	h.sendEmail(mail.Message{To: email, Subject: subject, Text: text})
+	return nil
+}
+
+// formatEmailTime writes times in emails, which readers may be anywhere
+func formatEmailTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 MST")
+}
+
+// sendEmail sends the email in the background, the response waits neither for
+// the mail server nor tells whether it accepted the email
+func (h *Handler) sendEmail(msg mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+		defer cancel()
+		if err := h.mailer.Send(ctx, msg); err != nil {
+			h.log.Error("could not send email:", err) //nolint:errcheck // Ignore unused function warning
+		}
+	}()
+}
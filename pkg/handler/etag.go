@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+// conversationETag is the strong entity tag of a conversation version
+func conversationETag(conversationID string, version int) string {
+	return fmt.Sprintf(`"%s-%d"`, conversationID, version)
+}
+
+// setConversationETag sends the version of a conversation read or written by the request
+func setConversationETag(c *gin.Context, conversationID string, version int) {
+	if version > 0 {
+		c.Header("ETag", conversationETag(conversationID, version))
+	}
+}
+
+// ifMatchVersions returns the conversation versions accepted by the If-Match
+// header of the request. It returns nil when the header is missing or "*",
+// which apply no precondition, and a 412 error when none of the listed tags
+// is a version of the conversation.
+func ifMatchVersions(c *gin.Context, conversationID string) ([]int64, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	prefix := `"` + conversationID + "-"
+	var versions []int64
+	for _, tag := range strings.Split(header, ",") {
+		// Weak tags never match If-Match
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, prefix) || !strings.HasSuffix(tag, `"`) {
+			continue
+		}
+		version, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(tag, prefix), `"`), 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	if len(versions) == 0 {
+		return nil, errPreconditionFailed(conversationID)
+	}
+	return versions, nil
+}
+
+// checkVersionConflict explains why an update guarded by versions changed no
+// row: the conversation is missing, or it was changed since the client read it
+func checkVersionConflict(q querier, userID, conversationID string, versions []int64) error {
+	if err := checkConversationOwner(q, userID, conversationID); err != nil {
+		return err
+	}
+	if versions != nil {
+		return errPreconditionFailed(conversationID)
+	}
+	return errConversationNotFound(conversationID)
+}
+
+func errPreconditionFailed(conversationID string) error {
+	return gerr.E(http.StatusPreconditionFailed, fmt.Sprintf("conversation with id %s was changed, reload it and try again", conversationID))
+}
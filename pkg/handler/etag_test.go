@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+	"github.com/lib/pq"
+)
+
+// versionedConversation answers the rename of conversation 1 of owner, at
+// version 3, when the versions in $4 are null or include it
+func versionedConversation(q dbtest.Query) (dbtest.Result, error) {
+	switch {
+	case q.Has("INSERT INTO audit_log"):
+		return dbtest.Result{RowsAffected: 1}, nil
+	case q.Has("UPDATE conversations c SET conversation_name = $1"):
+		res := dbtest.Result{Columns: []string{"previous", "name", "version"}}
+		var versions pq.Int64Array
+		if err := versions.Scan(q.Args[3]); err != nil {
+			return dbtest.Result{}, err
+		}
+		matches := q.Args[3] == nil
+		for _, version := range versions {
+			matches = matches || version == 3
+		}
+		if q.Args[1] == "1" && q.Args[2] == owner && matches {
+			res.Rows = [][]driver.Value{{"Plan", q.Args[0], int64(4)}}
+		}
+		return res, nil
+	case q.Has("SELECT EXISTS(SELECT 1 FROM conversations WHERE id = $1 AND user_id = $2"):
+		return dbtest.Result{Columns: []string{"exists"}, Rows: [][]driver.Value{{q.Args[0] == "1" && q.Args[1] == owner}}}, nil
+	}
+	return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+}
+
+func TestEditChat_IfMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		userID   string
+		ifMatch  string
+		wantCode int
+		wantETag string
+	}{
+		{name: "no header", userID: owner, wantCode: http.StatusOK, wantETag: `"1-4"`},
+		{name: "any version", userID: owner, ifMatch: "*", wantCode: http.StatusOK, wantETag: `"1-4"`},
+		{name: "current version", userID: owner, ifMatch: `"1-3"`, wantCode: http.StatusOK, wantETag: `"1-4"`},
+		{name: "current version in a list", userID: owner, ifMatch: `"1-2", "1-3"`, wantCode: http.StatusOK, wantETag: `"1-4"`},
+		{name: "stale version", userID: owner, ifMatch: `"1-2"`, wantCode: http.StatusPreconditionFailed},
+		{name: "weak tag", userID: owner, ifMatch: `W/"1-3"`, wantCode: http.StatusPreconditionFailed},
+		{name: "tag of another conversation", userID: owner, ifMatch: `"2-3"`, wantCode: http.StatusPreconditionFailed},
+		{name: "other user", userID: stranger, ifMatch: `"1-3"`, wantCode: http.StatusNotFound},
+		{name: "other user without header", userID: stranger, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler(t, versionedConversation)
+
+			req := newRequest(http.MethodPost, "/edit-chat", `{"conversation_id": "1", "new_name": "Renamed"}`)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := serve(h.EditChat, "/edit-chat", tt.userID, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if etag := w.Header().Get("ETag"); etag != tt.wantETag {
+				t.Errorf("ETag = %q, want %q", etag, tt.wantETag)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/Essen-Labs/bloom-be/pkg/export"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ExportChatRequest holds the query string parameters of the export endpoints
+type ExportChatRequest struct {
+	Format string `form:"format" json:"format" binding:"omitempty,oneof=md json html"`
+}
+
+// ExportChat downloads a conversation as Markdown, JSON or HTML
+// @Summary Export a conversation
+// @Description Streams a conversation as a Markdown transcript, a JSON document with metadata and usage, or a self-contained HTML page
+// @Tags export
+// @Produce text/markdown,application/json,text/html
+// @Param conversation_id path string true "Conversation ID"
+// @Param format query string false "Export format: md (default), json or html"
+// @Success 200 {file} file "The exported conversation"
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Conversation not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /export-chat/{conversation_id} [get]
+func (h *Handler) ExportChat(c *gin.Context) {
+	var req ExportChatRequest
+
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	if req.Format == "" {
+		req.Format = export.FormatMarkdown
+	}
+
+	conversationID := c.Param("conversation_id")
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	conversations, err := h.getExportConversations(c.Request.Context(), userID, []string{conversationID})
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+	if len(conversations) == 0 {
+		h.handleError(c, errConversationNotFound(conversationID))
+		return
+	}
+
+	c.Header("Content-Type", export.ContentType(req.Format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.FileName(conversations[0], req.Format)))
+	c.Status(http.StatusOK)
+
+	// The response is already streaming, a failure can only be logged and the body cut short
+	if err := h.writeConversationExport(c.Request.Context(), c.Writer, req.Format, conversations[0]); err != nil {
+		h.log.Error("could not export conversation:", err) //nolint:errcheck // Ignore unused function warning
+		c.Abort()
+	}
+}
+
+// ExportAllChat downloads all conversations of the user as a zip archive
+// @Summary Export all conversations
+// @Description Streams a zip archive with one file per conversation, in Markdown, JSON or HTML
+// @Tags export
+// @Produce application/zip
+// @Param format query string false "Export format of each file: md (default), json or html"
+// @Success 200 {file} file "Zip archive of the conversations"
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /export-all-chat [get]
+func (h *Handler) ExportAllChat(c *gin.Context) {
+	var req ExportChatRequest
+
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	if req.Format == "" {
+		req.Format = export.FormatMarkdown
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	conversations, err := h.getExportConversations(c.Request.Context(), userID, nil)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	h.streamExportArchive(c, req.Format, conversations)
+}
+
+// streamExportArchive sends conversations as a zip attachment
+func (h *Handler) streamExportArchive(c *gin.Context, format string, conversations []export.Conversation) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="bloom-export-%s.zip"`, time.Now().UTC().Format("20060102-150405")))
+	c.Status(http.StatusOK)
+
+	// The response is already streaming, a failure can only be logged and the body cut short
+	if err := h.writeExportArchive(c.Request.Context(), c.Writer, format, conversations); err != nil {
+		h.log.Error("could not export conversations:", err) //nolint:errcheck // Ignore unused function warning
+		c.Abort()
+	}
+}
+
+// getExportConversations loads the metadata of the user's conversations that are
+// not in the trash, restricted to ids when it is not nil
+func (h *Handler) getExportConversations(ctx context.Context, userID string, ids []string) ([]export.Conversation, error) {
+	args := []interface{}{userID}
+	filters := "c.user_id = $1 AND c.deleted_at IS NULL"
+	if ids != nil {
+		args = append(args, pq.Array(ids))
+		filters += " AND c.id::text = ANY($2)"
+	}
+	return h.queryExportConversations(ctx, filters, args...)
+}
+
+// queryExportConversations loads the metadata of the conversations matching
+// filters, a constant condition on c using args
+func (h *Handler) queryExportConversations(ctx context.Context, filters string, args ...interface{}) ([]export.Conversation, error) {
+	rows, err := h.db.QueryContext(ctx, `SELECT `+conversationColumns+` FROM conversations c WHERE `+filters+` ORDER BY c.id ASC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying conversations to export: %v", err)
+	}
+	defer rows.Close()
+
+	conversations := []export.Conversation{}
+	for rows.Next() {
+		conversation, err := scanConversation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning conversation to export: %v", err)
+		}
+		conversations = append(conversations, toExportConversation(conversation))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over conversations to export: %v", err)
+	}
+	return conversations, nil
+}
+
+// writeExportArchive writes a zip with one file per conversation, each file is
+// streamed from the database straight into the archive
+func (h *Handler) writeExportArchive(ctx context.Context, w io.Writer, format string, conversations []export.Conversation) error {
+	zw := zip.NewWriter(w)
+	for _, conversation := range conversations {
+		f, err := zw.Create(export.FileName(conversation, format))
+		if err != nil {
+			return fmt.Errorf("could not add conversation %s to archive: %v", conversation.ID, err)
+		}
+		if err := h.writeConversationExport(ctx, f, format, conversation); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// writeConversationExport streams the messages of a conversation into the export writer
+func (h *Handler) writeConversationExport(ctx context.Context, w io.Writer, format string, conversation export.Conversation) error {
+	ew, err := export.NewWriter(format, w)
+	if err != nil {
+		return err
+	}
+	if err := ew.Begin(conversation); err != nil {
+		return fmt.Errorf("could not write conversation %s: %v", conversation.ID, err)
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT m.id, m.role, m.content, m.content_encrypted, m.timestamp, m.model, m.prompt_tokens, m.completion_tokens, m.total_tokens,
+			coalesce(c.user_id, '')
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.conversation_id = $1
+		ORDER BY m.id ASC`, conversation.ID)
+	if err != nil {
+		return fmt.Errorf("could not query messages to export: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message export.Message
+		var role, content, timestamp, model sql.NullString
+		var promptTokens, completionTokens, totalTokens sql.NullInt64
+		var ownerID string
+		var encrypted bool
+		if err := rows.Scan(&message.ID, &role, &content, &encrypted, &timestamp, &model,
+			&promptTokens, &completionTokens, &totalTokens, &ownerID); err != nil {
+			return fmt.Errorf("error scanning message to export: %v", err)
+		}
+		message.Role = role.String
+		message.Content, err = h.cipher.Decrypt(ctx, ownerID, encryption.MessageContent(message.ID), content.String, encrypted)
+		if err != nil {
+			return fmt.Errorf("could not decrypt message %d: %v", message.ID, err)
+		}
+		message.CreatedAt = parseUnixTime(timestamp.String)
+		message.Model = model.String
+		if totalTokens.Valid {
+			message.Usage = &export.Usage{
+				PromptTokens:     int(promptTokens.Int64),
+				CompletionTokens: int(completionTokens.Int64),
+				TotalTokens:      int(totalTokens.Int64),
+			}
+		}
+
+		if err := ew.Message(message); err != nil {
+			return fmt.Errorf("could not write message %d: %v", message.ID, err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over messages to export: %v", err)
+	}
+	return ew.End()
+}
+
+func toExportConversation(c Conversation) export.Conversation {
+	return export.Conversation{
+		ID:        c.ID,
+		Name:      c.ConversationName,
+		Model:     c.Model,
+		CreatedAt: parseUnixTime(c.CreatedAt),
+		Pinned:    c.Pinned,
+		Archived:  c.Archived,
+		Tags:      c.Tags,
+	}
+}
+
+// parseUnixTime reads the unix seconds stored in the VARCHAR timestamp columns
+func parseUnixTime(s string) time.Time {
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// errFeedbackReportDate is returned when the period of the report does not parse
+var errFeedbackReportDate = gerr.E(http.StatusBadRequest, "from and to must be dates formatted as YYYY-MM-DD")
+
+// SetFeedbackRequest rates an assistant message, score is 1 for thumbs up and -1 for thumbs down
+type SetFeedbackRequest struct {
+	MessageID int      `json:"message_id" binding:"required"`
+	Score     int      `json:"score" binding:"required,oneof=-1 1"`
+	Reasons   []string `json:"reasons" binding:"omitempty,max=10,dive,oneof=accurate helpful well_written inaccurate unhelpful harmful too_long too_short off_topic other"`
+	Comment   string   `json:"comment" binding:"max=2000"`
+}
+
+// Feedback is the rating of an assistant message
+type Feedback struct {
+	MessageID int       `json:"messageId"`
+	Score     int       `json:"score"`
+	Reasons   []string  `json:"reasons"`
+	Comment   string    `json:"comment"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// FeedbackResponse represents the stored rating of a message
+type FeedbackResponse struct {
+	Success  bool     `json:"success"`
+	Message  string   `json:"message"`
+	Feedback Feedback `json:"feedback"`
+}
+
+// FeedbackReportRequest restricts the report to ratings updated between From and To, both inclusive
+type FeedbackReportRequest struct {
+	From time.Time `form:"from" time_format:"2006-01-02"`
+	To   time.Time `form:"to" time_format:"2006-01-02"`
+}
+
+// ModelFeedback aggregates the ratings of the messages of a model
+type ModelFeedback struct {
+	Model    string         `json:"model"`
+	Ratings  int            `json:"ratings"`
+	Up       int            `json:"up"`
+	Down     int            `json:"down"`
+	Score    float64        `json:"score"` // Average score, from -1 to 1
+	Comments int            `json:"comments"`
+	Reasons  map[string]int `json:"reasons"`
+}
+
+// FeedbackReportResponse represents the ratings per model
+type FeedbackReportResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Models  []ModelFeedback `json:"models"`
+}
+
+// SetFeedback rates an assistant message
+// @Summary Rate a message
+// @Description Sets the thumbs up or down of an assistant message with optional reason categories and comment, rating again replaces the previous feedback
+// @Tags feedback
+// @Accept json
+// @Produce json
+// @Param request body SetFeedbackRequest true "Rating"
+// @Success 200 {object} FeedbackResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Message not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /set-feedback [post]
+func (h *Handler) SetFeedback(c *gin.Context) {
+	var req SetFeedbackRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doSetFeedback(userID, req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doSetFeedback(userID string, req SetFeedbackRequest) (FeedbackResponse, error) {
+	reasons := req.Reasons
+	if reasons == nil {
+		reasons = []string{}
+	}
+	feedback := Feedback{
+		MessageID: req.MessageID,
+		Score:     req.Score,
+		Reasons:   reasons,
+		Comment:   strings.TrimSpace(req.Comment),
+	}
+
+	// Only the assistant messages of the user's own conversations can be rated
+	err := h.db.QueryRow(`
+		INSERT INTO message_feedback (message_id, user_id, score, reasons, comment)
+		SELECT m.id, c.user_id, $3, $4, $5
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.id = $1 AND c.user_id = $2 AND c.deleted_at IS NULL AND m.role = 'assistant'
+		ON CONFLICT (message_id) DO UPDATE
+		SET score = EXCLUDED.score, reasons = EXCLUDED.reasons, comment = EXCLUDED.comment, updated_at = now()
+		RETURNING updated_at`,
+		req.MessageID, userID, req.Score, pq.Array(feedback.Reasons), feedback.Comment).Scan(&feedback.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return FeedbackResponse{}, errMessageNotFound(req.MessageID)
+	}
+	if err != nil {
+		return FeedbackResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not set feedback: %v", err)))
+	}
+
+	return FeedbackResponse{
+		Success:  true,
+		Message:  fmt.Sprintf("Feedback on message %d saved", req.MessageID),
+		Feedback: feedback,
+	}, nil
+}
+
+// ClearFeedback removes the rating of a message
+// @Summary Clear a message rating
+// @Tags feedback
+// @Produce json
+// @Param message_id path int true "Message ID"
+// @Success 200 {object} organizeChatResponse
+// @Failure 404 {object} ErrorResponse "Feedback not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /clear-feedback/{message_id} [delete]
+func (h *Handler) ClearFeedback(c *gin.Context) {
+	messageID := c.Param("message_id")
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	result, err := h.db.Exec(`
+		DELETE FROM message_feedback WHERE message_id::text = $1 AND user_id = $2`, messageID, userID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(fmt.Errorf("could not clear feedback: %v", err))))
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(fmt.Errorf("could not check rows affected: %v", err))))
+		return
+	}
+	if rowsAffected == 0 {
+		h.handleError(c, gerr.E(http.StatusNotFound, fmt.Sprintf("feedback on message %s not found", messageID)))
+		return
+	}
+
+	c.JSON(http.StatusOK, organizeChatResponse{
+		Success: true,
+		Message: fmt.Sprintf("Feedback on message %s cleared", messageID),
+	})
+}
+
+// GetFeedbackReport aggregates the ratings of all users per model
+// @Summary Feedback report per model
+// @Description Counts thumbs up and down, average score, comments and reason categories of the rated messages of each model
+// @Tags feedback
+// @Produce json
+// @Param from query string false "First day, YYYY-MM-DD"
+// @Param to query string false "Last day, YYYY-MM-DD"
+// @Success 200 {object} FeedbackReportResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /get-feedback-report [get]
+func (h *Handler) GetFeedbackReport(c *gin.Context) {
+	var req FeedbackReportRequest
+
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		var parseErr *time.ParseError
+		if errors.As(err, &parseErr) {
+			err = errFeedbackReportDate
+		}
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.doGetFeedbackReport(req)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetFeedbackReport(req FeedbackReportRequest) (FeedbackReportResponse, error) {
+	var from, to interface{}
+	if !req.From.IsZero() {
+		from = req.From
+	}
+	if !req.To.IsZero() {
+		to = req.To.AddDate(0, 0, 1)
+	}
+
+	// Messages stored before models were recorded are grouped under an empty model
+	const rated = `
+		SELECT coalesce(m.model, '') AS model, f.score, f.reasons, f.comment
+		FROM message_feedback f
+		JOIN messages m ON m.id = f.message_id
+		WHERE ($1::timestamptz IS NULL OR f.updated_at >= $1)
+			AND ($2::timestamptz IS NULL OR f.updated_at < $2)`
+
+	rows, err := h.db.Query(`
+		SELECT r.model, COUNT(*),
+			COUNT(*) FILTER (WHERE r.score > 0),
+			COUNT(*) FILTER (WHERE r.score < 0),
+			AVG(r.score)::float8,
+			COUNT(*) FILTER (WHERE r.comment <> '')
+		FROM (`+rated+`) r
+		GROUP BY r.model
+		ORDER BY COUNT(*) DESC, r.model ASC`, from, to)
+	if err != nil {
+		return FeedbackReportResponse{}, fmt.Errorf("error querying feedback report: %v", err)
+	}
+	defer rows.Close()
+
+	models := []ModelFeedback{}
+	byModel := map[string]int{}
+	for rows.Next() {
+		m := ModelFeedback{Reasons: map[string]int{}}
+		if err := rows.Scan(&m.Model, &m.Ratings, &m.Up, &m.Down, &m.Score, &m.Comments); err != nil {
+			return FeedbackReportResponse{}, fmt.Errorf("error scanning feedback report: %v", err)
+		}
+		byModel[m.Model] = len(models)
+		models = append(models, m)
+	}
+	if err := rows.Err(); err != nil {
+		return FeedbackReportResponse{}, fmt.Errorf("error iterating over feedback report: %v", err)
+	}
+
+	reasonRows, err := h.db.Query(`
+		SELECT r.model, reason, COUNT(*)
+		FROM (`+rated+`) r, unnest(r.reasons) AS reason
+		GROUP BY r.model, reason`, from, to)
+	if err != nil {
+		return FeedbackReportResponse{}, fmt.Errorf("error querying feedback reasons: %v", err)
+	}
+	defer reasonRows.Close()
+
+	for reasonRows.Next() {
+		var model, reason string
+		var count int
+		if err := reasonRows.Scan(&model, &reason, &count); err != nil {
+			return FeedbackReportResponse{}, fmt.Errorf("error scanning feedback reason: %v", err)
+		}
+		if idx, ok := byModel[model]; ok {
+			models[idx].Reasons[reason] = count
+		}
+	}
+	if err := reasonRows.Err(); err != nil {
+		return FeedbackReportResponse{}, fmt.Errorf("error iterating over feedback reasons: %v", err)
+	}
+
+	return FeedbackReportResponse{
+		Success: len(models) > 0,
+		Message: fmt.Sprintf("Feedback found for %d models", len(models)),
+		Models:  models,
+	}, nil
+}
+
+func errMessageNotFound(messageID int) error {
+	return gerr.E(http.StatusNotFound, fmt.Sprintf("message with id %d not found", messageID))
+}
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+	"github.com/lib/pq"
+)
+
+// ratedMessage is a row of the fake messages table with its feedback
+type ratedMessage struct {
+	userID   string
+	role     string
+	model    string
+	score    int64
+	reasons  []string
+	comment  string
+	feedback bool
+}
+
+// feedbackStore is a fake database of messages and their feedback, it records
+// the period of the last report
+type feedbackStore struct {
+	mu       sync.Mutex
+	messages map[int64]*ratedMessage
+	from, to driver.Value
+}
+
+func (s *feedbackStore) handle(q dbtest.Query) (dbtest.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case q.Has("INSERT INTO message_feedback"):
+		res := dbtest.Result{Columns: []string{"updated_at"}}
+		m := s.messages[q.Args[0].(int64)]
+		if m == nil || m.userID != q.Args[1] || m.role != "assistant" {
+			return res, nil
+		}
+		var reasons pq.StringArray
+		if err := reasons.Scan(q.Args[3]); err != nil {
+			return dbtest.Result{}, err
+		}
+		m.feedback, m.score, m.reasons, m.comment = true, q.Args[2].(int64), reasons, q.Args[4].(string)
+		res.Rows = [][]driver.Value{{time.Now()}}
+		return res, nil
+	case q.Has("DELETE FROM message_feedback"):
+		id, _ := strconv.ParseInt(q.Args[0].(string), 10, 64)
+		m := s.messages[id]
+		if m == nil || !m.feedback || m.userID != q.Args[1] {
+			return dbtest.Result{}, nil
+		}
+		m.feedback = false
+		return dbtest.Result{RowsAffected: 1}, nil
+	case q.Has("GROUP BY r.model, reason"):
+		counts := map[[2]string]int64{}
+		for _, m := range s.messages {
+			for _, reason := range m.reasons {
+				if m.feedback {
+					counts[[2]string{m.model, reason}]++
+				}
+			}
+		}
+		res := dbtest.Result{Columns: []string{"model", "reason", "count"}}
+		for key, count := range counts {
+			res.Rows = append(res.Rows, []driver.Value{key[0], key[1], count})
+		}
+		return res, nil
+	case q.Has("GROUP BY r.model"):
+		s.from, s.to = q.Args[0], q.Args[1]
+		type total struct{ ratings, up, down, sum, comments int64 }
+		totals := map[string]*total{}
+		for _, m := range s.messages {
+			if !m.feedback {
+				continue
+			}
+			if totals[m.model] == nil {
+				totals[m.model] = &total{}
+			}
+			t := totals[m.model]
+			t.ratings++
+			t.sum += m.score
+			if m.score > 0 {
+				t.up++
+			} else {
+				t.down++
+			}
+			if m.comment != "" {
+				t.comments++
+			}
+		}
+		var models []string
+		for model := range totals {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		res := dbtest.Result{Columns: []string{"model", "ratings", "up", "down", "score", "comments"}}
+		for _, model := range models {
+			t := totals[model]
+			res.Rows = append(res.Rows, []driver.Value{model, t.ratings, t.up, t.down, float64(t.sum) / float64(t.ratings), t.comments})
+		}
+		return res, nil
+	}
+	return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+}
+
+func TestFeedback(t *testing.T) {
+	store := &feedbackStore{messages: map[int64]*ratedMessage{
+		10: {userID: owner, role: "assistant", model: "gpt"},
+		11: {userID: owner, role: "user", model: "gpt"},
+		12: {userID: owner, role: "assistant", model: "llama"},
+		20: {userID: stranger, role: "assistant", model: "gpt"},
+	}}
+	h, _ := newTestHandler(t, store.handle)
+
+	setFeedback := func(userID, body string) int {
+		t.Helper()
+		return serve(h.SetFeedback, "/set-feedback", userID, newRequest(http.MethodPost, "/set-feedback", body)).Code
+	}
+	clearFeedback := func(userID, messageID string) int {
+		t.Helper()
+		path := "/clear-feedback/" + messageID
+		return serve(h.ClearFeedback, "/clear-feedback/:message_id", userID, newRequest(http.MethodDelete, path, "")).Code
+	}
+
+	t.Run("set feedback", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			userID   string
+			body     string
+			wantCode int
+		}{
+			{name: "thumbs up", userID: owner, body: `{"message_id": 10, "score": 1, "reasons": ["helpful"], "comment": " clear "}`, wantCode: http.StatusOK},
+			{name: "thumbs down", userID: owner, body: `{"message_id": 12, "score": -1, "reasons": ["too_long", "off_topic"]}`, wantCode: http.StatusOK},
+			{name: "message of the user", userID: owner, body: `{"message_id": 11, "score": 1}`, wantCode: http.StatusNotFound},
+			{name: "message of another user", userID: owner, body: `{"message_id": 20, "score": 1}`, wantCode: http.StatusNotFound},
+			{name: "score out of range", userID: owner, body: `{"message_id": 10, "score": 2}`, wantCode: http.StatusBadRequest},
+			{name: "unknown reason", userID: owner, body: `{"message_id": 10, "score": 1, "reasons": ["funny"]}`, wantCode: http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if code := setFeedback(tt.userID, tt.body); code != tt.wantCode {
+					t.Errorf("status = %d, want %d", code, tt.wantCode)
+				}
+			})
+		}
+		if m := store.messages[10]; m.score != 1 || fmt.Sprint(m.reasons) != "[helpful]" || m.comment != "clear" {
+			t.Errorf("feedback on message 10 = %+v, want thumbs up, helpful, clear", m)
+		}
+		if store.messages[11].feedback || store.messages[20].feedback {
+			t.Error("feedback stored on a message that cannot be rated")
+		}
+	})
+
+	t.Run("feedback report", func(t *testing.T) {
+		w := serve(h.GetFeedbackReport, "/get-feedback-report", owner,
+			newRequest(http.MethodGet, "/get-feedback-report?from=2026-01-01&to=2026-01-31", ""))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		var res FeedbackReportResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		want := []ModelFeedback{
+			{Model: "gpt", Ratings: 1, Up: 1, Score: 1, Comments: 1, Reasons: map[string]int{"helpful": 1}},
+			{Model: "llama", Ratings: 1, Down: 1, Score: -1, Reasons: map[string]int{"too_long": 1, "off_topic": 1}},
+		}
+		if fmt.Sprint(res.Models) != fmt.Sprint(want) {
+			t.Errorf("models = %+v, want %+v", res.Models, want)
+		}
+		// The end date is inclusive, the period ends the next day
+		from, to := store.from.(time.Time), store.to.(time.Time)
+		if !from.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("period = %v to %v, want 2026-01-01 to 2026-02-01", from, to)
+		}
+
+		w = serve(h.GetFeedbackReport, "/get-feedback-report", owner,
+			newRequest(http.MethodGet, "/get-feedback-report?from=January", ""))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status with invalid date = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("clear feedback", func(t *testing.T) {
+		if code := clearFeedback(stranger, "10"); code != http.StatusNotFound {
+			t.Errorf("status as another user = %d, want %d", code, http.StatusNotFound)
+		}
+		if code := clearFeedback(owner, "10"); code != http.StatusOK {
+			t.Errorf("status = %d, want %d", code, http.StatusOK)
+		}
+		if store.messages[10].feedback {
+			t.Error("feedback on message 10 not cleared")
+		}
+		if code := clearFeedback(owner, "10"); code != http.StatusNotFound {
+			t.Errorf("status once cleared = %d, want %d", code, http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,360 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Folder is a user defined group of conversations
+type Folder struct {
+	ID                int       `json:"id"`
+	Name              string    `json:"name"`
+	ConversationCount int       `json:"conversationCount"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+// GetFolderListResponse represents the folders of a user
+type GetFolderListResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message"`
+	Folders []Folder `json:"folders"`
+}
+
+// FolderResponse represents a created or updated folder
+type FolderResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Folder  Folder `json:"folder"`
+}
+
+// CreateFolderRequest creates a folder
+type CreateFolderRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+}
+
+// EditFolderRequest renames a folder
+type EditFolderRequest struct {
+	FolderID int    `json:"folder_id" binding:"required"`
+	NewName  string `json:"new_name" binding:"required,max=255"`
+}
+
+// MoveChatRequest moves a conversation into a folder, or out of any folder when FolderID is null
+type MoveChatRequest struct {
+	ConversationID string `json:"conversation_id" binding:"required"`
+	FolderID       *int   `json:"folder_id"`
+}
+
+// GetFolderList lists the user's folders
+// @Summary List folders
+// @Description Lists the user's folders with the number of conversations in each
+// @Tags organize
+// @Produce json
+// @Success 200 {object} GetFolderListResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /get-folder-list [get]
+func (h *Handler) GetFolderList(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doGetFolderList(userID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetFolderList(userID string) (GetFolderListResponse, error) {
+	rows, err := h.reader().Query(`
+		SELECT f.id, f.name, f.created_at,
+			(SELECT COUNT(*) FROM conversations c WHERE c.folder_id = f.id AND c.deleted_at IS NULL)
+		FROM folders f
+		WHERE f.user_id = $1
+		ORDER BY f.name ASC`, userID)
+	if err != nil {
+		return GetFolderListResponse{}, fmt.Errorf("error querying folders: %v", err)
+	}
+	defer rows.Close()
+
+	folders := []Folder{}
+	for rows.Next() {
+		var folder Folder
+		if err := rows.Scan(&folder.ID, &folder.Name, &folder.CreatedAt, &folder.ConversationCount); err != nil {
+			return GetFolderListResponse{}, fmt.Errorf("error scanning folder: %v", err)
+		}
+		folders = append(folders, folder)
+	}
+
+	if err := rows.Err(); err != nil {
+		return GetFolderListResponse{}, fmt.Errorf("error iterating over folders: %v", err)
+	}
+
+	return GetFolderListResponse{
+		Success: len(folders) > 0,
+		Message: fmt.Sprintf("Found %d folders", len(folders)),
+		Folders: folders,
+	}, nil
+}
+
+// CreateFolder creates a folder
+// @Summary Create a folder
+// @Tags organize
+// @Accept json
+// @Produce json
+// @Param request body CreateFolderRequest true "Folder name"
+// @Success 200 {object} FolderResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 409 {object} ErrorResponse "Folder already exists"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /create-folder [post]
+func (h *Handler) CreateFolder(c *gin.Context) {
+	var req CreateFolderRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doCreateFolder(userID, req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doCreateFolder(userID string, req CreateFolderRequest) (FolderResponse, error) {
+	folder := Folder{Name: strings.TrimSpace(req.Name)}
+	err := h.db.QueryRow(`
+		INSERT INTO folders (user_id, name)
+		VALUES ($1, $2)
+		RETURNING id, created_at`, userID, folder.Name).Scan(&folder.ID, &folder.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return FolderResponse{}, gerr.E(http.StatusConflict, fmt.Sprintf("folder %s already exists", folder.Name))
+		}
+		return FolderResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not create folder: %v", err)))
+	}
+
+	return FolderResponse{
+		Success: true,
+		Message: "Folder created",
+		Folder:  folder,
+	}, nil
+}
+
+// EditFolder renames a folder
+// @Summary Rename a folder
+// @Tags organize
+// @Accept json
+// @Produce json
+// @Param request body EditFolderRequest true "Folder and its new name"
+// @Success 200 {object} FolderResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Folder not found"
+// @Failure 409 {object} ErrorResponse "Folder already exists"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /edit-folder [post]
+func (h *Handler) EditFolder(c *gin.Context) {
+	var req EditFolderRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doEditFolder(userID, req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doEditFolder(userID string, req EditFolderRequest) (FolderResponse, error) {
+	folder := Folder{ID: req.FolderID, Name: strings.TrimSpace(req.NewName)}
+	err := h.db.QueryRow(`
+		UPDATE folders SET name = $1
+		WHERE id = $2 AND user_id = $3
+		RETURNING created_at`, folder.Name, folder.ID, userID).Scan(&folder.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return FolderResponse{}, gerr.E(http.StatusConflict, fmt.Sprintf("folder %s already exists", folder.Name))
+		}
+		if err == sql.ErrNoRows {
+			return FolderResponse{}, errFolderNotFound(folder.ID)
+		}
+		return FolderResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not rename folder: %v", err)))
+	}
+
+	return FolderResponse{
+		Success: true,
+		Message: "Folder renamed",
+		Folder:  folder,
+	}, nil
+}
+
+// DeleteFolder deletes a folder, its conversations are kept outside of any folder
+// @Summary Delete a folder
+// @Tags organize
+// @Produce json
+// @Param folder_id path int true "Folder ID"
+// @Success 200 {object} organizeChatResponse
+// @Failure 404 {object} ErrorResponse "Folder not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /delete-folder/{folder_id} [delete]
+func (h *Handler) DeleteFolder(c *gin.Context) {
+	folderID := c.Param("folder_id")
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doDeleteFolder(h.actor(c), userID, folderID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doDeleteFolder(actor audit.Actor, userID, folderID string) (organizeChatResponse, error) {
+	// Conversations are detached by the ON DELETE SET NULL of conversations.folder_id
+	var name string
+	err := h.db.QueryRow(`DELETE FROM folders WHERE id = $1 AND user_id = $2 RETURNING name`, folderID, userID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return organizeChatResponse{}, gerr.E(http.StatusNotFound, fmt.Sprintf("folder with id %s not found", folderID))
+	}
+	if err != nil {
+		return organizeChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not delete folder: %v", err)))
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionFolderDelete,
+		TargetType: audit.TargetFolder,
+		TargetID:   folderID,
+		Before:     map[string]string{"name": name},
+	})
+
+	return organizeChatResponse{
+		Success: true,
+		Message: fmt.Sprintf("Folder with ID %s deleted", folderID),
+	}, nil
+}
+
+// MoveChat moves a conversation into a folder
+// @Summary Move a conversation to a folder
+// @Description Moves a conversation into one of the user's folders, a null folder_id removes it from its folder
+// @Tags organize
+// @Accept json
+// @Produce json
+// @Param request body MoveChatRequest true "Conversation and target folder"
+// @Param If-Match header string false "ETag of the conversation, the update fails with 412 if it changed"
+// @Success 200 {object} organizeChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Conversation or folder not found"
+// @Failure 412 {object} ErrorResponse "Conversation changed since it was read"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /move-chat [post]
+func (h *Handler) MoveChat(c *gin.Context) {
+	var req MoveChatRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	versions, err := ifMatchVersions(c, req.ConversationID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.doMoveChat(h.db, userID, req, versions)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	setConversationETag(c, req.ConversationID, res.Version)
+	c.JSON(http.StatusOK, res)
+}
+
+// doMoveChat moves a conversation, when versions is not nil the conversation
+// must still be at one of them
+func (h *Handler) doMoveChat(q querier, userID string, req MoveChatRequest, versions []int64) (organizeChatResponse, error) {
+	if req.FolderID != nil {
+		if err := checkFolderOwner(q, userID, *req.FolderID); err != nil {
+			return organizeChatResponse{}, err
+		}
+	}
+
+	var version int
+	err := q.QueryRow(`
+		UPDATE conversations SET folder_id = $1
+		WHERE id = $2 AND user_id = $3 AND deleted_at IS NULL AND ($4::bigint[] IS NULL OR version = ANY($4))
+		RETURNING version`, req.FolderID, req.ConversationID, userID, pq.Array(versions)).Scan(&version)
+	if err == sql.ErrNoRows {
+		return organizeChatResponse{}, checkVersionConflict(q, userID, req.ConversationID, versions)
+	}
+	if err != nil {
+		return organizeChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not move conversation: %v", err)))
+	}
+
+	message := fmt.Sprintf("Conversation with ID %s removed from its folder", req.ConversationID)
+	if req.FolderID != nil {
+		message = fmt.Sprintf("Conversation with ID %s moved to folder %d", req.ConversationID, *req.FolderID)
+	}
+	return organizeChatResponse{
+		Success: true,
+		Message: message,
+		Version: version,
+	}, nil
+}
+
+func checkFolderOwner(q querier, userID string, folderID int) error {
+	var exists bool
+	err := q.QueryRow(`SELECT EXISTS(SELECT 1 FROM folders WHERE id = $1 AND user_id = $2)`, folderID, userID).Scan(&exists)
+	if err != nil {
+		return gerr.E(500, gerr.Trace(fmt.Errorf("could not check folder owner: %v", err)))
+	}
+	if !exists {
+		return errFolderNotFound(folderID)
+	}
+	return nil
+}
+
+func errFolderNotFound(folderID int) error {
+	return gerr.E(http.StatusNotFound, fmt.Sprintf("folder with id %d not found", folderID))
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/Essen-Labs/bloom-be/pkg/importer"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+// forkSuffix is appended to the name of the original conversation
+const forkSuffix = " (copy)"
+
+// ForkChatRequest copies a conversation, up to and including MessageID when set
+type ForkChatRequest struct {
+	ConversationID string `json:"conversation_id" binding:"required"`
+	MessageID      int    `json:"message_id" binding:"omitempty,min=1"`
+}
+
+// ForkChatResponse represents the conversation created by a fork
+type ForkChatResponse struct {
+	Success          bool   `json:"success"`
+	Message          string `json:"message"`
+	ConversationID   string `json:"conversation_id"`
+	ConversationName string `json:"conversation_name"`
+	Messages         int    `json:"messages"`
+}
+
+// ForkChat duplicates a conversation of the user
+// @Summary Fork a conversation
+// @Description Copies a conversation into a new one with the same model and the name suffixed with "(copy)". When message_id is set, only the messages up to it are copied, system prompts are always kept. The original conversation is left untouched
+// @Tags chat
+// @Accept json
+// @Produce json
+// @Param request body ForkChatRequest true "Conversation and optional last message"
+// @Success 200 {object} ForkChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Conversation or message not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /fork-chat [post]
+func (h *Handler) ForkChat(c *gin.Context) {
+	var req ForkChatRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doForkChat(c.Request.Context(), userID, req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doForkChat(ctx context.Context, userID string, req ForkChatRequest) (ForkChatResponse, error) {
+	conversation := importer.Conversation{
+		Source:    "fork",
+		CreatedAt: time.Now(),
+	}
+	err := h.db.QueryRowContext(ctx, `
+		SELECT coalesce(conversation_name, ''), coalesce(model, '')
+		FROM conversations
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`, req.ConversationID, userID).
+		Scan(&conversation.Title, &conversation.Model)
+	if err == sql.ErrNoRows {
+		return ForkChatResponse{}, errConversationNotFound(req.ConversationID)
+	}
+	if err != nil {
+		return ForkChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not query conversation: %v", err)))
+	}
+	if conversation.Title == "" {
+		conversation.Title = "New Conversation"
+	}
+	conversation.Title += forkSuffix
+
+	// System prompts configure the whole conversation, they are copied even when
+	// they were set after the message the fork stops at
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id, coalesce(role, ''), coalesce(content, ''), content_encrypted, coalesce(timestamp, ''), coalesce(model, '')
+		FROM messages
+		WHERE conversation_id = $1 AND ($2::int = 0 OR id <= $2 OR role = 'system')
+		ORDER BY id ASC`, req.ConversationID, req.MessageID)
+	if err != nil {
+		return ForkChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not query messages: %v", err)))
+	}
+	defer rows.Close()
+
+	found := req.MessageID == 0
+	for rows.Next() {
+		var id int
+		var m importer.Message
+		var timestamp string
+		var encrypted bool
+		if err := rows.Scan(&id, &m.Role, &m.Content, &encrypted, &timestamp, &m.Model); err != nil {
+			return ForkChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("error scanning message: %v", err)))
+		}
+		m.Content, err = h.cipher.Decrypt(ctx, userID, encryption.MessageContent(id), m.Content, encrypted)
+		if err != nil {
+			return ForkChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not decrypt message: %v", err)))
+		}
+		m.CreatedAt = parseUnixTime(timestamp)
+		conversation.Messages = append(conversation.Messages, m)
+		found = found || id == req.MessageID
+	}
+	if err := rows.Err(); err != nil {
+		return ForkChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("error iterating over messages: %v", err)))
+	}
+	if !found {
+		return ForkChatResponse{}, errMessageNotFound(req.MessageID)
+	}
+
+	imported, err := h.ImportConversations(ctx, userID, importer.Result{Conversations: []importer.Conversation{conversation}})
+	if err != nil {
+		return ForkChatResponse{}, gerr.E(500, gerr.Trace(err))
+	}
+
+	conversationID := imported.Imported[0].ConversationID
+	return ForkChatResponse{
+		Success:          true,
+		Message:          fmt.Sprintf("Conversation with ID %s copied to conversation %s", req.ConversationID, conversationID),
+		ConversationID:   conversationID,
+		ConversationName: conversation.Title,
+		Messages:         len(conversation.Messages),
+	}, nil
+}
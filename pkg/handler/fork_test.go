@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+)
+
+// forkedMessage is a row of the fake messages table of the fork tests
+type forkedMessage struct {
+	id             int64
+	conversationID string
+	role           string
+	content        string
+}
+
+// forkedConversation is a row of the fake conversations table of the fork tests
+type forkedConversation struct {
+	userID string
+	name   string
+	model  string
+}
+
+// forkStore is a fake database of conversations and messages, in id order
+type forkStore struct {
+	mu            sync.Mutex
+	conversations map[string]forkedConversation
+	messages      []forkedMessage
+}
+
+func (s *forkStore) handle(q dbtest.Query) (dbtest.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case q.Has("SELECT coalesce(conversation_name, ''), coalesce(model, '') FROM conversations"):
+		res := dbtest.Result{Columns: []string{"name", "model"}}
+		if c, ok := s.conversations[q.Args[0].(string)]; ok && c.userID == q.Args[1] {
+			res.Rows = [][]driver.Value{{c.name, c.model}}
+		}
+		return res, nil
+	case q.Has("FROM messages", "($2::int = 0 OR id <= $2 OR role = 'system')"):
+		res := dbtest.Result{Columns: []string{"id", "role", "content", "content_encrypted", "timestamp", "model"}}
+		last := q.Args[1].(int64)
+		for _, m := range s.messages {
+			if m.conversationID == q.Args[0] && (last == 0 || m.id <= last || m.role == "system") {
+				res.Rows = append(res.Rows, []driver.Value{m.id, m.role, m.content, false, "1700000000", ""})
+			}
+		}
+		return res, nil
+	case q.Has("INSERT INTO users"):
+		return dbtest.Result{RowsAffected: 1}, nil
+	case q.Has("INSERT INTO conversations"):
+		id := fmt.Sprint(len(s.conversations) + 1)
+		s.conversations[id] = forkedConversation{userID: q.Args[2].(string), name: q.Args[1].(string), model: q.Args[0].(string)}
+		return dbtest.Result{Columns: []string{"id"}, Rows: [][]driver.Value{{id}}}, nil
+	case q.Has("SELECT nextval('messages_id_seq')"):
+		return dbtest.Result{Columns: []string{"nextval"}, Rows: [][]driver.Value{{int64(len(s.messages) + 1)}}}, nil
+	case q.Has("INSERT INTO messages"):
+		s.messages = append(s.messages, forkedMessage{
+			id: q.Args[0].(int64), conversationID: q.Args[1].(string), role: q.Args[2].(string), content: q.Args[3].(string),
+		})
+		return dbtest.Result{RowsAffected: 1}, nil
+	}
+	return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+}
+
+// contents returns the role and content of the messages of a conversation
+func (s *forkStore) contents(conversationID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var contents []string
+	for _, m := range s.messages {
+		if m.conversationID == conversationID {
+			contents = append(contents, m.role+": "+m.content)
+		}
+	}
+	return contents
+}
+
+func TestForkChat(t *testing.T) {
+	original := []string{"system: be brief", "user: hi", "assistant: hello", "system: answer in french", "user: more"}
+
+	tests := []struct {
+		name         string
+		userID       string
+		body         string
+		wantCode     int
+		wantName     string
+		wantMessages []string
+	}{
+		{
+			name:         "whole conversation",
+			userID:       owner,
+			body:         `{"conversation_id": "1"}`,
+			wantCode:     http.StatusOK,
+			wantName:     "Plan (copy)",
+			wantMessages: original,
+		},
+		{
+			name:         "up to a message, system prompts kept",
+			userID:       owner,
+			body:         `{"conversation_id": "1", "message_id": 2}`,
+			wantCode:     http.StatusOK,
+			wantName:     "Plan (copy)",
+			wantMessages: []string{"system: be brief", "user: hi", "system: answer in french"},
+		},
+		{
+			name:     "message of another conversation",
+			userID:   owner,
+			body:     `{"conversation_id": "1", "message_id": 6}`,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "conversation of another user",
+			userID:   stranger,
+			body:     `{"conversation_id": "1"}`,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "without conversation",
+			userID:   owner,
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &forkStore{
+				conversations: map[string]forkedConversation{
+					"1": {userID: owner, name: "Plan", model: "gpt"},
+					"2": {userID: owner, name: "Other", model: "gpt"},
+				},
+			}
+			for idx, content := range append(original, "user: elsewhere") {
+				conversationID := "1"
+				if idx == len(original) {
+					conversationID = "2"
+				}
+				role, text, _ := strings.Cut(content, ": ")
+				store.messages = append(store.messages, forkedMessage{id: int64(idx + 1), conversationID: conversationID, role: role, content: text})
+			}
+			h, _ := newTestHandler(t, store.handle)
+
+			w := serve(h.ForkChat, "/fork-chat", tt.userID, newRequest(http.MethodPost, "/fork-chat", tt.body))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if got := store.contents("1"); fmt.Sprint(got) != fmt.Sprint(original) {
+				t.Errorf("original messages = %q, want them untouched", got)
+			}
+			if tt.wantCode != http.StatusOK {
+				if len(store.conversations) != 2 {
+					t.Errorf("conversations = %d, want no fork", len(store.conversations))
+				}
+				return
+			}
+
+			var res ForkChatResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			fork := store.conversations[res.ConversationID]
+			if res.ConversationID != "3" || fork.userID != tt.userID || fork.name != tt.wantName || fork.model != "gpt" {
+				t.Errorf("fork %s = %+v, want conversation 3 %q of %s with model gpt", res.ConversationID, fork, tt.wantName, tt.userID)
+			}
+			if got := store.contents(res.ConversationID); fmt.Sprint(got) != fmt.Sprint(tt.wantMessages) {
+				t.Errorf("forked messages = %q, want %q", got, tt.wantMessages)
+			}
+			if res.Messages != len(tt.wantMessages) {
+				t.Errorf("messages = %d, want %d", res.Messages, len(tt.wantMessages))
+			}
+		})
+	}
+}
@@ -1,13 +1,25 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
+	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/authz"
 	"github.com/Essen-Labs/bloom-be/pkg/config"
 	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/embedding"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/Essen-Labs/bloom-be/pkg/mail"
+	"github.com/Essen-Labs/bloom-be/pkg/oidc"
+	"github.com/Essen-Labs/bloom-be/pkg/ratelimit"
 	"github.com/Essen-Labs/bloom-be/pkg/util"
 	"github.com/Essen-Labs/bloom-be/translation"
 	"github.com/dwarvesf/gerr"
@@ -15,24 +27,95 @@ import (
 	"github.com/go-playground/validator/v10"
 )
 
+// readyzTimeout bounds the database pings of the readiness check
+const readyzTimeout = 2 * time.Second
+
 // Handler for app
 type Handler struct {
 	log        gerr.Log
 	cfg        config.Config
 	translator translation.Helper
 	db         *sql.DB
+	replica    *sql.DB
+	embeddings *embedding.Index
+	cipher     *encryption.Cipher
+	auditLog   *audit.Log
+	tokens     *auth.Tokens
+	authorizer *authz.Authorizer
+	// limiter enforces the rate limits of routes and API keys
+	limiter ratelimit.Limiter
+	// mailer sends the verification and password reset emails
+	mailer mail.Mailer
+	// sso is the OpenID Connect provider, nil when single sign-on is not configured
+	sso *oidc.Provider
+	// embedding tracks the messages being embedded in the background
+	embedding sync.WaitGroup
+	// dataExports signals the build-data-exports job that an export was requested
+	dataExports chan struct{}
 }
 
 // NewHandler make handler
-func NewHandler(cfg config.Config, l gerr.Log, th translation.Helper, db *sql.DB) *Handler {
+func NewHandler(cfg config.Config, l gerr.Log, th translation.Helper, db *sql.DB, embeddings *embedding.Index, cipher *encryption.Cipher) *Handler {
 	return &Handler{
-		log:        l,
-		cfg:        cfg,
-		translator: th,
-		db:         db,
+		log:         l,
+		cfg:         cfg,
+		translator:  th,
+		db:          db,
+		embeddings:  embeddings,
+		cipher:      cipher,
+		auditLog:    audit.NewLog(db),
+		authorizer:  authz.New(authz.NewSQLLoader(db), authz.OwnerPolicy),
+		limiter:     ratelimit.NewMemory(),
+		mailer:      mail.NewOutbox(cfg.MailFrom, "", os.Stderr),
+		dataExports: make(chan struct{}, 1),
 	}
 }
 
+// WithReplica routes list and search queries to a read replica. They may then
+// lag slightly behind the writes made on the primary.
+func (h *Handler) WithReplica(replica *sql.DB) *Handler {
+	h.replica = replica
+	return h
+}
+
+// WithTokens sets the access tokens issued at login and checked by Authenticate
+func (h *Handler) WithTokens(tokens *auth.Tokens) *Handler {
+	h.tokens = tokens
+	return h
+}
+
+// WithLimiter sets where rate limits are counted, in memory by default
+func (h *Handler) WithLimiter(limiter ratelimit.Limiter) *Handler {
+	h.limiter = limiter
+	return h
+}
+
+// WithMailer sets how emails are sent, logged by default
+func (h *Handler) WithMailer(mailer mail.Mailer) *Handler {
+	h.mailer = mailer
+	return h
+}
+
+// WithSSO sets the OpenID Connect provider users may sign in with
+func (h *Handler) WithSSO(provider *oidc.Provider) *Handler {
+	h.sso = provider
+	return h
+}
+
+// Wait blocks until the messages being embedded in the background are stored,
+// commands exiting after an import call it so the import is searchable
+func (h *Handler) Wait() {
+	h.embedding.Wait()
+}
+
+// reader returns the pool serving list and search queries
+func (h *Handler) reader() *sql.DB {
+	if h.replica != nil {
+		return h.replica
+	}
+	return h.db
+}
+
 func (h *Handler) handleError(c *gin.Context, err error) {
 	if err == nil {
 		return
@@ -85,6 +168,11 @@ func (h *Handler) handleError(c *gin.Context, err error) {
 		parsedErr = gerr.E(arg.Error(), http.StatusInternalServerError)
 	}
 
+	// Messages registered as translation keys are sent in the request language
+	if msg, err := tr.T(parsedErr.Message); err == nil {
+		parsedErr.Message = msg
+	}
+
 	// log data to console
 	logDataRaw, ok := c.Get(constant.LogDataKey)
 	traceID := ""
@@ -128,3 +216,101 @@ func (h *Handler) Healthz(c *gin.Context) {
 	c.Writer.WriteHeader(http.StatusOK)
 	c.Writer.Write([]byte("OK")) //nolint
 }
+
+// PoolStatus reports the state of a database connection pool
+type PoolStatus struct {
+	Healthy      bool   `json:"healthy"`
+	Error        string `json:"error,omitempty"`
+	OpenConns    int    `json:"openConns"`
+	InUse        int    `json:"inUse"`
+	Idle         int    `json:"idle"`
+	WaitCount    int64  `json:"waitCount"`
+	WaitDuration string `json:"waitDuration"`
+}
+
+// ReadyzResponse reports whether the databases can serve requests
+type ReadyzResponse struct {
+	Ready  bool   `json:"ready"`
+	Status string `json:"status"`
+}
+
+// DatabaseStatusResponse reports the connection pools of the databases
+type DatabaseStatusResponse struct {
+	Ready   bool        `json:"ready"`
+	Primary PoolStatus  `json:"primary"`
+	Replica *PoolStatus `json:"replica,omitempty"`
+}
+
+// Readyz godoc
+// @Summary Readiness check
+// @Description Pings the primary database and the read replica, if any
+// @Tags health
+// @Produce  json
+// @Success 200 {object} ReadyzResponse
+// @Failure 503 {object} ReadyzResponse "A database is unreachable"
+// @Router /readyz [get]
+func (h *Handler) Readyz(c *gin.Context) {
+	// Driver errors and pool stats describe the infrastructure, they are logged
+	// and left to /admin/get-database-status
+	res := h.databaseStatus(c.Request.Context())
+	if res.Primary.Error != "" {
+		h.log.Error("primary database is not ready:", res.Primary.Error) //nolint:errcheck // Ignore unused function warning
+	}
+	if res.Replica != nil && res.Replica.Error != "" {
+		h.log.Error("replica database is not ready:", res.Replica.Error) //nolint:errcheck // Ignore unused function warning
+	}
+
+	if !res.Ready {
+		c.JSON(http.StatusServiceUnavailable, ReadyzResponse{Ready: false, Status: "unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, ReadyzResponse{Ready: true, Status: "ok"})
+}
+
+// GetDatabaseStatus godoc
+// @Summary Database status
+// @Description Pings the primary database and the read replica, if any, and reports their connection pools
+// @Tags admin
+// @Produce  json
+// @Success 200 {object} DatabaseStatusResponse
+// @Failure 403 {object} ErrorResponse "Not an admin"
+// @Failure 503 {object} DatabaseStatusResponse "A database is unreachable"
+// @Router /admin/get-database-status [get]
+func (h *Handler) GetDatabaseStatus(c *gin.Context) {
+	res := h.databaseStatus(c.Request.Context())
+
+	status := http.StatusOK
+	if !res.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, res)
+}
+
+func (h *Handler) databaseStatus(ctx context.Context) DatabaseStatusResponse {
+	ctx, cancel := context.WithTimeout(ctx, readyzTimeout)
+	defer cancel()
+
+	res := DatabaseStatusResponse{Primary: poolStatus(ctx, h.db)}
+	res.Ready = res.Primary.Healthy
+	if h.replica != nil {
+		replica := poolStatus(ctx, h.replica)
+		res.Replica = &replica
+		res.Ready = res.Ready && replica.Healthy
+	}
+	return res
+}
+
+func poolStatus(ctx context.Context, db *sql.DB) PoolStatus {
+	status := PoolStatus{Healthy: true}
+	if err := db.PingContext(ctx); err != nil {
+		status.Healthy = false
+		status.Error = err.Error()
+	}
+	stats := db.Stats()
+	status.OpenConns = stats.OpenConnections
+	status.InUse = stats.InUse
+	status.Idle = stats.Idle
+	status.WaitCount = stats.WaitCount
+	status.WaitDuration = stats.WaitDuration.String()
+	return status
+}
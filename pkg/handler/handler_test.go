@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+	"github.com/Essen-Labs/bloom-be/translation"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestHandler makes a handler over a fake database answering with handle
+func newTestHandler(t *testing.T, handle dbtest.Handler) (*Handler, *dbtest.DB) {
+	gin.SetMode(gin.TestMode)
+	db, fake := dbtest.Open(handle)
+	t.Cleanup(func() { db.Close() })
+	return NewHandler(config.Config{}, gerr.NewSimpleLog(), translation.NewTranslatorHelper(), db, nil, nil), fake
+}
+
+// newRequest makes a request with a JSON body, body may be empty
+func newRequest(method, path, body string) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+// serve handles req with endpoint mounted on route, as if userID were
+// authenticated, and returns the response
+func serve(endpoint gin.HandlerFunc, route, userID string, req *http.Request) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(req.Method, route, func(c *gin.Context) {
+		c.Set(constant.UserIDKey, userID)
+		c.Set(constant.LanguageKey, req.Header.Get("Accept-Language"))
+	}, endpoint)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/Essen-Labs/bloom-be/pkg/importer"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+const maxImportSize = 64 << 20
+
+// ImportedConversation reports a conversation created by an import
+type ImportedConversation struct {
+	ConversationID  string `json:"conversation_id"`
+	Title           string `json:"title"`
+	Source          string `json:"source"`
+	Messages        int    `json:"messages"`
+	SkippedMessages int    `json:"skipped_messages"`
+}
+
+// ImportChatResponse represents the outcome of an import
+type ImportChatResponse struct {
+	Success  bool                   `json:"success"`
+	Message  string                 `json:"message"`
+	Imported []ImportedConversation `json:"imported"`
+	Skipped  []importer.Skipped     `json:"skipped"`
+}
+
+// ImportChat imports conversations from a ChatGPT or bloom export
+// @Summary Import conversations
+// @Description Imports a ChatGPT conversations.json or a bloom JSON export, either as a multipart "file" field or as the raw request body. Original titles and timestamps are kept, items that cannot be imported are reported as skipped
+// @Tags export
+// @Accept json,mpfd
+// @Produce json
+// @Param file formData file false "Export file"
+// @Success 200 {object} ImportChatResponse
+// @Failure 400 {object} ErrorResponse "Unrecognized or invalid file"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /import-chat [post]
+func (h *Handler) ImportChat(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			h.handleError(c, gerr.E(http.StatusBadRequest, fmt.Sprintf("could not read file: %v", err)))
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			h.handleError(c, gerr.E(500, gerr.Trace(err)))
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	result, err := importer.Parse(body)
+	if err != nil {
+		h.handleError(c, gerr.E(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	res, err := h.ImportConversations(c.Request.Context(), userID, result)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// ImportConversations stores parsed conversations for a user. All conversations
+// are created in one transaction, so a failed import leaves nothing behind.
+func (h *Handler) ImportConversations(ctx context.Context, userID string, result importer.Result) (res ImportChatResponse, err error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	// The imported messages are embedded once they are committed
+	var messageIDs []int
+	var contents []string
+
+	// Rollback the transaction if there's an error
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback() //nolint:errcheck // the panic is what matters
+			panic(p)      // Re-raise the panic
+		} else if err != nil {
+			tx.Rollback() //nolint:errcheck // the original error is returned
+		} else if err = tx.Commit(); err != nil {
+			err = fmt.Errorf("could not commit import: %v", err)
+		} else {
+			h.embedMessages(userID, messageIDs, contents)
+		}
+	}()
+
+	imported := []ImportedConversation{}
+	for _, conversation := range result.Conversations {
+		conversationID, ids, err := h.importConversation(ctx, tx, userID, conversation)
+		if err != nil {
+			return res, err
+		}
+		messageIDs = append(messageIDs, ids...)
+		for _, m := range conversation.Messages {
+			contents = append(contents, m.Content)
+		}
+		imported = append(imported, ImportedConversation{
+			ConversationID:  conversationID,
+			Title:           conversation.Title,
+			Source:          conversation.Source,
+			Messages:        len(conversation.Messages),
+			SkippedMessages: conversation.SkippedMessages,
+		})
+	}
+
+	skipped := result.Skipped
+	if skipped == nil {
+		skipped = []importer.Skipped{}
+	}
+	return ImportChatResponse{
+		Success:  len(imported) > 0,
+		Message:  fmt.Sprintf("Imported %d conversations, skipped %d", len(imported), len(skipped)),
+		Imported: imported,
+		Skipped:  skipped,
+	}, nil
+}
+
+// importConversation stores a conversation and returns its id with the ids of
+// its messages, in order
+func (h *Handler) importConversation(ctx context.Context, q querier, userID string, conversation importer.Conversation) (string, []int, error) {
+	title := conversation.Title
+	if title == "" {
+		title = "New Conversation"
+	}
+	model := conversation.Model
+	if model == "" {
+		model = defaultModel
+	}
+
+	conversationID, err := insertConversation(q, userID, model, title, unixOrNow(conversation.CreatedAt))
+	if err != nil {
+		return "", nil, err
+	}
+
+	messageIDs := make([]int, 0, len(conversation.Messages))
+	for _, m := range conversation.Messages {
+		messageID, err := nextMessageID(q)
+		if err != nil {
+			return "", nil, err
+		}
+		content, encrypted, err := h.cipher.Encrypt(ctx, userID, encryption.MessageContent(messageID), m.Content)
+		if err != nil {
+			return "", nil, fmt.Errorf("could not encrypt message: %v", err)
+		}
+		_, err = q.Exec(`
+			INSERT INTO messages (id, conversation_id, role, content, content_encrypted, timestamp, model, search_en, search_vi)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, `+searchVectors("$8")+`)`,
+			messageID, conversationID, m.Role, content, encrypted, unixOrNow(m.CreatedAt), sql.NullString{String: m.Model, Valid: m.Model != ""},
+			h.searchable(m.Content))
+		if err != nil {
+			return "", nil, fmt.Errorf("could not import message: %v", err)
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+	return conversationID, messageIDs, nil
+}
+
+// unixOrNow converts t to the unix seconds stored in timestamp columns, a missing time becomes now
+func unixOrNow(t time.Time) int64 {
+	if t.IsZero() {
+		return time.Now().Unix()
+	}
+	return t.Unix()
+}
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/gin-gonic/gin"
+)
+
+// resealedRow is a row whose content is sealed again for its new owner
+type resealedRow struct {
+	id        int
+	content   string
+	encrypted bool
+}
+
+// mergeAnonymousVisitor moves the conversations of the anonymous visitor of the
+// request into the account that just signed up or logged in, then drops the
+// anonymous identity. A failed merge is logged and tried again at the next
+// login, as the cookie is kept.
+func (h *Handler) mergeAnonymousVisitor(c *gin.Context, userID string) {
+	anonymousID, err := h.GetUserFromCookie(c)
+	if err != nil {
+		return
+	}
+	h.mergeVisitor(c, anonymousID, userID)
+}
+
+// mergeVisitor merges the anonymous visitor anonymousID into the account
+func (h *Handler) mergeVisitor(c *gin.Context, anonymousID, userID string) {
+	actor := h.actor(c)
+	actor.UserID = userID
+	if err := h.mergeAnonymousUser(c.Request.Context(), actor, anonymousID, userID); err != nil {
+		h.log.Error("could not merge anonymous user:", err) //nolint:errcheck // Ignore unused function warning
+		return
+	}
+	h.clearUserCookie(c)
+}
+
+// mergeAnonymousUser gives everything of an anonymous user to an account.
+// Folders and tags named like one of the account are merged into it, and the
+// retention policy of the account wins over the anonymous one.
+func (h *Handler) mergeAnonymousUser(ctx context.Context, actor audit.Actor, anonymousID, userID string) (err error) {
+	if anonymousID == userID {
+		return nil
+	}
+	// Disabled visitors keep their state rather than move into an account
+	var disabled bool
+	anonymousID, disabled, err = h.checkAnonymousUser(anonymousID)
+	if err != nil || anonymousID == "" || disabled {
+		return err
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	var conversations int64
+
+	// Rollback the transaction if there's an error
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback() //nolint:errcheck // the panic is what matters
+			panic(p)      // Re-raise the panic
+		} else if err != nil {
+			tx.Rollback() //nolint:errcheck // the original error is returned
+		} else if err = tx.Commit(); err != nil {
+			err = fmt.Errorf("could not commit merge: %v", err)
+		} else {
+			h.recordAudit(audit.Entry{
+				Actor:      actor,
+				Action:     audit.ActionUserMerge,
+				TargetType: audit.TargetUser,
+				TargetID:   userID,
+				Before:     map[string]interface{}{"anonymous_user_id": anonymousID},
+				After:      map[string]interface{}{"conversations": conversations},
+			})
+		}
+	}()
+
+	// Content is sealed for its owner, it must be sealed again for the account
+	// before the rows change owner
+	err = h.resealContent(ctx, tx, "messages", `
+		SELECT m.id, m.content, m.content_encrypted FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE c.user_id = $1 AND m.content_encrypted`, anonymousID, userID)
+	if err != nil {
+		return err
+	}
+	err = h.resealContent(ctx, tx, "shared_messages", `
+		SELECT m.id, m.content, m.content_encrypted FROM shared_messages m
+		JOIN shared_conversations s ON s.id = m.share_id
+		WHERE s.user_id = $1 AND m.content_encrypted`, anonymousID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = h.resealEmbeddings(ctx, tx, anonymousID, userID)
+	if err != nil {
+		return err
+	}
+
+	statements := []struct {
+		what  string
+		query string
+	}{
+		{"folders", `
+			UPDATE conversations c SET folder_id = af.id
+			FROM folders f JOIN folders af ON af.user_id = $2 AND af.name = f.name
+			WHERE f.user_id = $1 AND c.folder_id = f.id`},
+		{"folders", `
+			DELETE FROM folders f USING folders af
+			WHERE f.user_id = $1 AND af.user_id = $2 AND af.name = f.name`},
+		{"folders", `UPDATE folders SET user_id = $2 WHERE user_id = $1`},
+		{"tags", `
+			INSERT INTO conversation_tags (conversation_id, tag_id)
+			SELECT ct.conversation_id, at.id
+			FROM conversation_tags ct
+			JOIN tags t ON t.id = ct.tag_id
+			JOIN tags at ON at.user_id = $2 AND at.name = t.name
+			WHERE t.user_id = $1
+			ON CONFLICT DO NOTHING`},
+		{"tags", `
+			DELETE FROM tags t USING tags at
+			WHERE t.user_id = $1 AND at.user_id = $2 AND at.name = t.name`},
+		{"tags", `UPDATE tags SET user_id = $2 WHERE user_id = $1`},
+		{"shares", `UPDATE shared_conversations SET user_id = $2 WHERE user_id = $1`},
+		{"feedback", `UPDATE message_feedback SET user_id = $2 WHERE user_id = $1`},
+		{"retention policy", `
+			UPDATE retention_policies SET user_id = $2
+			WHERE user_id = $1 AND NOT EXISTS (SELECT 1 FROM retention_policies WHERE user_id = $2)`},
+		{"retention policy", `DELETE FROM retention_policies WHERE user_id = $1`},
+	}
+	for _, s := range statements {
+		if _, err = tx.ExecContext(ctx, s.query, anonymousID, userID); err != nil {
+			return fmt.Errorf("could not merge %s: %v", s.what, err)
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE conversations SET user_id = $2 WHERE user_id = $1`, anonymousID, userID)
+	if err != nil {
+		return fmt.Errorf("could not merge conversations: %v", err)
+	}
+	if conversations, err = result.RowsAffected(); err != nil {
+		return fmt.Errorf("could not count merged conversations: %v", err)
+	}
+
+	// The anonymous data keys sealed nothing anymore
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_data_keys WHERE user_id = $1`, anonymousID)
+	if err != nil {
+		return fmt.Errorf("could not delete anonymous data keys: %v", err)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, anonymousID)
+	if err != nil {
+		return fmt.Errorf("could not delete anonymous user: %v", err)
+	}
+	return nil
+}
+
+// resealContent seals the content of the rows selected by query, given the
+// anonymous user id, again for the account
+func (h *Handler) resealContent(ctx context.Context, tx *sql.Tx, table, query, anonymousID, userID string) error {
+	rows, err := tx.QueryContext(ctx, query, anonymousID)
+	if err != nil {
+		return fmt.Errorf("could not query %s to reseal: %v", table, err)
+	}
+	var resealed []resealedRow
+	for rows.Next() {
+		var row resealedRow
+		if err := rows.Scan(&row.id, &row.content, &row.encrypted); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not scan %s to reseal: %v", table, err)
+		}
+		resealed = append(resealed, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over %s to reseal: %v", table, err)
+	}
+
+	for _, row := range resealed {
+		loc := encryption.Location{Table: table, ID: strconv.Itoa(row.id)}
+		content, encrypted, err := h.cipher.Reseal(ctx, anonymousID, userID, loc, row.content, row.encrypted)
+		if err != nil {
+			return fmt.Errorf("could not reseal %s %d: %v", table, row.id, err)
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE `+table+` SET content = $1, content_encrypted = $2 WHERE id = $3`, content, encrypted, row.id)
+		if err != nil {
+			return fmt.Errorf("could not update resealed %s %d: %v", table, row.id, err)
+		}
+	}
+	return nil
+}
+
+// resealEmbeddings seals the embeddings of the anonymous user's messages, given
+// the anonymous user id, again for the account
+func (h *Handler) resealEmbeddings(ctx context.Context, tx *sql.Tx, anonymousID, userID string) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT e.message_id, e.sealed_embedding FROM message_embeddings e
+		JOIN messages m ON m.id = e.message_id
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE c.user_id = $1 AND e.sealed_embedding IS NOT NULL`, anonymousID)
+	if err != nil {
+		return fmt.Errorf("could not query embeddings to reseal: %v", err)
+	}
+	type sealedEmbedding struct {
+		messageID int
+		sealed    []byte
+	}
+	var resealed []sealedEmbedding
+	for rows.Next() {
+		var e sealedEmbedding
+		if err := rows.Scan(&e.messageID, &e.sealed); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not scan embedding to reseal: %v", err)
+		}
+		resealed = append(resealed, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over embeddings to reseal: %v", err)
+	}
+
+	for _, e := range resealed {
+		loc := encryption.MessageEmbedding(e.messageID)
+		sealed, _, err := h.cipher.ResealBytes(ctx, anonymousID, userID, loc, e.sealed, true)
+		if err != nil {
+			return fmt.Errorf("could not reseal embedding of message %d: %v", e.messageID, err)
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE message_embeddings SET sealed_embedding = $1 WHERE message_id = $2`, sealed, e.messageID)
+		if err != nil {
+			return fmt.Errorf("could not update resealed embedding of message %d: %v", e.messageID, err)
+		}
+	}
+	return nil
+}
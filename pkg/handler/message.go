@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
 	"github.com/dwarvesf/gerr"
 	"github.com/gin-gonic/gin"
 )
@@ -23,7 +26,10 @@ import (
 func (h *Handler) GetAllMsgsByID(c *gin.Context) {
 	conversationID := c.Param("conversation_id")
 
-	res, err := h.doGetAllMsgsByID(conversationID)
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doGetAllMsgsByID(c.Request.Context(), userID, conversationID)
 	if err != nil {
 		h.handleError(c, gerr.E(500, gerr.Trace(err)))
 		return
@@ -32,13 +38,15 @@ func (h *Handler) GetAllMsgsByID(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
-func (h *Handler) doGetAllMsgsByID(conversationID string) ([]byte, error) {
-	rows, err := h.db.Query(`
-		SELECT id, conversation_id, role, content, timestamp 
-		FROM messages 
-		WHERE conversation_id = $1
-		ORDER BY timestamp ASC
-	`, conversationID)
+func (h *Handler) doGetAllMsgsByID(ctx context.Context, userID, conversationID string) ([]byte, error) {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT m.id, m.conversation_id, m.role, m.content, m.content_encrypted, m.timestamp, coalesce(c.user_id, ''), f.score
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		LEFT JOIN message_feedback f ON f.message_id = m.id
+		WHERE m.conversation_id = $1 AND c.user_id = $2 AND c.deleted_at IS NULL
+		ORDER BY m.timestamp ASC
+	`, conversationID, userID)
 	if err != nil {
 		return nil, fmt.Errorf("could not query messages: %v", err)
 	}
@@ -49,9 +57,15 @@ func (h *Handler) doGetAllMsgsByID(conversationID string) ([]byte, error) {
 	// Loop through the rows and scan data into the Message struct
 	for rows.Next() {
 		var message Message
-		if err := rows.Scan(&message.ID, &message.ConversationID, &message.Role, &message.Content, &message.Timestamp); err != nil {
+		var ownerID string
+		var encrypted bool
+		if err := rows.Scan(&message.ID, &message.ConversationID, &message.Role, &message.Content, &encrypted, &message.Timestamp, &ownerID, &message.Feedback); err != nil {
 			return nil, fmt.Errorf("error scanning message row: %v", err)
 		}
+		message.Content, err = h.cipher.Decrypt(ctx, ownerID, encryption.MessageContent(message.ID), message.Content, encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting message: %v", err)
+		}
 		messages = append(messages, message)
 	}
 
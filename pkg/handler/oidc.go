@@ -0,0 +1,308 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/oidc"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// oidcFlowCookie holds the secrets of a sign-in through the provider, and the
+// anonymous visitor to merge, until the provider redirects back. It is sent on
+// that redirect from another site, unlike the strict cookies of the session.
+const oidcFlowCookie = "oidc_flow"
+
+// oidcFlowTTL is how long users have to sign in at the provider
+const oidcFlowTTL = 10 * time.Minute
+
+// usernameSuffixBytes is the entropy of the suffix of provisioned usernames
+// already taken, encoded with a dash as 7 characters
+const usernameSuffixBytes = 3
+
+// usernameAttempts is how many usernames are tried when provisioning a user
+const usernameAttempts = 5
+
+// Single sign-on errors, their messages are translated
+var (
+	errSSONotConfigured      = gerr.E(http.StatusNotFound, "single sign-on is not configured")
+	errSSOFailed             = gerr.E(http.StatusUnauthorized, "single sign-on failed")
+	errPasswordLoginDisabled = gerr.E(http.StatusForbidden, "password login is disabled, sign in with single sign-on")
+)
+
+// OIDCLogin sends the user to the OpenID Connect provider to sign in
+// @Summary Sign in with single sign-on
+// @Description Redirects to the OpenID Connect provider, which redirects back to /oidc-callback once the user signed in
+// @Tags user
+// @Success 302
+// @Failure 404 {object} ErrorResponse "Single sign-on is not configured"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /oidc-login [get]
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	if h.sso == nil {
+		h.handleError(c, errSSONotConfigured)
+		return
+	}
+
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+	authURL, err := h.sso.AuthCodeURL(c.Request.Context(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	// The session cookies are not sent back from the provider, the anonymous
+	// visitor is carried along, signed, to be merged
+	var anonymous string
+	if anonymousID, err := h.GetUserFromCookie(c); err == nil {
+		anonymous = h.tokens.SignCookie(anonymousCookie, anonymousID)
+	}
+	setOIDCFlowCookie(c, strings.Join([]string{flow.State, flow.Nonce, flow.Verifier, anonymous}, "."), int(oidcFlowTTL.Seconds()))
+
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback signs the user in once the provider redirects back
+// @Summary Single sign-on callback
+// @Description Exchanges the code of the provider for the identity of the user, signing in the account linked to it, else the account of its verified email, else a new account. The refresh token is set as the session cookie before redirecting to the frontend, or returned when no frontend is configured.
+// @Tags user
+// @Produce json
+// @Param code query string true "Authorization code"
+// @Param state query string true "State of the sign-in"
+// @Success 200 {object} SessionResponse
+// @Success 302
+// @Failure 401 {object} ErrorResponse "Single sign-on failed"
+// @Failure 404 {object} ErrorResponse "Single sign-on is not configured"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /oidc-callback [get]
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	if h.sso == nil {
+		h.handleError(c, errSSONotConfigured)
+		return
+	}
+
+	// The flow is used once, whatever the outcome
+	value, _ := c.Cookie(oidcFlowCookie)
+	setOIDCFlowCookie(c, "", -1)
+
+	flow, anonymous, ok := parseOIDCFlow(value)
+	if !ok || subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(flow.State)) != 1 {
+		h.handleError(c, errSSOFailed)
+		return
+	}
+	if providerErr := c.Query("error"); providerErr != "" {
+		h.log.Error("single sign-on refused by the provider:", providerErr, c.Query("error_description")) //nolint:errcheck // Ignore unused function warning
+		h.handleError(c, errSSOFailed)
+		return
+	}
+
+	identity, err := h.sso.Exchange(c.Request.Context(), c.Query("code"), flow.Nonce, flow.Verifier)
+	if err != nil {
+		h.log.Error("could not complete single sign-on:", err) //nolint:errcheck // Ignore unused function warning
+		h.handleError(c, errSSOFailed)
+		return
+	}
+
+	res, err := h.doOIDCLogin(c.Request.Context(), h.actor(c), identity, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	if anonymousID, signed := h.tokens.VerifyCookie(anonymousCookie, anonymous); signed {
+		h.mergeVisitor(c, anonymousID, res.User.ID)
+	} else {
+		h.mergeAnonymousVisitor(c, res.User.ID)
+	}
+	h.setSessionCookie(c, res.RefreshToken, res.RefreshTokenExpiresAt)
+
+	// Browsers go back to the frontend, which gets its access token by refreshing
+	if h.cfg.FrontendBaseURL != "" {
+		c.Redirect(http.StatusFound, h.cfg.FrontendBaseURL)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// doOIDCLogin signs in the account linked to the identity, linking it to the
+// account of its verified email or provisioning an account on first sign-in
+func (h *Handler) doOIDCLogin(ctx context.Context, actor audit.Actor, identity oidc.Identity, ip, userAgent string) (res SessionResponse, err error) {
+	if identity.Subject == "" {
+		return res, errSSOFailed
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not begin transaction: %v", err)))
+	}
+
+	var user User
+	var action string
+
+	// Rollback the transaction if there's an error
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback() //nolint:errcheck // the panic is what matters
+			panic(p)      // Re-raise the panic
+		} else if err != nil {
+			tx.Rollback() //nolint:errcheck // the original error is returned
+		} else if err = tx.Commit(); err != nil {
+			err = gerr.E(500, gerr.Trace(fmt.Errorf("could not commit sign-in: %v", err)))
+		} else if action != "" {
+			actor.UserID = user.ID
+			h.recordAudit(audit.Entry{
+				Actor:      actor,
+				Action:     action,
+				TargetType: audit.TargetUser,
+				TargetID:   user.ID,
+				After: map[string]interface{}{
+					"issuer":   identity.Issuer,
+					"subject":  identity.Subject,
+					"username": user.Username,
+				},
+			})
+		}
+	}()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE user_identities i SET last_login_at = now(), email = $3
+		FROM users u
+		WHERE i.issuer = $1 AND i.subject = $2 AND u.id = i.user_id
+		RETURNING u.id, coalesce(u.username, ''), u.created_at`,
+		identity.Issuer, identity.Subject, nullIfEmpty(identity.Email)).Scan(&user.ID, &user.Username, &user.CreatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		user, action, err = h.linkIdentity(ctx, tx, identity)
+		if err != nil {
+			return res, err
+		}
+	case err != nil:
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not find identity: %v", err)))
+	}
+
+	// Disabled accounts cannot sign in, whichever way they are found
+	var disabled bool
+	err = tx.QueryRowContext(ctx, `SELECT disabled_at IS NOT NULL FROM users WHERE id = $1`, user.ID).Scan(&disabled)
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not find user: %v", err)))
+	}
+	if disabled {
+		return res, errAccountDisabled
+	}
+
+	res, err = h.issueTokens(tx, user, uuid.New().String(), ip, userAgent)
+	if err != nil {
+		return res, err
+	}
+	res.Message = "Logged in"
+	return res, nil
+}
+
+// linkIdentity links a new identity to the account of its email, when the
+// provider and the account both verified it, or else to a new account. It
+// returns the audited action.
+func (h *Handler) linkIdentity(ctx context.Context, tx *sql.Tx, identity oidc.Identity) (User, string, error) {
+	var user User
+	action := audit.ActionUserLinkIdentity
+
+	err := sql.ErrNoRows
+	if identity.Email != "" && identity.EmailVerified {
+		err = tx.QueryRowContext(ctx, `
+			SELECT id, coalesce(username, ''), created_at
+			FROM users
+			WHERE lower(email) = lower($1) AND email_verified_at IS NOT NULL`, identity.Email).
+			Scan(&user.ID, &user.Username, &user.CreatedAt)
+	}
+	if err == sql.ErrNoRows {
+		user, err = h.provisionUser(ctx, tx, identity)
+		action = audit.ActionUserProvision
+	}
+	if err != nil {
+		return User{}, "", gerr.E(500, gerr.Trace(fmt.Errorf("could not link identity: %v", err)))
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_identities (issuer, subject, user_id, email)
+		VALUES ($1, $2, $3, $4)`, identity.Issuer, identity.Subject, user.ID, nullIfEmpty(identity.Email))
+	if err != nil {
+		return User{}, "", gerr.E(500, gerr.Trace(fmt.Errorf("could not link identity: %v", err)))
+	}
+	return user, action, nil
+}
+
+// provisionUser creates the account of an identity, without password. Taken
+// usernames get a random suffix.
+func (h *Handler) provisionUser(ctx context.Context, tx *sql.Tx, identity oidc.Identity) (User, error) {
+	user := User{ID: uuid.New().String()}
+	base := identity.PreferredUsername(1 + 2*usernameSuffixBytes)
+
+	for attempt := 0; attempt < usernameAttempts; attempt++ {
+		user.Username = base
+		if attempt > 0 {
+			suffix := make([]byte, usernameSuffixBytes)
+			if _, err := rand.Read(suffix); err != nil {
+				return User{}, fmt.Errorf("could not generate username: %v", err)
+			}
+			user.Username = base + "-" + hex.EncodeToString(suffix)
+		}
+
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO users (id, username, email, email_verified_at)
+			VALUES ($1, $2, $3, CASE WHEN $4 THEN now() END)
+			ON CONFLICT DO NOTHING
+			RETURNING created_at`,
+			user.ID, user.Username, nullIfEmpty(identity.Email), identity.EmailVerified && identity.Email != "").
+			Scan(&user.CreatedAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return User{}, fmt.Errorf("could not create user: %v", err)
+		}
+		return user, nil
+	}
+	return User{}, fmt.Errorf("could not find a free username for %s", base)
+}
+
+// parseOIDCFlow reads the flow cookie, anonymous is the signed id of the
+// anonymous visitor, or ""
+func parseOIDCFlow(value string) (flow oidc.Flow, anonymous string, ok bool) {
+	parts := strings.SplitN(value, ".", 4)
+	if len(parts) != 4 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return oidc.Flow{}, "", false
+	}
+	return oidc.Flow{State: parts[0], Nonce: parts[1], Verifier: parts[2]}, parts[3], true
+}
+
+// setOIDCFlowCookie sets the flow cookie for maxAge seconds, or clears it with a negative maxAge
+func setOIDCFlowCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// nullIfEmpty stores empty strings as NULL
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// PinChatRequest pins or unpins a conversation
+type PinChatRequest struct {
+	ConversationID string `json:"conversation_id" binding:"required"`
+	Pinned         *bool  `json:"pinned" binding:"required"`
+}
+
+// ArchiveChatRequest archives or unarchives a conversation
+type ArchiveChatRequest struct {
+	ConversationID string `json:"conversation_id" binding:"required"`
+	Archived       *bool  `json:"archived" binding:"required"`
+}
+
+type organizeChatResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Version int    `json:"version,omitempty"` // New version of the updated conversation, also sent as ETag
+}
+
+// PinChat pins or unpins a conversation
+// @Summary Pin a conversation
+// @Description Pinned conversations are listed first in the conversation list
+// @Tags organize
+// @Accept json
+// @Produce json
+// @Param request body PinChatRequest true "Conversation and pinned flag"
+// @Param If-Match header string false "ETag of the conversation, the update fails with 412 if it changed"
+// @Success 200 {object} organizeChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Conversation not found"
+// @Failure 412 {object} ErrorResponse "Conversation changed since it was read"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /pin-chat [post]
+func (h *Handler) PinChat(c *gin.Context) {
+	var req PinChatRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	versions, err := ifMatchVersions(c, req.ConversationID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.doSetConversationFlag(h.db, userID, req.ConversationID, "pinned", *req.Pinned, versions)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	setConversationETag(c, req.ConversationID, res.Version)
+	c.JSON(http.StatusOK, res)
+}
+
+// ArchiveChat archives or unarchives a conversation
+// @Summary Archive a conversation
+// @Description Archived conversations are hidden from the conversation list unless archived=true is requested
+// @Tags organize
+// @Accept json
+// @Produce json
+// @Param request body ArchiveChatRequest true "Conversation and archived flag"
+// @Param If-Match header string false "ETag of the conversation, the update fails with 412 if it changed"
+// @Success 200 {object} organizeChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Conversation not found"
+// @Failure 412 {object} ErrorResponse "Conversation changed since it was read"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /archive-chat [post]
+func (h *Handler) ArchiveChat(c *gin.Context) {
+	var req ArchiveChatRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	versions, err := ifMatchVersions(c, req.ConversationID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.doSetConversationFlag(h.db, userID, req.ConversationID, "archived", *req.Archived, versions)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	setConversationETag(c, req.ConversationID, res.Version)
+	c.JSON(http.StatusOK, res)
+}
+
+// doSetConversationFlag updates a boolean column of a conversation, column is
+// always one of the constants passed by the handlers above. When versions is
+// not nil, the conversation must still be at one of them.
+func (h *Handler) doSetConversationFlag(q querier, userID, conversationID, column string, value bool, versions []int64) (organizeChatResponse, error) {
+	var version int
+	err := q.QueryRow(fmt.Sprintf(`
+		UPDATE conversations SET %s = $1
+		WHERE id = $2 AND user_id = $3 AND deleted_at IS NULL AND ($4::bigint[] IS NULL OR version = ANY($4))
+		RETURNING version`, column), value, conversationID, userID, pq.Array(versions)).Scan(&version)
+	if err == sql.ErrNoRows {
+		return organizeChatResponse{}, checkVersionConflict(q, userID, conversationID, versions)
+	}
+	if err != nil {
+		return organizeChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not update conversation: %v", err)))
+	}
+
+	return organizeChatResponse{
+		Success: true,
+		Message: fmt.Sprintf("Conversation with ID %s %s set to %t", conversationID, column, value),
+		Version: version,
+	}, nil
+}
+
+// checkConversationOwner returns a not found error unless the user owns the
+// conversation and it is not in the trash
+func checkConversationOwner(q querier, userID, conversationID string) error {
+	var exists bool
+	err := q.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM conversations WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL)`,
+		conversationID, userID).Scan(&exists)
+	if err != nil {
+		return gerr.E(500, gerr.Trace(fmt.Errorf("could not check conversation owner: %v", err)))
+	}
+	if !exists {
+		return errConversationNotFound(conversationID)
+	}
+	return nil
+}
+
+func errConversationNotFound(conversationID string) error {
+	return gerr.E(http.StatusNotFound, fmt.Sprintf("conversation with id %s not found", conversationID))
+}
@@ -0,0 +1,487 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// organizedConversation is a row of the fake conversations table
+type organizedConversation struct {
+	userID   string
+	folderID *int
+	pinned   bool
+	archived bool
+	version  int64
+	tags     map[int]bool
+}
+
+// namedRow is a row of the fake folders and tags tables
+type namedRow struct {
+	userID string
+	name   string
+}
+
+// organizeStore is a fake database of conversations with their folders and tags
+type organizeStore struct {
+	mu            sync.Mutex
+	conversations map[string]*organizedConversation
+	folders       map[int]namedRow
+	tags          map[int]namedRow
+	nextID        int
+}
+
+func newOrganizeStore() *organizeStore {
+	return &organizeStore{
+		conversations: map[string]*organizedConversation{
+			"1": {userID: owner, version: 1, tags: map[int]bool{}},
+			"2": {userID: stranger, version: 1, tags: map[int]bool{}},
+		},
+		folders: map[int]namedRow{100: {userID: stranger, name: "Theirs"}},
+		tags:    map[int]namedRow{200: {userID: stranger, name: "theirs"}},
+		nextID:  1,
+	}
+}
+
+// owned returns the conversation of $idx when it belongs to the user of $idx+1
+func (s *organizeStore) owned(q dbtest.Query, idx int) *organizedConversation {
+	conversation := s.conversations[fmt.Sprint(q.Args[idx])]
+	if conversation == nil || conversation.userID != q.Args[idx+1] {
+		return nil
+	}
+	return conversation
+}
+
+func (s *organizeStore) handle(q dbtest.Query) (dbtest.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case q.Has("INSERT INTO audit_log"):
+		return dbtest.Result{RowsAffected: 1}, nil
+
+	case q.Has("FROM folders f WHERE f.user_id = $1"):
+		res := dbtest.Result{Columns: []string{"id", "name", "created_at", "count"}}
+		for _, id := range sortedIDs(s.folders) {
+			if s.folders[id].userID != q.Args[0] {
+				continue
+			}
+			count := 0
+			for _, c := range s.conversations {
+				if c.folderID != nil && *c.folderID == id {
+					count++
+				}
+			}
+			res.Rows = append(res.Rows, []driver.Value{int64(id), s.folders[id].name, time.Now(), int64(count)})
+		}
+		return res, nil
+	case q.Has("INSERT INTO folders"):
+		for _, folder := range s.folders {
+			if folder.userID == q.Args[0] && folder.name == q.Args[1] {
+				return dbtest.Result{}, &pq.Error{Code: "23505"}
+			}
+		}
+		id := s.nextID
+		s.nextID++
+		s.folders[id] = namedRow{userID: q.Args[0].(string), name: q.Args[1].(string)}
+		return dbtest.Result{Columns: []string{"id", "created_at"}, Rows: [][]driver.Value{{int64(id), time.Now()}}}, nil
+	case q.Has("UPDATE folders SET name = $1"):
+		res := dbtest.Result{Columns: []string{"created_at"}}
+		id := int(q.Args[1].(int64))
+		if folder, ok := s.folders[id]; ok && folder.userID == q.Args[2] {
+			s.folders[id] = namedRow{userID: folder.userID, name: q.Args[0].(string)}
+			res.Rows = [][]driver.Value{{time.Now()}}
+		}
+		return res, nil
+	case q.Has("DELETE FROM folders"):
+		res := dbtest.Result{Columns: []string{"name"}}
+		id, _ := strconv.Atoi(q.Args[0].(string))
+		if folder, ok := s.folders[id]; ok && folder.userID == q.Args[1] {
+			delete(s.folders, id)
+			for _, c := range s.conversations {
+				if c.folderID != nil && *c.folderID == id {
+					c.folderID = nil
+				}
+			}
+			res.Rows = [][]driver.Value{{folder.name}}
+		}
+		return res, nil
+	case q.Has("SELECT EXISTS(SELECT 1 FROM folders WHERE id = $1 AND user_id = $2)"):
+		folder, ok := s.folders[int(q.Args[0].(int64))]
+		return dbtest.Result{Columns: []string{"exists"}, Rows: [][]driver.Value{{ok && folder.userID == q.Args[1]}}}, nil
+	case q.Has("SELECT EXISTS(SELECT 1 FROM conversations WHERE id = $1 AND user_id = $2"):
+		return dbtest.Result{Columns: []string{"exists"}, Rows: [][]driver.Value{{s.owned(q, 0) != nil}}}, nil
+	case q.Has("UPDATE conversations SET folder_id = $1"), q.Has("UPDATE conversations SET pinned = $1"), q.Has("UPDATE conversations SET archived = $1"):
+		res := dbtest.Result{Columns: []string{"version"}}
+		c := s.owned(q, 1)
+		if c == nil {
+			return res, nil
+		}
+		switch {
+		case q.Has("folder_id"):
+			c.folderID = nil
+			if id, ok := q.Args[0].(int64); ok {
+				folderID := int(id)
+				c.folderID = &folderID
+			}
+		case q.Has("pinned"):
+			c.pinned = q.Args[0].(bool)
+		default:
+			c.archived = q.Args[0].(bool)
+		}
+		c.version++
+		res.Rows = [][]driver.Value{{c.version}}
+		return res, nil
+
+	case q.Has("FROM tags t WHERE t.user_id = $1"):
+		res := dbtest.Result{Columns: []string{"id", "name", "count"}}
+		for _, id := range sortedIDs(s.tags) {
+			if s.tags[id].userID != q.Args[0] {
+				continue
+			}
+			count := 0
+			for _, c := range s.conversations {
+				if c.tags[id] {
+					count++
+				}
+			}
+			res.Rows = append(res.Rows, []driver.Value{int64(id), s.tags[id].name, int64(count)})
+		}
+		return res, nil
+	case q.Has("INSERT INTO tags"):
+		for id, tag := range s.tags {
+			if tag.userID == q.Args[0] && tag.name == q.Args[1] {
+				return dbtest.Result{Columns: []string{"id"}, Rows: [][]driver.Value{{int64(id)}}}, nil
+			}
+		}
+		id := s.nextID
+		s.nextID++
+		s.tags[id] = namedRow{userID: q.Args[0].(string), name: q.Args[1].(string)}
+		return dbtest.Result{Columns: []string{"id"}, Rows: [][]driver.Value{{int64(id)}}}, nil
+	case q.Has("INSERT INTO conversation_tags"):
+		s.conversations[q.Args[0].(string)].tags[int(q.Args[1].(int64))] = true
+		return dbtest.Result{RowsAffected: 1}, nil
+	case q.Has("DELETE FROM conversation_tags ct USING tags t"):
+		var names pq.StringArray
+		if err := names.Scan(q.Args[2]); err != nil {
+			return dbtest.Result{}, err
+		}
+		removed := int64(0)
+		c := s.conversations[q.Args[0].(string)]
+		for id := range c.tags {
+			for _, name := range names {
+				if s.tags[id].userID == q.Args[1] && s.tags[id].name == name {
+					delete(c.tags, id)
+					removed++
+				}
+			}
+		}
+		return dbtest.Result{RowsAffected: removed}, nil
+	case q.Has("DELETE FROM tags"):
+		res := dbtest.Result{Columns: []string{"name"}}
+		id, _ := strconv.Atoi(q.Args[0].(string))
+		if tag, ok := s.tags[id]; ok && tag.userID == q.Args[1] {
+			delete(s.tags, id)
+			for _, c := range s.conversations {
+				delete(c.tags, id)
+			}
+			res.Rows = [][]driver.Value{{tag.name}}
+		}
+		return res, nil
+	}
+	return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+}
+
+func sortedIDs(rows map[int]namedRow) []int {
+	ids := make([]int, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// organizeStep is a request of a scenario, check looks at the store and the
+// response body once the request succeeded as expected
+type organizeStep struct {
+	name     string
+	userID   string
+	method   string
+	route    string
+	path     string
+	body     string
+	wantCode int
+	check    func(t *testing.T, s *organizeStore, body []byte)
+}
+
+// runOrganizeSteps sends the steps in order to the handlers of their routes
+func runOrganizeSteps(t *testing.T, steps []organizeStep) {
+	store := newOrganizeStore()
+	h, _ := newTestHandler(t, store.handle)
+	endpoints := map[string]func(h *Handler) gin.HandlerFunc{
+		"/get-folder-list":          func(h *Handler) gin.HandlerFunc { return h.GetFolderList },
+		"/create-folder":            func(h *Handler) gin.HandlerFunc { return h.CreateFolder },
+		"/edit-folder":              func(h *Handler) gin.HandlerFunc { return h.EditFolder },
+		"/delete-folder/:folder_id": func(h *Handler) gin.HandlerFunc { return h.DeleteFolder },
+		"/move-chat":                func(h *Handler) gin.HandlerFunc { return h.MoveChat },
+		"/get-tag-list":             func(h *Handler) gin.HandlerFunc { return h.GetTagList },
+		"/tag-chat":                 func(h *Handler) gin.HandlerFunc { return h.TagChat },
+		"/untag-chat":               func(h *Handler) gin.HandlerFunc { return h.UntagChat },
+		"/delete-tag/:tag_id":       func(h *Handler) gin.HandlerFunc { return h.DeleteTag },
+		"/pin-chat":                 func(h *Handler) gin.HandlerFunc { return h.PinChat },
+		"/archive-chat":             func(h *Handler) gin.HandlerFunc { return h.ArchiveChat },
+	}
+
+	for _, step := range steps {
+		if !t.Run(step.name, func(t *testing.T) {
+			w := serve(endpoints[step.route](h), step.route, step.userID, newRequest(step.method, step.path, step.body))
+			if w.Code != step.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, step.wantCode, w.Body.String())
+			}
+			if step.check != nil {
+				store.mu.Lock()
+				defer store.mu.Unlock()
+				step.check(t, store, w.Body.Bytes())
+			}
+		}) {
+			return
+		}
+	}
+}
+
+func TestFolders(t *testing.T) {
+	runOrganizeSteps(t, []organizeStep{
+		{
+			name: "create folder", userID: owner, method: http.MethodPost, route: "/create-folder", path: "/create-folder",
+			body: `{"name": " Work "}`, wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				var res FolderResponse
+				if err := json.Unmarshal(body, &res); err != nil {
+					t.Fatal(err)
+				}
+				if res.Folder.ID != 1 || res.Folder.Name != "Work" || s.folders[1].name != "Work" {
+					t.Errorf("folder = %+v, stored %+v, want folder 1 named Work", res.Folder, s.folders[1])
+				}
+			},
+		},
+		{
+			name: "create folder with a taken name", userID: owner, method: http.MethodPost, route: "/create-folder", path: "/create-folder",
+			body: `{"name": "Work"}`, wantCode: http.StatusConflict,
+		},
+		{
+			name: "create folder without name", userID: owner, method: http.MethodPost, route: "/create-folder", path: "/create-folder",
+			body: `{}`, wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "rename folder", userID: owner, method: http.MethodPost, route: "/edit-folder", path: "/edit-folder",
+			body: `{"folder_id": 1, "new_name": "Projects"}`, wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if s.folders[1].name != "Projects" {
+					t.Errorf("folder name = %q, want Projects", s.folders[1].name)
+				}
+			},
+		},
+		{
+			name: "rename folder of another user", userID: owner, method: http.MethodPost, route: "/edit-folder", path: "/edit-folder",
+			body: `{"folder_id": 100, "new_name": "Mine"}`, wantCode: http.StatusNotFound,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if s.folders[100].name != "Theirs" {
+					t.Errorf("folder name = %q, want Theirs", s.folders[100].name)
+				}
+			},
+		},
+		{
+			name: "move conversation into folder", userID: owner, method: http.MethodPost, route: "/move-chat", path: "/move-chat",
+			body: `{"conversation_id": "1", "folder_id": 1}`, wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if c := s.conversations["1"]; c.folderID == nil || *c.folderID != 1 {
+					t.Errorf("folder of conversation 1 = %v, want 1", c.folderID)
+				}
+			},
+		},
+		{
+			name: "move conversation into folder of another user", userID: owner, method: http.MethodPost, route: "/move-chat", path: "/move-chat",
+			body: `{"conversation_id": "1", "folder_id": 100}`, wantCode: http.StatusNotFound,
+		},
+		{
+			name: "move conversation of another user", userID: owner, method: http.MethodPost, route: "/move-chat", path: "/move-chat",
+			body: `{"conversation_id": "2", "folder_id": 1}`, wantCode: http.StatusNotFound,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if s.conversations["2"].folderID != nil {
+					t.Errorf("conversation 2 moved to folder %d", *s.conversations["2"].folderID)
+				}
+			},
+		},
+		{
+			name: "list folders", userID: owner, method: http.MethodGet, route: "/get-folder-list", path: "/get-folder-list",
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				var res GetFolderListResponse
+				if err := json.Unmarshal(body, &res); err != nil {
+					t.Fatal(err)
+				}
+				if len(res.Folders) != 1 || res.Folders[0].Name != "Projects" || res.Folders[0].ConversationCount != 1 {
+					t.Errorf("folders = %+v, want Projects with 1 conversation", res.Folders)
+				}
+			},
+		},
+		{
+			name: "delete folder of another user", userID: owner, method: http.MethodDelete, route: "/delete-folder/:folder_id", path: "/delete-folder/100",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name: "delete folder", userID: owner, method: http.MethodDelete, route: "/delete-folder/:folder_id", path: "/delete-folder/1",
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if _, ok := s.folders[1]; ok {
+					t.Error("folder 1 not deleted")
+				}
+				if s.conversations["1"].folderID != nil {
+					t.Error("conversation 1 still in the deleted folder")
+				}
+			},
+		},
+		{
+			name: "move conversation out of any folder", userID: owner, method: http.MethodPost, route: "/move-chat", path: "/move-chat",
+			body: `{"conversation_id": "1", "folder_id": null}`, wantCode: http.StatusOK,
+		},
+	})
+}
+
+func TestTags(t *testing.T) {
+	tagNames := func(s *organizeStore, conversationID string) []string {
+		var names []string
+		for id := range s.conversations[conversationID].tags {
+			names = append(names, s.tags[id].name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	runOrganizeSteps(t, []organizeStep{
+		{
+			name: "tag conversation", userID: owner, method: http.MethodPost, route: "/tag-chat", path: "/tag-chat",
+			body: `{"conversation_id": "1", "tags": ["work", " urgent ", "work"]}`, wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if got := tagNames(s, "1"); fmt.Sprint(got) != "[urgent work]" {
+					t.Errorf("tags = %v, want [urgent work]", got)
+				}
+			},
+		},
+		{
+			name: "tag conversation of another user", userID: owner, method: http.MethodPost, route: "/tag-chat", path: "/tag-chat",
+			body: `{"conversation_id": "2", "tags": ["mine"]}`, wantCode: http.StatusNotFound,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if len(s.conversations["2"].tags) != 0 {
+					t.Errorf("conversation 2 tagged %v", tagNames(s, "2"))
+				}
+			},
+		},
+		{
+			name: "tag conversation without tags", userID: owner, method: http.MethodPost, route: "/tag-chat", path: "/tag-chat",
+			body: `{"conversation_id": "1", "tags": []}`, wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "list tags", userID: owner, method: http.MethodGet, route: "/get-tag-list", path: "/get-tag-list",
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				var res GetTagListResponse
+				if err := json.Unmarshal(body, &res); err != nil {
+					t.Fatal(err)
+				}
+				if len(res.Tags) != 2 || res.Tags[0].Name != "work" || res.Tags[0].ConversationCount != 1 {
+					t.Errorf("tags = %+v, want work and urgent on 1 conversation", res.Tags)
+				}
+			},
+		},
+		{
+			name: "untag conversation", userID: owner, method: http.MethodPost, route: "/untag-chat", path: "/untag-chat",
+			body: `{"conversation_id": "1", "tags": ["urgent", "unknown"]}`, wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if got := tagNames(s, "1"); fmt.Sprint(got) != "[work]" {
+					t.Errorf("tags = %v, want [work]", got)
+				}
+			},
+		},
+		{
+			name: "untag conversation of another user", userID: owner, method: http.MethodPost, route: "/untag-chat", path: "/untag-chat",
+			body: `{"conversation_id": "2", "tags": ["theirs"]}`, wantCode: http.StatusNotFound,
+		},
+		{
+			name: "delete tag of another user", userID: owner, method: http.MethodDelete, route: "/delete-tag/:tag_id", path: "/delete-tag/200",
+			wantCode: http.StatusNotFound,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if _, ok := s.tags[200]; !ok {
+					t.Error("tag 200 of another user deleted")
+				}
+			},
+		},
+		{
+			name: "delete tag", userID: owner, method: http.MethodDelete, route: "/delete-tag/:tag_id", path: "/delete-tag/1",
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if got := tagNames(s, "1"); len(got) != 0 {
+					t.Errorf("tags = %v, want none", got)
+				}
+			},
+		},
+	})
+}
+
+func TestPinAndArchive(t *testing.T) {
+	runOrganizeSteps(t, []organizeStep{
+		{
+			name: "pin conversation", userID: owner, method: http.MethodPost, route: "/pin-chat", path: "/pin-chat",
+			body: `{"conversation_id": "1", "pinned": true}`, wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if !s.conversations["1"].pinned {
+					t.Error("conversation 1 not pinned")
+				}
+			},
+		},
+		{
+			name: "pin without flag", userID: owner, method: http.MethodPost, route: "/pin-chat", path: "/pin-chat",
+			body: `{"conversation_id": "1"}`, wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "pin conversation of another user", userID: owner, method: http.MethodPost, route: "/pin-chat", path: "/pin-chat",
+			body: `{"conversation_id": "2", "pinned": true}`, wantCode: http.StatusNotFound,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if s.conversations["2"].pinned {
+					t.Error("conversation 2 of another user pinned")
+				}
+			},
+		},
+		{
+			name: "archive conversation", userID: owner, method: http.MethodPost, route: "/archive-chat", path: "/archive-chat",
+			body: `{"conversation_id": "1", "archived": true}`, wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if !s.conversations["1"].archived || !s.conversations["1"].pinned {
+					t.Error("conversation 1 not archived and pinned")
+				}
+			},
+		},
+		{
+			name: "unarchive conversation", userID: owner, method: http.MethodPost, route: "/archive-chat", path: "/archive-chat",
+			body: `{"conversation_id": "1", "archived": false}`, wantCode: http.StatusOK,
+			check: func(t *testing.T, s *organizeStore, body []byte) {
+				if s.conversations["1"].archived {
+					t.Error("conversation 1 still archived")
+				}
+			},
+		},
+		{
+			name: "archive conversation of another user", userID: owner, method: http.MethodPost, route: "/archive-chat", path: "/archive-chat",
+			body: `{"conversation_id": "2", "archived": true}`, wantCode: http.StatusNotFound,
+		},
+	})
+}
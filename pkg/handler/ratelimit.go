@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/middleware"
+	"github.com/Essen-Labs/bloom-be/pkg/ratelimit"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+// errTooManyMessages is returned over the chat budget, its message is translated
+var errTooManyMessages = gerr.E(http.StatusTooManyRequests, "too many messages, wait a moment before sending another")
+
+// chatRoutes send messages to the model, they have a budget of their own
+var chatRoutes = []string{
+	"POST /send-chat",
+}
+
+// RateLimit limits how often each API key, user or IP calls the routes, with a
+// budget for chatRoutes and another for every other route. On protected routes
+// it must run after Authenticate.
+func (h *Handler) RateLimit() gin.HandlerFunc {
+	return middleware.NewRateLimitMiddleware(middleware.RateLimitConfig{
+		Limiter:    h.limiter,
+		Chat:       ratelimit.PerMinute(h.cfg.RateLimitChatPerMinute),
+		Read:       ratelimit.PerMinute(h.cfg.RateLimitReadPerMinute),
+		ChatRoutes: chatRoutes,
+		Key:        rateLimitKey,
+		Deny: func(c *gin.Context, budget string, _ ratelimit.Result) {
+			if budget == middleware.BudgetChat {
+				h.handleError(c, errTooManyMessages)
+				return
+			}
+			h.handleError(c, errTooManyRequests)
+		},
+		OnError: func(_ *gin.Context, err error) {
+			h.log.Error("could not rate limit request:", err) //nolint:errcheck // Ignore unused function warning
+		},
+	})
+}
+
+// rateLimitKey counts requests by API key, then by account. Anonymous visitors
+// are counted by IP, as they get a new id by dropping their cookie.
+func rateLimitKey(c *gin.Context) string {
+	if keyID := c.GetString(constant.APIKeyIDKey); keyID != "" {
+		return "api_key:" + keyID
+	}
+	if userID := c.GetString(constant.UserIDKey); userID != "" && !c.GetBool(constant.AnonymousKey) {
+		return "user:" + userID
+	}
+	return "ip:" + c.ClientIP()
+}
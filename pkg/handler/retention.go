@@ -0,0 +1,379 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+// retentionCandidates selects what the retention rules delete, with the global
+// rule days in $1 (0 when disabled), whether it exempts pinned conversations in
+// $2 and an optional user in $3. A user rule can only shorten the global one.
+// Message and conversation timestamps are unix seconds stored as VARCHAR.
+// Copies of the content expire with it: the messages of share snapshots by the
+// rule of the sharing user, and the data exports holding an expired message.
+const retentionCandidates = `
+	WITH rules AS (
+		SELECT c.id AS conversation_id, c.user_id, c.created_at,
+			CASE
+				WHEN p.max_age_days IS NULL THEN NULLIF($1::int, 0)
+				WHEN $1::int = 0 THEN p.max_age_days
+				ELSE LEAST(p.max_age_days, $1::int)
+			END AS max_age_days,
+			c.pinned AND coalesce(p.exempt_pinned, true) AND ($1::int = 0 OR $2::bool) AS exempt
+		FROM conversations c
+		LEFT JOIN retention_policies p ON p.user_id = c.user_id
+		WHERE $3::text = '' OR c.user_id = $3::text
+	), expired AS (
+		SELECT m.id, m.conversation_id, r.user_id, r.max_age_days,
+			CASE WHEN m.timestamp ~ '^[0-9]+$' THEN m.timestamp::bigint END AS sent_at
+		FROM messages m
+		JOIN rules r ON r.conversation_id = m.conversation_id
+		WHERE r.max_age_days IS NOT NULL AND NOT r.exempt
+			AND CASE WHEN m.timestamp ~ '^[0-9]+$' THEN m.timestamp::bigint END
+				< extract(epoch FROM now())::bigint - r.max_age_days * 86400
+	), emptied AS (
+		SELECT r.conversation_id, r.user_id, r.max_age_days
+		FROM rules r
+		WHERE r.max_age_days IS NOT NULL AND NOT r.exempt
+			AND CASE WHEN r.created_at ~ '^[0-9]+$' THEN r.created_at::bigint END
+				< extract(epoch FROM now())::bigint - r.max_age_days * 86400
+			AND NOT EXISTS (
+				SELECT 1 FROM messages m
+				WHERE m.conversation_id = r.conversation_id
+					AND NOT EXISTS (SELECT 1 FROM expired e WHERE e.id = m.id))
+	), share_rules AS (
+		SELECT s.id AS share_id, s.user_id, s.created_at,
+			CASE
+				WHEN p.max_age_days IS NULL THEN NULLIF($1::int, 0)
+				WHEN $1::int = 0 THEN p.max_age_days
+				ELSE LEAST(p.max_age_days, $1::int)
+			END AS max_age_days,
+			coalesce(c.pinned, false) AND coalesce(p.exempt_pinned, true) AND ($1::int = 0 OR $2::bool) AS exempt
+		FROM shared_conversations s
+		LEFT JOIN conversations c ON c.id = s.conversation_id
+		LEFT JOIN retention_policies p ON p.user_id = s.user_id
+		WHERE $3::text = '' OR s.user_id = $3::text
+	), expired_shared AS (
+		SELECT sm.id, r.user_id, r.max_age_days
+		FROM shared_messages sm
+		JOIN share_rules r ON r.share_id = sm.share_id
+		WHERE r.max_age_days IS NOT NULL AND NOT r.exempt
+			AND CASE WHEN sm.timestamp ~ '^[0-9]+$' THEN sm.timestamp::bigint END
+				< extract(epoch FROM now())::bigint - r.max_age_days * 86400
+	), emptied_shares AS (
+		SELECT r.share_id, r.user_id, r.max_age_days
+		FROM share_rules r
+		WHERE r.max_age_days IS NOT NULL AND NOT r.exempt
+			AND r.created_at < now() - make_interval(days => r.max_age_days)
+			AND NOT EXISTS (
+				SELECT 1 FROM shared_messages sm
+				WHERE sm.share_id = r.share_id
+					AND NOT EXISTS (SELECT 1 FROM expired_shared e WHERE e.id = sm.id))
+	), expired_exports AS (
+		SELECT d.id, d.user_id, MAX(e.max_age_days) AS max_age_days
+		FROM data_exports d
+		JOIN expired e ON e.user_id = d.user_id AND e.sent_at <= extract(epoch FROM d.created_at)::bigint
+		WHERE d.status <> 'pending'
+		GROUP BY d.id, d.user_id
+	)`
+
+// RetentionPolicy is a retention rule, MaxAgeDays 0 keeps data forever
+type RetentionPolicy struct {
+	MaxAgeDays   int  `json:"maxAgeDays"`
+	ExemptPinned bool `json:"exemptPinned"`
+}
+
+// GetRetentionPolicyResponse represents the retention rules applying to a user
+type GetRetentionPolicyResponse struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message"`
+	Global  RetentionPolicy  `json:"global"`
+	User    *RetentionPolicy `json:"user"`
+}
+
+// SetRetentionPolicyRequest sets the retention rule of the user
+type SetRetentionPolicyRequest struct {
+	MaxAgeDays   int   `json:"max_age_days" binding:"required,min=1,max=36500"`
+	ExemptPinned *bool `json:"exempt_pinned"`
+}
+
+// RetentionReportEntry counts what the retention rules would delete for a user
+type RetentionReportEntry struct {
+	UserID         string `json:"userId"`
+	MaxAgeDays     int    `json:"maxAgeDays"`
+	Messages       int    `json:"messages"`
+	Conversations  int    `json:"conversations"`
+	SharedMessages int    `json:"sharedMessages"`
+	DataExports    int    `json:"dataExports"`
+}
+
+// RetentionReportResponse represents a dry run of the retention rules
+type RetentionReportResponse struct {
+	Success bool                   `json:"success"`
+	Message string                 `json:"message"`
+	Report  []RetentionReportEntry `json:"report"`
+}
+
+// GetRetentionPolicy returns the retention rules applying to the user
+// @Summary Get retention policy
+// @Description Returns the global retention rule and the user's own rule, the shortest of the two applies
+// @Tags retention
+// @Produce json
+// @Success 200 {object} GetRetentionPolicyResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /get-retention-policy [get]
+func (h *Handler) GetRetentionPolicy(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doGetRetentionPolicy(userID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetRetentionPolicy(userID string) (GetRetentionPolicyResponse, error) {
+	res := GetRetentionPolicyResponse{
+		Success: true,
+		Global: RetentionPolicy{
+			MaxAgeDays:   h.cfg.RetentionDays,
+			ExemptPinned: h.cfg.RetentionExemptPinned,
+		},
+	}
+
+	var policy RetentionPolicy
+	err := h.db.QueryRow(`
+		SELECT max_age_days, exempt_pinned
+		FROM retention_policies
+		WHERE user_id = $1`, userID).Scan(&policy.MaxAgeDays, &policy.ExemptPinned)
+	switch {
+	case err == sql.ErrNoRows:
+		res.Message = "No user retention policy"
+	case err != nil:
+		return GetRetentionPolicyResponse{}, fmt.Errorf("could not query retention policy: %v", err)
+	default:
+		res.Message = "User retention policy found"
+		res.User = &policy
+	}
+	return res, nil
+}
+
+// SetRetentionPolicy sets the retention rule of the user
+// @Summary Set retention policy
+// @Description Deletes the user's messages older than max_age_days, pinned conversations are exempt unless exempt_pinned is false
+// @Tags retention
+// @Accept json
+// @Produce json
+// @Param request body SetRetentionPolicyRequest true "Retention rule"
+// @Success 200 {object} GetRetentionPolicyResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /set-retention-policy [post]
+func (h *Handler) SetRetentionPolicy(c *gin.Context) {
+	var req SetRetentionPolicyRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	exemptPinned := true
+	if req.ExemptPinned != nil {
+		exemptPinned = *req.ExemptPinned
+	}
+
+	_, err = h.db.Exec(`
+		INSERT INTO retention_policies (user_id, max_age_days, exempt_pinned)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET max_age_days = EXCLUDED.max_age_days, exempt_pinned = EXCLUDED.exempt_pinned, updated_at = now()`,
+		userID, req.MaxAgeDays, exemptPinned)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(fmt.Errorf("could not set retention policy: %v", err))))
+		return
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      h.actor(c),
+		Action:     audit.ActionRetentionSet,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+		After:      RetentionPolicy{MaxAgeDays: req.MaxAgeDays, ExemptPinned: exemptPinned},
+	})
+
+	res, err := h.doGetRetentionPolicy(userID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// DeleteRetentionPolicy removes the retention rule of the user
+// @Summary Delete retention policy
+// @Description Removes the user's own rule, only the global rule applies afterwards
+// @Tags retention
+// @Produce json
+// @Success 200 {object} organizeChatResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /delete-retention-policy [delete]
+func (h *Handler) DeleteRetentionPolicy(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	var policy RetentionPolicy
+	err := h.db.QueryRow(`
+		DELETE FROM retention_policies WHERE user_id = $1
+		RETURNING max_age_days, exempt_pinned`, userID).Scan(&policy.MaxAgeDays, &policy.ExemptPinned)
+	switch {
+	case err == sql.ErrNoRows:
+		// Deleting a missing policy is not an error, there is nothing to record
+	case err != nil:
+		h.handleError(c, gerr.E(500, gerr.Trace(fmt.Errorf("could not delete retention policy: %v", err))))
+		return
+	default:
+		h.recordAudit(audit.Entry{
+			Actor:      h.actor(c),
+			Action:     audit.ActionRetentionDelete,
+			TargetType: audit.TargetUser,
+			TargetID:   userID,
+			Before:     policy,
+		})
+	}
+
+	c.JSON(http.StatusOK, organizeChatResponse{
+		Success: true,
+		Message: "Retention policy deleted",
+	})
+}
+
+// GetRetentionReport reports what the retention rules would delete for the user
+// @Summary Retention dry run
+// @Description Counts the messages, conversations, shared messages and data exports of the user the next retention run would delete, without deleting anything
+// @Tags retention
+// @Produce json
+// @Success 200 {object} RetentionReportResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /get-retention-report [get]
+func (h *Handler) GetRetentionReport(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	report, err := h.RetentionReport(c.Request.Context(), userID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, RetentionReportResponse{
+		Success: len(report) > 0,
+		Message: fmt.Sprintf("Retention would delete data of %d users", len(report)),
+		Report:  report,
+	})
+}
+
+// RetentionReport counts per user what ApplyRetention would delete. An empty
+// userID reports on every user.
+func (h *Handler) RetentionReport(ctx context.Context, userID string) ([]RetentionReportEntry, error) {
+	rows, err := h.db.QueryContext(ctx, retentionCandidates+`
+		SELECT user_id, MAX(max_age_days),
+			COUNT(*) FILTER (WHERE kind = 'message'),
+			COUNT(*) FILTER (WHERE kind = 'conversation'),
+			COUNT(*) FILTER (WHERE kind = 'shared_message'),
+			COUNT(*) FILTER (WHERE kind = 'data_export')
+		FROM (
+			SELECT user_id, max_age_days, 'message' AS kind FROM expired
+			UNION ALL
+			SELECT user_id, max_age_days, 'conversation' FROM emptied
+			UNION ALL
+			SELECT user_id, max_age_days, 'shared_message' FROM expired_shared
+			UNION ALL
+			SELECT user_id, max_age_days, 'data_export' FROM expired_exports
+		) candidates
+		GROUP BY user_id
+		ORDER BY user_id`, h.cfg.RetentionDays, h.cfg.RetentionExemptPinned, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query retention report: %v", err)
+	}
+	defer rows.Close()
+
+	report := []RetentionReportEntry{}
+	for rows.Next() {
+		var entry RetentionReportEntry
+		var userID sql.NullString
+		if err := rows.Scan(&userID, &entry.MaxAgeDays, &entry.Messages, &entry.Conversations,
+			&entry.SharedMessages, &entry.DataExports); err != nil {
+			return nil, fmt.Errorf("error scanning retention report: %v", err)
+		}
+		entry.UserID = userID.String
+		report = append(report, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over retention report: %v", err)
+	}
+	return report, nil
+}
+
+// ApplyRetention deletes the messages older than the retention rules allow with
+// their embeddings, the conversations left without messages, and the copies of
+// expired messages in share snapshots and data exports
+func (h *Handler) ApplyRetention(ctx context.Context) error {
+	var messages, embeddings, conversations, sharedMessages, shares, exports int64
+	err := h.db.QueryRowContext(ctx, retentionCandidates+`,
+		deleted_embeddings AS (
+			DELETE FROM message_embeddings WHERE message_id IN (SELECT id FROM expired) RETURNING message_id
+		), deleted_messages AS (
+			DELETE FROM messages WHERE id IN (SELECT id FROM expired) RETURNING id
+		), deleted_conversations AS (
+			DELETE FROM conversations WHERE id IN (SELECT conversation_id FROM emptied) RETURNING id
+		), deleted_shared_messages AS (
+			DELETE FROM shared_messages WHERE id IN (SELECT id FROM expired_shared) RETURNING id
+		), deleted_shares AS (
+			DELETE FROM shared_conversations WHERE id IN (SELECT share_id FROM emptied_shares) RETURNING id
+		), deleted_exports AS (
+			DELETE FROM data_exports WHERE id IN (SELECT id FROM expired_exports) RETURNING id
+		)
+		SELECT (SELECT COUNT(*) FROM deleted_messages), (SELECT COUNT(*) FROM deleted_embeddings),
+			(SELECT COUNT(*) FROM deleted_conversations), (SELECT COUNT(*) FROM deleted_shared_messages),
+			(SELECT COUNT(*) FROM deleted_shares), (SELECT COUNT(*) FROM deleted_exports)`,
+		h.cfg.RetentionDays, h.cfg.RetentionExemptPinned, "").
+		Scan(&messages, &embeddings, &conversations, &sharedMessages, &shares, &exports)
+	if err != nil {
+		return fmt.Errorf("could not apply retention: %v", err)
+	}
+
+	if messages > 0 || conversations > 0 || sharedMessages > 0 || shares > 0 || exports > 0 {
+		h.log.Info("retention deleted ", messages, " messages, ", conversations, " conversations, ", //nolint:errcheck // Ignore unused function warning
+			sharedMessages, " shared messages, ", shares, " shares and ", exports, " data exports")
+		h.recordAudit(audit.Entry{
+			Actor:      audit.System(),
+			Action:     audit.ActionRetentionApply,
+			TargetType: audit.TargetConversation,
+			After: map[string]int64{
+				"messages":        messages,
+				"embeddings":      embeddings,
+				"conversations":   conversations,
+				"shared_messages": sharedMessages,
+				"shares":          shares,
+				"data_exports":    exports,
+			},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultSearchPageSize = 20
+	headlineOptions       = "StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2"
+)
+
+// searchLanguage maps a translation locale to its text search config and the
+// tsvector columns maintained for it by createTables
+type searchLanguage struct {
+	config string
+	column string
+}
+
+var searchLanguages = map[string]searchLanguage{
+	"en": {config: "english", column: "search_en"},
+	"vi": {config: "vietnamese", column: "search_vi"},
+}
+
+// SearchChatRequest holds the query string parameters of the search endpoint
+type SearchChatRequest struct {
+	Query    string `form:"q" json:"q" binding:"required"`
+	Lang     string `form:"lang" json:"lang" binding:"omitempty,oneof=en vi"`
+	Page     int    `form:"page" json:"page" binding:"omitempty,min=1"`
+	PageSize int    `form:"page_size" json:"page_size" binding:"omitempty,min=1,max=100"`
+}
+
+// errSearchSealed is returned when message content is encrypted, no search
+// vectors are stored for messages then
+var errSearchSealed = gerr.E(http.StatusNotImplemented, "full-text search is not available while message content is encrypted")
+
+// SearchResult is a single ranked hit, either a message or a conversation name
+type SearchResult struct {
+	Type             string  `json:"type"`
+	ConversationID   string  `json:"conversation_id"`
+	ConversationName string  `json:"conversation_name"`
+	MessageID        *int    `json:"message_id,omitempty"`
+	Role             string  `json:"role,omitempty"`
+	Snippet          string  `json:"snippet"`
+	Rank             float64 `json:"rank"`
+	Timestamp        string  `json:"timestamp"`
+}
+
+// SearchChatResponse represents a page of search results
+type SearchChatResponse struct {
+	Success  bool           `json:"success"`
+	Message  string         `json:"message"`
+	Results  []SearchResult `json:"results"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	Total    int            `json:"total"`
+}
+
+// SearchChat searches the current user's messages and conversation names
+// @Summary Search conversations
+// @Description Full-text search over the user's messages and conversation names, ranked by relevance with highlighted snippets
+// @Tags chat
+// @Produce json
+// @Param q query string true "Search query, websearch syntax (quotes, OR, -exclude)"
+// @Param lang query string false "Search language (en, vi), defaults to Accept-Language"
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Results per page (max 100)"
+// @Success 200 {object} SearchChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 500 {object} ErrorResponse "Internal Server Error"
+// @Failure 501 {object} ErrorResponse "Message content is encrypted"
+// @Router /search-chat [get]
+func (h *Handler) SearchChat(c *gin.Context) {
+	var req SearchChatRequest
+
+	if h.cipher.Enabled() {
+		h.handleError(c, errSearchSealed)
+		return
+	}
+
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	if req.Lang == "" {
+		req.Lang = c.GetString(constant.LanguageKey)
+	}
+
+	res, err := h.doSearchChat(c.Request.Context(), userID, req)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doSearchChat(ctx context.Context, userID string, req SearchChatRequest) (SearchChatResponse, error) {
+	lang := resolveSearchLanguage(req.Lang)
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	if req.PageSize == 0 {
+		req.PageSize = defaultSearchPageSize
+	}
+
+	hits, total, err := h.searchIndexedChat(ctx, userID, lang, req)
+	if err != nil {
+		return SearchChatResponse{}, err
+	}
+
+	results := make([]SearchResult, 0, len(hits))
+	bodies := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, hit.result)
+		bodies = append(bodies, hit.body)
+	}
+
+	snippets, err := h.searchHeadlines(ctx, lang, req.Query, bodies)
+	if err != nil {
+		return SearchChatResponse{}, err
+	}
+	for idx := range results {
+		results[idx].Snippet = snippets[idx]
+	}
+
+	message := "Results found"
+	if len(results) == 0 {
+		message = "No results found"
+	}
+
+	return SearchChatResponse{
+		Success:  len(results) > 0,
+		Message:  message,
+		Results:  results,
+		Page:     req.Page,
+		PageSize: req.PageSize,
+		Total:    total,
+	}, nil
+}
+
+// searchHit is a search result with the plaintext body it is highlighted from
+type searchHit struct {
+	result SearchResult
+	body   string
+}
+
+// searchIndexedChat ranks a page of hits with the search columns of messages and
+// conversations
+func (h *Handler) searchIndexedChat(ctx context.Context, userID string, lang searchLanguage, req SearchChatRequest) ([]searchHit, int, error) {
+	// Column and config names come from the searchLanguages whitelist, never from input.
+	// Message content may still be encrypted, so snippets are highlighted afterwards.
+	query := fmt.Sprintf(`
+		WITH q AS (
+			SELECT websearch_to_tsquery('%[1]s', $2) AS query
+		), hits AS (
+			SELECT 'message' AS type, c.id AS conversation_id, c.conversation_name,
+				m.id AS message_id, m.role, m.content AS body, m.content_encrypted AS encrypted, m.timestamp,
+				ts_rank(m.%[2]s, q.query) AS rank
+			FROM messages m
+			JOIN conversations c ON c.id = m.conversation_id, q
+			WHERE c.user_id = $1 AND c.deleted_at IS NULL AND m.%[2]s @@ q.query
+			UNION ALL
+			SELECT 'conversation', c.id, c.conversation_name,
+				NULL, NULL, c.conversation_name, false, c.created_at,
+				ts_rank(c.%[2]s, q.query)
+			FROM conversations c, q
+			WHERE c.user_id = $1 AND c.deleted_at IS NULL AND c.%[2]s @@ q.query
+		)
+		SELECT type, conversation_id, coalesce(conversation_name, ''),
+			message_id, coalesce(role, ''), coalesce(body, ''), encrypted,
+			rank, coalesce(timestamp, ''), COUNT(*) OVER() AS total
+		FROM hits
+		ORDER BY rank DESC, conversation_id DESC, message_id DESC NULLS FIRST
+		LIMIT $3 OFFSET $4
+	`, lang.config, lang.column)
+
+	rows, err := h.reader().QueryContext(ctx, query, userID, req.Query, req.PageSize, (req.Page-1)*req.PageSize)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not search conversations: %v", err)
+	}
+	defer rows.Close()
+
+	hits := []searchHit{}
+	total := 0
+	for rows.Next() {
+		var hit searchHit
+		var encrypted bool
+		r := &hit.result
+		if err := rows.Scan(&r.Type, &r.ConversationID, &r.ConversationName, &r.MessageID, &r.Role,
+			&hit.body, &encrypted, &r.Rank, &r.Timestamp, &total); err != nil {
+			return nil, 0, fmt.Errorf("error scanning search result: %v", err)
+		}
+		if r.Type == "message" {
+			hit.body, err = h.cipher.Decrypt(ctx, userID, encryption.MessageContent(*r.MessageID), hit.body, encrypted)
+			if err != nil {
+				return nil, 0, fmt.Errorf("error decrypting search result: %v", err)
+			}
+		}
+		hits = append(hits, hit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating over search results: %v", err)
+	}
+	return hits, total, nil
+}
+
+// searchHeadlines highlights the query in the plaintext bodies of a page of results
+func (h *Handler) searchHeadlines(ctx context.Context, lang searchLanguage, query string, bodies []string) ([]string, error) {
+	if len(bodies) == 0 {
+		return nil, nil
+	}
+
+	rows, err := h.reader().QueryContext(ctx, fmt.Sprintf(`
+		SELECT ts_headline('%[1]s', b.body, websearch_to_tsquery('%[1]s', $1), '%[2]s')
+		FROM unnest($2::text[]) WITH ORDINALITY AS b(body, n)
+		ORDER BY b.n`, lang.config, headlineOptions), query, pq.Array(bodies))
+	if err != nil {
+		return nil, fmt.Errorf("could not highlight search results: %v", err)
+	}
+	defer rows.Close()
+
+	snippets := make([]string, 0, len(bodies))
+	for rows.Next() {
+		var snippet string
+		if err := rows.Scan(&snippet); err != nil {
+			return nil, fmt.Errorf("error scanning search snippet: %v", err)
+		}
+		snippets = append(snippets, snippet)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over search snippets: %v", err)
+	}
+	return snippets, nil
+}
+
+// searchVectors returns the search_en and search_vi values of a message whose
+// plaintext content is in the placeholder param
+func searchVectors(param string) string {
+	return fmt.Sprintf(`to_tsvector('english', coalesce(%[1]s, '')), to_tsvector('vietnamese', coalesce(%[1]s, ''))`, param)
+}
+
+// searchable returns the plaintext the search vectors of a message are computed
+// from. Vectors would reveal the words of encrypted content, so they stay empty
+// when encryption is enabled and SearchChat answers errSearchSealed.
+func (h *Handler) searchable(content string) sql.NullString {
+	if h.cipher.Enabled() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: content, Valid: true}
+}
+
+// resolveSearchLanguage picks the search language from a locale or an
+// Accept-Language header value, falling back to english
+func resolveSearchLanguage(locale string) searchLanguage {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if len(locale) >= 2 {
+		if lang, ok := searchLanguages[locale[:2]]; ok {
+			return lang
+		}
+	}
+	return searchLanguages["en"]
+}
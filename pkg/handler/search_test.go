@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+	"github.com/lib/pq"
+)
+
+// searchedMessage is a row of the fake messages table of the search tests
+type searchedMessage struct {
+	userID         string
+	conversationID string
+	messageID      int64
+	content        string
+}
+
+// searchDB answers the search and headline queries from messages, matching
+// those of the user in $1 whose content contains the query. lang records the
+// text search config of the search query.
+func searchDB(messages []searchedMessage, lang *string) dbtest.Handler {
+	return func(q dbtest.Query) (dbtest.Result, error) {
+		switch {
+		case q.Has("websearch_to_tsquery", "LIMIT $3 OFFSET $4"):
+			for config := range map[string]bool{"english": true, "vietnamese": true} {
+				if q.Has(fmt.Sprintf("websearch_to_tsquery('%s', $2)", config)) {
+					*lang = config
+				}
+			}
+			var hits []searchedMessage
+			for _, m := range messages {
+				if m.userID == q.Args[0] && strings.Contains(m.content, q.Args[1].(string)) {
+					hits = append(hits, m)
+				}
+			}
+			res := dbtest.Result{Columns: []string{"type", "conversation_id", "conversation_name", "message_id", "role", "body", "encrypted", "rank", "timestamp", "total"}}
+			limit, offset := q.Args[2].(int64), q.Args[3].(int64)
+			for idx := offset; idx < offset+limit && idx < int64(len(hits)); idx++ {
+				m := hits[idx]
+				res.Rows = append(res.Rows, []driver.Value{"message", m.conversationID, "", m.messageID, "user", m.content, false, 0.5, "", int64(len(hits))})
+			}
+			return res, nil
+		case q.Has("ts_headline"):
+			var bodies pq.StringArray
+			if err := bodies.Scan(q.Args[1]); err != nil {
+				return dbtest.Result{}, err
+			}
+			res := dbtest.Result{Columns: []string{"snippet"}}
+			for _, body := range bodies {
+				res.Rows = append(res.Rows, []driver.Value{body})
+			}
+			return res, nil
+		}
+		return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+	}
+}
+
+func TestSearchChat(t *testing.T) {
+	messages := []searchedMessage{
+		{userID: owner, conversationID: "1", messageID: 1, content: "budget draft"},
+		{userID: owner, conversationID: "1", messageID: 2, content: "budget review"},
+		{userID: owner, conversationID: "2", messageID: 3, content: "budget final"},
+		{userID: stranger, conversationID: "3", messageID: 4, content: "budget of someone else"},
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptLanguage string
+		wantLang       string
+		wantMessages   []int
+		wantPage       int
+		wantPageSize   int
+		wantTotal      int
+	}{
+		{
+			name:         "english by default",
+			path:         "/search-chat?q=budget",
+			wantLang:     "english",
+			wantMessages: []int{1, 2, 3},
+			wantPage:     1,
+			wantPageSize: defaultSearchPageSize,
+			wantTotal:    3,
+		},
+		{
+			name:         "language parameter",
+			path:         "/search-chat?q=budget&lang=vi",
+			wantLang:     "vietnamese",
+			wantMessages: []int{1, 2, 3},
+			wantPage:     1,
+			wantPageSize: defaultSearchPageSize,
+			wantTotal:    3,
+		},
+		{
+			name:           "Accept-Language",
+			path:           "/search-chat?q=budget",
+			acceptLanguage: "vi-VN,vi;q=0.9",
+			wantLang:       "vietnamese",
+			wantMessages:   []int{1, 2, 3},
+			wantPage:       1,
+			wantPageSize:   defaultSearchPageSize,
+			wantTotal:      3,
+		},
+		{
+			name:           "language parameter over Accept-Language",
+			path:           "/search-chat?q=budget&lang=en",
+			acceptLanguage: "vi",
+			wantLang:       "english",
+			wantMessages:   []int{1, 2, 3},
+			wantPage:       1,
+			wantPageSize:   defaultSearchPageSize,
+			wantTotal:      3,
+		},
+		{
+			name:         "second page",
+			path:         "/search-chat?q=budget&page=2&page_size=2",
+			wantLang:     "english",
+			wantMessages: []int{3},
+			wantPage:     2,
+			wantPageSize: 2,
+			wantTotal:    3,
+		},
+		{
+			name:         "page past the end",
+			path:         "/search-chat?q=budget&page=3&page_size=2",
+			wantLang:     "english",
+			wantMessages: []int{},
+			wantPage:     3,
+			wantPageSize: 2,
+			wantTotal:    0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lang string
+			h, _ := newTestHandler(t, searchDB(messages, &lang))
+
+			req := newRequest(http.MethodGet, tt.path, "")
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			w := serve(h.SearchChat, "/search-chat", owner, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+
+			var res SearchChatResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if lang != tt.wantLang {
+				t.Errorf("text search config = %q, want %q", lang, tt.wantLang)
+			}
+			got := []int{}
+			for _, r := range res.Results {
+				if r.ConversationID == "3" {
+					t.Errorf("result %+v belongs to another user", r)
+				}
+				got = append(got, *r.MessageID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantMessages) {
+				t.Errorf("messages = %v, want %v", got, tt.wantMessages)
+			}
+			if res.Page != tt.wantPage || res.PageSize != tt.wantPageSize || res.Total != tt.wantTotal {
+				t.Errorf("page = %d, page size = %d, total = %d, want %d, %d, %d",
+					res.Page, res.PageSize, res.Total, tt.wantPage, tt.wantPageSize, tt.wantTotal)
+			}
+		})
+	}
+
+	t.Run("other user", func(t *testing.T) {
+		var lang string
+		h, _ := newTestHandler(t, searchDB(messages, &lang))
+
+		w := serve(h.SearchChat, "/search-chat", stranger, newRequest(http.MethodGet, "/search-chat?q=budget", ""))
+		var res SearchChatResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Results) != 1 || res.Results[0].ConversationID != "3" || res.Total != 1 {
+			t.Errorf("results = %+v, total = %d, want conversation 3 only", res.Results, res.Total)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	embedTimeout               = 30 * time.Second
+	embedBatchSize             = 32
+	defaultSemanticSearchLimit = 10
+	semanticSnippetLength      = 200
+)
+
+// errSemanticSearchDisabled is returned when no EMBEDDING_PROVIDER is configured
+var errSemanticSearchDisabled = gerr.E(http.StatusNotImplemented, "semantic search is not enabled")
+
+// SemanticSearchChatRequest holds the query string parameters of the semantic search endpoint
+type SemanticSearchChatRequest struct {
+	Query string `form:"q" json:"q" binding:"required"`
+	Limit int    `form:"limit" json:"limit" binding:"omitempty,min=1,max=50"`
+}
+
+// SemanticSearchResult is the closest message of a matching conversation
+type SemanticSearchResult struct {
+	ConversationID   string  `json:"conversation_id"`
+	ConversationName string  `json:"conversation_name"`
+	MessageID        int     `json:"message_id"`
+	Role             string  `json:"role"`
+	Snippet          string  `json:"snippet"`
+	Score            float64 `json:"score"`
+}
+
+// SemanticSearchChatResponse represents the closest conversations to a query
+type SemanticSearchChatResponse struct {
+	Success bool                   `json:"success"`
+	Message string                 `json:"message"`
+	Results []SemanticSearchResult `json:"results"`
+}
+
+// SemanticSearchChat finds the conversations closest in meaning to a question
+// @Summary Semantic search over conversations
+// @Description Embeds the query and returns the user's conversations whose messages are closest to it
+// @Tags chat
+// @Produce json
+// @Param q query string true "Natural language query"
+// @Param limit query int false "Maximum number of conversations (max 50)"
+// @Success 200 {object} SemanticSearchChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 500 {object} ErrorResponse "Internal Server Error"
+// @Failure 501 {object} ErrorResponse "Semantic search is not enabled"
+// @Router /semantic-search-chat [get]
+func (h *Handler) SemanticSearchChat(c *gin.Context) {
+	var req SemanticSearchChatRequest
+
+	if !h.embeddings.Enabled() {
+		h.handleError(c, errSemanticSearchDisabled)
+		return
+	}
+
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doSemanticSearchChat(c.Request.Context(), userID, req)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doSemanticSearchChat(ctx context.Context, userID string, req SemanticSearchChatRequest) (SemanticSearchChatResponse, error) {
+	if req.Limit == 0 {
+		req.Limit = defaultSemanticSearchLimit
+	}
+
+	matches, err := h.embeddings.Search(ctx, userID, req.Query, req.Limit)
+	if err != nil {
+		return SemanticSearchChatResponse{}, err
+	}
+
+	results := make([]SemanticSearchResult, 0, len(matches))
+	for _, m := range matches {
+		m.Content, err = h.cipher.Decrypt(ctx, userID, encryption.MessageContent(m.MessageID), m.Content, m.Encrypted)
+		if err != nil {
+			return SemanticSearchChatResponse{}, fmt.Errorf("could not decrypt match: %v", err)
+		}
+		results = append(results, SemanticSearchResult{
+			ConversationID:   m.ConversationID,
+			ConversationName: m.ConversationName,
+			MessageID:        m.MessageID,
+			Role:             m.Role,
+			Snippet:          truncate(m.Content, semanticSnippetLength),
+			Score:            m.Score,
+		})
+	}
+
+	message := "Conversations found"
+	if len(results) == 0 {
+		message = "No conversations found"
+	}
+
+	return SemanticSearchChatResponse{
+		Success: len(results) > 0,
+		Message: message,
+		Results: results,
+	}, nil
+}
+
+// embedMessage indexes a stored message of the user in the background so the chat request
+// does not wait on the embedding provider. Messages missed here are picked up
+// by the embed-backfill command.
+func (h *Handler) embedMessage(userID string, messageID int, content string) {
+	if !h.embeddings.Enabled() {
+		return
+	}
+	h.embedding.Add(1)
+	go func() {
+		defer h.embedding.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+		defer cancel()
+
+		if err := h.embeddings.IndexMessage(ctx, userID, messageID, content); err != nil {
+			h.log.Error("could not embed message:", err) //nolint:errcheck // Ignore unused function warning
+		}
+	}()
+}
+
+// embedMessages indexes many stored messages of the user in the background, in batches so
+// an import does not flood the embedding provider. Messages of a failed batch
+// and the ones after it are left to the embed-backfill command.
+func (h *Handler) embedMessages(userID string, messageIDs []int, contents []string) {
+	if !h.embeddings.Enabled() || len(messageIDs) == 0 {
+		return
+	}
+	h.embedding.Add(1)
+	go func() {
+		defer h.embedding.Done()
+		for start := 0; start < len(messageIDs); start += embedBatchSize {
+			end := start + embedBatchSize
+			if end > len(messageIDs) {
+				end = len(messageIDs)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+			err := h.embeddings.IndexMessages(ctx, userID, messageIDs[start:end], contents[start:end])
+			cancel()
+			if err != nil {
+				h.log.Error("could not embed messages:", err) //nolint:errcheck // Ignore unused function warning
+				return
+			}
+		}
+	}()
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
@@ -0,0 +1,488 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/Essen-Labs/bloom-be/pkg/encryption"
+	"github.com/Essen-Labs/bloom-be/pkg/importer"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+// activeShareFilter selects shares that are neither revoked nor expired
+const activeShareFilter = `s.revoked_at IS NULL AND (s.expires_at IS NULL OR s.expires_at > now())`
+
+// Share is a published snapshot of a conversation. Only a hash of the token is
+// stored, Token and Path are returned once, when the share is created.
+type Share struct {
+	ID             int        `json:"id"`
+	Token          string     `json:"token,omitempty"`
+	Path           string     `json:"path,omitempty"`
+	ConversationID *int       `json:"conversationId"` // Null once the original conversation is purged
+	Title          string     `json:"title"`
+	Model          string     `json:"model"`
+	MessageCount   int        `json:"messageCount"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+	RevokedAt      *time.Time `json:"revokedAt"`
+}
+
+// SharedMessage is a message of a share snapshot
+type SharedMessage struct {
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	Timestamp string `json:"timestamp"`
+	Model     string `json:"model,omitempty"`
+}
+
+// ShareChatRequest publishes a conversation, ExpiresInHours is optional
+type ShareChatRequest struct {
+	ConversationID string `json:"conversation_id" binding:"required"`
+	ExpiresInHours *int   `json:"expires_in_hours" binding:"omitempty,min=1,max=8760"`
+}
+
+// ShareChatResponse represents a created share
+type ShareChatResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Share   Share  `json:"share"`
+}
+
+// GetShareListResponse represents the shares of a user
+type GetShareListResponse struct {
+	Success bool    `json:"success"`
+	Message string  `json:"message"`
+	Shares  []Share `json:"shares"`
+}
+
+// GetSharedChatResponse represents a public share snapshot
+type GetSharedChatResponse struct {
+	Success   bool            `json:"success"`
+	Title     string          `json:"title"`
+	Model     string          `json:"model"`
+	CreatedAt time.Time       `json:"createdAt"`
+	ExpiresAt *time.Time      `json:"expiresAt"`
+	Messages  []SharedMessage `json:"messages"`
+}
+
+// ForkSharedChatResponse represents a conversation created from a share
+type ForkSharedChatResponse struct {
+	Success        bool   `json:"success"`
+	Message        string `json:"message"`
+	ConversationID string `json:"conversation_id"`
+}
+
+// ShareChat publishes a read-only snapshot of a conversation
+// @Summary Share a conversation
+// @Description Copies the conversation into an immutable snapshot reachable by anyone with its unguessable token, optionally expiring after expires_in_hours. The token is only returned in this response, only a hash of it is stored.
+// @Tags share
+// @Accept json
+// @Produce json
+// @Param request body ShareChatRequest true "Conversation and optional expiry"
+// @Success 200 {object} ShareChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Conversation not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /share-chat [post]
+func (h *Handler) ShareChat(c *gin.Context) {
+	var req ShareChatRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doShareChat(c.Request.Context(), h.actor(c), userID, req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doShareChat(ctx context.Context, actor audit.Actor, userID string, req ShareChatRequest) (res ShareChatResponse, err error) {
+	token, tokenHash, err := auth.NewToken()
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(err))
+	}
+
+	share := Share{Token: token, Path: sharePath(token)}
+	if req.ExpiresInHours != nil {
+		expiresAt := time.Now().Add(time.Duration(*req.ExpiresInHours) * time.Hour)
+		share.ExpiresAt = &expiresAt
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not begin transaction: %v", err)))
+	}
+
+	// Rollback the transaction if there's an error
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback() //nolint:errcheck // the panic is what matters
+			panic(p)      // Re-raise the panic
+		} else if err != nil {
+			tx.Rollback() //nolint:errcheck // the original error is returned
+		} else if err = tx.Commit(); err != nil {
+			err = gerr.E(500, gerr.Trace(fmt.Errorf("could not commit share: %v", err)))
+		} else {
+			h.recordAudit(audit.Entry{
+				Actor:      actor,
+				Action:     audit.ActionShareCreate,
+				TargetType: audit.TargetShare,
+				TargetID:   strconv.Itoa(share.ID),
+				After: map[string]interface{}{
+					"conversation_id": share.ConversationID,
+					"messages":        share.MessageCount,
+					"expires_at":      share.ExpiresAt,
+				},
+			})
+		}
+	}()
+
+	err = tx.QueryRow(`
+		INSERT INTO shared_conversations (token_hash, conversation_id, user_id, conversation_name, model, expires_at)
+		SELECT $1, c.id, c.user_id, c.conversation_name, c.model, $4
+		FROM conversations c
+		WHERE c.id = $2 AND c.user_id = $3 AND c.deleted_at IS NULL
+		RETURNING id, conversation_id, coalesce(conversation_name, ''), coalesce(model, ''), created_at`,
+		tokenHash, req.ConversationID, userID, share.ExpiresAt).
+		Scan(&share.ID, &share.ConversationID, &share.Title, &share.Model, &share.CreatedAt)
+	if err == sql.ErrNoRows {
+		return res, errConversationNotFound(req.ConversationID)
+	}
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not create share: %v", err)))
+	}
+
+	share.MessageCount, err = h.copySharedMessages(ctx, tx, userID, share.ID, req.ConversationID)
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(err))
+	}
+
+	return ShareChatResponse{
+		Success: true,
+		Message: fmt.Sprintf("Conversation with ID %s shared", req.ConversationID),
+		Share:   share,
+	}, nil
+}
+
+// copySharedMessages snapshots the messages of a conversation into a share,
+// content is sealed again for the snapshot row it is copied to
+func (h *Handler) copySharedMessages(ctx context.Context, tx *sql.Tx, userID string, shareID int, conversationID string) (int, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, role, content, content_encrypted, timestamp, model
+		FROM messages
+		WHERE conversation_id = $1
+		ORDER BY id ASC`, conversationID)
+	if err != nil {
+		return 0, fmt.Errorf("could not query messages to share: %v", err)
+	}
+	defer rows.Close()
+
+	type sharedRow struct {
+		id                              int
+		role, content, timestamp, model sql.NullString
+		encrypted                       bool
+	}
+	var messages []sharedRow
+	for rows.Next() {
+		var m sharedRow
+		if err := rows.Scan(&m.id, &m.role, &m.content, &m.encrypted, &m.timestamp, &m.model); err != nil {
+			return 0, fmt.Errorf("error scanning message to share: %v", err)
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating over messages to share: %v", err)
+	}
+	rows.Close()
+
+	for _, m := range messages {
+		var sharedID int
+		if err := tx.QueryRowContext(ctx, `SELECT nextval('shared_messages_id_seq')`).Scan(&sharedID); err != nil {
+			return 0, fmt.Errorf("could not allocate a shared message ID: %v", err)
+		}
+		if m.content.Valid {
+			plaintext, err := h.cipher.Decrypt(ctx, userID, encryption.MessageContent(m.id), m.content.String, m.encrypted)
+			if err != nil {
+				return 0, fmt.Errorf("could not decrypt message to share: %v", err)
+			}
+			m.content.String, m.encrypted, err = h.cipher.Encrypt(ctx, userID, encryption.SharedMessageContent(sharedID), plaintext)
+			if err != nil {
+				return 0, fmt.Errorf("could not encrypt shared message: %v", err)
+			}
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO shared_messages (id, share_id, role, content, content_encrypted, timestamp, model)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			sharedID, shareID, m.role, m.content, m.encrypted, m.timestamp, m.model)
+		if err != nil {
+			return 0, fmt.Errorf("could not copy message to share: %v", err)
+		}
+	}
+	return len(messages), nil
+}
+
+// GetShareList lists the shares created by the user
+// @Summary List shares
+// @Description Lists the user's shares, including revoked and expired ones, without their tokens
+// @Tags share
+// @Produce json
+// @Success 200 {object} GetShareListResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /get-share-list [get]
+func (h *Handler) GetShareList(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doGetShareList(userID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetShareList(userID string) (GetShareListResponse, error) {
+	rows, err := h.reader().Query(`
+		SELECT s.id, s.conversation_id, coalesce(s.conversation_name, ''), coalesce(s.model, ''),
+			(SELECT COUNT(*) FROM shared_messages m WHERE m.share_id = s.id),
+			s.created_at, s.expires_at, s.revoked_at
+		FROM shared_conversations s
+		WHERE s.user_id = $1
+		ORDER BY s.created_at DESC`, userID)
+	if err != nil {
+		return GetShareListResponse{}, fmt.Errorf("error querying shares: %v", err)
+	}
+	defer rows.Close()
+
+	shares := []Share{}
+	for rows.Next() {
+		var share Share
+		if err := rows.Scan(&share.ID, &share.ConversationID, &share.Title, &share.Model,
+			&share.MessageCount, &share.CreatedAt, &share.ExpiresAt, &share.RevokedAt); err != nil {
+			return GetShareListResponse{}, fmt.Errorf("error scanning share: %v", err)
+		}
+		shares = append(shares, share)
+	}
+
+	if err := rows.Err(); err != nil {
+		return GetShareListResponse{}, fmt.Errorf("error iterating over shares: %v", err)
+	}
+
+	return GetShareListResponse{
+		Success: len(shares) > 0,
+		Message: fmt.Sprintf("Found %d shares", len(shares)),
+		Shares:  shares,
+	}, nil
+}
+
+// RevokeShare makes a share unreachable
+// @Summary Revoke a share
+// @Tags share
+// @Produce json
+// @Param share_id path int true "Share ID"
+// @Success 200 {object} organizeChatResponse
+// @Failure 404 {object} ErrorResponse "Share not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /revoke-share/{share_id} [delete]
+func (h *Handler) RevokeShare(c *gin.Context) {
+	id := c.Param("share_id")
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doRevokeShare(h.actor(c), userID, id)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doRevokeShare(actor audit.Actor, userID, id string) (organizeChatResponse, error) {
+	if _, err := strconv.Atoi(id); err != nil {
+		return organizeChatResponse{}, errShareNotFound()
+	}
+
+	var conversationID sql.NullInt64
+	err := h.db.QueryRow(`
+		UPDATE shared_conversations SET revoked_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+		RETURNING conversation_id`, id, userID).Scan(&conversationID)
+	if err == sql.ErrNoRows {
+		return organizeChatResponse{}, errShareNotFound()
+	}
+	if err != nil {
+		return organizeChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not revoke share: %v", err)))
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionShareRevoke,
+		TargetType: audit.TargetShare,
+		TargetID:   id,
+		Before:     map[string]interface{}{"conversation_id": conversationID.Int64},
+	})
+
+	return organizeChatResponse{
+		Success: true,
+		Message: "Share revoked",
+	}, nil
+}
+
+// GetSharedChat returns a share snapshot, it does not require a user
+// @Summary Read a shared conversation
+// @Description Returns the snapshot behind a share token. Revoked and expired shares are reported as not found
+// @Tags share
+// @Produce json
+// @Param token path string true "Share token"
+// @Success 200 {object} GetSharedChatResponse
+// @Failure 404 {object} ErrorResponse "Share not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /shared/{token} [get]
+func (h *Handler) GetSharedChat(c *gin.Context) {
+	token := c.Param("token")
+
+	res, err := h.doGetSharedChat(c.Request.Context(), token)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetSharedChat(ctx context.Context, token string) (GetSharedChatResponse, error) {
+	res := GetSharedChatResponse{Success: true}
+
+	var shareID int
+	var ownerID string
+	err := h.db.QueryRowContext(ctx, `
+		SELECT s.id, s.user_id, coalesce(s.conversation_name, ''), coalesce(s.model, ''), s.created_at, s.expires_at
+		FROM shared_conversations s
+		WHERE s.token_hash = $1 AND `+activeShareFilter, auth.HashToken(token)).
+		Scan(&shareID, &ownerID, &res.Title, &res.Model, &res.CreatedAt, &res.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return res, errShareNotFound()
+	}
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not query share: %v", err)))
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id, coalesce(role, ''), coalesce(content, ''), content_encrypted, coalesce(timestamp, ''), coalesce(model, '')
+		FROM shared_messages
+		WHERE share_id = $1
+		ORDER BY id ASC`, shareID)
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not query shared messages: %v", err)))
+	}
+	defer rows.Close()
+
+	res.Messages = []SharedMessage{}
+	for rows.Next() {
+		var message SharedMessage
+		var sharedID int
+		var encrypted bool
+		if err := rows.Scan(&sharedID, &message.Role, &message.Content, &encrypted, &message.Timestamp, &message.Model); err != nil {
+			return res, gerr.E(500, gerr.Trace(fmt.Errorf("error scanning shared message: %v", err)))
+		}
+		// Snapshots are sealed with the key of the owner
+		message.Content, err = h.cipher.Decrypt(ctx, ownerID, encryption.SharedMessageContent(sharedID), message.Content, encrypted)
+		if err != nil {
+			return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not decrypt shared message: %v", err)))
+		}
+		res.Messages = append(res.Messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("error iterating over shared messages: %v", err)))
+	}
+	return res, nil
+}
+
+// ForkSharedChat copies a share snapshot into a new conversation of the user
+// @Summary Continue a shared conversation
+// @Description Creates a conversation owned by the user with the messages of the share, so it can be continued
+// @Tags share
+// @Produce json
+// @Param token path string true "Share token"
+// @Success 200 {object} ForkSharedChatResponse
+// @Failure 404 {object} ErrorResponse "Share not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /fork-shared-chat/{token} [post]
+func (h *Handler) ForkSharedChat(c *gin.Context) {
+	token := c.Param("token")
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doForkSharedChat(c.Request.Context(), userID, token)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doForkSharedChat(ctx context.Context, userID, token string) (ForkSharedChatResponse, error) {
+	shared, err := h.doGetSharedChat(ctx, token)
+	if err != nil {
+		return ForkSharedChatResponse{}, err
+	}
+
+	conversation := importer.Conversation{
+		Source:    "share",
+		Title:     shared.Title,
+		Model:     shared.Model,
+		CreatedAt: time.Now(),
+	}
+	for _, m := range shared.Messages {
+		conversation.Messages = append(conversation.Messages, importer.Message{
+			Role:      m.Role,
+			Content:   m.Content,
+			CreatedAt: parseUnixTime(m.Timestamp),
+			Model:     m.Model,
+		})
+	}
+
+	imported, err := h.ImportConversations(ctx, userID, importer.Result{Conversations: []importer.Conversation{conversation}})
+	if err != nil {
+		return ForkSharedChatResponse{}, gerr.E(500, gerr.Trace(err))
+	}
+
+	conversationID := imported.Imported[0].ConversationID
+	return ForkSharedChatResponse{
+		Success:        true,
+		Message:        fmt.Sprintf("Shared conversation copied to conversation %s", conversationID),
+		ConversationID: conversationID,
+	}, nil
+}
+
+func sharePath(token string) string {
+	return "/shared/" + token
+}
+
+func errShareNotFound() error {
+	return gerr.E(http.StatusNotFound, "share not found")
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+)
+
+// storedShare is a row of the fake shared_conversations table
+type storedShare struct {
+	tokenHash string
+	name      string
+	expiresAt *time.Time
+	revoked   bool
+	messages  []string
+}
+
+// shareStore is a fake database holding conversation 1 of owner and its shares
+type shareStore struct {
+	mu       sync.Mutex
+	name     string
+	messages []string
+	shares   []*storedShare
+}
+
+func (s *shareStore) handle(q dbtest.Query) (dbtest.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case q.Has("INSERT INTO audit_log"):
+		return dbtest.Result{RowsAffected: 1}, nil
+	case q.Has("INSERT INTO shared_conversations"):
+		res := dbtest.Result{Columns: []string{"id", "conversation_id", "conversation_name", "model", "created_at"}}
+		if q.Args[1] != "1" || q.Args[2] != owner {
+			return res, nil
+		}
+		share := &storedShare{tokenHash: q.Args[0].(string), name: s.name}
+		if expiresAt, ok := q.Args[3].(time.Time); ok {
+			share.expiresAt = &expiresAt
+		}
+		s.shares = append(s.shares, share)
+		res.Rows = [][]driver.Value{{int64(len(s.shares)), int64(1), s.name, "gpt", time.Now()}}
+		return res, nil
+	case q.Has("SELECT id, role, content, content_encrypted, timestamp, model FROM messages"):
+		res := dbtest.Result{Columns: []string{"id", "role", "content", "content_encrypted", "timestamp", "model"}}
+		for idx, content := range s.messages {
+			res.Rows = append(res.Rows, []driver.Value{int64(idx + 1), "user", content, false, "", ""})
+		}
+		return res, nil
+	case q.Has("SELECT nextval('shared_messages_id_seq')"):
+		return dbtest.Result{Columns: []string{"nextval"}, Rows: [][]driver.Value{{int64(1)}}}, nil
+	case q.Has("INSERT INTO shared_messages"):
+		share := s.shares[q.Args[1].(int64)-1]
+		share.messages = append(share.messages, q.Args[3].(string))
+		return dbtest.Result{RowsAffected: 1}, nil
+	case q.Has("UPDATE conversations c SET conversation_name = $1"):
+		previous := s.name
+		s.name = q.Args[0].(string)
+		return dbtest.Result{Columns: []string{"previous", "name", "version"}, Rows: [][]driver.Value{{previous, s.name, int64(2)}}}, nil
+	case q.Has("UPDATE shared_conversations SET revoked_at = now()", "WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL"):
+		res := dbtest.Result{Columns: []string{"conversation_id"}}
+		id, _ := strconv.Atoi(q.Args[0].(string))
+		if id >= 1 && id <= len(s.shares) && q.Args[1] == owner && !s.shares[id-1].revoked {
+			s.shares[id-1].revoked = true
+			res.Rows = [][]driver.Value{{int64(1)}}
+		}
+		return res, nil
+	case q.Has("FROM shared_conversations s", "WHERE s.token_hash = $1 AND "+activeShareFilter):
+		res := dbtest.Result{Columns: []string{"id", "user_id", "conversation_name", "model", "created_at", "expires_at"}}
+		for idx, share := range s.shares {
+			if share.tokenHash != q.Args[0] || share.revoked || (share.expiresAt != nil && !share.expiresAt.After(time.Now())) {
+				continue
+			}
+			var expiresAt driver.Value
+			if share.expiresAt != nil {
+				expiresAt = *share.expiresAt
+			}
+			res.Rows = [][]driver.Value{{int64(idx + 1), owner, share.name, "gpt", time.Now(), expiresAt}}
+		}
+		return res, nil
+	case q.Has("FROM shared_messages", "WHERE share_id = $1"):
+		res := dbtest.Result{Columns: []string{"id", "role", "content", "content_encrypted", "timestamp", "model"}}
+		for idx, content := range s.shares[q.Args[0].(int64)-1].messages {
+			res.Rows = append(res.Rows, []driver.Value{int64(idx + 1), "user", content, false, "", ""})
+		}
+		return res, nil
+	}
+	return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+}
+
+func TestShare(t *testing.T) {
+	store := &shareStore{name: "Plan", messages: []string{"first draft", "second draft"}}
+	h, _ := newTestHandler(t, store.handle)
+
+	share := func(body string) Share {
+		t.Helper()
+		w := serve(h.ShareChat, "/share-chat", owner, newRequest(http.MethodPost, "/share-chat", body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("share status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		var res ShareChatResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res.Share
+	}
+	getShared := func(token string) (int, GetSharedChatResponse) {
+		t.Helper()
+		w := serve(h.GetSharedChat, "/shared/:token", "", newRequest(http.MethodGet, "/shared/"+token, ""))
+		var res GetSharedChatResponse
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, res
+	}
+
+	active := share(`{"conversation_id": "1"}`)
+	expiring := share(`{"conversation_id": "1", "expires_in_hours": 1}`)
+	if active.Token == "" || active.Path != "/shared/"+active.Token {
+		t.Fatalf("share = %+v, want its token and path", active)
+	}
+	for idx, stored := range store.shares {
+		if stored.tokenHash == active.Token || stored.tokenHash == expiring.Token {
+			t.Errorf("share %d token stored in plaintext", idx+1)
+		}
+	}
+	if store.shares[0].tokenHash != auth.HashToken(active.Token) {
+		t.Errorf("stored token = %q, want the hash of the token", store.shares[0].tokenHash)
+	}
+
+	t.Run("snapshot unchanged after edit", func(t *testing.T) {
+		w := serve(h.EditChat, "/edit-chat", owner,
+			newRequest(http.MethodPost, "/edit-chat", `{"conversation_id": "1", "new_name": "Renamed"}`))
+		if w.Code != http.StatusOK {
+			t.Fatalf("edit status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		store.mu.Lock()
+		store.messages[0] = "rewritten draft"
+		store.messages = append(store.messages, "later message")
+		store.mu.Unlock()
+
+		code, res := getShared(active.Token)
+		if code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+		if res.Title != "Plan" {
+			t.Errorf("title = %q, want %q", res.Title, "Plan")
+		}
+		var contents []string
+		for _, m := range res.Messages {
+			contents = append(contents, m.Content)
+		}
+		if fmt.Sprint(contents) != fmt.Sprint([]string{"first draft", "second draft"}) {
+			t.Errorf("messages = %q, want the messages as shared", contents)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		if code, _ := getShared(expiring.Token); code != http.StatusOK {
+			t.Fatalf("status before expiry = %d, want %d", code, http.StatusOK)
+		}
+		store.mu.Lock()
+		expired := time.Now().Add(-time.Minute)
+		store.shares[1].expiresAt = &expired
+		store.mu.Unlock()
+		if code, _ := getShared(expiring.Token); code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		path := fmt.Sprintf("/revoke-share/%d", active.ID)
+		w := serve(h.RevokeShare, "/revoke-share/:share_id", owner, newRequest(http.MethodDelete, path, ""))
+		if w.Code != http.StatusOK {
+			t.Fatalf("revoke status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		if code, _ := getShared(active.Token); code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("stored hash as token", func(t *testing.T) {
+		if code, _ := getShared(store.shares[0].tokenHash); code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", code, http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Tag is a user defined label that can be put on many conversations
+type Tag struct {
+	ID                int    `json:"id"`
+	Name              string `json:"name"`
+	ConversationCount int    `json:"conversationCount"`
+}
+
+// GetTagListResponse represents the tags of a user
+type GetTagListResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Tags    []Tag  `json:"tags"`
+}
+
+// TagChatRequest adds or removes tags on a conversation, tags are created on first use
+type TagChatRequest struct {
+	ConversationID string   `json:"conversation_id" binding:"required"`
+	Tags           []string `json:"tags" binding:"required,min=1,dive,required,max=255"`
+}
+
+// GetTagList lists the user's tags
+// @Summary List tags
+// @Description Lists the user's tags with the number of conversations using each
+// @Tags organize
+// @Produce json
+// @Success 200 {object} GetTagListResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /get-tag-list [get]
+func (h *Handler) GetTagList(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doGetTagList(userID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetTagList(userID string) (GetTagListResponse, error) {
+	rows, err := h.reader().Query(`
+		SELECT t.id, t.name,
+			(SELECT COUNT(*) FROM conversation_tags ct
+				JOIN conversations c ON c.id = ct.conversation_id
+				WHERE ct.tag_id = t.id AND c.deleted_at IS NULL)
+		FROM tags t
+		WHERE t.user_id = $1
+		ORDER BY t.name ASC`, userID)
+	if err != nil {
+		return GetTagListResponse{}, fmt.Errorf("error querying tags: %v", err)
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.ConversationCount); err != nil {
+			return GetTagListResponse{}, fmt.Errorf("error scanning tag: %v", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return GetTagListResponse{}, fmt.Errorf("error iterating over tags: %v", err)
+	}
+
+	return GetTagListResponse{
+		Success: len(tags) > 0,
+		Message: fmt.Sprintf("Found %d tags", len(tags)),
+		Tags:    tags,
+	}, nil
+}
+
+// TagChat adds tags to a conversation
+// @Summary Tag a conversation
+// @Description Adds tags to a conversation, unknown tag names are created
+// @Tags organize
+// @Accept json
+// @Produce json
+// @Param request body TagChatRequest true "Conversation and tag names"
+// @Success 200 {object} organizeChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Conversation not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /tag-chat [post]
+func (h *Handler) TagChat(c *gin.Context) {
+	var req TagChatRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doTagChat(userID, req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doTagChat(userID string, req TagChatRequest) (res organizeChatResponse, err error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return res, gerr.E(500, gerr.Trace(fmt.Errorf("could not begin transaction: %v", err)))
+	}
+
+	// Rollback the transaction if there's an error
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback() //nolint:errcheck // the panic is what matters
+			panic(p)      // Re-raise the panic
+		} else if err != nil {
+			tx.Rollback() //nolint:errcheck // the original error is returned
+		} else if err = tx.Commit(); err != nil {
+			err = gerr.E(500, gerr.Trace(fmt.Errorf("could not commit transaction: %v", err)))
+		}
+	}()
+
+	if err = addConversationTags(tx, userID, req.ConversationID, req.Tags); err != nil {
+		return res, err
+	}
+
+	return organizeChatResponse{
+		Success: true,
+		Message: fmt.Sprintf("Tagged conversation with ID %s", req.ConversationID),
+	}, nil
+}
+
+// addConversationTags puts tags on a conversation owned by the user, creating missing tags
+func addConversationTags(q querier, userID, conversationID string, names []string) error {
+	if err := checkConversationOwner(q, userID, conversationID); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		// The no-op update makes RETURNING yield the id of an existing tag
+		var tagID int
+		err := q.QueryRow(`
+			INSERT INTO tags (user_id, name)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id, name) DO UPDATE SET name = EXCLUDED.name
+			RETURNING id`, userID, name).Scan(&tagID)
+		if err != nil {
+			return gerr.E(500, gerr.Trace(fmt.Errorf("could not create tag %s: %v", name, err)))
+		}
+
+		_, err = q.Exec(`
+			INSERT INTO conversation_tags (conversation_id, tag_id)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, conversationID, tagID)
+		if err != nil {
+			return gerr.E(500, gerr.Trace(fmt.Errorf("could not tag conversation: %v", err)))
+		}
+	}
+	return nil
+}
+
+// UntagChat removes tags from a conversation
+// @Summary Untag a conversation
+// @Description Removes tags from a conversation, the tags themselves are kept
+// @Tags organize
+// @Accept json
+// @Produce json
+// @Param request body TagChatRequest true "Conversation and tag names"
+// @Success 200 {object} organizeChatResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 404 {object} ErrorResponse "Conversation not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /untag-chat [post]
+func (h *Handler) UntagChat(c *gin.Context) {
+	var req TagChatRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doUntagChat(userID, req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doUntagChat(userID string, req TagChatRequest) (organizeChatResponse, error) {
+	if err := checkConversationOwner(h.db, userID, req.ConversationID); err != nil {
+		return organizeChatResponse{}, err
+	}
+
+	result, err := h.db.Exec(`
+		DELETE FROM conversation_tags ct
+		USING tags t
+		WHERE ct.tag_id = t.id AND ct.conversation_id = $1 AND t.user_id = $2 AND t.name = ANY($3)`,
+		req.ConversationID, userID, pq.Array(req.Tags))
+	if err != nil {
+		return organizeChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not untag conversation: %v", err)))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return organizeChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not check rows affected: %v", err)))
+	}
+
+	return organizeChatResponse{
+		Success: true,
+		Message: fmt.Sprintf("Removed %d tags from conversation with ID %s", rowsAffected, req.ConversationID),
+	}, nil
+}
+
+// DeleteTag deletes a tag and removes it from every conversation
+// @Summary Delete a tag
+// @Tags organize
+// @Produce json
+// @Param tag_id path int true "Tag ID"
+// @Success 200 {object} organizeChatResponse
+// @Failure 404 {object} ErrorResponse "Tag not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /delete-tag/{tag_id} [delete]
+func (h *Handler) DeleteTag(c *gin.Context) {
+	tagID := c.Param("tag_id")
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doDeleteTag(h.actor(c), userID, tagID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doDeleteTag(actor audit.Actor, userID, tagID string) (organizeChatResponse, error) {
+	var name string
+	err := h.db.QueryRow(`DELETE FROM tags WHERE id = $1 AND user_id = $2 RETURNING name`, tagID, userID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return organizeChatResponse{}, gerr.E(http.StatusNotFound, fmt.Sprintf("tag with id %s not found", tagID))
+	}
+	if err != nil {
+		return organizeChatResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not delete tag: %v", err)))
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionTagDelete,
+		TargetType: audit.TargetTag,
+		TargetID:   tagID,
+		Before:     map[string]string{"name": name},
+	})
+
+	return organizeChatResponse{
+		Success: true,
+		Message: fmt.Sprintf("Tag with ID %s deleted", tagID),
+	}, nil
+}
@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/audit"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// errConversationTrashed is returned when a message is sent to a conversation
+// in the trash
+var errConversationTrashed = gerr.E(http.StatusConflict, "conversation is in the trash, restore it to continue")
+
+// TrashedConversation is a conversation waiting in the trash to be purged
+type TrashedConversation struct {
+	Conversation
+	DeletedAt time.Time `json:"deletedAt"` // Time the conversation was moved to the trash
+	PurgeAt   time.Time `json:"purgeAt"`   // Time the conversation will be permanently deleted
+}
+
+// GetTrashListResponse represents the conversations in the user's trash
+type GetTrashListResponse struct {
+	Success       bool                  `json:"success"`
+	Message       string                `json:"message"`
+	Conversations []TrashedConversation `json:"conversations"`
+}
+
+type trashActionResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// GetTrashList lists the conversations in the trash
+// @Summary List trashed conversations
+// @Description Lists the user's deleted conversations with the time they will be purged
+// @Tags trash
+// @Produce json
+// @Success 200 {object} GetTrashListResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /get-trash-list [get]
+func (h *Handler) GetTrashList(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doGetTrashList(userID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetTrashList(userID string) (GetTrashListResponse, error) {
+	rows, err := h.reader().Query(`
+		SELECT `+conversationColumns+`, c.deleted_at
+		FROM conversations c
+		WHERE c.user_id = $1 AND c.deleted_at IS NOT NULL
+		ORDER BY c.deleted_at DESC`, userID)
+	if err != nil {
+		return GetTrashListResponse{}, fmt.Errorf("error querying trashed conversations: %v", err)
+	}
+	defer rows.Close()
+
+	retention := h.cfg.GetTrashRetention()
+	conversations := []TrashedConversation{}
+	for rows.Next() {
+		var conversation TrashedConversation
+		var err error
+		conversation.Conversation, err = scanConversation(rows, &conversation.DeletedAt)
+		if err != nil {
+			return GetTrashListResponse{}, fmt.Errorf("error scanning trashed conversation: %v", err)
+		}
+		conversation.PurgeAt = conversation.DeletedAt.Add(retention)
+		conversations = append(conversations, conversation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return GetTrashListResponse{}, fmt.Errorf("error iterating over trashed conversations: %v", err)
+	}
+
+	message := "Trashed conversations found"
+	if len(conversations) == 0 {
+		message = "Trash is empty"
+	}
+
+	return GetTrashListResponse{
+		Success:       len(conversations) > 0,
+		Message:       message,
+		Conversations: conversations,
+	}, nil
+}
+
+// RestoreChatById moves a conversation out of the trash
+// @Summary Restore a trashed conversation
+// @Description Restores a conversation from the trash with all of its messages
+// @Tags trash
+// @Produce json
+// @Param conversation_id path string true "Conversation ID"
+// @Success 200 {object} trashActionResponse
+// @Failure 404 {object} ErrorResponse "Conversation not found in trash"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /restore-chat/{conversation_id} [post]
+func (h *Handler) RestoreChatById(c *gin.Context) {
+	conversationID := c.Param("conversation_id")
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doRestoreChatByID(h.actor(c), userID, conversationID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doRestoreChatByID(actor audit.Actor, userID, conversationID string) (trashActionResponse, error) {
+	result, err := h.db.Exec(`
+		UPDATE conversations SET deleted_at = NULL
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL`, conversationID, userID)
+	if err != nil {
+		return trashActionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not restore conversation: %v", err)))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return trashActionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not check rows affected: %v", err)))
+	}
+	if rowsAffected == 0 {
+		return trashActionResponse{}, gerr.E(http.StatusNotFound, fmt.Sprintf("conversation with id %s not found in trash", conversationID))
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionConversationRestore,
+		TargetType: audit.TargetConversation,
+		TargetID:   conversationID,
+	})
+
+	return trashActionResponse{
+		Success: true,
+		Message: fmt.Sprintf("Conversation with ID %s restored", conversationID),
+	}, nil
+}
+
+// PurgeChatById permanently deletes a trashed conversation
+// @Summary Permanently delete a trashed conversation
+// @Description Permanently deletes a conversation that is in the trash, with all of its messages
+// @Tags trash
+// @Produce json
+// @Param conversation_id path string true "Conversation ID"
+// @Success 200 {object} trashActionResponse
+// @Failure 404 {object} ErrorResponse "Conversation not found in trash"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /purge-chat/{conversation_id} [delete]
+func (h *Handler) PurgeChatById(c *gin.Context) {
+	conversationID := c.Param("conversation_id")
+
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doPurgeChatByID(h.actor(c), userID, conversationID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doPurgeChatByID(actor audit.Actor, userID, conversationID string) (trashActionResponse, error) {
+	// Messages are removed by the ON DELETE CASCADE of messages.conversation_id
+	var name sql.NullString
+	err := h.db.QueryRow(`
+		DELETE FROM conversations
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL
+		RETURNING conversation_name`, conversationID, userID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return trashActionResponse{}, gerr.E(http.StatusNotFound, fmt.Sprintf("conversation with id %s not found in trash", conversationID))
+	}
+	if err != nil {
+		return trashActionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not purge conversation: %v", err)))
+	}
+
+	h.recordAudit(audit.Entry{
+		Actor:      actor,
+		Action:     audit.ActionConversationPurge,
+		TargetType: audit.TargetConversation,
+		TargetID:   conversationID,
+		Before:     map[string]string{"conversation_name": name.String},
+	})
+
+	return trashActionResponse{
+		Success: true,
+		Message: fmt.Sprintf("Conversation with ID %s permanently deleted", conversationID),
+	}, nil
+}
+
+// EmptyTrash permanently deletes every conversation in the trash
+// @Summary Empty the trash
+// @Description Permanently deletes all of the user's trashed conversations
+// @Tags trash
+// @Produce json
+// @Success 200 {object} trashActionResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /empty-trash [delete]
+func (h *Handler) EmptyTrash(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	res, err := h.doEmptyTrash(h.actor(c), userID)
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doEmptyTrash(actor audit.Actor, userID string) (trashActionResponse, error) {
+	conversationIDs, err := h.deleteTrashed(context.Background(), `user_id = $1`, userID)
+	if err != nil {
+		return trashActionResponse{}, fmt.Errorf("could not empty trash: %v", err)
+	}
+	rowsAffected := len(conversationIDs)
+
+	if rowsAffected > 0 {
+		h.recordAudit(audit.Entry{
+			Actor:      actor,
+			Action:     audit.ActionTrashEmpty,
+			TargetType: audit.TargetUser,
+			TargetID:   userID,
+			Before:     map[string][]int64{"conversation_ids": conversationIDs},
+		})
+	}
+
+	return trashActionResponse{
+		Success: true,
+		Message: fmt.Sprintf("Permanently deleted %d conversations", rowsAffected),
+	}, nil
+}
+
+// PurgeExpiredTrash permanently deletes conversations that stayed in the trash
+// longer than the configured retention period
+func (h *Handler) PurgeExpiredTrash(ctx context.Context) error {
+	cutoff := time.Now().Add(-h.cfg.GetTrashRetention())
+	conversationIDs, err := h.deleteTrashed(ctx, `deleted_at < $1`, cutoff)
+	if err != nil {
+		return fmt.Errorf("could not purge expired trash: %v", err)
+	}
+
+	if len(conversationIDs) > 0 {
+		h.log.Info("purged ", len(conversationIDs), " conversations from the trash") //nolint:errcheck // Ignore unused function warning
+		h.recordAudit(audit.Entry{
+			Actor:      audit.System(),
+			Action:     audit.ActionTrashPurgeExpired,
+			TargetType: audit.TargetConversation,
+			Before:     map[string][]int64{"conversation_ids": conversationIDs},
+		})
+	}
+	return nil
+}
+
+// deleteTrashed permanently deletes the trashed conversations matching
+// condition, a constant using $1 for arg, and returns their ids
+func (h *Handler) deleteTrashed(ctx context.Context, condition string, arg interface{}) ([]int64, error) {
+	var conversationIDs []int64
+	err := h.db.QueryRowContext(ctx, `
+		WITH deleted AS (
+			DELETE FROM conversations
+			WHERE deleted_at IS NOT NULL AND `+condition+`
+			RETURNING id
+		)
+		SELECT coalesce(array_agg(id ORDER BY id), '{}') FROM deleted`, arg).Scan(pq.Array(&conversationIDs))
+	return conversationIDs, err
+}
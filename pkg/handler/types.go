@@ -1,12 +1,42 @@
 package handler
 
+import "github.com/lib/pq"
+
 // Conversation struct represents a conversation with an array of messages
 type Conversation struct {
-	ID               string `gorm:"primaryKey;autoIncrement"` // Unique ID for the conversation
-	Model            string `json:"model"`                    // Model used for the conversation
-	ConversationName string `json:"conversationName"`         // Name of the conversation
-	UserID           string `json:"userID"`                   // User ID associated with the conversation
-	CreatedAt        string `json:"createdAt"`                // Time the conversation was created
+	ID               string   `gorm:"primaryKey;autoIncrement"` // Unique ID for the conversation
+	Model            string   `json:"model"`                    // Model used for the conversation
+	ConversationName string   `json:"conversationName"`         // Name of the conversation
+	UserID           string   `json:"userID"`                   // User ID associated with the conversation
+	CreatedAt        string   `json:"createdAt"`                // Time the conversation was created
+	Pinned           bool     `json:"pinned"`                   // Pinned conversations are listed first
+	Archived         bool     `json:"archived"`                 // Archived conversations are hidden from the default list
+	FolderID         *int     `json:"folderID"`                 // Folder holding the conversation, if any
+	Tags             []string `json:"tags"`                     // Names of the tags on the conversation
+	Version          int      `json:"version"`                  // Incremented by every change, sent as ETag
+}
+
+// conversationColumns selects a conversation aliased as c in the order read by scanConversation
+const conversationColumns = `c.id, coalesce(c.model, ''), coalesce(c.conversation_name, ''), coalesce(c.user_id, ''),
+	coalesce(c.created_at, ''), c.pinned, c.archived, c.folder_id,
+	coalesce((SELECT array_agg(t.name ORDER BY t.name) FROM conversation_tags ct
+		JOIN tags t ON t.id = ct.tag_id WHERE ct.conversation_id = c.id), '{}'), c.version`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanConversation reads a row selected with conversationColumns, followed by any extra columns
+func scanConversation(row rowScanner, extra ...interface{}) (Conversation, error) {
+	var conversation Conversation
+	dest := []interface{}{
+		&conversation.ID, &conversation.Model, &conversation.ConversationName, &conversation.UserID,
+		&conversation.CreatedAt, &conversation.Pinned, &conversation.Archived, &conversation.FolderID,
+		pq.Array(&conversation.Tags), &conversation.Version,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return conversation, err
 }
 
 type Message struct {
@@ -15,6 +45,7 @@ type Message struct {
 	Role           string `json:"role"`
 	Content        string `json:"content"`
 	Timestamp      string `json:"timestamp"`
+	Feedback       *int   `json:"feedback,omitempty"` // Score the user gave to an assistant message
 }
 
 // ErrorResponse represents the structure for error messages
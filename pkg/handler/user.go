@@ -1,15 +1,91 @@
 package handler
 
 import (
+	"database/sql"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/constant"
+	"github.com/dwarvesf/gerr"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// sessionCookie holds the refresh token of browsers
+const sessionCookie = "session"
+
+// anonymousCookie holds the id of anonymous visitors, signed with the access
+// token keys
+const anonymousCookie = "user_id"
+
+// Authentication errors, their messages are translated
+var (
+	errInvalidCredentials  = gerr.E(http.StatusUnauthorized, "invalid username or password")
+	errInvalidRefreshToken = gerr.E(http.StatusUnauthorized, "refresh token is invalid or expired")
+)
+
+// User is a user account, or an anonymous visitor
+type User struct {
+	ID                  string     `json:"id"`
+	Username            string     `json:"username"`
+	Email               string     `json:"email,omitempty"`
+	EmailVerified       bool       `json:"emailVerified,omitempty"`
+	Anonymous           bool       `json:"anonymous"`
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt,omitempty"`
+	CreatedAt           time.Time  `json:"createdAt"`
+}
+
+// SessionResponse represents the user and the tokens of a signup, login or refresh
+type SessionResponse struct {
+	Success               bool      `json:"success"`
+	Message               string    `json:"message"`
+	User                  User      `json:"user"`
+	AccessToken           string    `json:"accessToken"`
+	AccessTokenExpiresAt  time.Time `json:"accessTokenExpiresAt"`
+	RefreshToken          string    `json:"refreshToken"`
+	RefreshTokenExpiresAt time.Time `json:"refreshTokenExpiresAt"`
+}
+
+// RefreshTokenRequest carries the refresh token of clients not using the session cookie
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// CurrentUserResponse represents the user of the current session
+type CurrentUserResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	User    User   `json:"user"`
+}
+
+// LogoutResponse represents the result of a logout
+type LogoutResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
 // Signup create a user
+// @Summary Sign up
+// @Description Creates a user account and logs it in, taking over the conversations of the anonymous visitor. The refresh token is returned and set as the session cookie. When an email is given, a link verifying it is sent.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body signupRequest true "Username, password and optional email"
+// @Success 200 {object} SessionResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 403 {object} ErrorResponse "Password login is disabled"
+// @Failure 409 {object} ErrorResponse "Username already taken"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /signup [post]
 func (h *Handler) Signup(c *gin.Context) {
+	if !h.cfg.PasswordLogin {
+		h.handleError(c, errPasswordLoginDisabled)
+		return
+	}
+
 	var req signupRequest
 
 	err := c.ShouldBindJSON(&req)
@@ -18,25 +94,392 @@ func (h *Handler) Signup(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, req)
+	res, err := h.doSignup(c.ClientIP(), c.Request.UserAgent(), req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	// The account exists either way, a failed email can be sent again later
+	if res.User.Email != "" {
+		if _, err := h.doSendVerificationEmail(res.User.ID, c.GetString(constant.LanguageKey), ""); err != nil {
+			h.log.Error("could not send verification email:", err) //nolint:errcheck // Ignore unused function warning
+		}
+	}
+
+	h.mergeAnonymousVisitor(c, res.User.ID)
+	h.setSessionCookie(c, res.RefreshToken, res.RefreshTokenExpiresAt)
+	c.JSON(http.StatusOK, res)
 }
 
-func (h *Handler) GetUserFromCookie(c *gin.Context) (string, error) {
-	cookie, err := c.Request.Cookie("user_id")
+func (h *Handler) doSignup(ip, userAgent string, req signupRequest) (SessionResponse, error) {
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return SessionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not hash password: %v", err)))
+	}
+
+	user := User{ID: uuid.New().String(), Username: strings.TrimSpace(req.Username), Email: strings.TrimSpace(req.Email)}
+	err = h.db.QueryRow(`
+		INSERT INTO users (id, username, password_hash, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at`, user.ID, user.Username, hash, nullIfEmpty(user.Email)).Scan(&user.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return SessionResponse{}, gerr.E(http.StatusConflict, fmt.Sprintf("username %s is already taken", user.Username))
+		}
+		return SessionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not create user: %v", err)))
+	}
+
+	return h.createSession(user, ip, userAgent, "User created")
+}
+
+// Login logs a user in
+// @Summary Log in
+// @Description Checks the username and password and starts a session, taking over the conversations of the anonymous visitor. The refresh token is returned and set as the session cookie.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body loginRequest true "Username and password"
+// @Success 200 {object} SessionResponse
+// @Failure 400 {object} ErrorResponse "Bad Request"
+// @Failure 401 {object} ErrorResponse "Invalid username or password"
+// @Failure 403 {object} ErrorResponse "Password login is disabled"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /login [post]
+func (h *Handler) Login(c *gin.Context) {
+	if !h.cfg.PasswordLogin {
+		h.handleError(c, errPasswordLoginDisabled)
+		return
+	}
+
+	var req loginRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	res, err := h.doLogin(c.ClientIP(), c.Request.UserAgent(), req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	h.mergeAnonymousVisitor(c, res.User.ID)
+	h.setSessionCookie(c, res.RefreshToken, res.RefreshTokenExpiresAt)
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doLogin(ip, userAgent string, req loginRequest) (SessionResponse, error) {
+	var user User
+	var hash sql.NullString
+	var disabled bool
+	err := h.db.QueryRow(`
+		SELECT id, username, password_hash, created_at, disabled_at IS NOT NULL
+		FROM users
+		WHERE lower(username) = lower($1)`, strings.TrimSpace(req.Username)).Scan(&user.ID, &user.Username, &hash, &user.CreatedAt, &disabled)
+	if err != nil && err != sql.ErrNoRows {
+		return SessionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not find user: %v", err)))
+	}
+
+	// Unknown users are checked against an empty hash, which costs as much as a real check
+	if !auth.CheckPassword(hash.String, req.Password) {
+		return SessionResponse{}, errInvalidCredentials
+	}
+	// Only tell who knows the password that the account is disabled
+	if disabled {
+		return SessionResponse{}, errAccountDisabled
+	}
+
+	return h.createSession(user, ip, userAgent, "Logged in")
+}
+
+// createSession starts a session of the user and issues its first tokens
+func (h *Handler) createSession(user User, ip, userAgent, message string) (SessionResponse, error) {
+	res, err := h.issueTokens(h.db, user, uuid.New().String(), ip, userAgent)
+	if err != nil {
+		return SessionResponse{}, err
+	}
+	res.Message = message
+	return res, nil
+}
+
+// issueTokens stores a new refresh token in the session family and signs an access token
+func (h *Handler) issueTokens(q querier, user User, familyID, ip, userAgent string) (SessionResponse, error) {
+	refreshToken, hash, err := auth.NewToken()
+	if err != nil {
+		return SessionResponse{}, gerr.E(500, gerr.Trace(err))
+	}
+
+	refreshExpiresAt := time.Now().Add(h.cfg.SessionTTL)
+	_, err = q.Exec(`
+		INSERT INTO user_sessions (id, family_id, user_id, expires_at, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6)`, hash, familyID, user.ID, refreshExpiresAt, ip, userAgent)
+	if err != nil {
+		return SessionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not create session: %v", err)))
+	}
+
+	accessToken, accessExpiresAt, err := h.tokens.Issue(user.ID, familyID)
+	if err != nil {
+		return SessionResponse{}, gerr.E(500, gerr.Trace(err))
+	}
+
+	return SessionResponse{
+		Success:               true,
+		User:                  user,
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  accessExpiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// RefreshToken exchanges a refresh token for new tokens
+// @Summary Refresh tokens
+// @Description Exchanges the refresh token, from the body or the session cookie, for a new access token and a new refresh token. Each refresh token can be used once, presenting a used one again revokes the whole session.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body RefreshTokenRequest false "Refresh token, when not sent as the session cookie"
+// @Success 200 {object} SessionResponse
+// @Failure 401 {object} ErrorResponse "Refresh token is invalid or expired"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /refresh-token [post]
+func (h *Handler) RefreshToken(c *gin.Context) {
+	res, err := h.doRefreshToken(requestRefreshToken(c), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	h.setSessionCookie(c, res.RefreshToken, res.RefreshTokenExpiresAt)
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doRefreshToken(token, ip, userAgent string) (res SessionResponse, err error) {
+	if token == "" {
+		return SessionResponse{}, errInvalidRefreshToken
+	}
+	hash := auth.HashToken(token)
+
+	tx, err := h.db.Begin()
 	if err != nil {
-		if err == http.ErrNoCookie {
-			return "", err
+		return SessionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not begin transaction: %v", err)))
+	}
+
+	// Rollback the transaction if there's an error
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback() //nolint:errcheck // the panic is what matters
+			panic(p)      // Re-raise the panic
+		} else if err != nil {
+			tx.Rollback() //nolint:errcheck // the original error is returned
+		} else if err = tx.Commit(); err != nil {
+			err = gerr.E(500, gerr.Trace(fmt.Errorf("could not commit refresh: %v", err)))
 		}
+	}()
+
+	// Use up the refresh token, it is replaced by the one issued below
+	var user User
+	var familyID string
+	err = tx.QueryRow(`
+		UPDATE user_sessions s SET revoked_at = now()
+		FROM users u
+		WHERE s.id = $1 AND u.id = s.user_id AND s.revoked_at IS NULL AND s.expires_at > now() AND u.disabled_at IS NULL
+		RETURNING u.id, coalesce(u.username, ''), u.created_at, s.family_id`, hash).Scan(&user.ID, &user.Username, &user.CreatedAt, &familyID)
+	if err == sql.ErrNoRows {
+		if err := h.revokeReusedToken(hash); err != nil {
+			return SessionResponse{}, gerr.E(500, gerr.Trace(err))
+		}
+		return SessionResponse{}, errInvalidRefreshToken
+	}
+	if err != nil {
+		return SessionResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not use refresh token: %v", err)))
+	}
+
+	res, err = h.issueTokens(tx, user, familyID, ip, userAgent)
+	if err != nil {
+		return SessionResponse{}, err
+	}
+	res.Message = "Tokens refreshed"
+	return res, nil
+}
+
+// revokeReusedToken revokes the whole session when a refresh token that was
+// already exchanged is presented again, as it was most likely stolen
+func (h *Handler) revokeReusedToken(hash string) error {
+	_, err := h.db.Exec(`
+		UPDATE user_sessions SET revoked_at = now()
+		WHERE revoked_at IS NULL AND family_id = (
+			SELECT family_id FROM user_sessions
+			WHERE id = $1 AND revoked_at IS NOT NULL AND expires_at > now())`, hash)
+	if err != nil {
+		return fmt.Errorf("could not revoke reused session: %v", err)
+	}
+	return nil
+}
+
+// Logout ends the current session
+// @Summary Log out
+// @Description Revokes the session of the refresh token, from the body or the session cookie, and clears the cookie. Access tokens already issued stay valid until they expire.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body RefreshTokenRequest false "Refresh token, when not sent as the session cookie"
+// @Success 200 {object} LogoutResponse
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /logout [post]
+func (h *Handler) Logout(c *gin.Context) {
+	res, err := h.doLogout(requestRefreshToken(c))
+	if err != nil {
+		h.handleError(c, gerr.E(500, gerr.Trace(err)))
+		return
+	}
+
+	h.setSessionCookie(c, "", time.Unix(0, 0))
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doLogout(token string) (LogoutResponse, error) {
+	if token == "" {
+		return LogoutResponse{Success: false, Message: "No session"}, nil
+	}
+
+	result, err := h.db.Exec(`
+		UPDATE user_sessions SET revoked_at = now()
+		WHERE revoked_at IS NULL
+			AND family_id = (SELECT family_id FROM user_sessions WHERE id = $1)`, auth.HashToken(token))
+	if err != nil {
+		return LogoutResponse{}, fmt.Errorf("could not revoke session: %v", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return LogoutResponse{}, fmt.Errorf("could not check revoked session: %v", err)
+	}
+
+	return LogoutResponse{
+		Success: affected > 0,
+		Message: "Logged out",
+	}, nil
+}
+
+// GetCurrentUser returns the authenticated user
+// @Summary Current user
+// @Description Returns the user of the access token, or the anonymous visitor of the user_id cookie
+// @Tags user
+// @Produce json
+// @Success 200 {object} CurrentUserResponse
+// @Failure 401 {object} ErrorResponse "Authentication required"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /get-current-user [get]
+func (h *Handler) GetCurrentUser(c *gin.Context) {
+	// Get the authenticated user ID
+	userID := c.GetString(constant.UserIDKey)
+
+	if c.GetBool(constant.AnonymousKey) {
+		c.JSON(http.StatusOK, CurrentUserResponse{
+			Success: true,
+			Message: "Anonymous visitor",
+			User:    User{ID: userID, Anonymous: true},
+		})
+		return
+	}
+
+	res, err := h.doGetCurrentUser(userID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) doGetCurrentUser(userID string) (CurrentUserResponse, error) {
+	user := User{ID: userID}
+	err := h.db.QueryRow(`
+		SELECT coalesce(username, ''), coalesce(email, ''), email_verified_at IS NOT NULL, deletion_scheduled_at, created_at
+		FROM users
+		WHERE id = $1`, userID).Scan(&user.Username, &user.Email, &user.EmailVerified, &user.DeletionScheduledAt, &user.CreatedAt)
+	if err == sql.ErrNoRows {
+		return CurrentUserResponse{}, gerr.E(http.StatusNotFound, "user not found")
+	}
+	if err != nil {
+		return CurrentUserResponse{}, gerr.E(500, gerr.Trace(fmt.Errorf("could not find user: %v", err)))
+	}
+
+	return CurrentUserResponse{
+		Success: true,
+		Message: "Found user",
+		User:    user,
+	}, nil
+}
+
+// requestRefreshToken reads the refresh token from the request body, falling back to
+// the session cookie
+func requestRefreshToken(c *gin.Context) string {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err == nil && req.RefreshToken != "" {
+		return req.RefreshToken
+	}
+	if cookie, err := c.Request.Cookie(sessionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// setSessionCookie sets the refresh token cookie, or clears it when token is empty
+func (h *Handler) setSessionCookie(c *gin.Context, token string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(c.Writer, cookie)
+}
+
+// ensureUser creates the user record of a user id that has none yet, such as
+// ids sent by clients without an account
+func ensureUser(q querier, userID string) error {
+	_, err := q.Exec(`
+		INSERT INTO users (id)
+		VALUES ($1)
+		ON CONFLICT (id) DO NOTHING`, userID)
+	if err != nil {
+		return fmt.Errorf("could not create user: %v", err)
+	}
+	return nil
+}
+
+// GetUserFromCookie returns the id of the anonymous visitor of the request.
+// The cookie is signed, a missing or forged signature is reported as no cookie.
+func (h *Handler) GetUserFromCookie(c *gin.Context) (string, error) {
+	cookie, err := c.Request.Cookie(anonymousCookie)
+	if err != nil {
 		return "", err
 	}
-	return cookie.Value, nil
+	userID, ok := h.tokens.VerifyCookie(anonymousCookie, cookie.Value)
+	if !ok {
+		return "", http.ErrNoCookie
+	}
+	return userID, nil
 }
 
+// SetUserCookie identifies the request with a new anonymous visitor
 func (h *Handler) SetUserCookie(c *gin.Context) string {
 	userID := uuid.New().String()
 	cookie := &http.Cookie{
-		Name:     "user_id",
-		Value:    userID,
+		Name:     anonymousCookie,
+		Value:    h.tokens.SignCookie(anonymousCookie, userID),
+		Path:     "/",
 		Expires:  time.Now().AddDate(5, 0, 0),
 		HttpOnly: true,
 		Secure:   true,
@@ -45,3 +488,15 @@ func (h *Handler) SetUserCookie(c *gin.Context) string {
 	http.SetCookie(c.Writer, cookie)
 	return userID
 }
+
+// clearUserCookie drops the anonymous identity once merged into an account
+func (h *Handler) clearUserCookie(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     anonymousCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
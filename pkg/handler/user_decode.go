@@ -1,6 +1,12 @@
 package handler
 
 type signupRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=64"`
+	Password string `json:"password" binding:"required,strongpassword"`
+	Email    string `json:"email" binding:"omitempty,email,max=320"`
+}
+
+type loginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 }
@@ -0,0 +1,41 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Essen-Labs/bloom-be/pkg/export"
+)
+
+// parseBloom reads a document written by the JSON export
+func parseBloom(raw json.RawMessage) (Conversation, error) {
+	var doc export.Document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return Conversation{}, errors.New("invalid bloom export: " + err.Error())
+	}
+	if doc.Version > export.DocumentVersion {
+		return Conversation{}, fmt.Errorf("bloom export version %d is newer than supported version %d", doc.Version, export.DocumentVersion)
+	}
+
+	conversation := Conversation{
+		Source:    SourceBloom,
+		Title:     strings.TrimSpace(doc.Conversation.Name),
+		Model:     doc.Conversation.Model,
+		CreatedAt: doc.Conversation.CreatedAt,
+	}
+	for _, m := range doc.Messages {
+		if m.Role == "" || m.Content == "" {
+			conversation.SkippedMessages++
+			continue
+		}
+		conversation.Messages = append(conversation.Messages, Message{
+			Role:      m.Role,
+			Content:   m.Content,
+			CreatedAt: m.CreatedAt,
+			Model:     m.Model,
+		})
+	}
+	return conversation, nil
+}
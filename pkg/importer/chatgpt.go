@@ -0,0 +1,147 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// chatGPTConversation is an item of the conversations.json file in a ChatGPT data export.
+// Messages form a tree, regenerated answers and edited prompts create branches.
+type chatGPTConversation struct {
+	Title            string                 `json:"title"`
+	CreateTime       float64                `json:"create_time"`
+	Mapping          map[string]chatGPTNode `json:"mapping"`
+	CurrentNode      string                 `json:"current_node"`
+	DefaultModelSlug string                 `json:"default_model_slug"`
+}
+
+type chatGPTNode struct {
+	ID       string          `json:"id"`
+	Message  *chatGPTMessage `json:"message"`
+	Parent   *string         `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime *float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug string `json:"model_slug"`
+	} `json:"metadata"`
+}
+
+// parseChatGPT keeps the branch ending at current_node, the one the user last
+// saw, falling back to the most recent leaf when current_node is missing
+func parseChatGPT(raw json.RawMessage) (Conversation, error) {
+	var src chatGPTConversation
+	if err := json.Unmarshal(raw, &src); err != nil {
+		return Conversation{}, errors.New("invalid ChatGPT conversation: " + err.Error())
+	}
+	if len(src.Mapping) == 0 {
+		return Conversation{}, errors.New("ChatGPT conversation has no messages")
+	}
+
+	leaf := src.CurrentNode
+	if _, ok := src.Mapping[leaf]; !ok {
+		leaf = latestLeaf(src.Mapping)
+	}
+
+	// Walk up to the root, guarding against cycles in malformed files
+	var branch []chatGPTNode
+	seen := map[string]bool{}
+	for id := leaf; id != "" && !seen[id]; {
+		node, ok := src.Mapping[id]
+		if !ok {
+			break
+		}
+		seen[id] = true
+		branch = append(branch, node)
+		if node.Parent == nil {
+			break
+		}
+		id = *node.Parent
+	}
+
+	conversation := Conversation{
+		Source:    SourceChatGPT,
+		Title:     strings.TrimSpace(src.Title),
+		Model:     src.DefaultModelSlug,
+		CreatedAt: unixFloat(src.CreateTime),
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		m := branch[i].Message
+		if m == nil {
+			continue
+		}
+		content := chatGPTText(m)
+		role := m.Author.Role
+		if content == "" || (role != "user" && role != "assistant" && role != "system") {
+			// Empty system prompts, tool calls and non text content such as images
+			conversation.SkippedMessages++
+			continue
+		}
+
+		createdAt := conversation.CreatedAt
+		if m.CreateTime != nil {
+			createdAt = unixFloat(*m.CreateTime)
+		}
+		conversation.Messages = append(conversation.Messages, Message{
+			Role:      role,
+			Content:   content,
+			CreatedAt: createdAt,
+			Model:     m.Metadata.ModelSlug,
+		})
+	}
+	return conversation, nil
+}
+
+// chatGPTText joins the text parts of a message, other parts (images, files) are dropped
+func chatGPTText(m *chatGPTMessage) string {
+	if m.Content.Text != "" {
+		return strings.TrimSpace(m.Content.Text)
+	}
+	var parts []string
+	for _, raw := range m.Content.Parts {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil && strings.TrimSpace(s) != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n\n"))
+}
+
+func latestLeaf(mapping map[string]chatGPTNode) string {
+	leaf := ""
+	latest := math.Inf(-1)
+	for id, node := range mapping {
+		if len(node.Children) > 0 || node.Message == nil {
+			continue
+		}
+		created := 0.0
+		if node.Message.CreateTime != nil {
+			created = *node.Message.CreateTime
+		}
+		if created > latest || (created == latest && id > leaf) {
+			leaf, latest = id, created
+		}
+	}
+	return leaf
+}
+
+func unixFloat(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+}
@@ -0,0 +1,151 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/export"
+)
+
+// Sources of an imported conversation
+const (
+	SourceChatGPT = "chatgpt"
+	SourceBloom   = "bloom"
+)
+
+// Conversation is a conversation read from an export file, ready to be stored
+type Conversation struct {
+	Source          string
+	Title           string
+	Model           string
+	CreatedAt       time.Time
+	Messages        []Message
+	SkippedMessages int
+}
+
+// Message is an imported message
+type Message struct {
+	Role      string
+	Content   string
+	CreatedAt time.Time
+	Model     string
+}
+
+// Skipped reports an item of the file that could not be imported
+type Skipped struct {
+	Index  int    `json:"index"`
+	Title  string `json:"title"`
+	Reason string `json:"reason"`
+}
+
+// Result of parsing an export file
+type Result struct {
+	Conversations []Conversation
+	Skipped       []Skipped
+
+	recognized int
+}
+
+// ErrUnknownFormat is returned when the file is neither a ChatGPT nor a bloom export
+var ErrUnknownFormat = errors.New("unrecognized export format, expected a ChatGPT conversations.json or a bloom JSON export")
+
+// Parse reads a ChatGPT conversations.json or bloom JSON export, either a single
+// conversation or an array of them. Conversations are decoded one at a time, a
+// conversation that cannot be mapped is reported in Skipped instead of failing
+// the whole file.
+func Parse(r io.Reader) (Result, error) {
+	br := bufio.NewReader(r)
+	first, err := firstNonSpace(br)
+	if err != nil {
+		return Result{}, ErrUnknownFormat
+	}
+
+	dec := json.NewDecoder(br)
+	var result Result
+	switch first {
+	case '{':
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return Result{}, fmt.Errorf("invalid JSON: %v", err)
+		}
+		result.add(0, raw)
+	case '[':
+		if _, err := dec.Token(); err != nil {
+			return Result{}, fmt.Errorf("invalid JSON: %v", err)
+		}
+		for idx := 0; dec.More(); idx++ {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return Result{}, fmt.Errorf("invalid JSON at item %d: %v", idx, err)
+			}
+			result.add(idx, raw)
+		}
+	default:
+		return Result{}, ErrUnknownFormat
+	}
+
+	if result.recognized == 0 {
+		return Result{}, ErrUnknownFormat
+	}
+	return result, nil
+}
+
+func (r *Result) add(idx int, raw json.RawMessage) {
+	var probe struct {
+		Format  string          `json:"format"`
+		Mapping json.RawMessage `json:"mapping"`
+		Title   string          `json:"title"`
+
+		Conversation struct {
+			Name string `json:"name"`
+		} `json:"conversation"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		r.Skipped = append(r.Skipped, Skipped{Index: idx, Reason: "not a conversation object"})
+		return
+	}
+
+	var conversation Conversation
+	var err error
+	switch {
+	case probe.Format == export.DocumentFormat:
+		r.recognized++
+		conversation, err = parseBloom(raw)
+	case probe.Mapping != nil:
+		r.recognized++
+		conversation, err = parseChatGPT(raw)
+	default:
+		err = errors.New("unrecognized conversation format")
+	}
+	if err == nil && len(conversation.Messages) == 0 {
+		err = errors.New("conversation has no importable messages")
+	}
+	if err != nil {
+		title := probe.Title
+		if title == "" {
+			title = probe.Conversation.Name
+		}
+		r.Skipped = append(r.Skipped, Skipped{Index: idx, Title: title, Reason: err.Error()})
+		return
+	}
+	r.Conversations = append(r.Conversations, conversation)
+}
+
+// firstNonSpace peeks at the first byte that is neither whitespace nor part of a UTF-8 byte order mark
+func firstNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n', 0xEF, 0xBB, 0xBF:
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const chatGPTExport = `[
+  {
+    "title": "Payment retry bug",
+    "create_time": 1700000000.5,
+    "default_model_slug": "gpt-4",
+    "current_node": "answer-2",
+    "mapping": {
+      "root": {"id": "root", "message": null, "parent": null, "children": ["system"]},
+      "system": {"id": "system", "parent": "root", "children": ["question"],
+        "message": {"author": {"role": "system"}, "content": {"content_type": "text", "parts": [""]}}},
+      "question": {"id": "question", "parent": "system", "children": ["answer-1", "answer-2"],
+        "message": {"author": {"role": "user"}, "create_time": 1700000001,
+          "content": {"content_type": "text", "parts": ["Why do payments retry twice?"]}}},
+      "answer-1": {"id": "answer-1", "parent": "question", "children": [],
+        "message": {"author": {"role": "assistant"}, "create_time": 1700000002,
+          "content": {"content_type": "text", "parts": ["Regenerated away"]}}},
+      "answer-2": {"id": "answer-2", "parent": "question", "children": [],
+        "message": {"author": {"role": "assistant"}, "create_time": 1700000003,
+          "content": {"content_type": "text", "parts": ["Because of the backoff."]},
+          "metadata": {"model_slug": "gpt-4o"}}}
+    }
+  },
+  {"title": "Empty", "mapping": {"root": {"id": "root", "message": null, "parent": null, "children": []}}}
+]`
+
+const bloomExport = `{
+  "format": "bloom.conversation",
+  "version": 1,
+  "conversation": {"id": "3", "name": "Exported", "model": "Meta-Llama-3-1-8B-Instruct-FP8", "created_at": "2024-01-02T03:04:05Z"},
+  "messages": [
+    {"id": 1, "role": "user", "content": "Hello", "created_at": "2024-01-02T03:04:06Z"},
+    {"id": 2, "role": "assistant", "content": "Hi there", "created_at": "2024-01-02T03:04:07Z"}
+  ],
+  "usage": {"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0}
+}`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		wantErr       bool
+		wantTitles    []string
+		wantMessages  [][]string
+		wantSkipped   int
+		wantSkippedMs []int
+	}{
+		{
+			name:          "chatgpt export keeps the current branch",
+			input:         chatGPTExport,
+			wantTitles:    []string{"Payment retry bug"},
+			wantMessages:  [][]string{{"Why do payments retry twice?", "Because of the backoff."}},
+			wantSkipped:   1,
+			wantSkippedMs: []int{1},
+		},
+		{
+			name:          "bloom export",
+			input:         "\ufeff" + bloomExport,
+			wantTitles:    []string{"Exported"},
+			wantMessages:  [][]string{{"Hello", "Hi there"}},
+			wantSkippedMs: []int{0},
+		},
+		{
+			name:          "array mixing formats and garbage",
+			input:         "[" + bloomExport + `, 42, {"foo": "bar"}]`,
+			wantTitles:    []string{"Exported"},
+			wantMessages:  [][]string{{"Hello", "Hi there"}},
+			wantSkipped:   2,
+			wantSkippedMs: []int{0},
+		},
+		{
+			name:    "unknown format",
+			input:   `[{"foo": "bar"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "not json",
+			input:   "title,content",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got.Skipped) != tt.wantSkipped {
+				t.Errorf("Parse() skipped = %v, want %v", got.Skipped, tt.wantSkipped)
+			}
+			if len(got.Conversations) != len(tt.wantTitles) {
+				t.Fatalf("Parse() conversations = %v, want %v", len(got.Conversations), len(tt.wantTitles))
+			}
+			for i, c := range got.Conversations {
+				if c.Title != tt.wantTitles[i] {
+					t.Errorf("Conversation.Title = %v, want %v", c.Title, tt.wantTitles[i])
+				}
+				if c.SkippedMessages != tt.wantSkippedMs[i] {
+					t.Errorf("Conversation.SkippedMessages = %v, want %v", c.SkippedMessages, tt.wantSkippedMs[i])
+				}
+				var contents []string
+				for _, m := range c.Messages {
+					contents = append(contents, m.Content)
+				}
+				if strings.Join(contents, "|") != strings.Join(tt.wantMessages[i], "|") {
+					t.Errorf("Conversation.Messages = %v, want %v", contents, tt.wantMessages[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParse_ChatGPTTimestamps(t *testing.T) {
+	got, err := Parse(strings.NewReader(chatGPTExport))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	c := got.Conversations[0]
+	if want := time.Unix(1700000000, 5e8).UTC(); !c.CreatedAt.Equal(want) {
+		t.Errorf("Conversation.CreatedAt = %v, want %v", c.CreatedAt, want)
+	}
+	if want := time.Unix(1700000003, 0).UTC(); !c.Messages[1].CreatedAt.Equal(want) {
+		t.Errorf("Message.CreatedAt = %v, want %v", c.Messages[1].CreatedAt, want)
+	}
+	if c.Messages[1].Model != "gpt-4o" || c.Model != "gpt-4" {
+		t.Errorf("models = %v %v, want gpt-4o gpt-4", c.Messages[1].Model, c.Model)
+	}
+}
@@ -0,0 +1,93 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AkashEmbedder calls the OpenAI compatible embeddings endpoint of AkashChat
+type AkashEmbedder struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+// NewAkashEmbedder make an embedder backed by the AkashChat API
+func NewAkashEmbedder(baseURL, apiKey, model string) *AkashEmbedder {
+	return &AkashEmbedder{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type embeddingsRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingsResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Model of the embeddings
+func (e *AkashEmbedder) Model() string {
+	return e.model
+}
+
+// Embed inputs with the embeddings endpoint
+func (e *AkashEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	jsonData, err := json.Marshal(embeddingsRequest{Model: e.model, Input: inputs})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal embeddings request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/embeddings", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("could not create embeddings request: %v", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+e.apiKey)
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not call embeddings API: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read embeddings response: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings API returned %d: %s", res.StatusCode, body)
+	}
+
+	var parsed embeddingsResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("could not unmarshal embeddings response: %v", err)
+	}
+	if len(parsed.Data) != len(inputs) {
+		return nil, fmt.Errorf("embeddings API returned %d vectors for %d inputs", len(parsed.Data), len(inputs))
+	}
+
+	vectors := make([][]float32, len(inputs))
+	for _, d := range parsed.Data {
+		if d.Index < 0 || d.Index >= len(inputs) {
+			return nil, fmt.Errorf("embeddings API returned out of range index %d", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
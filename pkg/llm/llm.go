@@ -0,0 +1,56 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+)
+
+// Embedder turns text into vectors that can be compared with CosineSimilarity
+type Embedder interface {
+	// Embed returns one vector per input, in the same order
+	Embed(ctx context.Context, inputs []string) ([][]float32, error)
+	// Model identifies the vector space, vectors of different models must not be compared
+	Model() string
+}
+
+// Embedding providers accepted in EMBEDDING_PROVIDER
+const (
+	ProviderAkash = "akash"
+	ProviderLocal = "local"
+)
+
+// NewEmbedder make the embedder configured for the app, nil when no provider is
+// set, which disables semantic search. The local embedder is only a stand-in
+// for development.
+func NewEmbedder(cfg config.Config) (Embedder, error) {
+	switch cfg.EmbeddingProvider {
+	case ProviderAkash:
+		return NewAkashEmbedder(cfg.LLMBaseURL, cfg.LLMAPIKey, cfg.EmbeddingModel), nil
+	case ProviderLocal:
+		return NewLocalEmbedder(localDimensions), nil
+	case "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider %q", cfg.EmbeddingProvider)
+	}
+}
+
+// CosineSimilarity of two vectors, 0 when their lengths differ or one is empty
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
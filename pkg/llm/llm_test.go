@@ -0,0 +1,34 @@
+package llm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+)
+
+func TestNewEmbedder(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		want     Embedder
+		wantErr  bool
+	}{
+		{name: "akash", provider: ProviderAkash, want: &AkashEmbedder{}},
+		{name: "local", provider: ProviderLocal, want: &LocalEmbedder{}},
+		{name: "not set", provider: ""},
+		{name: "unknown", provider: "openai", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEmbedder(config.Config{EmbeddingProvider: tt.provider})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewEmbedder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprintf("%T", got) != fmt.Sprintf("%T", tt.want) {
+				t.Errorf("NewEmbedder() = %T, want %T", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const localDimensions = 256
+
+// LocalEmbedder is a deterministic stand-in for a real embedding model. It hashes
+// words and word pairs into a fixed number of buckets, so texts sharing vocabulary
+// end up close to each other. It needs no network and is meant for tests and
+// local development.
+type LocalEmbedder struct {
+	dimensions int
+}
+
+// NewLocalEmbedder make a local embedder producing vectors of the given size
+func NewLocalEmbedder(dimensions int) *LocalEmbedder {
+	return &LocalEmbedder{dimensions: dimensions}
+}
+
+// Model of the embeddings
+func (e *LocalEmbedder) Model() string {
+	return fmt.Sprintf("local-hash-%d", e.dimensions)
+}
+
+// Embed inputs by feature hashing
+func (e *LocalEmbedder) Embed(_ context.Context, inputs []string) ([][]float32, error) {
+	vectors := make([][]float32, len(inputs))
+	for i, input := range inputs {
+		vectors[i] = e.embed(input)
+	}
+	return vectors, nil
+}
+
+func (e *LocalEmbedder) embed(input string) []float32 {
+	vector := make([]float32, e.dimensions)
+	words := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	for i, word := range words {
+		e.add(vector, word, 1)
+		if i > 0 {
+			e.add(vector, words[i-1]+" "+word, 0.5)
+		}
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+	return vector
+}
+
+// add a feature to its bucket, the sign bit spreads collisions around zero
+func (e *LocalEmbedder) add(vector []float32, feature string, weight float32) {
+	h := fnv.New64a()
+	h.Write([]byte(feature)) //nolint:errcheck // hash writes never fail
+	sum := h.Sum64()
+	if sum&(1<<63) != 0 {
+		weight = -weight
+	}
+	vector[sum%uint64(e.dimensions)] += weight
+}
@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestLocalEmbedder_Embed(t *testing.T) {
+	e := NewLocalEmbedder(localDimensions)
+
+	first, err := e.Embed(context.Background(), []string{"Payment retry bug in checkout"})
+	if err != nil {
+		t.Fatalf("LocalEmbedder.Embed() error = %v", err)
+	}
+	second, _ := e.Embed(context.Background(), []string{"Payment retry bug in checkout"})
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("LocalEmbedder.Embed() is not deterministic")
+	}
+	if len(first[0]) != localDimensions {
+		t.Errorf("LocalEmbedder.Embed() len = %v, want %v", len(first[0]), localDimensions)
+	}
+}
+
+func TestLocalEmbedder_Similarity(t *testing.T) {
+	e := NewLocalEmbedder(localDimensions)
+	query := "where did we discuss the payment retry bug"
+	tests := []struct {
+		name   string
+		closer string
+		far    string
+	}{
+		{
+			name:   "shared vocabulary ranks higher",
+			closer: "The payment retry bug happens when the card is declined twice",
+			far:    "Write me a haiku about autumn leaves",
+		},
+		{
+			name:   "case and punctuation are ignored",
+			closer: "PAYMENT, RETRY... bug!",
+			far:    "recipe for banana bread",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vectors, err := e.Embed(context.Background(), []string{query, tt.closer, tt.far})
+			if err != nil {
+				t.Fatalf("LocalEmbedder.Embed() error = %v", err)
+			}
+			closer := CosineSimilarity(vectors[0], vectors[1])
+			far := CosineSimilarity(vectors[0], vectors[2])
+			if closer <= far {
+				t.Errorf("CosineSimilarity() closer = %v, far = %v", closer, far)
+			}
+		})
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []float32
+		b    []float32
+		want float64
+	}{
+		{name: "identical", a: []float32{1, 2}, b: []float32{1, 2}, want: 1},
+		{name: "orthogonal", a: []float32{1, 0}, b: []float32{0, 1}, want: 0},
+		{name: "different length", a: []float32{1}, b: []float32{1, 2}, want: 0},
+		{name: "zero vector", a: []float32{0, 0}, b: []float32{1, 2}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CosineSimilarity(tt.a, tt.b)
+			if got < tt.want-1e-6 || got > tt.want+1e-6 {
+				t.Errorf("CosineSimilarity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Package mail sends emails to users, through an SMTP server or, during
+// development, an outbox writing them to files or to the log.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LoadMailer makes the mailer of MAIL_BACKEND, smtp or outbox
+func LoadMailer(cfg config.Config) (Mailer, error) {
+	if _, err := mail.ParseAddress(cfg.MailFrom); err != nil {
+		return nil, fmt.Errorf("could not parse MAIL_FROM: %v", err)
+	}
+
+	switch cfg.MailBackend {
+	case "", "outbox":
+		if cfg.MailOutboxDir != "" {
+			if err := os.MkdirAll(cfg.MailOutboxDir, 0o700); err != nil {
+				return nil, fmt.Errorf("could not create MAIL_OUTBOX_DIR: %v", err)
+			}
+		}
+		return NewOutbox(cfg.MailFrom, cfg.MailOutboxDir, os.Stderr), nil
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("SMTP_HOST is required with MAIL_BACKEND=smtp")
+		}
+		return NewSMTP(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_BACKEND %q", cfg.MailBackend)
+	}
+}
+
+// encode formats the message as sent, with CRLF line endings and the body
+// encoded as quoted-printable
+func (m Message) encode(from string, now time.Time) ([]byte, error) {
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender: %v", err)
+	}
+	toAddr, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient: %v", err)
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return nil, errors.New("invalid subject: line break")
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("could not generate message id: %v", err)
+	}
+	domain := fromAddr.Address[strings.LastIndex(fromAddr.Address, "@")+1:]
+
+	var b bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	header("From", fromAddr.String())
+	header("To", toAddr.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	b.WriteString("\r\n")
+
+	w := quotedprintable.NewWriter(&b)
+	text := strings.ReplaceAll(strings.ReplaceAll(m.Text, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := io.WriteString(w, text); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	b.WriteString("\r\n")
+	return b.Bytes(), nil
+}
+
+// addresses returns the bare addresses of the sender and recipient of the envelope
+func (m Message) addresses(from string) (string, string, error) {
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid sender: %v", err)
+	}
+	toAddr, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid recipient: %v", err)
+	}
+	return fromAddr.Address, toAddr.Address, nil
+}
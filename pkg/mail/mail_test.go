@@ -0,0 +1,316 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+)
+
+const testFrom = "Bloom <no-reply@bloom.test>"
+
+func TestMessage_Encode(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := Message{
+		To:      "alice@example.com",
+		Subject: "Xác minh địa chỉ email",
+		Text:    "Xin chào alice,\nmở liên kết này: https://bloom.test/verify-email?token=abc=def\n",
+	}
+
+	data, err := msg.encode(testFrom, now)
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	if bytes.Contains(bytes.ReplaceAll(data, []byte("\r\n"), nil), []byte("\n")) {
+		t.Error("encode() has bare line feeds")
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not parse encoded message: %v", err)
+	}
+	headers := map[string]string{
+		"From":         `"Bloom" <no-reply@bloom.test>`,
+		"To":           "<alice@example.com>",
+		"Date":         "Tue, 02 Jan 2024 03:04:05 +0000",
+		"Content-Type": "text/plain; charset=utf-8",
+	}
+	for name, want := range headers {
+		if got := parsed.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject")); err != nil || subject != msg.Subject {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, msg.Subject)
+	}
+	if id := parsed.Header.Get("Message-ID"); !strings.HasSuffix(id, "@bloom.test>") {
+		t.Errorf("Message-ID = %q, want a bloom.test id", id)
+	}
+
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatalf("could not decode body: %v", err)
+	}
+	if got := strings.ReplaceAll(string(body), "\r\n", "\n"); got != msg.Text+"\n" {
+		t.Errorf("body = %q, want %q", got, msg.Text+"\n")
+	}
+}
+
+func TestMessage_EncodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		msg  Message
+	}{
+		{name: "invalid recipient", from: testFrom, msg: Message{To: "not an address", Subject: "Hi"}},
+		{name: "header injection in recipient", from: testFrom, msg: Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hi"}},
+		{name: "header injection in subject", from: testFrom, msg: Message{To: "alice@example.com", Subject: "Hi\r\nBcc: eve@example.com"}},
+		{name: "invalid sender", from: "bloom", msg: Message{To: "alice@example.com", Subject: "Hi"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.msg.encode(tt.from, time.Now()); err == nil {
+				t.Error("encode() error = nil")
+			}
+		})
+	}
+}
+
+func TestOutbox_Send(t *testing.T) {
+	msg := Message{To: "alice@example.com", Subject: "Reset your password", Text: "Open the link"}
+
+	t.Run("to the log", func(t *testing.T) {
+		var log bytes.Buffer
+		o := NewOutbox(testFrom, "", &log)
+		if err := o.Send(context.Background(), msg); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		if !strings.Contains(log.String(), "Subject: Reset your password") || !strings.Contains(log.String(), "Open the link") {
+			t.Errorf("log = %q, want the email", log.String())
+		}
+	})
+
+	t.Run("to files", func(t *testing.T) {
+		var log bytes.Buffer
+		dir := t.TempDir()
+		o := NewOutbox(testFrom, dir, &log)
+		for i := 0; i < 2; i++ {
+			if err := o.Send(context.Background(), msg); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+		}
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+		if err != nil || len(files) != 2 {
+			t.Fatalf("outbox files = %v (%v), want 2", files, err)
+		}
+		data, err := os.ReadFile(files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mail.ReadMessage(bytes.NewReader(data)); err != nil {
+			t.Errorf("outbox file is not an email: %v", err)
+		}
+		if !strings.Contains(log.String(), files[0]) {
+			t.Errorf("log = %q, want the file name", log.String())
+		}
+	})
+}
+
+// fakeSMTP is an SMTP server accepting every email
+type fakeSMTP struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	commands []string
+	data     []string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{listener: l}
+	t.Cleanup(func() { l.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTP) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+		case "EHLO":
+			reply("250-fake")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			reply("235 authenticated")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.mu.Lock()
+			s.data = append(s.data, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTP_Send(t *testing.T) {
+	msg := Message{To: "Alice <alice@example.com>", Subject: "Verify your email address", Text: "Open the link"}
+
+	tests := []struct {
+		name         string
+		username     string
+		wantCommands []string
+	}{
+		{
+			name:         "without authentication",
+			wantCommands: []string{"MAIL FROM:<no-reply@bloom.test>", "RCPT TO:<alice@example.com>", "DATA", "QUIT"},
+		},
+		{
+			name:         "with authentication",
+			username:     "bloom",
+			wantCommands: []string{"AUTH PLAIN", "MAIL FROM:<no-reply@bloom.test>", "RCPT TO:<alice@example.com>", "DATA", "QUIT"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeSMTP(t)
+			s := NewSMTP("127.0.0.1", server.port(), tt.username, "secret", testFrom)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.Send(ctx, msg); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			var commands []string
+			for _, c := range server.commands[1:] {
+				if strings.HasPrefix(c, "AUTH PLAIN") {
+					c = "AUTH PLAIN"
+				}
+				// The client may announce the body size
+				commands = append(commands, strings.SplitN(c, " BODY=", 2)[0])
+			}
+			if strings.Join(commands, "|") != strings.Join(tt.wantCommands, "|") {
+				t.Errorf("commands = %q, want %q", commands, tt.wantCommands)
+			}
+			if len(server.data) != 1 || !strings.Contains(server.data[0], "Subject: Verify your email address") {
+				t.Errorf("data = %q, want the email", server.data)
+			}
+		})
+	}
+}
+
+func TestSMTP_SendUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	s := NewSMTP("127.0.0.1", port, "", "", testFrom)
+	if err := s.Send(context.Background(), Message{To: "alice@example.com", Subject: "Hi"}); err == nil {
+		t.Error("Send() error = nil with the server down")
+	}
+}
+
+func TestLoadMailer(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		want    Mailer
+		wantErr bool
+	}{
+		{name: "outbox by default", cfg: config.Config{MailFrom: testFrom}, want: &Outbox{}},
+		{name: "outbox directory", cfg: config.Config{MailFrom: testFrom, MailOutboxDir: filepath.Join(t.TempDir(), "outbox")}, want: &Outbox{}},
+		{name: "smtp", cfg: config.Config{MailBackend: "smtp", MailFrom: testFrom, SMTPHost: "smtp.example.com", SMTPPort: 587}, want: &SMTP{}},
+		{name: "smtp without host", cfg: config.Config{MailBackend: "smtp", MailFrom: testFrom}, wantErr: true},
+		{name: "invalid sender", cfg: config.Config{MailFrom: "bloom"}, wantErr: true},
+		{name: "unknown backend", cfg: config.Config{MailBackend: "sendgrid", MailFrom: testFrom}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadMailer(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadMailer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if fmt.Sprintf("%T", got) != fmt.Sprintf("%T", tt.want) {
+				t.Errorf("LoadMailer() = %T, want %T", got, tt.want)
+			}
+			if tt.cfg.MailOutboxDir != "" {
+				if _, err := os.Stat(tt.cfg.MailOutboxDir); err != nil {
+					t.Errorf("outbox directory was not created: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outbox keeps the emails instead of sending them, for development. Each email
+// is written to a .eml file in dir, which mail clients open, or to log when
+// dir is empty.
+type Outbox struct {
+	from string
+	dir  string
+
+	mu  sync.Mutex
+	log io.Writer
+	now func() time.Time
+}
+
+// NewOutbox makes an outbox of the emails sent from from
+func NewOutbox(from, dir string, log io.Writer) *Outbox {
+	return &Outbox{from: from, dir: dir, log: log, now: time.Now}
+}
+
+// Send writes the email to the outbox
+func (o *Outbox) Send(ctx context.Context, msg Message) error {
+	now := o.now()
+	data, err := msg.encode(o.from, now)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.dir == "" {
+		_, err = fmt.Fprintf(o.log, "outbox: email to %s\n%s\n", msg.To, data)
+		return err
+	}
+
+	// Names sort by date, the recipient helps finding the email
+	recipient := strings.NewReplacer("/", "_", `\`, "_", "..", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), recipient)
+	path := filepath.Join(o.dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("could not write email: %v", err)
+	}
+	_, err = fmt.Fprintf(o.log, "outbox: email to %s written to %s\n", msg.To, path)
+	return err
+}
@@ -0,0 +1,101 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// smtpTimeout bounds the whole exchange with the server, when the context has no deadline
+const smtpTimeout = 30 * time.Second
+
+// smtpsPort is the port of SMTP over implicit TLS, other ports upgrade with STARTTLS
+const smtpsPort = 465
+
+// SMTP sends emails through an SMTP server. Connections are encrypted with
+// STARTTLS when the server offers it, and credentials are only sent encrypted
+// or to a server on localhost.
+type SMTP struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	now      func() time.Time
+}
+
+// NewSMTP makes a mailer sending from from through the server at host:port,
+// authenticating when username is set
+func NewSMTP(host string, port int, username, password, from string) *SMTP {
+	return &SMTP{host: host, port: port, username: username, password: password, from: from, now: time.Now}
+}
+
+// Send sends the email
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := msg.encode(s.from, s.now())
+	if err != nil {
+		return err
+	}
+	from, to, err := msg.addresses(s.from)
+	if err != nil {
+		return err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.host, strconv.Itoa(s.port)))
+	if err != nil {
+		return fmt.Errorf("could not connect to SMTP server: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("could not connect to SMTP server: %v", err)
+	}
+
+	tlsConfig := &tls.Config{ServerName: s.host, MinVersion: tls.VersionTLS12}
+	if s.port == smtpsPort {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return fmt.Errorf("could not greet SMTP server: %v", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && s.port != smtpsPort {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("could not start TLS: %v", err)
+		}
+	}
+	if s.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return fmt.Errorf("could not authenticate to SMTP server: %v", err)
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return fmt.Errorf("SMTP server refused the sender: %v", err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("SMTP server refused the recipient: %v", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("could not send email: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("could not send email: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("could not send email: %v", err)
+	}
+	return c.Quit()
+}
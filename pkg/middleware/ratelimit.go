@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"github.com/Essen-Labs/bloom-be/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Budgets of the rate limit middleware
+const (
+	BudgetChat = "chat"
+	BudgetRead = "read"
+)
+
+// RateLimitConfig configures the rate limit middleware
+type RateLimitConfig struct {
+	Limiter ratelimit.Limiter
+	// Chat is the budget of ChatRoutes, which send messages to the model, and
+	// Read the budget of every other route
+	Chat ratelimit.Limit
+	Read ratelimit.Limit
+	// ChatRoutes lists the routes as "METHOD /path", the path as registered
+	ChatRoutes []string
+	// Key identifies who makes the request, like a user, an API key or an IP
+	Key func(c *gin.Context) string
+	// Deny answers a request over its budget, the RateLimit headers already set
+	Deny func(c *gin.Context, budget string, res ratelimit.Result)
+	// OnError is told when the limiter fails, the request is then allowed
+	OnError func(c *gin.Context, err error)
+}
+
+// NewRateLimitMiddleware make a rate limit middleware, taking a token from the
+// bucket of the caller in the budget of the route
+func NewRateLimitMiddleware(cfg RateLimitConfig) gin.HandlerFunc {
+	chatRoutes := map[string]bool{}
+	for _, route := range cfg.ChatRoutes {
+		chatRoutes[route] = true
+	}
+
+	return func(c *gin.Context) {
+		budget, limit := BudgetRead, cfg.Read
+		if chatRoutes[c.Request.Method+" "+c.FullPath()] {
+			budget, limit = BudgetChat, cfg.Chat
+		}
+
+		res, err := cfg.Limiter.Allow(c.Request.Context(), budget+":"+cfg.Key(c), limit)
+		if err != nil {
+			// A limiter out of service does not take the service down with it
+			if cfg.OnError != nil {
+				cfg.OnError(c, err)
+			}
+			c.Next()
+			return
+		}
+		if res.Limit > 0 {
+			ratelimit.SetHeaders(c.Writer.Header(), res)
+		}
+		if !res.Allowed {
+			cfg.Deny(c, budget, res)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
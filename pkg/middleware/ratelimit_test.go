@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Essen-Labs/bloom-be/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// brokenLimiter always fails, as a limiter out of service
+type brokenLimiter struct{}
+
+func (brokenLimiter) Allow(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func newRateLimitRouter(limiter ratelimit.Limiter, denied *[]string, failures *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(NewRateLimitMiddleware(RateLimitConfig{
+		Limiter:    limiter,
+		Chat:       ratelimit.PerMinute(1),
+		Read:       ratelimit.PerMinute(2),
+		ChatRoutes: []string{"POST /send/:id"},
+		Key:        func(c *gin.Context) string { return c.GetHeader("X-Caller") },
+		Deny: func(c *gin.Context, budget string, _ ratelimit.Result) {
+			*denied = append(*denied, budget)
+			c.JSON(http.StatusTooManyRequests, gin.H{"budget": budget})
+		},
+		OnError: func(*gin.Context, error) { *failures++ },
+	}))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.POST("/send/:id", ok)
+	r.GET("/read", ok)
+	r.POST("/read", ok)
+	return r
+}
+
+func TestNewRateLimitMiddleware(t *testing.T) {
+	type request struct {
+		method, path, caller string
+		wantStatus           int
+		wantRemaining        string
+	}
+	tests := []struct {
+		name       string
+		requests   []request
+		wantDenied []string
+	}{
+		{
+			name: "chat budget",
+			requests: []request{
+				{http.MethodPost, "/send/1", "alice", http.StatusOK, "0"},
+				{http.MethodPost, "/send/2", "alice", http.StatusTooManyRequests, "0"},
+			},
+			wantDenied: []string{BudgetChat},
+		},
+		{
+			name: "every other route shares the read budget",
+			requests: []request{
+				{http.MethodGet, "/read", "alice", http.StatusOK, "1"},
+				{http.MethodPost, "/read", "alice", http.StatusOK, "0"},
+				{http.MethodGet, "/read", "alice", http.StatusTooManyRequests, "0"},
+			},
+			wantDenied: []string{BudgetRead},
+		},
+		{
+			name: "budgets are separate",
+			requests: []request{
+				{http.MethodPost, "/send/1", "alice", http.StatusOK, "0"},
+				{http.MethodGet, "/read", "alice", http.StatusOK, "1"},
+			},
+		},
+		{
+			name: "callers are separate",
+			requests: []request{
+				{http.MethodPost, "/send/1", "alice", http.StatusOK, "0"},
+				{http.MethodPost, "/send/1", "bob", http.StatusOK, "0"},
+			},
+		},
+		{
+			name: "unknown routes count as reads",
+			requests: []request{
+				{http.MethodGet, "/missing", "alice", http.StatusNotFound, "1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var denied []string
+			var failures int
+			r := newRateLimitRouter(ratelimit.NewMemory(), &denied, &failures)
+			for i, req := range tt.requests {
+				w := httptest.NewRecorder()
+				httpReq := httptest.NewRequest(req.method, req.path, nil)
+				httpReq.Header.Set("X-Caller", req.caller)
+				r.ServeHTTP(w, httpReq)
+				if w.Code != req.wantStatus {
+					t.Errorf("request %d: status = %d, want %d", i, w.Code, req.wantStatus)
+				}
+				if got := w.Header().Get("RateLimit-Remaining"); got != req.wantRemaining {
+					t.Errorf("request %d: RateLimit-Remaining = %q, want %q", i, got, req.wantRemaining)
+				}
+				if req.wantStatus == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+					t.Errorf("request %d: Retry-After is missing", i)
+				}
+			}
+			if len(denied) != len(tt.wantDenied) {
+				t.Fatalf("denied = %v, want %v", denied, tt.wantDenied)
+			}
+			for i := range denied {
+				if denied[i] != tt.wantDenied[i] {
+					t.Errorf("denied = %v, want %v", denied, tt.wantDenied)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRateLimitMiddleware_LimiterFails(t *testing.T) {
+	var denied []string
+	var failures int
+	r := newRateLimitRouter(brokenLimiter{}, &denied, &failures)
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/send/1", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("request %d: status = %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+	if failures != 3 {
+		t.Errorf("failures = %d, want 3", failures)
+	}
+	if len(denied) != 0 {
+		t.Errorf("denied = %v, want none", denied)
+	}
+}
@@ -0,0 +1,65 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/oauth2"
+)
+
+// Username lengths accepted at signup
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 64
+)
+
+// Flow holds the secrets of a sign-in between sending the user to the provider
+// and the callback
+type Flow struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewFlow makes the secrets of a new sign-in
+func NewFlow() (Flow, error) {
+	state, err := randomString()
+	if err != nil {
+		return Flow{}, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return Flow{}, err
+	}
+	return Flow{State: state, Nonce: nonce, Verifier: oauth2.GenerateVerifier()}, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate sign-in secret: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PreferredUsername is the username to give the user when provisioning their
+// account: the username claim, else the name of their email, else "user".
+// It is shortened to leave room for a suffix when it is already taken.
+func (i Identity) PreferredUsername(suffixLength int) string {
+	username := strings.TrimSpace(i.Username)
+	if utf8.RuneCountInString(username) < minUsernameLength {
+		username, _, _ = strings.Cut(strings.TrimSpace(i.Email), "@")
+	}
+	if utf8.RuneCountInString(username) < minUsernameLength {
+		username = "user"
+	}
+
+	runes := []rune(username)
+	if max := maxUsernameLength - suffixLength; len(runes) > max {
+		runes = runes[:max]
+	}
+	return string(runes)
+}
@@ -0,0 +1,258 @@
+// Package oidc signs users in with an OpenID Connect provider, following the
+// authorization code flow with PKCE. The provider is discovered from its issuer
+// URL on first use, and ID tokens are checked against the keys it publishes.
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// httpTimeout bounds the requests made to the provider
+const httpTimeout = 10 * time.Second
+
+// clockSkew is the difference tolerated between the clocks of the provider and the service
+const clockSkew = time.Minute
+
+// ErrInvalidToken is returned for ID tokens that cannot be trusted
+var ErrInvalidToken = errors.New("ID token is invalid")
+
+// Config is the client registered with the provider and how to read its claims
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// UsernameClaim and EmailClaim name the claims of the ID token read as the
+	// username and email of provisioned users
+	UsernameClaim string
+	EmailClaim    string
+}
+
+// Identity is the user the provider authenticated
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Username      string
+	Email         string
+	EmailVerified bool
+}
+
+// discovery is the part of the provider metadata the flow uses
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider
+type Provider struct {
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keySet
+}
+
+// LoadProvider makes the provider of OIDC_ISSUER, or returns nil when single
+// sign-on is not configured
+func LoadProvider(cfg config.Config) (*Provider, error) {
+	if cfg.OIDCIssuer == "" {
+		return nil, nil
+	}
+	if cfg.OIDCClientID == "" {
+		return nil, errors.New("OIDC_CLIENT_ID is required with OIDC_ISSUER")
+	}
+
+	redirectURL := cfg.OIDCRedirectURL
+	if redirectURL == "" {
+		redirectURL = strings.TrimSuffix(cfg.BaseURL, "/") + "/oidc-callback"
+	}
+	return NewProvider(Config{
+		Issuer:        cfg.OIDCIssuer,
+		ClientID:      cfg.OIDCClientID,
+		ClientSecret:  cfg.OIDCClientSecret,
+		RedirectURL:   redirectURL,
+		Scopes:        strings.FieldsFunc(cfg.OIDCScopes, isListSeparator),
+		UsernameClaim: cfg.OIDCUsernameClaim,
+		EmailClaim:    cfg.OIDCEmailClaim,
+	}, &http.Client{Timeout: httpTimeout}), nil
+}
+
+func isListSeparator(r rune) bool {
+	return r == ' ' || r == ','
+}
+
+// NewProvider makes a provider, discovered on first use with client
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if !hasScope(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.EmailClaim == "" {
+		cfg.EmailClaim = "email"
+	}
+	return &Provider{cfg: cfg, client: client, now: time.Now, keys: &keySet{}}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthCodeURL returns the URL of the provider to send the user to. state and
+// nonce are checked back in Exchange, verifier proves that the code is
+// exchanged by the client that asked for it.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.oauth2Config(d).AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("nonce", nonce)), nil
+}
+
+// Exchange trades the code the provider redirected the user with for the
+// identity of its ID token
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (Identity, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	token, err := p.oauth2Config(d).Exchange(context.WithValue(ctx, oauth2.HTTPClient, p.client), code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("could not exchange code: %v", err)
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return Identity{}, fmt.Errorf("%w: the token response has no ID token", ErrInvalidToken)
+	}
+
+	claims, err := p.verifyIDToken(ctx, d, rawIDToken, nonce)
+	if err != nil {
+		return Identity{}, err
+	}
+	return p.identity(d, claims), nil
+}
+
+// verifyIDToken checks the signature, issuer, audience, lifetime and nonce of an ID token
+func (p *Provider) verifyIDToken(ctx context.Context, d discovery, raw, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.keys.get(ctx, p.client, d.JWKSURI, kid, p.now())
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockSkew),
+		jwt.WithTimeFunc(p.now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	// Tokens issued to several clients name the one they were issued for
+	if azp, ok := claims["azp"].(string); ok && azp != p.cfg.ClientID {
+		return nil, fmt.Errorf("%w: issued for %s", ErrInvalidToken, azp)
+	}
+	got, _ := claims["nonce"].(string)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// identity maps the claims of an ID token to the user they describe
+func (p *Provider) identity(d discovery, claims jwt.MapClaims) Identity {
+	identity := Identity{Issuer: d.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Username, _ = claims[p.cfg.UsernameClaim].(string)
+	identity.Email, _ = claims[p.cfg.EmailClaim].(string)
+
+	// Some providers send the flag as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	return identity
+}
+
+func (p *Provider) oauth2Config(d discovery) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Scopes:       p.cfg.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  d.AuthorizationEndpoint,
+			TokenURL: d.TokenEndpoint,
+		},
+	}
+}
+
+// discover reads the metadata of the provider, once it succeeds
+func (p *Provider) discover(ctx context.Context) (discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return *p.discovery, nil
+	}
+
+	var d discovery
+	url := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, p.client, url, &d); err != nil {
+		return d, fmt.Errorf("could not discover provider: %v", err)
+	}
+	// The metadata must come from the issuer it describes
+	if d.Issuer != p.cfg.Issuer {
+		return d, fmt.Errorf("could not discover provider: issuer %s does not match %s", d.Issuer, p.cfg.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return d, errors.New("could not discover provider: endpoints are missing")
+	}
+	p.discovery = &d
+	return d, nil
+}
+
+// getJSON decodes the JSON document at url
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,351 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/Essen-Labs/bloom-be/pkg/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testClientID     = "bloom"
+	testClientSecret = "secret"
+	testRedirectURL  = "http://localhost:8100/oidc-callback"
+)
+
+func newTestProvider(issuer *oidctest.Issuer, usernameClaim string) *Provider {
+	return NewProvider(Config{
+		Issuer:        issuer.URL,
+		ClientID:      testClientID,
+		ClientSecret:  testClientSecret,
+		RedirectURL:   testRedirectURL,
+		Scopes:        []string{"email", "profile"},
+		UsernameClaim: usernameClaim,
+	}, http.DefaultClient)
+}
+
+// signIn runs the flow up to the code the issuer redirects back with
+func signIn(t *testing.T, p *Provider, issuer *oidctest.Issuer, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), "state-1", nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	code, state, err := issuer.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	if state != "state-1" {
+		t.Fatalf("state = %q, want state-1", state)
+	}
+	return code
+}
+
+func TestProvider_AuthCodeURL(t *testing.T) {
+	issuer := oidctest.NewIssuer(testClientID, testClientSecret)
+	defer issuer.Close()
+	p := newTestProvider(issuer, "")
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state-1", "nonce-1", oauth2.GenerateVerifier())
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("could not parse %q: %v", authURL, err)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"response_type":         "code",
+		"scope":                 "openid email profile",
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := q.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if q.Get("code_challenge") == "" {
+		t.Error("code_challenge is missing")
+	}
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	tests := []struct {
+		name          string
+		usernameClaim string
+		claims        map[string]interface{}
+		want          Identity
+	}{
+		{
+			name: "default claims",
+			want: Identity{Subject: "user-1", Username: "alice", Email: "alice@example.com", EmailVerified: true},
+		},
+		{
+			name:          "mapped username claim",
+			usernameClaim: "login",
+			claims:        map[string]interface{}{"login": "alice.smith"},
+			want:          Identity{Subject: "user-1", Username: "alice.smith", Email: "alice@example.com", EmailVerified: true},
+		},
+		{
+			name:   "email verified as a string",
+			claims: map[string]interface{}{"email_verified": "true"},
+			want:   Identity{Subject: "user-1", Username: "alice", Email: "alice@example.com", EmailVerified: true},
+		},
+		{
+			name:   "unverified email",
+			claims: map[string]interface{}{"email_verified": false},
+			want:   Identity{Subject: "user-1", Username: "alice", Email: "alice@example.com"},
+		},
+		{
+			name:   "no email",
+			claims: map[string]interface{}{"email": nil, "email_verified": nil},
+			want:   Identity{Subject: "user-1", Username: "alice"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := oidctest.NewIssuer(testClientID, testClientSecret)
+			defer issuer.Close()
+			issuer.SetClaims(tt.claims)
+			p := newTestProvider(issuer, tt.usernameClaim)
+
+			verifier := oauth2.GenerateVerifier()
+			code := signIn(t, p, issuer, "nonce-1", verifier)
+			got, err := p.Exchange(context.Background(), code, "nonce-1", verifier)
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			tt.want.Issuer = issuer.URL
+			if got != tt.want {
+				t.Errorf("Exchange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProvider_ExchangeRejected(t *testing.T) {
+	tests := []struct {
+		name        string
+		claims      map[string]interface{}
+		nonce       string
+		verifier    string
+		wantInvalid bool
+	}{
+		{name: "wrong verifier", verifier: oauth2.GenerateVerifier()},
+		{name: "wrong nonce", nonce: "nonce-2", wantInvalid: true},
+		{name: "other audience", claims: map[string]interface{}{"aud": "other-client"}, wantInvalid: true},
+		{name: "issued for another client", claims: map[string]interface{}{"aud": []string{testClientID, "other-client"}, "azp": "other-client"}, wantInvalid: true},
+		{name: "other issuer", claims: map[string]interface{}{"iss": "https://evil.example.com"}, wantInvalid: true},
+		{name: "expired", claims: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}, wantInvalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := oidctest.NewIssuer(testClientID, testClientSecret)
+			defer issuer.Close()
+			issuer.SetClaims(tt.claims)
+			p := newTestProvider(issuer, "")
+
+			verifier := oauth2.GenerateVerifier()
+			code := signIn(t, p, issuer, "nonce-1", verifier)
+			nonce, exchangeVerifier := "nonce-1", verifier
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			if tt.verifier != "" {
+				exchangeVerifier = tt.verifier
+			}
+			_, err := p.Exchange(context.Background(), code, nonce, exchangeVerifier)
+			if err == nil {
+				t.Fatal("Exchange() error = nil, want an error")
+			}
+			if got := errors.Is(err, ErrInvalidToken); got != tt.wantInvalid {
+				t.Errorf("Exchange() error = %v, want ErrInvalidToken %v", err, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestProvider_VerifyIDToken(t *testing.T) {
+	issuer := oidctest.NewIssuer(testClientID, testClientSecret)
+	defer issuer.Close()
+	p := newTestProvider(issuer, "")
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	d, err := p.discover(context.Background())
+	if err != nil {
+		t.Fatalf("discover() error = %v", err)
+	}
+
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   issuer.URL,
+			"aud":   testClientID,
+			"sub":   "user-1",
+			"iat":   now.Unix(),
+			"exp":   now.Add(5 * time.Minute).Unix(),
+			"nonce": "nonce-1",
+		}
+	}
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("could not make unsigned token: %v", err)
+	}
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims()).SignedString([]byte(testClientSecret))
+	if err != nil {
+		t.Fatalf("could not make HMAC token: %v", err)
+	}
+
+	if _, err := p.verifyIDToken(context.Background(), d, issuer.SignIDToken(claims()), "nonce-1"); err != nil {
+		t.Fatalf("verifyIDToken() error = %v", err)
+	}
+	if _, err := p.verifyIDToken(context.Background(), d, unsigned, "nonce-1"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("unsigned token error = %v, want ErrInvalidToken", err)
+	}
+	if _, err := p.verifyIDToken(context.Background(), d, hmac, "nonce-1"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("HMAC token error = %v, want ErrInvalidToken", err)
+	}
+	if _, err := p.verifyIDToken(context.Background(), d, issuer.SignIDToken(claims()), ""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token without expected nonce error = %v, want ErrInvalidToken", err)
+	}
+
+	// Keys are fetched again after a rotation, though not more than once a minute
+	issuer.RotateKey()
+	rotated := issuer.SignIDToken(claims())
+	if _, err := p.verifyIDToken(context.Background(), d, rotated, "nonce-1"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token of a rotated key right after fetching error = %v, want ErrInvalidToken", err)
+	}
+	now = now.Add(keysRefreshInterval)
+	if _, err := p.verifyIDToken(context.Background(), d, rotated, "nonce-1"); err != nil {
+		t.Errorf("token of a rotated key error = %v, want nil", err)
+	}
+}
+
+func TestProvider_Discover(t *testing.T) {
+	issuer := oidctest.NewIssuer(testClientID, testClientSecret)
+	defer issuer.Close()
+
+	// The issuer must match the metadata exactly, so a trailing slash is refused
+	p := NewProvider(Config{Issuer: issuer.URL + "/", ClientID: testClientID}, http.DefaultClient)
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", oauth2.GenerateVerifier()); err == nil {
+		t.Error("AuthCodeURL() error = nil, want an issuer mismatch")
+	}
+
+	issuer.Close()
+	p = newTestProvider(issuer, "")
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", oauth2.GenerateVerifier()); err == nil {
+		t.Error("AuthCodeURL() error = nil with the issuer down")
+	}
+}
+
+func TestLoadProvider(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          config.Config
+		wantProvider bool
+		wantErr      bool
+		wantRedirect string
+		wantScopes   []string
+	}{
+		{
+			name: "not configured",
+		},
+		{
+			name:    "missing client id",
+			cfg:     config.Config{OIDCIssuer: "https://id.example.com"},
+			wantErr: true,
+		},
+		{
+			name:         "default redirect",
+			cfg:          config.Config{BaseURL: "https://api.example.com/", OIDCIssuer: "https://id.example.com", OIDCClientID: "bloom", OIDCScopes: "email, groups"},
+			wantProvider: true,
+			wantRedirect: "https://api.example.com/oidc-callback",
+			wantScopes:   []string{"openid", "email", "groups"},
+		},
+		{
+			name:         "configured redirect",
+			cfg:          config.Config{OIDCIssuer: "https://id.example.com", OIDCClientID: "bloom", OIDCRedirectURL: "https://bloom.example.com/api/oidc-callback", OIDCScopes: "openid profile"},
+			wantProvider: true,
+			wantRedirect: "https://bloom.example.com/api/oidc-callback",
+			wantScopes:   []string{"openid", "profile"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := LoadProvider(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadProvider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (p != nil) != tt.wantProvider {
+				t.Fatalf("LoadProvider() = %v, want provider %v", p, tt.wantProvider)
+			}
+			if p == nil {
+				return
+			}
+			if p.cfg.RedirectURL != tt.wantRedirect {
+				t.Errorf("RedirectURL = %q, want %q", p.cfg.RedirectURL, tt.wantRedirect)
+			}
+			if len(p.cfg.Scopes) != len(tt.wantScopes) {
+				t.Fatalf("Scopes = %v, want %v", p.cfg.Scopes, tt.wantScopes)
+			}
+			for i := range tt.wantScopes {
+				if p.cfg.Scopes[i] != tt.wantScopes[i] {
+					t.Errorf("Scopes = %v, want %v", p.cfg.Scopes, tt.wantScopes)
+				}
+			}
+		})
+	}
+}
+
+func TestIdentity_PreferredUsername(t *testing.T) {
+	long := "a-very-long-username-that-goes-on-and-on-past-the-limit-of-sixty-four"
+	tests := []struct {
+		name         string
+		identity     Identity
+		suffixLength int
+		want         string
+	}{
+		{name: "username claim", identity: Identity{Username: " alice ", Email: "bob@example.com"}, want: "alice"},
+		{name: "email without username", identity: Identity{Email: "bob@example.com"}, want: "bob"},
+		{name: "short username", identity: Identity{Username: "al", Email: "alice.smith@example.com"}, want: "alice.smith"},
+		{name: "nothing usable", identity: Identity{Username: "a", Email: "b@example.com"}, want: "user"},
+		{name: "too long", identity: Identity{Username: long}, want: long[:64]},
+		{name: "room for a suffix", identity: Identity{Username: long}, suffixLength: 5, want: long[:59]},
+		{name: "multibyte characters", identity: Identity{Username: "nguyễn-văn-an"}, suffixLength: 60, want: "nguy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.identity.PreferredUsername(tt.suffixLength); got != tt.want {
+				t.Errorf("PreferredUsername() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFlow(t *testing.T) {
+	flow, err := NewFlow()
+	if err != nil {
+		t.Fatalf("NewFlow() error = %v", err)
+	}
+	if flow.State == "" || flow.Nonce == "" || flow.Verifier == "" || flow.State == flow.Nonce {
+		t.Errorf("NewFlow() = %+v, want distinct secrets", flow)
+	}
+	other, err := NewFlow()
+	if err != nil {
+		t.Fatalf("NewFlow() error = %v", err)
+	}
+	if other.State == flow.State || other.Verifier == flow.Verifier {
+		t.Error("NewFlow() returned the same secrets twice")
+	}
+}
@@ -0,0 +1,181 @@
+// Package ratelimit limits how often a key, like a user or an API key, may make
+// requests. Each key has a token bucket holding up to Limit.Requests tokens,
+// refilled at Limit.Requests per Limit.Period; every request takes a token.
+// Buckets are kept in memory by each instance of the service, or in Redis to
+// share them between instances.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces the buckets in Redis
+const redisKeyPrefix = "bloom:ratelimit:"
+
+// Limiter takes tokens from the buckets of keys
+type Limiter interface {
+	// Allow takes a token from the bucket of key. Limits without requests
+	// allow everything.
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// LoadLimiter makes the limiter of RATE_LIMIT_BACKEND, memory or redis
+func LoadLimiter(cfg config.Config) (Limiter, error) {
+	switch cfg.RateLimitBackend {
+	case "", "memory":
+		return NewMemory(), nil
+	case "redis":
+		if cfg.RateLimitRedisURL == "" {
+			return nil, fmt.Errorf("RATE_LIMIT_REDIS_URL is required with RATE_LIMIT_BACKEND=redis")
+		}
+		opts, err := redis.ParseURL(cfg.RateLimitRedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse RATE_LIMIT_REDIS_URL: %v", err)
+		}
+		return NewRedis(redis.NewClient(opts), redisKeyPrefix), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_BACKEND %q", cfg.RateLimitBackend)
+	}
+}
+
+// Limit is the budget of a key
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// PerMinute returns a limit of n requests per minute
+func PerMinute(n int) Limit {
+	return Limit{Requests: n, Period: time.Minute}
+}
+
+// unlimited tells whether the limit allows every request
+func (l Limit) unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// interval is the time to refill a token
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Result is the outcome of a request and the state of its bucket
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is the wait before the next request is allowed, zero when allowed
+	RetryAfter time.Duration
+	// Reset is the wait before the bucket is full again
+	Reset time.Duration
+}
+
+// bucket is the state of a key, tokens are fractional as they refill continuously
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket up to now and takes a token when one is left
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	capacity := float64(limit.Requests)
+	interval := limit.interval()
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+float64(elapsed)/float64(interval))
+		b.updated = now
+	}
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return newResult(limit, b.tokens, allowed)
+}
+
+// newResult describes a bucket left with tokens once a request took one, or
+// was denied for lack of one
+func newResult(limit Limit, tokens float64, allowed bool) Result {
+	interval := limit.interval()
+	res := Result{Allowed: allowed, Limit: limit.Requests, Remaining: int(tokens)}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) * float64(interval))
+	}
+	res.Reset = time.Duration((float64(limit.Requests) - tokens) * float64(interval))
+	return res
+}
+
+// SetHeaders reports the budget left to the client, in the fields of the IETF
+// RateLimit header draft, and when to retry once it is spent. When several
+// limits apply to a request, the one with the fewest requests left is reported.
+func SetHeaders(h http.Header, res Result) {
+	if remaining, err := strconv.Atoi(h.Get("RateLimit-Remaining")); err == nil && res.Allowed && remaining <= res.Remaining {
+		return
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+	}
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Memory keeps the buckets in memory, each instance of the service limiting
+// its own requests
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewMemory makes an in-memory limiter
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+// Allow takes a token from the bucket of key, it never fails
+func (m *Memory) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.unlimited() {
+		return Result{Allowed: true}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now, limit.Period)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), updated: now}
+		m.buckets[key] = b
+	}
+	return b.take(limit, now), nil
+}
+
+// sweep forgets, once per period, the buckets untouched for a period, which
+// are full again and would start over the same
+func (m *Memory) sweep(now time.Time, period time.Duration) {
+	if now.Sub(m.swept) < period {
+		return
+	}
+	m.swept = now
+	for key, b := range m.buckets {
+		if now.Sub(b.updated) >= period {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemory_Allow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := PerMinute(3)
+
+	tests := []struct {
+		name          string
+		key           string
+		at            time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+	}{
+		{name: "first request", key: "a", at: 0, wantAllowed: true, wantRemaining: 2},
+		{name: "second request", key: "a", at: 0, wantAllowed: true, wantRemaining: 1},
+		{name: "third request", key: "a", at: 0, wantAllowed: true, wantRemaining: 0},
+		{name: "bucket empty", key: "a", at: 0, wantAllowed: false, wantRemaining: 0, wantRetry: 20 * time.Second},
+		{name: "other key has its own bucket", key: "b", at: 0, wantAllowed: true, wantRemaining: 2},
+		{name: "partly refilled", key: "a", at: 10 * time.Second, wantAllowed: false, wantRemaining: 0, wantRetry: 10 * time.Second},
+		{name: "one token refilled", key: "a", at: 20 * time.Second, wantAllowed: true, wantRemaining: 0},
+		{name: "refill stops at the limit", key: "a", at: time.Hour, wantAllowed: true, wantRemaining: 2},
+	}
+
+	m := NewMemory()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.now = func() time.Time { return start.Add(tt.at) }
+			got, err := m.Allow(context.Background(), tt.key, limit)
+			if err != nil {
+				t.Fatalf("Allow() error = %v", err)
+			}
+			if got.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", got.Allowed, tt.wantAllowed)
+			}
+			if got.Remaining != tt.wantRemaining {
+				t.Errorf("Remaining = %d, want %d", got.Remaining, tt.wantRemaining)
+			}
+			if got.RetryAfter != tt.wantRetry {
+				t.Errorf("RetryAfter = %v, want %v", got.RetryAfter, tt.wantRetry)
+			}
+			if got.Limit != limit.Requests {
+				t.Errorf("Limit = %d, want %d", got.Limit, limit.Requests)
+			}
+		})
+	}
+}
+
+func TestMemory_AllowWithoutLimit(t *testing.T) {
+	m := NewMemory()
+	for i := 0; i < 100; i++ {
+		if res, _ := m.Allow(context.Background(), "a", Limit{}); !res.Allowed {
+			t.Fatalf("request %d denied without limit", i)
+		}
+	}
+}
+
+func TestMemory_Sweep(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+
+	m.Allow(context.Background(), "a", PerMinute(1)) //nolint:errcheck // memory never fails
+	now = start.Add(2 * time.Minute)
+	m.Allow(context.Background(), "b", PerMinute(1)) //nolint:errcheck // memory never fails
+	if _, ok := m.buckets["a"]; ok {
+		t.Error("idle bucket was not swept")
+	}
+	if _, ok := m.buckets["b"]; !ok {
+		t.Error("bucket in use was swept")
+	}
+}
+
+func TestRedis_Allow(t *testing.T) {
+	server := miniredis.RunT(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := PerMinute(3)
+
+	tests := []struct {
+		name          string
+		key           string
+		at            time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+	}{
+		{name: "first request", key: "a", at: 0, wantAllowed: true, wantRemaining: 2},
+		{name: "second request", key: "a", at: 0, wantAllowed: true, wantRemaining: 1},
+		{name: "third request", key: "a", at: 0, wantAllowed: true, wantRemaining: 0},
+		{name: "bucket empty", key: "a", at: 0, wantAllowed: false, wantRemaining: 0, wantRetry: 20 * time.Second},
+		{name: "other key has its own bucket", key: "b", at: 0, wantAllowed: true, wantRemaining: 2},
+		{name: "partly refilled", key: "a", at: 10 * time.Second, wantAllowed: false, wantRemaining: 0, wantRetry: 10 * time.Second},
+		{name: "one token refilled", key: "a", at: 20 * time.Second, wantAllowed: true, wantRemaining: 0},
+		{name: "refill stops at the limit", key: "a", at: time.Hour, wantAllowed: true, wantRemaining: 2},
+	}
+
+	r := NewRedis(redis.NewClient(&redis.Options{Addr: server.Addr()}), "test:")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.SetTime(start.Add(tt.at))
+			got, err := r.Allow(context.Background(), tt.key, limit)
+			if err != nil {
+				t.Fatalf("Allow() error = %v", err)
+			}
+			if got.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", got.Allowed, tt.wantAllowed)
+			}
+			if got.Remaining != tt.wantRemaining {
+				t.Errorf("Remaining = %d, want %d", got.Remaining, tt.wantRemaining)
+			}
+			if got.RetryAfter != tt.wantRetry {
+				t.Errorf("RetryAfter = %v, want %v", got.RetryAfter, tt.wantRetry)
+			}
+		})
+	}
+
+	if !server.Exists("test:a") {
+		t.Error("bucket is not stored under the prefix")
+	}
+}
+
+func TestRedis_AllowWithoutLimit(t *testing.T) {
+	server := miniredis.RunT(t)
+	r := NewRedis(redis.NewClient(&redis.Options{Addr: server.Addr()}), "test:")
+	for i := 0; i < 10; i++ {
+		if res, err := r.Allow(context.Background(), "a", Limit{}); err != nil || !res.Allowed {
+			t.Fatalf("request %d denied without limit: %v", i, err)
+		}
+	}
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Errorf("unlimited requests stored buckets %v", keys)
+	}
+}
+
+func TestRedis_AllowUnavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+	r := NewRedis(redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1}), "test:")
+	server.Close()
+	if _, err := r.Allow(context.Background(), "a", PerMinute(1)); err == nil {
+		t.Error("Allow() error = nil with the server down")
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []Result
+		want    map[string]string
+	}{
+		{
+			name:    "allowed",
+			results: []Result{{Allowed: true, Limit: 10, Remaining: 9, Reset: 5500 * time.Millisecond}},
+			want:    map[string]string{"RateLimit-Limit": "10", "RateLimit-Remaining": "9", "RateLimit-Reset": "6", "Retry-After": ""},
+		},
+		{
+			name:    "denied",
+			results: []Result{{Limit: 10, RetryAfter: 300 * time.Millisecond, Reset: time.Minute}},
+			want:    map[string]string{"RateLimit-Limit": "10", "RateLimit-Remaining": "0", "RateLimit-Reset": "60", "Retry-After": "1"},
+		},
+		{
+			name: "fewest remaining is kept",
+			results: []Result{
+				{Allowed: true, Limit: 5, Remaining: 1, Reset: time.Second},
+				{Allowed: true, Limit: 100, Remaining: 50, Reset: time.Second},
+			},
+			want: map[string]string{"RateLimit-Limit": "5", "RateLimit-Remaining": "1"},
+		},
+		{
+			name: "fewer remaining replaces",
+			results: []Result{
+				{Allowed: true, Limit: 100, Remaining: 50, Reset: time.Second},
+				{Allowed: true, Limit: 5, Remaining: 1, Reset: time.Second},
+			},
+			want: map[string]string{"RateLimit-Limit": "5", "RateLimit-Remaining": "1"},
+		},
+		{
+			name: "denial replaces",
+			results: []Result{
+				{Allowed: true, Limit: 5, Remaining: 0, Reset: time.Second},
+				{Limit: 100, RetryAfter: 2 * time.Second, Reset: time.Second},
+			},
+			want: map[string]string{"RateLimit-Limit": "100", "Retry-After": "2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for _, res := range tt.results {
+				SetHeaders(h, res)
+			}
+			for name, want := range tt.want {
+				if got := h.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		want    Limiter
+		wantErr bool
+	}{
+		{name: "memory by default", cfg: config.Config{}, want: &Memory{}},
+		{name: "memory", cfg: config.Config{RateLimitBackend: "memory"}, want: &Memory{}},
+		{name: "redis", cfg: config.Config{RateLimitBackend: "redis", RateLimitRedisURL: "redis://localhost:6379/0"}, want: &Redis{}},
+		{name: "redis without URL", cfg: config.Config{RateLimitBackend: "redis"}, wantErr: true},
+		{name: "redis with invalid URL", cfg: config.Config{RateLimitBackend: "redis", RateLimitRedisURL: "http://localhost"}, wantErr: true},
+		{name: "unknown backend", cfg: config.Config{RateLimitBackend: "memcached"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadLimiter(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadLimiter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if fmt.Sprintf("%T", got) != fmt.Sprintf("%T", tt.want) {
+				t.Errorf("LoadLimiter() = %T, want %T", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript refills and takes a token from the bucket of KEYS[1] atomically,
+// holding ARGV[1] tokens refilled one per ARGV[2] microseconds. The clock of
+// the server is used so that instances with drifting clocks share buckets
+// fairly. Numbers are formatted by hand as Lua would round them. It returns
+// whether the request is allowed and the tokens left.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens = capacity
+	updated = now
+end
+if now > updated then
+	tokens = math.min(capacity, tokens + (now - updated) / interval)
+	updated = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', string.format('%.6f', tokens), 'updated', string.format('%.0f', updated))
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) * interval / 1000) + 1000)
+return {allowed, string.format('%.6f', tokens)}
+`)
+
+// Redis keeps the buckets in Redis, or a server speaking its protocol, so that
+// every instance of the service shares them. Buckets expire once full again.
+type Redis struct {
+	client redis.Scripter
+	prefix string
+}
+
+// NewRedis makes a limiter keeping its buckets in client, under keys starting with prefix
+func NewRedis(client redis.Scripter, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+// Allow takes a token from the bucket of key
+func (r *Redis) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.unlimited() {
+		return Result{Allowed: true}, nil
+	}
+
+	interval := limit.interval() / time.Microsecond
+	if interval < 1 {
+		interval = 1
+	}
+	values, err := takeScript.Run(ctx, r.client, []string{r.prefix + key}, limit.Requests, int64(interval)).Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("could not take token: %v", err)
+	}
+	if len(values) != 2 {
+		return Result{}, fmt.Errorf("could not take token: unexpected reply %v", values)
+	}
+	allowed, _ := values[0].(int64)
+	left, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(left, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("could not take token: unexpected reply %v", values)
+	}
+	return newResult(limit, tokens, allowed == 1), nil
+}
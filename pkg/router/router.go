@@ -0,0 +1,126 @@
+// Package router registers the routes of the API and their middlewares
+package router
+
+import (
+	"log"
+
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/Essen-Labs/bloom-be/pkg/handler"
+	"github.com/Essen-Labs/bloom-be/pkg/middleware"
+	"github.com/Essen-Labs/bloom-be/pkg/validator"
+	"github.com/Essen-Labs/bloom-be/translation"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	_ "github.com/Essen-Labs/bloom-be/docs"
+	swaggerFiles "github.com/swaggo/files"
+	ginSwagger "github.com/swaggo/gin-swagger"
+)
+
+// New makes the router of the API, serving the routes of h
+func New(cfg config.Config, th translation.Helper, h *handler.Handler) *gin.Engine {
+	r := gin.New()
+	// Client IPs key rate limits and audit entries, X-Forwarded-For is only
+	// believed from the configured proxies
+	if err := r.SetTrustedProxies(cfg.GetTrustedProxies()); err != nil {
+		log.Fatal("Error setting trusted proxies: ", err)
+	}
+	binding.Validator = validator.NewStructValidator(th)
+	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	r.Use(middleware.NewLogDataMiddleware(cfg.ServiceName, cfg.Env))
+	r.Use(cors.New(cors.Config{
+		AllowOrigins:     []string{"*"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "HEAD"},
+		AllowHeaders:     []string{"Origin", "Host", "Content-Type", "Content-Length", "Accept-Encoding", "Accept-Language", "Accept", "X-CSRF-Token", "Authorization", "X-Requested-With", "X-Access-Token", "If-Match"},
+		ExposeHeaders:    []string{"Content-Length", "ETag", "WWW-Authenticate", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+		AllowCredentials: true,
+	}))
+
+	// handlers
+	r.GET("/healthz", h.Healthz)
+	r.GET("/readyz", h.Readyz)
+
+	// public handlers, rate limited by IP
+	rateLimit := h.RateLimit()
+	public := r.Group("", rateLimit)
+	public.POST("/signup", h.Signup)
+	public.POST("/login", h.Login)
+	public.POST("/refresh-token", h.RefreshToken)
+	public.POST("/logout", h.Logout)
+	public.GET("/oidc-login", h.OIDCLogin)
+	public.GET("/oidc-callback", h.OIDCCallback)
+	public.POST("/verify-email", h.VerifyEmail)
+	public.POST("/forgot-password", h.ForgotPassword)
+	public.POST("/reset-password", h.ResetPassword)
+	public.GET("/shared/:token", h.GetSharedChat)
+
+	// handlers of the authenticated user
+	authed := r.Group("", h.Authenticate, rateLimit, h.Authorize())
+	authed.GET("/get-current-user", h.GetCurrentUser)
+	authed.POST("/send-verification-email", h.SendVerificationEmail)
+	authed.GET("/get-chat-by-id/:conversation_id", h.GetChatById)
+	authed.GET("/get-chat-list", h.GetAllChat)
+	authed.POST("/send-chat", h.Completions)
+	authed.GET("/get-all-msgs-by-id/:conversation_id", h.GetAllMsgsByID)
+	authed.DELETE("/delete-chat/:conversation_id", h.DeleteChatById)
+	authed.DELETE("/delete-all-chat", h.DeleteAllChat)
+	authed.POST("/edit-chat", h.EditChat)
+	authed.POST("/fork-chat", h.ForkChat)
+	authed.GET("/search-chat", h.SearchChat)
+	authed.GET("/semantic-search-chat", h.SemanticSearchChat)
+	authed.GET("/get-trash-list", h.GetTrashList)
+	authed.POST("/restore-chat/:conversation_id", h.RestoreChatById)
+	authed.DELETE("/purge-chat/:conversation_id", h.PurgeChatById)
+	authed.DELETE("/empty-trash", h.EmptyTrash)
+	authed.POST("/pin-chat", h.PinChat)
+	authed.POST("/archive-chat", h.ArchiveChat)
+	authed.POST("/move-chat", h.MoveChat)
+	authed.POST("/bulk-chat", h.BulkChat)
+	authed.GET("/get-folder-list", h.GetFolderList)
+	authed.POST("/create-folder", h.CreateFolder)
+	authed.POST("/edit-folder", h.EditFolder)
+	authed.DELETE("/delete-folder/:folder_id", h.DeleteFolder)
+	authed.GET("/get-tag-list", h.GetTagList)
+	authed.POST("/tag-chat", h.TagChat)
+	authed.POST("/untag-chat", h.UntagChat)
+	authed.DELETE("/delete-tag/:tag_id", h.DeleteTag)
+	authed.GET("/export-chat/:conversation_id", h.ExportChat)
+	authed.GET("/export-all-chat", h.ExportAllChat)
+	authed.POST("/import-chat", h.ImportChat)
+	authed.POST("/share-chat", h.ShareChat)
+	authed.GET("/get-share-list", h.GetShareList)
+	authed.DELETE("/revoke-share/:share_id", h.RevokeShare)
+	authed.POST("/fork-shared-chat/:token", h.ForkSharedChat)
+	authed.GET("/get-retention-policy", h.GetRetentionPolicy)
+	authed.POST("/set-retention-policy", h.SetRetentionPolicy)
+	authed.DELETE("/delete-retention-policy", h.DeleteRetentionPolicy)
+	authed.GET("/get-retention-report", h.GetRetentionReport)
+	authed.POST("/set-feedback", h.SetFeedback)
+	authed.DELETE("/clear-feedback/:message_id", h.ClearFeedback)
+	authed.GET("/get-feedback-report", h.GetFeedbackReport)
+	authed.GET("/get-audit-log", h.GetAuditLog)
+	authed.GET("/export-audit-log", h.ExportAuditLog)
+	authed.POST("/create-api-key", h.CreateAPIKey)
+	authed.GET("/get-api-key-list", h.GetAPIKeyList)
+	authed.DELETE("/revoke-api-key/:key_id", h.RevokeAPIKey)
+	authed.POST("/request-data-export", h.RequestDataExport)
+	authed.GET("/get-data-export-list", h.GetDataExportList)
+	authed.GET("/download-data-export/:export_id", h.DownloadDataExport)
+	authed.POST("/delete-account", h.DeleteAccount)
+	authed.POST("/cancel-account-deletion", h.CancelAccountDeletion)
+
+	admin := authed.Group("/admin", h.RequireAdmin)
+	admin.GET("/get-user-list", h.GetUserList)
+	admin.GET("/get-user-usage/:user_id", h.GetUserUsage)
+	admin.GET("/get-user-chat-list/:user_id", h.GetUserChatList)
+	admin.GET("/get-user-chat/:user_id/:conversation_id", h.GetUserChat)
+	admin.POST("/disable-user", h.DisableUser)
+	admin.POST("/enable-user", h.EnableUser)
+	admin.POST("/logout-user", h.LogoutUser)
+	admin.POST("/set-user-quota", h.SetUserQuota)
+	admin.POST("/set-user-role", h.SetUserRole)
+	admin.GET("/get-database-status", h.GetDatabaseStatus)
+	// r.GET("/get-model-list", h.Completions)     // TODO
+	return r
+}
@@ -0,0 +1,309 @@
+package router
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
+	"github.com/Essen-Labs/bloom-be/pkg/authz"
+	"github.com/Essen-Labs/bloom-be/pkg/config"
+	"github.com/Essen-Labs/bloom-be/pkg/database/dbtest"
+	"github.com/Essen-Labs/bloom-be/pkg/handler"
+	"github.com/Essen-Labs/bloom-be/translation"
+	"github.com/dwarvesf/gerr"
+	"github.com/gin-gonic/gin"
+)
+
+// unauthorizedRoutes are the routes without authz rule, with the reason they
+// need none. A route acting on a resource of a given user must have a rule or
+// check the owner in its handler.
+var unauthorizedRoutes = map[string]string{
+	"GET /swagger/*any":     "public",
+	"GET /healthz":          "public",
+	"GET /readyz":           "public",
+	"POST /signup":          "public",
+	"POST /login":           "public",
+	"POST /refresh-token":   "public",
+	"POST /logout":          "public",
+	"GET /oidc-login":       "public",
+	"GET /oidc-callback":    "public",
+	"POST /verify-email":    "public",
+	"POST /forgot-password": "public",
+	"POST /reset-password":  "public",
+	"GET /shared/:token":    "public, the token is the secret of the share",
+
+	"GET /get-current-user":           "acts on the user",
+	"POST /send-verification-email":   "acts on the user",
+	"GET /get-chat-list":              "acts on the user",
+	"DELETE /delete-all-chat":         "acts on the user",
+	"GET /search-chat":                "acts on the user",
+	"GET /semantic-search-chat":       "acts on the user",
+	"GET /get-trash-list":             "acts on the user",
+	"DELETE /empty-trash":             "acts on the user",
+	"GET /get-folder-list":            "acts on the user",
+	"POST /create-folder":             "acts on the user",
+	"GET /get-tag-list":               "acts on the user",
+	"GET /export-all-chat":            "acts on the user",
+	"POST /import-chat":               "acts on the user",
+	"GET /get-share-list":             "acts on the user",
+	"GET /get-retention-policy":       "acts on the user",
+	"POST /set-retention-policy":      "acts on the user",
+	"DELETE /delete-retention-policy": "acts on the user",
+	"GET /get-retention-report":       "acts on the user",
+	"GET /get-feedback-report":        "acts on the user",
+	"GET /get-audit-log":              "acts on the user",
+	"GET /export-audit-log":           "acts on the user",
+	"POST /create-api-key":            "acts on the user",
+	"GET /get-api-key-list":           "acts on the user",
+	"POST /request-data-export":       "acts on the user",
+	"GET /get-data-export-list":       "acts on the user",
+	"POST /delete-account":            "acts on the user",
+	"POST /cancel-account-deletion":   "acts on the user",
+
+	"POST /bulk-chat":                      "checks each conversation and its folder in the handler",
+	"POST /fork-shared-chat/:token":        "the token is the secret of the share, whoever holds it may fork",
+	"DELETE /revoke-share/:share_id":       "checks the owner in the handler, see TestHandler_OwnedByOtherUser",
+	"DELETE /revoke-api-key/:key_id":       "checks the owner in the handler, see TestHandler_OwnedByOtherUser",
+	"GET /download-data-export/:export_id": "checks the owner in the handler, see TestHandler_OwnedByOtherUser",
+
+	"GET /admin/get-user-list":                           "admins only",
+	"GET /admin/get-user-usage/:user_id":                 "admins only",
+	"GET /admin/get-user-chat-list/:user_id":             "admins only",
+	"GET /admin/get-user-chat/:user_id/:conversation_id": "admins only",
+	"POST /admin/disable-user":                           "admins only",
+	"POST /admin/enable-user":                            "admins only",
+	"POST /admin/logout-user":                            "admins only",
+	"POST /admin/set-user-quota":                         "admins only",
+	"POST /admin/set-user-role":                          "admins only",
+	"GET /admin/get-database-status":                     "admins only",
+}
+
+func TestNew_Authorization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, _ := dbtest.Open(nil)
+	defer db.Close()
+	cfg, th := config.Config{}, translation.NewTranslatorHelper()
+	h := handler.NewHandler(cfg, gerr.NewSimpleLog(), th, db, nil, nil)
+
+	rules := map[string]bool{}
+	for _, rule := range authz.Rules {
+		rules[rule.Method+" "+rule.Path] = true
+	}
+
+	routes := map[string]bool{}
+	for _, route := range New(cfg, th, h).Routes() {
+		key := route.Method + " " + route.Path
+		routes[key] = true
+		_, exempted := unauthorizedRoutes[key]
+		switch {
+		case rules[key] && exempted:
+			t.Errorf("route %s has an authz rule and is exempted from it", key)
+		case !rules[key] && !exempted:
+			t.Errorf("route %s has no authz rule, add one to authz.Rules or exempt it with a reason", key)
+		}
+	}
+
+	for key := range rules {
+		if !routes[key] {
+			t.Errorf("authz rule for %s matches no route", key)
+		}
+	}
+	for key := range unauthorizedRoutes {
+		if !routes[key] {
+			t.Errorf("exempted route %s does not exist", key)
+		}
+	}
+}
+
+// testServer is the router of the app over a fake database, requests are sent
+// with access tokens of the given users
+type testServer struct {
+	router *gin.Engine
+	tokens *auth.Tokens
+	db     *dbtest.DB
+}
+
+func newTestServer(t *testing.T, handle dbtest.Handler) testServer {
+	gin.SetMode(gin.TestMode)
+	keys, err := auth.ParseSigningKeys("k1:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := auth.NewTokens(keys, time.Minute)
+	db, fake := dbtest.Open(handle)
+	t.Cleanup(func() { db.Close() })
+
+	cfg, th := config.Config{PasswordLogin: true}, translation.NewTranslatorHelper()
+	h := handler.NewHandler(cfg, gerr.NewSimpleLog(), th, db, nil, nil).WithTokens(tokens)
+	return testServer{router: New(cfg, th, h), tokens: tokens, db: fake}
+}
+
+func (s testServer) do(t *testing.T, userID, method, path, body string) *httptest.ResponseRecorder {
+	token, _, err := s.tokens.Issue(userID, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// accountRow answers the account check of Authenticate, for any user
+func accountRow(q dbtest.Query) (dbtest.Result, bool) {
+	if !q.Has("SELECT role, disabled_at IS NOT NULL, sessions_revoked_at") {
+		return dbtest.Result{}, false
+	}
+	return dbtest.Result{
+		Columns: []string{"role", "disabled", "sessions_revoked_at"},
+		Rows:    [][]driver.Value{{"user", false, nil}},
+	}, true
+}
+
+func TestSendChat_Trashed(t *testing.T) {
+	const owner = "owner"
+	s := newTestServer(t, func(q dbtest.Query) (dbtest.Result, error) {
+		if res, ok := accountRow(q); ok {
+			return res, nil
+		}
+		switch {
+		case q.Has("SELECT coalesce(user_id, ''), deleted_at IS NOT NULL FROM conversations"):
+			return dbtest.Result{Columns: []string{"user_id", "trashed"}, Rows: [][]driver.Value{{owner, true}}}, nil
+		case q.Has("INSERT INTO users"):
+			return dbtest.Result{RowsAffected: 1}, nil
+		case q.Has("INSERT INTO user_usage"):
+			return dbtest.Result{Columns: []string{"messages"}, Rows: [][]driver.Value{{int64(1)}}}, nil
+		case q.Has("SELECT deleted_at IS NOT NULL FROM conversations"):
+			if q.Args[1] != owner {
+				return dbtest.Result{Columns: []string{"trashed"}}, nil
+			}
+			return dbtest.Result{Columns: []string{"trashed"}, Rows: [][]driver.Value{{true}}}, nil
+		}
+		return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+	})
+
+	w := s.do(t, owner, http.MethodPost, "/send-chat", `{"role": "user", "content": "hello", "conversation_id": "1"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+}
+
+// loaderQueries are the statements of authz.SQLLoader, by resource type
+var loaderQueries = map[string]string{
+	authz.Conversation: "SELECT coalesce(user_id, ''), deleted_at IS NOT NULL FROM conversations WHERE id = $1",
+	authz.Message:      "FROM messages m JOIN conversations c ON c.id = m.conversation_id WHERE m.id = $1",
+	authz.Folder:       "SELECT user_id, false FROM folders WHERE id = $1",
+	authz.Tag:          "SELECT user_id, false FROM tags WHERE id = $1",
+}
+
+// TestNew_Rules sends a request to each route of authz.Rules as another user
+// than the owner of its resources, the middleware must answer 404 before the
+// handler runs
+func TestNew_Rules(t *testing.T) {
+	const owner, stranger = "owner", "stranger"
+	byRoute := map[string][]authz.Rule{}
+	var routes []string
+	for _, rule := range authz.Rules {
+		key := rule.Method + " " + rule.Path
+		if byRoute[key] == nil {
+			routes = append(routes, key)
+		}
+		byRoute[key] = append(byRoute[key], rule)
+	}
+
+	for _, key := range routes {
+		rules := byRoute[key]
+		t.Run(key, func(t *testing.T) {
+			// Resources are owned by owner, and in the trash when a rule requires it
+			trashed := rules[0].State == authz.Trashed
+			s := newTestServer(t, func(q dbtest.Query) (dbtest.Result, error) {
+				if res, ok := accountRow(q); ok {
+					return res, nil
+				}
+				for _, query := range loaderQueries {
+					if q.Has(query) {
+						return dbtest.Result{Columns: []string{"user_id", "trashed"}, Rows: [][]driver.Value{{owner, trashed}}}, nil
+					}
+				}
+				return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+			})
+
+			path := rules[0].Path
+			fields := map[string]int{}
+			for _, rule := range rules {
+				if rule.Body {
+					fields[rule.Param] = 1
+				} else {
+					path = strings.Replace(path, ":"+rule.Param, "1", 1)
+				}
+			}
+			body := ""
+			if len(fields) > 0 {
+				data, err := json.Marshal(fields)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(data)
+			}
+
+			w := s.do(t, stranger, rules[0].Method, path, body)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body.String())
+			}
+			loaded := false
+			for _, q := range s.db.Queries() {
+				loaded = loaded || q.Has(loaderQueries[rules[0].Resource])
+			}
+			if !loaded {
+				t.Errorf("the %s of the request was not loaded by the middleware", rules[0].Resource)
+			}
+		})
+	}
+}
+
+func TestSignup_PasswordStrength(t *testing.T) {
+	s := newTestServer(t, func(q dbtest.Query) (dbtest.Result, error) {
+		return dbtest.Result{}, fmt.Errorf("unexpected query %s", q.SQL)
+	})
+
+	tests := []struct {
+		name     string
+		password string
+		lang     string
+		want     string
+	}{
+		{name: "too short", password: "abc123", lang: "en", want: "password must be at least 10 characters long"},
+		{name: "too long", password: strings.Repeat("a1", 40), lang: "en", want: "password must be at most 72 bytes long"},
+		{name: "letters only", password: "abcdefghijkl", lang: "en", want: "password must mix letters with digits or symbols"},
+		{name: "common", password: "Password123", lang: "en", want: "password is too common"},
+		{name: "too short vi", password: "abc123", lang: "vi", want: "mật khẩu phải dài ít nhất 10 ký tự"},
+		{name: "common vi", password: "Password123", lang: "vi", want: "mật khẩu quá phổ biến"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"username": "alice", "password": tt.password})
+			req := httptest.NewRequest(http.MethodPost, "/signup", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept-Language", tt.lang)
+			w := httptest.NewRecorder()
+			s.router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("body = %s, want it to contain %q", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+)
+
+// Locker elects the instance that runs an exclusive job
+type Locker interface {
+	// TryLock takes the lock called name without waiting, ok is false when
+	// another instance holds it. unlock must be called once the job is done.
+	TryLock(ctx context.Context, name string) (unlock func(), ok bool, err error)
+}
+
+// PGLocker uses Postgres session advisory locks, so that only one of the server
+// instances sharing a database runs an exclusive job at a time
+type PGLocker struct {
+	db *sql.DB
+}
+
+// NewPGLocker make a locker on db
+func NewPGLocker(db *sql.DB) *PGLocker {
+	return &PGLocker{db: db}
+}
+
+// TryLock implements Locker. The advisory lock belongs to a session, so the
+// connection is kept out of the pool until unlock.
+func (l *PGLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not get connection for lock %s: %v", name, err)
+	}
+
+	key := lockKey(name)
+	var ok bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&ok); err != nil {
+		// The lock may have been taken before the error
+		discard(conn)
+		return nil, false, fmt.Errorf("could not take lock %s: %v", name, err)
+	}
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			discard(conn)
+			return
+		}
+		conn.Close()
+	}, true, nil
+}
+
+// discard closes the session of conn instead of returning it to the pool,
+// which releases the advisory locks it may still hold. Pooled, the session
+// would keep them until the connection happens to be closed.
+func discard(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn }) //nolint:errcheck // the error is what drops the connection
+	conn.Close()
+}
+
+// lockKey maps a job name to the bigint key of its advisory lock
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("bloom-scheduler:" + name)) //nolint:errcheck // hash writes never fail
+	return int64(h.Sum64())
+}
@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeLockDB is a database whose advisory locks are always free, counting the
+// sessions closed
+type fakeLockDB struct {
+	failUnlock bool
+
+	mu     sync.Mutex
+	closed int
+}
+
+func (d *fakeLockDB) Connect(context.Context) (driver.Conn, error) { return &fakeLockConn{db: d}, nil }
+func (d *fakeLockDB) Driver() driver.Driver                        { return nil }
+
+type fakeLockConn struct {
+	db *fakeLockDB
+}
+
+func (c *fakeLockConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeLockConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *fakeLockConn) Close() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.closed++
+	return nil
+}
+
+func (c *fakeLockConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &lockedRows{}, nil
+}
+
+func (c *fakeLockConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	if c.db.failUnlock {
+		return nil, errors.New("connection reset by peer")
+	}
+	return driver.RowsAffected(0), nil
+}
+
+// lockedRows is the single true row of pg_try_advisory_lock
+type lockedRows struct {
+	done bool
+}
+
+func (r *lockedRows) Columns() []string { return []string{"pg_try_advisory_lock"} }
+func (r *lockedRows) Close() error      { return nil }
+
+func (r *lockedRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = true
+	return nil
+}
+
+func TestPGLocker_Unlock(t *testing.T) {
+	tests := []struct {
+		name       string
+		failUnlock bool
+		wantClosed int
+	}{
+		{name: "session back to the pool once unlocked", wantClosed: 0},
+		{name: "session dropped when the unlock fails", failUnlock: true, wantClosed: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeLockDB{failUnlock: tt.failUnlock}
+			db := sql.OpenDB(fake)
+			defer db.Close()
+
+			unlock, ok, err := NewPGLocker(db).TryLock(context.Background(), "job")
+			if err != nil || !ok {
+				t.Fatalf("TryLock() = %v, %v, want the lock", ok, err)
+			}
+			unlock()
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if fake.closed != tt.wantClosed {
+				t.Errorf("sessions closed = %d, want %d", fake.closed, tt.wantClosed)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/dwarvesf/gerr"
+)
+
+// Job is a task run periodically inside the server process
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+
+	// Exclusive jobs only run on the instance holding the job lock, when the
+	// scheduler has a Locker
+	Exclusive bool
+
+	// Trigger, when set, runs the job before its interval is over each time it
+	// receives, for work that is requested rather than only due
+	Trigger <-chan struct{}
+}
+
+// Scheduler runs jobs on their interval until its context is cancelled
+type Scheduler struct {
+	log    gerr.Log
+	jobs   []Job
+	locker Locker
+}
+
+// New make a scheduler for the given jobs
+func New(l gerr.Log, jobs ...Job) *Scheduler {
+	return &Scheduler{
+		log:  l,
+		jobs: jobs,
+	}
+}
+
+// WithLocker sets the locker electing the instance that runs exclusive jobs
+func (s *Scheduler) WithLocker(l Locker) *Scheduler {
+	s.locker = l
+	return s
+}
+
+// Start runs every job once right away, then on its interval, each in its own goroutine
+func (s *Scheduler) Start(ctx context.Context) {
+	for idx := range s.jobs {
+		go s.loop(ctx, s.jobs[idx])
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.run(ctx, job)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-job.Trigger:
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	if job.Exclusive && s.locker != nil {
+		unlock, ok, err := s.locker.TryLock(ctx, job.Name)
+		if err != nil {
+			s.log.Error("job ", job.Name, " lock failed: ", err) //nolint:errcheck // Ignore unused function warning
+			return
+		}
+		if !ok {
+			// Another instance is the leader for this run
+			return
+		}
+		defer unlock()
+	}
+
+	if err := job.Run(ctx); err != nil && ctx.Err() == nil {
+		s.log.Error("job ", job.Name, " failed: ", err) //nolint:errcheck // Ignore unused function warning
+	}
+}
@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dwarvesf/gerr"
+)
+
+func TestScheduler_Start(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{
+			name: "run successful job on interval",
+		},
+		{
+			name: "keep running after a failure",
+			err:  errors.New("boom"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs int32
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			New(gerr.NewSimpleLog(), Job{
+				Name:     "count",
+				Interval: 10 * time.Millisecond,
+				Run: func(ctx context.Context) error {
+					atomic.AddInt32(&runs, 1)
+					return tt.err
+				},
+			}).Start(ctx)
+
+			time.Sleep(55 * time.Millisecond)
+			cancel()
+			if got := atomic.LoadInt32(&runs); got < 2 {
+				t.Errorf("Scheduler.Start() runs = %v, want at least 2", got)
+			}
+		})
+	}
+}
+
+func TestScheduler_Trigger(t *testing.T) {
+	var runs int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trigger := make(chan struct{})
+	New(gerr.NewSimpleLog(), Job{
+		Name:     "requested",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		},
+		Trigger: trigger,
+	}).Start(ctx)
+
+	trigger <- struct{}{}
+	trigger <- struct{}{}
+	time.Sleep(20 * time.Millisecond)
+	if got := atomic.LoadInt32(&runs); got != 3 {
+		t.Errorf("Scheduler.Start() runs = %v, want 3, once at start and once per trigger", got)
+	}
+}
+
+type fakeLocker struct {
+	held     bool
+	unlocked int32
+}
+
+func (l *fakeLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	if l.held {
+		return nil, false, nil
+	}
+	return func() { atomic.AddInt32(&l.unlocked, 1) }, true, nil
+}
+
+func TestScheduler_Exclusive(t *testing.T) {
+	tests := []struct {
+		name      string
+		exclusive bool
+		held      bool
+		wantRun   bool
+	}{
+		{
+			name:      "run exclusive job when lock is free",
+			exclusive: true,
+			wantRun:   true,
+		},
+		{
+			name:      "skip exclusive job when lock is held",
+			exclusive: true,
+			held:      true,
+		},
+		{
+			name:    "ignore lock for regular job",
+			held:    true,
+			wantRun: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs int32
+			locker := &fakeLocker{held: tt.held}
+			s := New(gerr.NewSimpleLog()).WithLocker(locker)
+			s.run(context.Background(), Job{
+				Name:      "count",
+				Exclusive: tt.exclusive,
+				Run: func(ctx context.Context) error {
+					atomic.AddInt32(&runs, 1)
+					return nil
+				},
+			})
+
+			if got := atomic.LoadInt32(&runs) == 1; got != tt.wantRun {
+				t.Errorf("Scheduler.run() ran = %v, want %v", got, tt.wantRun)
+			}
+			wantUnlocked := int32(0)
+			if tt.exclusive && !tt.held {
+				wantUnlocked = 1
+			}
+			if got := atomic.LoadInt32(&locker.unlocked); got != wantUnlocked {
+				t.Errorf("Scheduler.run() unlocked = %v, want %v", got, wantUnlocked)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
 	"github.com/Essen-Labs/bloom-be/translation"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
@@ -47,9 +48,11 @@ func (v *defaultValidator) lazyinit() {
 		v.validate = validator.New()
 		v.validate.SetTagName("binding")
 
+		// add any custom validations etc. here
+		v.validate.RegisterValidation("strongpassword", validateStrongPassword) //nolint:errcheck // tag name is valid
+
 		v.transHelper.InitErrorTranslator(v.validate)
 
-		// add any custom validations etc. here
 		v.validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
 			if name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]; name != "-" && name != "" {
 				return name
@@ -65,6 +68,11 @@ func (v *defaultValidator) lazyinit() {
 	})
 }
 
+// validateStrongPassword checks a password against the rules of auth.CheckPasswordStrength
+func validateStrongPassword(fl validator.FieldLevel) bool {
+	return auth.CheckPasswordStrength(fl.Field().String()) == nil
+}
+
 func kindOfData(data interface{}) reflect.Kind {
 
 	value := reflect.ValueOf(data)
@@ -0,0 +1,57 @@
+package english
+
+import (
+	ut "github.com/go-playground/universal-translator"
+)
+
+// addEmailTranslations adds the templates of the emails sent to users. Bodies
+// take the username, the email, the link and when the link expires, the
+// account deletion body takes the username, the email and when it is deleted.
+func addEmailTranslations(en ut.Translator) error {
+	err := en.Add("email_verify_subject", "Verify your email address", false)
+	if err != nil {
+		return err
+	}
+	err = en.Add("email_verify_body", `Hi {0},
+
+Please confirm that {1} is your email address by opening this link:
+
+{2}
+
+The link expires on {3}. If you did not ask for it, you can ignore this email.
+
+The Bloom team
+`, false)
+	if err != nil {
+		return err
+	}
+	err = en.Add("email_reset_subject", "Reset your password", false)
+	if err != nil {
+		return err
+	}
+	err = en.Add("email_reset_body", `Hi {0},
+
+Someone asked to reset the password of the Bloom account of {1}. To choose a new password, open this link:
+
+{2}
+
+The link expires on {3} and can be used once. If you did not ask for it, you can ignore this email, your password stays the same.
+
+The Bloom team
+`, false)
+	if err != nil {
+		return err
+	}
+	err = en.Add("email_delete_subject", "Your account will be deleted", false)
+	if err != nil {
+		return err
+	}
+	return en.Add("email_delete_body", `Hi {0},
+
+The Bloom account of {1} will be deleted on {2}, with its conversations, messages, feedback and usage. Until then, you can download your data and cancel the deletion from your account settings.
+
+If you did not ask for it, sign in and cancel the deletion, then change your password.
+
+The Bloom team
+`, false)
+}
@@ -2,6 +2,9 @@ package english
 
 import (
 	"errors"
+	"strconv"
+
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
 
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
@@ -19,14 +22,65 @@ func Init(uni *ut.UniversalTranslator, validate *validator.Validate) error {
 		return errors.New("Translation not found")
 	}
 
+	err := addEmailTranslations(en)
+	if err != nil {
+		return errors.New("Error adding email translations: " + err.Error())
+	}
+
 	// validator translations & Overrides
-	err := valtrans.RegisterDefaultTranslations(validate, en)
+	err = valtrans.RegisterDefaultTranslations(validate, en)
 	if err != nil {
 		return errors.New("Error adding default translations: " + err.Error())
 	}
+	err = validate.RegisterTranslation("strongpassword", en, func(ut ut.Translator) error {
+		if err := ut.Add("strongpassword-short", "{0} must be at least {1} characters long", false); err != nil {
+			return err
+		}
+		if err := ut.Add("strongpassword-long", "{0} must be at most {1} bytes long", false); err != nil {
+			return err
+		}
+		if err := ut.Add("strongpassword-weak", "{0} must mix letters with digits or symbols", false); err != nil {
+			return err
+		}
+		return ut.Add("strongpassword-common", "{0} is too common", false)
+	}, func(ut ut.Translator, fe validator.FieldError) string {
+		t, err := ut.T(strongPasswordKey(fe), fe.Field(), strongPasswordParam(fe))
+		if err != nil {
+			return fe.(error).Error()
+		}
+		return t
+	})
+	if err != nil {
+		return errors.New("Error adding custom translations: " + err.Error())
+	}
 
 	if err := en.VerifyTranslations(); err != nil {
 		return errors.New("Missing Translations: " + err.Error())
 	}
 	return nil
 }
+
+// strongPasswordKey picks the translation of the strongpassword rule a
+// password broke
+func strongPasswordKey(fe validator.FieldError) string {
+	password, _ := fe.Value().(string)
+	switch auth.CheckPasswordStrength(password) {
+	case auth.ErrPasswordTooShort:
+		return "strongpassword-short"
+	case auth.ErrPasswordTooLong:
+		return "strongpassword-long"
+	case auth.ErrPasswordCommon:
+		return "strongpassword-common"
+	default:
+		return "strongpassword-weak"
+	}
+}
+
+// strongPasswordParam is the length limit quoted by the translation of a
+// strongpassword error
+func strongPasswordParam(fe validator.FieldError) string {
+	if strongPasswordKey(fe) == "strongpassword-long" {
+		return strconv.Itoa(auth.MaxPasswordBytes)
+	}
+	return strconv.Itoa(auth.MinPasswordLength)
+}
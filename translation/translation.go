@@ -1,6 +1,8 @@
 package translation
 
 import (
+	"strings"
+
 	english "github.com/Essen-Labs/bloom-be/translation/en"
 	vietnamese "github.com/Essen-Labs/bloom-be/translation/vi"
 
@@ -43,13 +45,22 @@ func (h *helper) InitErrorTranslator(validate *validator.Validate) {
 	h.defTranslator = defTrans
 }
 
+// GetTranslator returns the translator of locale, which may be an
+// Accept-Language header such as "vi-VN,vi;q=0.9,en;q=0.8". Languages are
+// tried in the order listed, regional ones falling back to their base language.
 func (h *helper) GetTranslator(locale string) ut.Translator {
-	if locale == "" {
-		return h.defTranslator
-	}
-	t, found := h.uni.GetTranslator(locale)
-	if !found {
-		return h.defTranslator
+	for _, tag := range strings.Split(locale, ",") {
+		tag = strings.TrimSpace(strings.SplitN(tag, ";", 2)[0])
+		if tag == "" {
+			continue
+		}
+		if t, found := h.uni.GetTranslator(tag); found {
+			return t
+		}
+		base := strings.SplitN(tag, "-", 2)[0]
+		if t, found := h.uni.GetTranslator(strings.ToLower(base)); found {
+			return t
+		}
 	}
-	return t
+	return h.defTranslator
 }
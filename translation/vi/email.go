@@ -0,0 +1,57 @@
+package vietnamese
+
+import (
+	ut "github.com/go-playground/universal-translator"
+)
+
+// addEmailTranslations adds the templates of the emails sent to users. Bodies
+// take the username, the email, the link and when the link expires, the
+// account deletion body takes the username, the email and when it is deleted.
+func addEmailTranslations(vi ut.Translator) error {
+	err := vi.Add("email_verify_subject", "Xác minh địa chỉ email của bạn", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("email_verify_body", `Xin chào {0},
+
+Vui lòng xác nhận {1} là địa chỉ email của bạn bằng cách mở liên kết sau:
+
+{2}
+
+Liên kết sẽ hết hạn vào {3}. Nếu bạn không yêu cầu email này, vui lòng bỏ qua.
+
+Đội ngũ Bloom
+`, false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("email_reset_subject", "Đặt lại mật khẩu của bạn", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("email_reset_body", `Xin chào {0},
+
+Có người đã yêu cầu đặt lại mật khẩu cho tài khoản Bloom của {1}. Để chọn mật khẩu mới, vui lòng mở liên kết sau:
+
+{2}
+
+Liên kết sẽ hết hạn vào {3} và chỉ dùng được một lần. Nếu bạn không yêu cầu, vui lòng bỏ qua email này, mật khẩu của bạn sẽ không thay đổi.
+
+Đội ngũ Bloom
+`, false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("email_delete_subject", "Tài khoản của bạn sẽ bị xóa", false)
+	if err != nil {
+		return err
+	}
+	return vi.Add("email_delete_body", `Xin chào {0},
+
+Tài khoản Bloom của {1} sẽ bị xóa vào {2}, cùng với các cuộc trò chuyện, tin nhắn, phản hồi và dữ liệu sử dụng. Trước thời điểm đó, bạn có thể tải xuống dữ liệu của mình và hủy việc xóa trong phần cài đặt tài khoản.
+
+Nếu bạn không yêu cầu, vui lòng đăng nhập và hủy việc xóa, sau đó đổi mật khẩu.
+
+Đội ngũ Bloom
+`, false)
+}
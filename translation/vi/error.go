@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Essen-Labs/bloom-be/pkg/auth"
 	"github.com/go-playground/locales"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
@@ -1323,6 +1324,56 @@ func RegisterDefaultTranslations(v *validator.Validate, trans ut.Translator) (er
 			translation: "{0} phải được viết hoa",
 			override:    false,
 		},
+		{
+			tag: "strongpassword",
+			customRegisFunc: func(ut ut.Translator) (err error) {
+
+				if err = ut.Add("strongpassword-short", "{0} phải dài ít nhất {1} ký tự", false); err != nil {
+					return
+				}
+
+				if err = ut.Add("strongpassword-long", "{0} chỉ được dài tối đa {1} byte", false); err != nil {
+					return
+				}
+
+				if err = ut.Add("strongpassword-weak", "{0} phải kết hợp chữ cái với chữ số hoặc ký hiệu", false); err != nil {
+					return
+				}
+
+				if err = ut.Add("strongpassword-common", "{0} quá phổ biến", false); err != nil {
+					return
+				}
+
+				return
+			},
+			customTransFunc: func(ut ut.Translator, fe validator.FieldError) string {
+
+				password, _ := fe.Value().(string)
+
+				var key string
+				param := strconv.Itoa(auth.MinPasswordLength)
+
+				switch auth.CheckPasswordStrength(password) {
+				case auth.ErrPasswordTooShort:
+					key = "strongpassword-short"
+				case auth.ErrPasswordTooLong:
+					key = "strongpassword-long"
+					param = strconv.Itoa(auth.MaxPasswordBytes)
+				case auth.ErrPasswordCommon:
+					key = "strongpassword-common"
+				default:
+					key = "strongpassword-weak"
+				}
+
+				t, err := ut.T(key, translateField(ut, fe), param)
+				if err != nil {
+					log.Printf("warning: error translating FieldError: %#v", fe)
+					return fe.(error).Error()
+				}
+
+				return t
+			},
+		},
 		{
 			tag:         "datetime",
 			translation: "{0} phải là định dạng {1}",
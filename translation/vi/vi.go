@@ -34,6 +34,126 @@ func Init(uni *ut.UniversalTranslator, validate *validator.Validate) error {
 	if err != nil {
 		return err
 	}
+	err = vi.Add("authentication required", "bạn cần đăng nhập để thực hiện yêu cầu này", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("invalid username or password", "tên đăng nhập hoặc mật khẩu không đúng", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("refresh token is invalid or expired", "phiên đăng nhập không hợp lệ hoặc đã hết hạn", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("API key does not allow this request", "khóa API không được phép thực hiện yêu cầu này", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("sign up to create API keys", "bạn cần đăng ký tài khoản để tạo khóa API", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("too many requests", "bạn đã gửi quá nhiều yêu cầu, vui lòng thử lại sau", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("too many messages, wait a moment before sending another", "bạn đã gửi quá nhiều tin nhắn, vui lòng đợi một lát trước khi gửi tiếp", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("single sign-on is not configured", "đăng nhập một lần (SSO) chưa được cấu hình", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("single sign-on failed", "đăng nhập một lần (SSO) không thành công", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("password login is disabled, sign in with single sign-on", "đăng nhập bằng mật khẩu đã bị tắt, vui lòng đăng nhập một lần (SSO)", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("account is disabled", "tài khoản đã bị vô hiệu hóa", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("admin access required", "chỉ quản trị viên mới có quyền truy cập", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("daily message quota reached, try again tomorrow", "bạn đã dùng hết số tin nhắn trong ngày, vui lòng thử lại vào ngày mai", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("admins cannot disable, log out or demote themselves", "quản trị viên không thể tự vô hiệu hóa, đăng xuất hoặc hạ quyền của chính mình", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("link is invalid or expired", "liên kết không hợp lệ hoặc đã hết hạn", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("email is already used by another account", "email đã được sử dụng bởi một tài khoản khác", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("add an email address to your account first", "vui lòng thêm địa chỉ email vào tài khoản của bạn trước", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("email is already verified", "email đã được xác minh", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("sign up to verify an email address", "bạn cần đăng ký tài khoản để xác minh địa chỉ email", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("an email was just sent, wait a minute before asking for another", "email vừa được gửi, vui lòng đợi một phút trước khi yêu cầu gửi lại", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("data export is not ready yet", "bản xuất dữ liệu chưa sẵn sàng", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("data export failed, request a new one", "xuất dữ liệu thất bại, vui lòng yêu cầu lại", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("only accounts can be deleted, delete your conversations instead", "chỉ có thể xóa tài khoản đã đăng ký, hãy xóa các cuộc trò chuyện của bạn", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("password is incorrect", "mật khẩu không đúng", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("account deletion is not scheduled", "tài khoản không có lịch xóa", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("semantic search is not enabled", "tìm kiếm theo ngữ nghĩa chưa được bật", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("full-text search is not available while message content is encrypted", "không thể tìm kiếm toàn văn khi nội dung tin nhắn được mã hóa", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("from and to must be dates formatted as YYYY-MM-DD", "from và to phải là ngày theo định dạng YYYY-MM-DD", false)
+	if err != nil {
+		return err
+	}
+	err = vi.Add("conversation is in the trash, restore it to continue", "cuộc hội thoại đang trong thùng rác, hãy khôi phục để tiếp tục", false)
+	if err != nil {
+		return err
+	}
+	err = addEmailTranslations(vi)
+	if err != nil {
+		return errors.New("Error adding email translations: " + err.Error())
+	}
 
 	// validator translations & Overrides
 	err = RegisterDefaultTranslations(validate, vi)